@@ -0,0 +1,121 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ansibleFacts renders the addresses in the structure of the facts gathered by Ansible's setup module, so the
+// output can be used as a lightweight drop-in on minimal hosts.
+func ansibleFacts(list ips, code int) (string, int, error) {
+	facts := map[string]any{}
+	allIPv4, allIPv6 := make([]string, 0), make([]string, 0)
+	interfaces := make([]string, 0)
+	devices := map[string]map[string]any{}
+
+	for _, i := range list {
+		if i.isPublic() {
+			facts["ips_public_"+i.family()] = i.Address
+			continue
+		}
+		address, network, err := net.ParseCIDR(i.Address)
+		if err != nil {
+			continue
+		}
+		device, ok := devices[i.Interface]
+		if !ok {
+			device = map[string]any{"device": i.Interface, "active": true}
+			devices[i.Interface] = device
+			interfaces = append(interfaces, i.Interface)
+		}
+		ones, _ := network.Mask.Size()
+		// ansible reports prefix lengths as strings
+		prefix := strconv.Itoa(ones)
+
+		if address.To4() != nil {
+			if !address.IsLoopback() {
+				allIPv4 = append(allIPv4, address.String())
+			}
+			entry := map[string]any{
+				"address": address.String(),
+				"netmask": net.IP(network.Mask).String(),
+				"network": network.IP.String(),
+				"prefix":  prefix,
+			}
+			if _, ok := device["ipv4"]; !ok {
+				device["ipv4"] = entry
+			} else {
+				secondaries, _ := device["ipv4_secondaries"].([]map[string]any)
+				device["ipv4_secondaries"] = append(secondaries, entry)
+			}
+			continue
+		}
+
+		if !address.IsLoopback() {
+			allIPv6 = append(allIPv6, address.String())
+		}
+		v6, _ := device["ipv6"].([]map[string]any)
+		device["ipv6"] = append(v6, map[string]any{
+			"address": address.String(),
+			"prefix":  prefix,
+			"scope":   ipv6Scope(address),
+		})
+	}
+
+	facts["ansible_all_ipv4_addresses"] = allIPv4
+	facts["ansible_all_ipv6_addresses"] = allIPv6
+	facts["ansible_interfaces"] = interfaces
+	for name, device := range devices {
+		facts["ansible_"+strings.NewReplacer("-", "_", ".", "_").Replace(name)] = device
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if d := ansibleDefault(family, devices); d != nil {
+			facts["ansible_default_"+family] = d
+		}
+	}
+
+	data, err := json.Marshal(map[string]any{"ansible_facts": facts})
+	if err != nil {
+		return "", code, err
+	}
+	return string(data), code, nil
+}
+
+// ansibleDefault returns the ansible_default_<family> fact describing the address used by the default route.
+func ansibleDefault(family string, devices map[string]map[string]any) map[string]any {
+	destination := net.ParseIP("192.0.2.1")
+	if family == "ipv6" {
+		destination = net.ParseIP("2001:db8::1")
+	}
+	r := routeTo(destination)
+	if r.Error != "" {
+		return nil
+	}
+	result := map[string]any{"address": r.Source, "interface": r.Interface}
+	device, ok := devices[r.Interface]
+	if !ok || family == "ipv6" {
+		return result
+	}
+	if entry, ok := device["ipv4"].(map[string]any); ok && entry["address"] == r.Source {
+		for _, key := range []string{"netmask", "network", "prefix"} {
+			result[key] = entry[key]
+		}
+	}
+	return result
+}
+
+// ipv6Scope returns the scope of an ipv6 address as reported by Ansible.
+func ipv6Scope(address net.IP) string {
+	switch {
+	case address.IsLoopback():
+		return "host"
+	case address.IsLinkLocalUnicast():
+		return "link"
+	default:
+		return "global"
+	}
+}
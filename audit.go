@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// auditLog is the file every outbound request is recorded in, auditing is disabled when empty
+var auditLog string
+
+var auditMu sync.Mutex
+
+// auditEntry is a single line of the audit log.
+type auditEntry struct {
+
+	// Time is the moment the request was started
+	Time time.Time
+
+	// Kind is the type of request, e.g. http, dns or tcp
+	Kind string
+
+	// Target is the url or address the request was sent to
+	Target string
+
+	// Result is the http status, the response code of dns lookups or "ok" for other kinds
+	Result string `json:",omitempty"`
+
+	// Duration is the time the request took
+	Duration string
+
+	// Error describes why the request failed
+	Error string `json:",omitempty"`
+}
+
+// audit appends an entry for a request started at start to the audit log.
+func audit(kind, target string, start time.Time, result string, err error) {
+	if auditLog == "" {
+		return
+	}
+	entry := auditEntry{
+		Time:     start,
+		Kind:     kind,
+		Target:   target,
+		Result:   result,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	_ = appendLog(auditLog, data)
+}
+
+// auditTransport records every http request passing through it in the audit log.
+type auditTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip performs the request using the wrapped transport and records it.
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	result := ""
+	if resp != nil {
+		result = resp.Status
+	}
+	audit("http", req.Method+" "+req.URL.String(), start, result, err)
+	return resp, err
+}
+
+// newHTTPClient returns a client using the configured timeout whose requests pass the no-external gate and are
+// recorded in the audit log. A nil transport selects the default transport.
+func newHTTPClient(transport http.RoundTripper) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{Timeout: timeout, Transport: &auditTransport{next: &gateTransport{next: transport}}}
+}
+
+// dnsTypeNames are the names of the record types written to the audit log, others are written as TYPE<number>
+var dnsTypeNames = map[uint16]string{1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA", 33: "SRV", 65: "HTTPS"}
+
+// dnsResponseCodes are the names of the response codes written to the audit log
+var dnsResponseCodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+type (
+
+	// auditConn records the DNS queries sent over a connection of the resolver in the audit log, each once its
+	// answer arrived or the connection was closed.
+	auditConn struct {
+		net.Conn
+
+		// server is the resolver the queries are sent to
+		server string
+
+		// stream is set for tcp, tls and https connections, messages are prefixed by their length
+		stream bool
+
+		// received collects the answers read from a stream until they are complete
+		received bytes.Buffer
+
+		// queries are the queries not answered yet, keyed by their id
+		queries map[uint16]*auditQuery
+	}
+
+	// auditQuery is a query sent by the resolver.
+	auditQuery struct {
+		question string
+		start    time.Time
+	}
+
+	// auditPacketConn is an auditConn over udp, the go resolver only sends a single query per message if the
+	// connection is a packet connection.
+	auditPacketConn struct {
+		*auditConn
+	}
+)
+
+// auditedResolver returns a resolver sending its queries like resolver does and recording them in the audit log.
+// The go resolver is used, the lookups of the system resolver can't be observed.
+func auditedResolver(resolver *net.Resolver) *net.Resolver {
+	dial := resolver.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return &net.Resolver{
+		PreferGo:     true,
+		StrictErrors: resolver.StrictErrors,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			start := time.Now()
+			conn, err := dial(ctx, network, address)
+			if err != nil {
+				audit("dns", address, start, "", err)
+				return nil, err
+			}
+			c := &auditConn{Conn: conn, server: address, queries: make(map[uint16]*auditQuery)}
+			if remote := conn.RemoteAddr(); remote != nil {
+				c.server = remote.String()
+			} else if dnsServer != "" {
+				c.server = dnsServer
+			}
+			if _, ok := conn.(net.PacketConn); ok {
+				return auditPacketConn{c}, nil
+			}
+			c.stream = true
+			return c, nil
+		},
+	}
+}
+
+// Write notes the queries written, the go resolver writes every message in a single call.
+func (c *auditConn) Write(b []byte) (int, error) {
+	msg := b
+	if c.stream && len(msg) >= 2 {
+		msg = msg[2:]
+	}
+	if len(msg) >= 12 {
+		name, next, err := readName(msg, 12)
+		question := "?"
+		if err == nil && len(msg) >= next+2 {
+			rrtype := binary.BigEndian.Uint16(msg[next : next+2])
+			question = dnsTypeNames[rrtype]
+			if question == "" {
+				question = fmt.Sprintf("TYPE%d", rrtype)
+			}
+			question += " " + name
+		}
+		c.queries[binary.BigEndian.Uint16(msg)] = &auditQuery{question: question, start: time.Now()}
+	}
+	return c.Conn.Write(b)
+}
+
+// Read records the queries answered by the messages read.
+func (c *auditConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.stream {
+		c.answered(b[:n])
+		return n, err
+	}
+	c.received.Write(b[:n])
+	for c.received.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.received.Bytes()))
+		if c.received.Len() < 2+size {
+			break
+		}
+		c.received.Next(2)
+		c.answered(c.received.Next(size))
+	}
+	return n, err
+}
+
+// answered records the query the answer belongs to with the response code of the answer.
+func (c *auditConn) answered(msg []byte) {
+	if len(msg) < 12 {
+		return
+	}
+	q, ok := c.queries[binary.BigEndian.Uint16(msg)]
+	if !ok {
+		return
+	}
+	delete(c.queries, binary.BigEndian.Uint16(msg))
+	result := fmt.Sprintf("RCODE%d", msg[3]&0x0f)
+	if code := int(msg[3] & 0x0f); code < len(dnsResponseCodes) {
+		result = dnsResponseCodes[code]
+	}
+	audit("dns", q.question+" @"+c.server, q.start, result, nil)
+}
+
+// Close records the queries left unanswered, e.g. after a timeout.
+func (c *auditConn) Close() error {
+	for _, q := range c.queries {
+		audit("dns", q.question+" @"+c.server, q.start, "", errors.New("no answer"))
+	}
+	clear(c.queries)
+	return c.Conn.Close()
+}
+
+// ReadFrom reads an answer like Read does.
+func (c auditPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+// WriteTo writes a query like Write does, the connection is connected to the resolver.
+func (c auditPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
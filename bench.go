@@ -0,0 +1,112 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResult summarizes the runs against a single provider for one address family.
+type benchResult struct {
+
+	// Provider is the name of the benchmarked provider
+	Provider string
+
+	// Family is the address family that was queried
+	Family string
+
+	// Runs is the number of queries sent to the provider
+	Runs uint
+
+	// SuccessRate is the share of queries answered without error
+	SuccessRate float64
+
+	// Consistency is the share of successful answers that match the most common answer
+	Consistency float64
+
+	// MedianLatency is the median duration of successful queries
+	MedianLatency time.Duration
+
+	// Answer is the most common address returned by the provider
+	Answer string `json:",omitempty"`
+}
+
+// runBenchProviders queries every known provider repeatedly and prints a table ranked by success rate,
+// consistency of the answers and latency.
+func runBenchProviders(logger *slog.Logger, _ []string) int {
+	results := make([]*benchResult, 0, 2*len(knownProviders))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range knownProviders {
+		for _, family := range []string{"ipv4", "ipv6"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := benchProvider(logger, p, family)
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b *benchResult) int {
+		return cmp.Or(
+			cmp.Compare(b.SuccessRate, a.SuccessRate),
+			cmp.Compare(b.Consistency, a.Consistency),
+			cmp.Compare(a.MedianLatency, b.MedianLatency),
+			cmp.Compare(a.Provider, b.Provider),
+			cmp.Compare(a.Family, b.Family),
+		)
+	})
+
+	if jsonOutput {
+		return printJSON(logger, results)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "RANK\tPROVIDER\tFAMILY\tSUCCESS\tCONSISTENCY\tMEDIAN\tANSWER")
+	for idx, r := range results {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%.0f%%\t%.0f%%\t%s\t%s\n", idx+1, r.Provider, r.Family, 100*r.SuccessRate, 100*r.Consistency, r.MedianLatency, r.Answer)
+	}
+	_ = w.Flush()
+	return exitOK
+}
+
+// benchProvider queries the provider runs times for the given family and summarizes the outcome.
+func benchProvider(logger *slog.Logger, p *provider, family string) *benchResult {
+	result := &benchResult{Provider: p.Name, Family: family, Runs: runs}
+	latencies := make([]time.Duration, 0, runs)
+	answers := make(map[string]int)
+	for range runs {
+		start := time.Now()
+		address, err := p.query(family)
+		if err != nil {
+			logger.Debug("provider query failed", "err", err, "provider", p.Name, "family", family)
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+		answers[address]++
+	}
+	if len(latencies) == 0 {
+		return result
+	}
+
+	result.SuccessRate = float64(len(latencies)) / float64(runs)
+	slices.Sort(latencies)
+	result.MedianLatency = latencies[len(latencies)/2].Round(time.Millisecond)
+	for address, count := range answers {
+		if count > answers[result.Answer] || (count == answers[result.Answer] && address < result.Answer) {
+			result.Answer = address
+		}
+	}
+	result.Consistency = float64(answers[result.Answer]) / float64(len(latencies))
+	return result
+}
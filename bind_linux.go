@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// bindSupport names how -via binds sockets to the interface
+const bindSupport = "SO_BINDTODEVICE"
+
+// bindInterface makes connections of dialer leave through the named interface using SO_BINDTODEVICE, regardless
+// of the routing table.
+func bindInterface(dialer *net.Dialer, name, _ string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return err
+	}
+	dialer.Control = func(_, _ string, raw syscall.RawConn) error {
+		var sockErr error
+		err := raw.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), name)
+		})
+		if err != nil {
+			return err
+		}
+		return os.NewSyscallError("setsockopt", sockErr)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// bindSupport names how -via makes connections use the interface
+const bindSupport = "source address"
+
+// bindInterface makes connections of dialer use the first global address of the family of the named interface as
+// source address, the routing table has to send such traffic through the interface. An explicit source address
+// is kept.
+func bindInterface(dialer *net.Dialer, name, family string) error {
+	if dialer.LocalAddr != nil {
+		return nil
+	}
+	i, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		prefix, ok := a.(*net.IPNet)
+		if !ok || !prefix.IP.IsGlobalUnicast() || (prefix.IP.To4() != nil) != (family == "ipv4") {
+			continue
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: prefix.IP}
+		return nil
+	}
+	return fmt.Errorf("interface %s has no global %s address", name, family)
+}
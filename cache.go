@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockPoll is the time between attempts to take the lock of the cache
+const lockPoll = 50 * time.Millisecond
+
+// previousPublic contains the public addresses known before the lookup of this run
+var previousPublic publicCache
+
+type (
+
+	// cachedAddress is a public address as stored in the cache file.
+	cachedAddress struct {
+
+		// Address is the public address
+		Address string
+
+		// Time is the moment the address was retrieved
+		Time time.Time
+
+		// Changes counts how often the address changed since the cache was created
+		Changes uint64 `json:",omitempty"`
+
+		// Labels are the labels the provider attached to the address
+		Labels map[string]string `json:",omitempty"`
+	}
+
+	// publicCache maps the address families to the last known public address.
+	publicCache map[string]*cachedAddress
+)
+
+// publicCachePath returns the location of the cache file for public addresses.
+func publicCachePath() (string, error) {
+	return statePath("public.json")
+}
+
+// loadPublicCache reads the cache file, a missing file results in an empty cache.
+func loadPublicCache() (publicCache, error) {
+	path, err := publicCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(publicCache), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := make(publicCache)
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// save writes the cache file atomically.
+func (c publicCache) save() error {
+	path, err := publicCachePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeState(path, data)
+}
+
+// fresh returns the cached address of the family if it is younger than the cache ttl.
+func (c publicCache) fresh(family string) (*cachedAddress, bool) {
+	entry, ok := c[family]
+	if !ok {
+		return nil, false
+	}
+	return entry, time.Since(entry.Time) < cacheTTL
+}
+
+// storePublic records the public addresses in list in the cache file. The addresses known before are kept in
+// previousPublic.
+func storePublic(list ips) error {
+	cache, err := loadPublicCache()
+	if err != nil {
+		cache = make(publicCache)
+	}
+	previousPublic = make(publicCache, len(cache))
+	for family, entry := range cache {
+		previousPublic[family] = entry
+	}
+	now := time.Now()
+	for _, i := range list {
+		if !i.isPublic() {
+			continue
+		}
+		entry := &cachedAddress{Address: i.Address, Time: now, Labels: i.Labels}
+		if previous, ok := cache[i.family()]; ok {
+			entry.Changes = previous.Changes
+			if previous.Address != i.Address {
+				entry.Changes++
+			}
+		}
+		cache[i.family()] = entry
+	}
+	return cache.save()
+}
+
+// publicChanged reports whether a public address of the list differs from the one known before the lookup of this
+// run. A family looked up for the first time counts as changed.
+func publicChanged(list ips) bool {
+	for _, i := range list {
+		if !i.isPublic() {
+			continue
+		}
+		if previous, ok := previousPublic[i.family()]; !ok || previous.Address != i.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// lockPublicCache takes the lock coordinating the public lookups of concurrent ips processes, waiting at most wait
+// for another process to release it. The returned function releases the lock.
+func lockPublicCache(wait time.Duration) (func(), error) {
+	path, err := publicCachePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(strings.TrimSuffix(path, ".json")+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := tryLock(f)
+		if locked {
+			return func() { _ = f.Close() }, nil
+		}
+		if err == nil && time.Now().After(deadline) {
+			err = errors.New("lock held by another process")
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// lookupPublic looks up the public address of both families and records them in the cache. Concurrent ips
+// processes, e.g. a prompt, a cron job and a daemon, perform the lookup once: it runs under a lock of the cache and
+// a process that had to wait for the lock takes the addresses stored meanwhile instead of asking the providers
+// again. Without the lock, e.g. if the holder takes too long, the providers are asked anyway. Lookups bound to an
+// interface or source address bypass the cache, as they may see another uplink. Families that could not be looked
+// up are returned as ErrNoPublicProvider.
+func lookupPublic(logger *slog.Logger) (ips, error) {
+	start := time.Now()
+	cache := make(publicCache)
+	if !bound() {
+		unlock, err := lockPublicCache(2 * timeout)
+		if err != nil {
+			logger.Debug("could not lock cache, looking up without coordination", "err", err)
+			unlock = func() {}
+		}
+		defer unlock()
+		if loaded, err := loadPublicCache(); err == nil {
+			cache = loaded
+		}
+	}
+
+	found := make(ips, 0, 2)
+	errs := make([]error, 0)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if entry, ok := cache[family]; ok && entry.Time.After(start) {
+			logger.Debug("using public ip looked up by another process", "family", family)
+			found = append(found, &ip{Address: entry.Address, Interface: fmt.Sprintf("public %s", strings.ToUpper(family)), Labels: entry.Labels})
+			continue
+		}
+		address, err := getPublicIp(family)
+		if err != nil {
+			logger.Info("could not get public ip", "err", err, "family", family)
+			errs = append(errs, fmt.Errorf("%w for %s: %w", ErrNoPublicProvider, family, err))
+			continue
+		}
+		found = append(found, address)
+	}
+	if !bound() {
+		if err := storePublic(found); err != nil {
+			logger.Debug("could not write cache", "err", err)
+		}
+	}
+	return found, errors.Join(errs...)
+}
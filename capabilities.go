@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+)
+
+type (
+
+	// capability is a feature whose availability depends on the platform or the build.
+	capability struct {
+		Name      string
+		Supported bool
+
+		// Detail names the mechanism implementing the feature on this platform
+		Detail string `json:",omitempty"`
+	}
+
+	// capabilityReport is the answer of ips capabilities.
+	capabilityReport struct {
+
+		// Platform is the operating system and architecture, e.g. linux/amd64
+		Platform     string
+		Capabilities []*capability
+
+		// Subsystems names the subsystems compiled in, empty for builds tagged ips_minimal
+		Subsystems []string
+	}
+)
+
+// capabilities returns the features of this build. Features implemented in portable code are always supported,
+// the others are supported if the platform provides the mechanism they rely on.
+func capabilities() []*capability {
+	result := make([]*capability, 0)
+	add := func(name, detail string) {
+		result = append(result, &capability{Name: name, Supported: detail != "", Detail: detail})
+	}
+	add("address-flags", addressFlagsSupport)
+	add("change-notifications", changeNotificationSupport)
+	add("desktop-notifications", desktopNotificationSupport)
+	add("gateway-discovery", gatewayDiscoverySupport)
+	add("multihome", multihomeSupport)
+	add("trace", traceSupport)
+	add("dbus", dbusSupport)
+	add("tray", traySupport)
+	clipboard := ""
+	if traySupport != "" {
+		clipboard = "tray menu"
+	}
+	add("clipboard", clipboard)
+	add("service", serviceSupport)
+	add("router-advertisements", raSupport)
+	add("bind-interface", bindSupport)
+	add("lock", lockSupport)
+	add("firewall", firewallSupport)
+	containers := ""
+	if runtime.GOOS == "linux" {
+		containers = "cgroups, marker files"
+	}
+	add("container-detection", containers)
+	add("upnp", upnpSupport)
+	add("nat-pmp", natPMPSupport)
+	add("mdns", mdnsSupport)
+	add("plugins", pluginSupport)
+	add("wake-on-lan", wakeOnLANSupport)
+	add("modem", modemSupport)
+	return result
+}
+
+// runCapabilities prints which features the build supports on this platform, one line per feature with its name,
+// yes or no and the mechanism implementing it, so scripts can adapt to heterogeneous fleets.
+func runCapabilities(logger *slog.Logger, _ []string) int {
+	report := &capabilityReport{
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Capabilities: capabilities(),
+		Subsystems:   subsystemNames(),
+	}
+	if jsonOutput {
+		return printJSON(logger, report)
+	}
+	for _, c := range report.Capabilities {
+		supported := "no"
+		if c.Supported {
+			supported = "yes"
+		}
+		fmt.Printf("%s\t%s\t%s\n", c.Name, supported, c.Detail)
+	}
+	return exitOK
+}
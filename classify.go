@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// specialRanges lists address ranges not covered by the classification methods of net.IP
+var specialRanges = []struct {
+	class   string
+	network *net.IPNet
+}{
+	{"cgnat", mustParseCIDR("100.64.0.0/10")},
+	{"documentation", mustParseCIDR("192.0.2.0/24")},
+	{"documentation", mustParseCIDR("198.51.100.0/24")},
+	{"documentation", mustParseCIDR("203.0.113.0/24")},
+	{"documentation", mustParseCIDR("2001:db8::/32")},
+	{"nat64", mustParseCIDR("64:ff9b::/96")},
+}
+
+// addressClasses are the classes classifyAddress assigns
+var addressClasses = []string{"unspecified", "loopback", "multicast", "link-local", "private", "ula", "cgnat", "documentation", "nat64", "global"}
+
+// addressClass describes what kind of address an ip is.
+type addressClass struct {
+
+	// Address is the classified address
+	Address string
+
+	// Family is either ipv4 or ipv6
+	Family string
+
+	// Class is one of unspecified, loopback, multicast, link-local, private, ula, cgnat, documentation, nat64
+	// or global
+	Class string
+
+	// Public is set for addresses routed on the internet
+	Public bool
+}
+
+// classifyAddress parses the address, which may be given in CIDR notation, and determines its class.
+func classifyAddress(address string) (*addressClass, error) {
+	parsed := net.ParseIP(address)
+	if parsed == nil {
+		var err error
+		if parsed, _, err = net.ParseCIDR(address); err != nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+	}
+	c := &addressClass{Address: parsed.String(), Family: "ipv6"}
+	if parsed.To4() != nil {
+		c.Family = "ipv4"
+	}
+	switch {
+	case parsed.IsUnspecified():
+		c.Class = "unspecified"
+	case parsed.IsLoopback():
+		c.Class = "loopback"
+	case parsed.IsMulticast():
+		c.Class = "multicast"
+	case parsed.IsLinkLocalUnicast():
+		c.Class = "link-local"
+	case parsed.IsPrivate() && c.Family == "ipv4":
+		c.Class = "private"
+	case parsed.IsPrivate():
+		c.Class = "ula"
+	}
+	for _, r := range specialRanges {
+		if c.Class == "" && r.network.Contains(parsed) {
+			c.Class = r.class
+		}
+	}
+	if c.Class == "" {
+		c.Class, c.Public = "global", true
+	}
+	return c, nil
+}
+
+// mustParseCIDR parses a network known to be valid.
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// parsePrefixOrAddr parses an address with or without network length.
+func parsePrefixOrAddr(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(address, address.BitLen()), nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "log/slog"
+
+// command is a subcommand of ips, args contains the verbs following the command name.
+// It returns the exit code the process should terminate with.
+type command func(logger *slog.Logger, args []string) int
+
+// commands maps the verbs accepted as first argument to their implementation, subsystems add theirs when registering
+var commands = map[string]command{
+	"capabilities": runCapabilities,
+	"config":       runConfig,
+	"env":          runEnv,
+	"fmt":          runFmt,
+	"prompt":       runPrompt,
+	"route-to":     runRouteTo,
+	"state":        runState,
+	"version":      runVersion,
+}
+
+// runCommand dispatches to the subcommand named by the first verb.
+func runCommand(logger *slog.Logger, verbs []string) int {
+	cmd, ok := commands[verbs[0]]
+	if !ok {
+		logger.Error("unknown command", "command", verbs[0])
+		return exitInternalError
+	}
+	return cmd(logger, verbs[1:])
+}
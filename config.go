@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	goflag "flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// configFile is the file options are read from, defaults to config in the ips user config directory
+	configFile string
+
+	// effective makes config show print the merged configuration instead of the config file
+	effective bool
+)
+
+// sources of option values, in order of precedence
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// repeatableOptions lists the options that may be set more than once
+var repeatableOptions = []string{"header", "template", "purpose", "sink", "route"}
+
+// optionSources maps options not set to their default to the source of their value
+var optionSources = make(map[string]string)
+
+type (
+
+	// configEntry is an option set in the config file.
+	configEntry struct {
+		name  string
+		value string
+		line  int
+
+		// column is the position of the value in the line
+		column int
+	}
+
+	// configError is a problem of the config file located by line and column.
+	configError struct {
+		file   string
+		line   int
+		column int
+		msg    string
+	}
+
+	// effectiveOption is an option as printed by config show -effective.
+	effectiveOption struct {
+		Name   string
+		Value  string
+		Source string
+	}
+)
+
+// Error returns the location followed by the message like compilers do.
+func (e *configError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.file, e.line, e.column, e.msg)
+}
+
+// configPath returns the location of the config file and whether it was given explicitly.
+func configPath() (string, bool) {
+	if configFile != "" {
+		return configFile, true
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, "ips", "config"), false
+}
+
+// envName returns the environment variable an option is read from.
+func envName(option string) string {
+	return "IPS_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// applyConfig records where the options were set and sets the options found in the config file, unless they were
+// given as flag or environment variable. A missing config file is only an error if it was given explicitly.
+func applyConfig() error {
+	goflag.Visit(func(f *goflag.Flag) {
+		optionSources[f.Name] = sourceFlag
+	})
+	// most flags read their default from the environment on definition already, setting all of them again makes
+	// flags with custom types like history-keep reachable as well
+	errs := make([]error, 0)
+	goflag.VisitAll(func(f *goflag.Flag) {
+		value, found := os.LookupEnv(envName(f.Name))
+		if !found || optionSources[f.Name] != "" {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err))
+		}
+		optionSources[f.Name] = sourceEnv
+	})
+	return errors.Join(append(errs, applyConfigFile())...)
+}
+
+// applyConfigFile sets the options found in the config file that were not given as flag or environment variable.
+func applyConfigFile() error {
+	path, explicit := configPath()
+	if path == "" {
+		return nil
+	}
+	entries, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	errs := []error{err}
+	for _, e := range entries {
+		if source := optionSources[e.name]; source == sourceFlag || source == sourceEnv {
+			continue
+		}
+		if err := e.apply(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		optionSources[e.name] = sourceFile
+	}
+	return errors.Join(errs...)
+}
+
+// reloadConfig reads the config file again, options given as flag or environment variable keep their value and
+// options removed from the file return to their default. Secrets are resolved again. If the file or the resulting
+// configuration rejected by validate is invalid, the previous configuration is restored.
+func reloadConfig(validate func() error) error {
+	previous := make(map[string][]string)
+	previousSources := make(map[string]string)
+	goflag.VisitAll(func(f *goflag.Flag) {
+		previous[f.Name] = optionValues(f)
+	})
+	for name, source := range optionSources {
+		previousSources[name] = source
+	}
+	previousRefs := make(map[string]string)
+	for name, ref := range secretRefs {
+		previousRefs[name] = ref
+	}
+
+	for name, source := range previousSources {
+		if source == sourceFile {
+			resetOption(goflag.Lookup(name))
+			delete(optionSources, name)
+			delete(secretRefs, name)
+		}
+	}
+	err := applyConfigFile()
+	if err == nil {
+		err = resolveSecretOptions()
+	}
+	if err == nil {
+		err = validate()
+	}
+	if err == nil {
+		return nil
+	}
+
+	goflag.VisitAll(func(f *goflag.Flag) {
+		resetOption(f)
+		for _, v := range previous[f.Name] {
+			_ = f.Value.Set(v)
+		}
+	})
+	optionSources, secretRefs = previousSources, previousRefs
+	return err
+}
+
+// optionValues returns the current values of an option, options that may be set more than once may have none.
+func optionValues(f *goflag.Flag) []string {
+	if v, ok := f.Value.(interface{ values() []string }); ok {
+		return v.values()
+	}
+	return []string{f.Value.String()}
+}
+
+// resetOption sets an option back to its default.
+func resetOption(f *goflag.Flag) {
+	if r, ok := f.Value.(interface{ reset() }); ok {
+		r.reset()
+		return
+	}
+	_ = f.Value.Set(f.DefValue)
+}
+
+// apply sets the option, an invalid value results in a configError located at the value.
+func (e *configEntry) apply(path string) error {
+	if err := goflag.Set(e.name, e.value); err != nil {
+		return &configError{file: path, line: e.line, column: e.column, msg: fmt.Sprintf("invalid value %q for %s: %v", e.value, e.name, err)}
+	}
+	return nil
+}
+
+// readConfig parses the config file. Every line sets an option given by its flag name, e.g. timeout = 10s, lines
+// starting with # are comments. Values may be quoted as in Go. All syntax errors, unknown options and options set
+// twice are returned, the values are not validated.
+func readConfig(path string) ([]*configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]*configEntry, 0)
+	errs := make([]error, 0)
+	setOn := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fail := func(column int, format string, args ...any) {
+			errs = append(errs, &configError{file: path, line: line, column: column, msg: fmt.Sprintf(format, args...)})
+		}
+		keyColumn := len(text) - len(strings.TrimLeft(text, " \t")) + 1
+
+		key, value, found := strings.Cut(text, "=")
+		if !found {
+			fail(keyColumn, "expected option = value")
+			continue
+		}
+		name := strings.TrimSpace(key)
+		valueColumn := len(key) + 2 + len(value) - len(strings.TrimLeft(value, " \t"))
+		value = strings.TrimSpace(value)
+
+		switch {
+		case name == "":
+			fail(keyColumn, "missing option name")
+			continue
+		case name == "config":
+			fail(keyColumn, "config cannot be set in the config file")
+			continue
+		case goflag.Lookup(name) == nil:
+			fail(keyColumn, "unknown option %q", name)
+			continue
+		case setOn[name] > 0 && !slices.Contains(repeatableOptions, name):
+			fail(keyColumn, "option %q is already set on line %d", name, setOn[name])
+			continue
+		}
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				fail(valueColumn, "invalid quoted value %s", value)
+				continue
+			}
+			value = unquoted
+		}
+		setOn[name] = line
+		entries = append(entries, &configEntry{name: name, value: value, line: line, column: valueColumn})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errors.Join(errs...)
+}
+
+// runConfig validates or prints the configuration:
+//
+//   - check [file] validates the config file, including the values of options overridden by flags
+//   - show prints the config file, with -effective the merged configuration of flags, environment and config file
+func runConfig(logger *slog.Logger, args []string) int {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "check":
+		path, _ := configPath()
+		if len(args) == 2 {
+			path = args[1]
+		}
+		return printChecks(logger, checkConfig(path))
+	case len(args) == 1 && args[0] == "show" && effective:
+		return printEffectiveConfig(logger)
+	case len(args) == 1 && args[0] == "show":
+		path, _ := configPath()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Error("could not read config file", "err", err)
+			return exitInternalError
+		}
+		fmt.Print(string(data))
+		return exitOK
+	}
+	logger.Error("usage: ips config check [file]|show [-effective]")
+	return exitInternalError
+}
+
+// checkConfig validates the config file and returns a failed check per problem ordered by line, or a single
+// passed one.
+func checkConfig(path string) []*check {
+	entries, err := readConfig(path)
+	errs := []error{err}
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		errs = joined.Unwrap()
+	}
+	for _, e := range entries {
+		errs = append(errs, e.apply(path))
+	}
+	line := func(err error) int {
+		var ce *configError
+		if errors.As(err, &ce) {
+			return ce.line
+		}
+		return 0
+	}
+	slices.SortStableFunc(errs, func(a, b error) int { return line(a) - line(b) })
+
+	checks := make([]*check, 0)
+	for _, err := range errs {
+		if err != nil {
+			checks = append(checks, &check{Name: "config", Detail: err.Error()})
+		}
+	}
+	if len(checks) == 0 {
+		checks = append(checks, &check{Name: "config", OK: true, Detail: path})
+	}
+	return checks
+}
+
+// printEffectiveConfig prints the value and source of every option. The text output is a valid config file, the
+// options are grouped by source as comments. Secrets are printed as the secret:// uri given.
+func printEffectiveConfig(logger *slog.Logger) int {
+	options := slices.DeleteFunc(effectiveOptions(), func(o *effectiveOption) bool {
+		// a repeatable option not set has no value
+		return o.Name == "config" || o.Name == "effective" || o.Value == "" && slices.Contains(repeatableOptions, o.Name)
+	})
+	if jsonOutput {
+		return printJSON(logger, options)
+	}
+	for _, source := range []string{sourceFlag, sourceEnv, sourceFile, sourceDefault} {
+		first := true
+		for _, o := range options {
+			if o.Source != source {
+				continue
+			}
+			if first {
+				fmt.Printf("# %s\n", source)
+				first = false
+			}
+			value := o.Value
+			if value != strings.TrimSpace(value) || strings.ContainsAny(value, "\"\n") {
+				value = strconv.Quote(value)
+			}
+			fmt.Printf("%s = %s\n", o.Name, value)
+		}
+	}
+	return exitOK
+}
+
+// effectiveOptions returns the value and source of every option ordered by name, options set more than once are
+// returned once per value. Secrets are returned as the secret:// uri given.
+func effectiveOptions() []*effectiveOption {
+	options := make([]*effectiveOption, 0)
+	goflag.VisitAll(func(f *goflag.Flag) {
+		source := optionSources[f.Name]
+		if source == "" {
+			source = sourceDefault
+		}
+		values := optionValues(f)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		if ref, ok := secretRefs[f.Name]; ok {
+			values = []string{ref}
+		}
+		for _, v := range values {
+			options = append(options, &effectiveOption{Name: f.Name, Value: v, Source: source})
+		}
+	})
+	return options
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(value string) []string {
+	result := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			result = append(result, element)
+		}
+	}
+	return result
+}
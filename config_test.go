@@ -0,0 +1,133 @@
+package main
+
+import (
+	goflag "flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// defineConfigFlags defines the options used by the config tests, main defines them in the binary. The values are
+// restored when the test finished.
+func defineConfigFlags(t *testing.T) {
+	t.Helper()
+	if goflag.Lookup("timeout") == nil {
+		goflag.DurationVar(&timeout, "timeout", 5*time.Second, "")
+		goflag.StringVar(&providerNames, "providers", "wtfismyip", "")
+		goflag.Var(headerValues{}, "header", "")
+		goflag.StringVar(&configFile, "config", "", "")
+	}
+	previous := make(map[string][]string)
+	for _, name := range []string{"timeout", "providers", "header", "config"} {
+		previous[name] = optionValues(goflag.Lookup(name))
+	}
+	t.Cleanup(func() {
+		for name, values := range previous {
+			f := goflag.Lookup(name)
+			resetOption(f)
+			for _, v := range values {
+				_ = f.Value.Set(v)
+			}
+		}
+	})
+}
+
+// writeConfig writes a config file to a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	defineConfigFlags(t)
+	path := writeConfig(t, "# public ip lookups\n\n  timeout = 10s  \nproviders=\"ipify, identme\"\n"+
+		"header = X-A: 1\n\theader = \"X-B: =2\"\n")
+	entries, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s|%s|%d:%d", e.name, e.value, e.line, e.column))
+	}
+	want := []string{"timeout|10s|3:13", "providers|ipify, identme|4:11", "header|X-A: 1|5:10", "header|X-B: =2|6:11"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := readConfig(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("reading a missing file returned %v", err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	defineConfigFlags(t)
+	tests := []struct {
+		name    string
+		content string
+		// want are the details of the failed checks without the path, none if the file is valid
+		want []string
+	}{
+		{name: "empty", content: ""},
+		{name: "comments only", content: "# nothing\n   # set\n"},
+		{name: "valid", content: "timeout = 10s\nproviders = ipify\nheader = X-A: 1\nheader = X-B: 2\n"},
+		{name: "quoted value", content: "providers = \"ipify, identme\"\n"},
+		{name: "missing equals", content: "timeout 10s\n", want: []string{":1:1: expected option = value"}},
+		{name: "missing name", content: "  = 10s\n", want: []string{":1:3: missing option name"}},
+		{name: "unknown option", content: "\ttimeouts = 10s\n", want: []string{`:1:2: unknown option "timeouts"`}},
+		{name: "config option", content: "config = other\n", want: []string{":1:1: config cannot be set in the config file"}},
+		{
+			name:    "set twice",
+			content: "timeout = 1s\n# again\ntimeout = 2s\n",
+			want:    []string{`:3:1: option "timeout" is already set on line 1`},
+		},
+		{name: "unterminated quote", content: "providers = \"ipify\n", want: []string{`:1:13: invalid quoted value "ipify`}},
+		{name: "invalid value", content: "timeout =  soon\n", want: []string{`:1:12: invalid value "soon" for timeout`}},
+		{name: "invalid repeated value", content: "header = X-A: 1\nheader = no colon\n", want: []string{`:2:10: invalid value "no colon" for header`}},
+		{
+			name:    "all problems ordered by line",
+			content: "timeout = soon\nunknown = 1\nproviders = a\nproviders = b\n",
+			want:    []string{`:1:11: invalid value "soon"`, `:2:1: unknown option "unknown"`, `:4:1: option "providers" is already set on line 3`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.content)
+			checks := checkConfig(path)
+			if len(tt.want) == 0 {
+				if len(checks) != 1 || !checks[0].OK || checks[0].Detail != path {
+					t.Fatalf("got %+v, want a passed check", checks[0])
+				}
+				return
+			}
+			if len(checks) != len(tt.want) {
+				t.Fatalf("got %d checks, want %d: %+v", len(checks), len(tt.want), checks)
+			}
+			for i, c := range checks {
+				if c.OK || !strings.HasPrefix(c.Detail, path+tt.want[i]) {
+					t.Errorf("check %d is %+v, want %s%s", i, c, path, tt.want[i])
+				}
+			}
+		})
+	}
+
+	missing := checkConfig(filepath.Join(t.TempDir(), "missing"))
+	if len(missing) != 1 || missing[0].OK {
+		t.Errorf("checking a missing file returned %+v", missing)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for option, want := range map[string]string{"timeout": "IPS_TIMEOUT", "cache-ttl": "IPS_CACHE_TTL", "l": "IPS_L"} {
+		if got := envName(option); got != want {
+			t.Errorf("envName(%q) = %s, want %s", option, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"runtime"
+)
+
+// containerMarkers map parts of the cgroup paths of pid 1 to the container runtime creating them
+var containerMarkers = []struct{ marker, kind string }{
+	{"kubepods", "kubernetes"},
+	{"libpod", "podman"},
+	{"docker", "docker"},
+	{"containerd", "containerd"},
+	{"lxc", "lxc"},
+	{"machine.slice", "systemd-nspawn"},
+}
+
+// detectContainer returns the container runtime ips runs in, container if the runtime is not known and an empty
+// string if it does not run in a container. Only Linux is checked, using the files runtimes place in containers,
+// the environment, the cgroups of pid 1 and whether pid 1 is a different process outside its pid namespace.
+func detectContainer() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if kind := os.Getenv("container"); kind != "" {
+		return kind
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if cgroup, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, m := range containerMarkers {
+			if bytes.Contains(cgroup, []byte(m.marker)) {
+				return m.kind
+			}
+		}
+	}
+	// the first line is e.g. "systemd (1, #threads: 1)", older kernels print the pid outside the namespace
+	if sched, err := os.ReadFile("/proc/1/sched"); err == nil {
+		line, _, _ := bytes.Cut(sched, []byte("\n"))
+		if _, pid, found := bytes.Cut(line, []byte("(")); found && !bytes.HasPrefix(pid, []byte("1,")) {
+			return "container"
+		}
+	}
+	return ""
+}
+
+// labelContainer marks the addresses of the interfaces as local to the network namespace of the container by the
+// container label, so they are not taken for the addresses of the host.
+func labelContainer(logger *slog.Logger, list ips) ips {
+	if kind := detectContainer(); kind != "" {
+		for _, i := range list {
+			if i.Labels == nil {
+				i.Labels = make(map[string]string)
+			}
+			i.Labels["container"] = kind
+		}
+	}
+	return list
+}
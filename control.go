@@ -0,0 +1,360 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+// hostSocket is the control socket of ips watch running on the host, mounted into the container to show the host
+// view in addition to the addresses of the container
+var hostSocket string
+
+// requests passed from the control socket to the watch loop
+const (
+	controlRefresh = "refresh"
+	controlReload  = "reload"
+)
+
+// controlMethods lists the requests accepted on the control socket
+var controlMethods = []string{"status", "dump", "seen", controlRefresh, "pause", "resume", controlReload}
+
+// controlSocket is the unix socket a running watch accepts control requests on, defaults to ips/ctl.sock in the
+// runtime directory or ctl.sock in the state directory
+var controlSocket string
+
+type (
+
+	// daemonControl connects the control socket with the watch loop.
+	daemonControl struct {
+		mu sync.Mutex
+
+		// current contains the addresses known to the watch loop
+		current ips
+
+		// paused is set while polling is suspended
+		paused bool
+
+		// requests asks the watch loop to poll or reload right away
+		requests chan string
+	}
+
+	// controlStatus is the answer to the status request.
+	controlStatus struct {
+		Pid       int
+		Started   time.Time
+		LastPoll  time.Time
+		LastError string `json:",omitempty"`
+		Paused    bool
+		Addresses int
+		Interval  string
+	}
+
+	// controlResponse is a JSON-RPC response as read by ips ctl.
+	controlResponse struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+)
+
+// control is set when the control socket is served, the watch loop reports to it
+var control *daemonControl
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "control",
+		commands: map[string]command{"ctl": runCtl},
+		flags: func() {
+			flag.BoolVar(&stdio, "stdio", false, "serve JSON-RPC on stdin and stdout for GUIs and editor plugins")
+			flag.StringVar(&hostSocket, "host-socket", "", "control socket of watch on the host mounted into a container, its addresses are shown as well")
+			flag.StringVar(&controlSocket, "control-socket", "", "unix socket watch accepts requests of ips ctl on, defaults to ips/ctl.sock in the runtime dir or ctl.sock in the state dir")
+		},
+	})
+}
+
+// controlSocketPath returns the location of the control socket.
+func controlSocketPath() (string, error) {
+	if controlSocket != "" {
+		return controlSocket, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ips", "ctl.sock"), nil
+	}
+	dir, err := stateDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ctl.sock"), nil
+}
+
+// serveControl accepts control requests on the control socket until ctx is cancelled. A socket in use by another
+// instance is left alone, a stale one is replaced. The socket is only accessible by the user running ips.
+func serveControl(ctx context.Context, logger *slog.Logger) error {
+	path, err := controlSocketPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is used by another instance", path)
+	}
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	control = &daemonControl{requests: make(chan string, 1)}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logger.Error("could not accept control connection", "err", err)
+				}
+				return
+			}
+			go control.serve(logger, conn)
+		}
+	}()
+	logger.Info("serving control socket", "path", path)
+	return nil
+}
+
+// serve answers the requests of a single client until it disconnects.
+func (c *daemonControl) serve(logger *slog.Logger, conn net.Conn) {
+	defer conn.Close()
+	rpc := newRPCConn(conn)
+	err := rpc.serve(logger, conn, func(method string, _ json.RawMessage) (any, *rpcError) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch method {
+		case "status":
+			status := &controlStatus{Pid: os.Getpid(), Paused: c.paused, Addresses: len(c.current), Interval: interval.String()}
+			health.mu.Lock()
+			status.Started, status.LastPoll = health.started, health.lastPoll
+			if health.lastErr != nil {
+				status.LastError = health.lastErr.Error()
+			}
+			health.mu.Unlock()
+			return status, nil
+		case "dump":
+			return c.current, nil
+		case "peers":
+			return responder.list(), nil
+		case controlRefresh:
+			guard.forget()
+			c.request(controlRefresh)
+		case "pause":
+			c.paused = true
+		case "resume":
+			c.paused = false
+			c.request(controlRefresh)
+		case controlReload:
+			c.request(controlReload)
+		default:
+			return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + method}
+		}
+		logger.Info("control request", "method", method)
+		return nil, nil
+	})
+	if err != nil {
+		logger.Debug("could not read control request", "err", err)
+	}
+}
+
+// request passes a request to the watch loop, a request still pending is replaced. The caller must hold the lock.
+func (c *daemonControl) request(r string) {
+	select {
+	case <-c.requests:
+	default:
+	}
+	c.requests <- r
+}
+
+// update records the addresses known after a poll.
+func (c *daemonControl) update(current ips) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = current
+}
+
+// isPaused reports whether polling is suspended.
+func (c *daemonControl) isPaused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// pending returns the channel of requests for the watch loop, nil if the control socket is not served.
+func (c *daemonControl) pending() <-chan string {
+	if c == nil {
+		return nil
+	}
+	return c.requests
+}
+
+// runCtl sends a request to a running watch and prints the answer:
+//
+//   - status prints the state of the watch loop
+//   - dump prints the addresses known to the watch loop like ips does, without collecting them
+//   - seen prints the addresses known to the watch loop with the time they were observed first and last, the
+//     newest first
+//   - refresh polls right away, bypassing the rate limit of the providers
+//   - pause and resume suspend and continue polling
+//   - reload reads the config file again like SIGHUP does
+func runCtl(logger *slog.Logger, args []string) int {
+	if len(args) != 1 || !slices.Contains(controlMethods, args[0]) {
+		logger.Error("usage: ips ctl status|dump|seen|refresh|pause|resume|reload")
+		return exitInternalError
+	}
+	method := args[0]
+	if method == "seen" {
+		// the sightings are part of the addresses dumped
+		method = "dump"
+	}
+	result, err := controlRequest(method)
+	if err != nil {
+		logger.Error("could not send control request", "err", err)
+		return exitInternalError
+	}
+
+	switch args[0] {
+	case "status":
+		var status controlStatus
+		if err := json.Unmarshal(result, &status); err != nil {
+			logger.Error("could not parse status", "err", err)
+			return exitInternalError
+		}
+		if jsonOutput {
+			return printJSON(logger, &status)
+		}
+		fmt.Printf("pid\t%d\n", status.Pid)
+		fmt.Printf("started\t%s\n", status.Started.Format(time.RFC3339))
+		fmt.Printf("last poll\t%s\n", status.LastPoll.Format(time.RFC3339))
+		if status.LastError != "" {
+			fmt.Printf("last error\t%s\n", status.LastError)
+		}
+		fmt.Printf("paused\t%t\n", status.Paused)
+		fmt.Printf("addresses\t%d\n", status.Addresses)
+		fmt.Printf("interval\t%s\n", status.Interval)
+	case "dump":
+		var list ips
+		if err := json.Unmarshal(result, &list); err != nil {
+			logger.Error("could not parse addresses", "err", err)
+			return exitInternalError
+		}
+		return printAddresses(logger, list, exitOK)
+	case "seen":
+		var list ips
+		if err := json.Unmarshal(result, &list); err != nil {
+			logger.Error("could not parse addresses", "err", err)
+			return exitInternalError
+		}
+		sort.SliceStable(list, func(a, b int) bool { return list[a].FirstSeen.After(list[b].FirstSeen) })
+		if jsonOutput {
+			return printJSON(logger, list)
+		}
+		fmt.Println("address\tinterface\tfirst seen\tlast seen")
+		for _, i := range list {
+			fmt.Printf("%s\t%s\t%s\t%s\n", i.Address, i.Interface, i.FirstSeen.Format(time.RFC3339), i.LastSeen.Format(time.RFC3339))
+		}
+	}
+	return exitOK
+}
+
+// controlRequest sends a request to the control socket and returns the result.
+func controlRequest(method string) (json.RawMessage, error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	return controlRequestAt(path, method)
+}
+
+// addHostAddresses appends the addresses known to the watch of the host with -host-socket set, their interfaces
+// prefixed by host. A host daemon not answering is logged only.
+func addHostAddresses(logger *slog.Logger, list ips) ips {
+	if hostSocket == "" {
+		return list
+	}
+	result, err := controlRequestAt(hostSocket, "dump")
+	if err != nil {
+		logger.Warn("could not get the addresses of the host", "err", err, "socket", hostSocket)
+		return list
+	}
+	var host ips
+	if err := json.Unmarshal(result, &host); err != nil {
+		logger.Warn("could not parse the addresses of the host", "err", err, "socket", hostSocket)
+		return list
+	}
+	for _, i := range host {
+		if !strings.HasPrefix(i.Interface, "host ") {
+			i.Interface = "host " + i.Interface
+		}
+		list = append(list, i)
+	}
+	return list
+}
+
+// controlRequestAt sends a request to the control socket at path and returns the result.
+func controlRequestAt(path, method string) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("no running watch found: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(&rpcRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method}); err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRPCMessage)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("connection closed without answer")
+	}
+	var resp controlResponse
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, errors.New(resp.Error.Message)
+	}
+	return resp.Result, nil
+}
@@ -0,0 +1,71 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// confirmWindow is the time a new public address has to be stable before a change is reported
+var confirmWindow time.Duration
+
+// publicDamper holds back changes of the public addresses until they were observed unchanged for the
+// confirmation window, so flapping mobile links do not produce a change for every hiccup.
+type publicDamper struct {
+
+	// window is the confirmation window, changes are reported immediately when it is not positive
+	window time.Duration
+
+	// initialized is set after the first observation was confirmed
+	initialized bool
+
+	// confirmed contains the public addresses reported last
+	confirmed ips
+
+	// candidate contains the public addresses waiting for confirmation
+	candidate ips
+
+	// since is the time the candidate was observed first
+	since time.Time
+
+	logger *slog.Logger
+}
+
+// apply returns current with its public addresses replaced by the confirmed ones while a change is pending.
+func (d *publicDamper) apply(current ips, now time.Time) ips {
+	if d.window <= 0 {
+		return current
+	}
+	public, local := splitPublic(current)
+	switch {
+	case !d.initialized:
+		d.initialized, d.confirmed = true, public
+		return current
+	case diff(d.confirmed, public) == nil:
+		d.candidate = nil
+		return current
+	case d.candidate == nil || diff(d.candidate, public) != nil:
+		d.candidate, d.since = public, now
+	}
+	if now.Sub(d.since) >= d.window {
+		d.confirmed, d.candidate = public, nil
+		return current
+	}
+	d.logger.Debug("public address change pending confirmation", "since", d.since, "window", d.window)
+	return slices.Concat(d.confirmed, local)
+}
+
+// splitPublic separates the public addresses in list from the local ones.
+func splitPublic(list ips) (public, local ips) {
+	public, local = make(ips, 0), make(ips, 0)
+	for _, i := range list {
+		if i.isPublic() {
+			public = append(public, i)
+		} else {
+			local = append(local, i)
+		}
+	}
+	return public, local
+}
@@ -0,0 +1,151 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// dbusSupport names the buses the service can be offered on
+const dbusSupport = "session and system bus"
+
+func init() {
+	registerSubsystem(&subsystem{name: "dbus", commands: map[string]command{"dbus": runDBus}})
+}
+
+const (
+	// dbusName is the well-known bus name and interface of the service
+	dbusName = "org.saschaandres.Ips"
+
+	// dbusPath is the object exposing the interface
+	dbusPath = dbus.ObjectPath("/org/saschaandres/Ips")
+)
+
+// dbusIntrospection describes the interface for clients like d-feet or busctl
+const dbusIntrospection = `
+<node>
+	<interface name="` + dbusName + `">
+		<method name="Addresses">
+			<arg direction="out" type="a(ss)" name="addresses"/>
+		</method>
+		<method name="PublicIP">
+			<arg direction="in" type="s" name="family"/>
+			<arg direction="out" type="s" name="address"/>
+		</method>
+		<signal name="Changed">
+			<arg type="a(ss)" name="added"/>
+			<arg type="a(ss)" name="removed"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+type (
+
+	// dbusAddress is an address as sent over the bus, marshalled as (ss).
+	dbusAddress struct {
+		Address   string
+		Interface string
+	}
+
+	// dbusService answers method calls from the addresses observed by the watch loop, so clients never wait for
+	// the network.
+	dbusService struct {
+		mu      sync.Mutex
+		current ips
+	}
+)
+
+// runDBus exposes the addresses selected by the p and a flags as D-Bus service on the session bus, or the system
+// bus if "system" is given. Changes found by the watch loop are emitted as Changed signal, so desktop applets can
+// react without polling.
+func runDBus(logger *slog.Logger, args []string) int {
+	if len(args) > 1 || len(args) == 1 && args[0] != "session" && args[0] != "system" {
+		logger.Error("usage: ips dbus [session|system]")
+		return exitInternalError
+	}
+	connect := dbus.ConnectSessionBus
+	if len(args) == 1 && args[0] == "system" {
+		connect = dbus.ConnectSystemBus
+	}
+	conn, err := connect()
+	if err != nil {
+		logger.Error("could not connect to bus", "err", err)
+		return exitInternalError
+	}
+	defer conn.Close()
+
+	s := &dbusService{}
+	if err := conn.Export(s, dbusPath, dbusName); err != nil {
+		logger.Error("could not export service", "err", err)
+		return exitInternalError
+	}
+	if err := conn.Export(introspect.Introspectable(dbusIntrospection), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		logger.Error("could not export introspection", "err", err)
+		return exitInternalError
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		logger.Error("could not request name", "err", err, "name", dbusName)
+		return exitInternalError
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		logger.Error("name already taken", "name", dbusName)
+		return exitInternalError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watch(ctx, logger, func(logger *slog.Logger, c *change) int {
+		s.apply(c)
+		if err := conn.Emit(dbusPath, dbusName+".Changed", toDBus(c.Added), toDBus(c.Removed)); err != nil {
+			logger.Warn("could not emit signal", "err", err)
+		}
+		return exitOK
+	})
+}
+
+// Addresses returns all addresses currently known.
+func (s *dbusService) Addresses() ([]dbusAddress, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return toDBus(s.current), nil
+}
+
+// PublicIP returns the public address of the family, empty if it is unknown.
+func (s *dbusService) PublicIP(family string) (string, *dbus.Error) {
+	if family != "ipv4" && family != "ipv6" {
+		return "", dbus.MakeFailedError(fmt.Errorf("unknown family %q", family))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.current {
+		if i.isPublic() && i.family() == family {
+			return i.Address, nil
+		}
+	}
+	return "", nil
+}
+
+// apply updates the known addresses by a change.
+func (s *dbusService) apply(c *change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = applyChange(s.current, c)
+}
+
+// toDBus converts addresses to their bus representation.
+func toDBus(list ips) []dbusAddress {
+	result := make([]dbusAddress, 0, len(list))
+	for _, i := range list {
+		result = append(result, dbusAddress{Address: i.Address, Interface: i.Interface})
+	}
+	return result
+}
@@ -0,0 +1,18 @@
+//go:build !linux && (!ips_minimal || ips_full)
+
+package main
+
+import "log/slog"
+
+// dbusSupport is empty, there is no D-Bus on this platform
+const dbusSupport = ""
+
+func init() {
+	registerSubsystem(&subsystem{name: "dbus", commands: map[string]command{"dbus": runDBus}})
+}
+
+// runDBus is only supported on linux.
+func runDBus(logger *slog.Logger, _ []string) int {
+	logger.Error("the dbus command is not supported on this platform")
+	return exitInternalError
+}
@@ -0,0 +1,84 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// debounceMaxPeriods limits a batch to this many quiet periods, so a link flapping for hours is still reported
+const debounceMaxPeriods = 10
+
+// debounce is the quiet period sinks wait for before a batch of changes is delivered, 0 delivers every change
+var debounce time.Duration
+
+// batch collects changes while the event bus waits for a quiet period.
+type batch struct {
+
+	// pending is the net change of the batch, nil while none is waiting
+	pending *change
+
+	// started is the time the first change of the batch arrived
+	started time.Time
+
+	timer *time.Timer
+}
+
+// add merges c into the pending change and returns the time to wait for before the batch is delivered.
+func (b *batch) add(c *change, now time.Time) time.Duration {
+	if b.pending == nil {
+		b.pending = &change{Time: c.Time, Added: slices.Clone(c.Added), Removed: slices.Clone(c.Removed), Prefix: c.Prefix, previous: c.previous}
+		b.started = now
+	} else {
+		mergeChange(b.pending, c)
+	}
+	wait := debounce
+	if deadline := b.started.Add(debounceMaxPeriods * debounce); now.Add(wait).After(deadline) {
+		wait = deadline.Sub(now)
+	}
+	return wait
+}
+
+// take returns the pending change and starts a new batch, nil if the changes of the batch cancelled out.
+func (b *batch) take() *change {
+	c := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if c == nil || (len(c.Added) == 0 && len(c.Removed) == 0 && c.Prefix == nil) {
+		return nil
+	}
+	return c
+}
+
+// mergeChange merges c into the earlier change into, so it describes the difference between the addresses before
+// into and after c. Addresses added and removed again within the batch, or the other way round, cancel out.
+func mergeChange(into, c *change) {
+	into.Time = c.Time
+	for _, i := range c.Removed {
+		if index := slices.IndexFunc(into.Added, func(a *ip) bool { return a.key() == i.key() }); index >= 0 {
+			into.Added = slices.Delete(into.Added, index, index+1)
+			continue
+		}
+		into.Removed = append(into.Removed, i)
+	}
+	for _, i := range c.Added {
+		if index := slices.IndexFunc(into.Removed, func(r *ip) bool { return r.key() == i.key() }); index >= 0 {
+			into.Removed = slices.Delete(into.Removed, index, index+1)
+			continue
+		}
+		into.Added = append(into.Added, i)
+	}
+	if c.Prefix != nil {
+		if into.Prefix == nil {
+			into.Prefix = c.Prefix
+		} else {
+			into.Prefix = &prefixChange{Previous: into.Prefix.Previous, Current: c.Prefix.Current}
+		}
+	}
+	if into.Prefix != nil && slices.Equal(into.Prefix.Previous, into.Prefix.Current) {
+		into.Prefix = nil
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// notifyEvents is the comma separated list of change types announced using desktop notifications in watch mode
+var notifyEvents string
+
+// desktopSink announces changes using native desktop notifications, one per kind of change: the public address,
+// the addresses of the interfaces and the delegated prefix. The initial addresses are not announced.
+type desktopSink struct {
+	logger *slog.Logger
+}
+
+// send shows one notification per kind of change contained in the change, or a single one showing the payload
+// rendered by the template of the sink.
+func (d *desktopSink) send(c *change, payload []byte) error {
+	if c.previous == nil {
+		return nil
+	}
+	if payload != nil {
+		return notifyDesktop("IP addresses changed", string(payload))
+	}
+	public, local := make([]string, 0), make([]string, 0)
+	for _, i := range c.Removed {
+		if i.isPublic() {
+			public = append(public, "- "+i.Address)
+			continue
+		}
+		local = append(local, "- "+i.Address+" "+i.Interface)
+	}
+	for _, i := range c.Added {
+		if i.isPublic() {
+			public = append(public, "+ "+i.Address)
+			continue
+		}
+		local = append(local, "+ "+i.Address+" "+i.Interface)
+	}
+
+	if len(public) > 0 {
+		d.notify("Public IP changed", public)
+	}
+	if len(local) > 0 {
+		d.notify("Interface addresses changed", local)
+	}
+	if c.Prefix != nil {
+		d.notify("Delegated prefix changed", []string{strings.Join(c.Prefix.Previous, ",") + " -> " + strings.Join(c.Prefix.Current, ",")})
+	}
+	return nil
+}
+
+// close does nothing, notifications hold no connection.
+func (d *desktopSink) close() {}
+
+// notify shows a notification with one line per element of body.
+func (d *desktopSink) notify(title string, body []string) {
+	if err := notifyDesktop(title, strings.Join(body, "\n")); err != nil {
+		d.logger.Warn("could not show notification", "err", err, "title", title)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import "github.com/sascha-andres/reuse/flag"
+
+// runs is the number of queries per provider when benchmarking and of connections per target in quality
+var runs uint
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "diagnostics",
+		commands: map[string]command{
+			"bench-providers": runBenchProviders,
+			"dnscheck":        runDNSCheck,
+			"he":              runHappyEyeballs,
+			"multihome":       runMultihome,
+			"quality":         runQuality,
+			"reachable":       runReachable,
+			"trace-public":    runTracePublic,
+			"uplinks":         runUplinks,
+			"v6check":         runV6Check,
+			"vpn-check":       runVPNCheck,
+		},
+		flags: func() {
+			flag.StringVar(&reachableURL, "reachable-url", "", "server connecting back in reachable, e.g. another host running ips serve")
+			flag.UintVar(&runs, "runs", 5, "number of queries per provider when benchmarking, connections per target in quality")
+			flag.StringVar(&qualityTargets, "quality-targets", "", "comma separated host:port targets of quality, defaults to anycast DNS resolvers")
+			flag.StringVar(&throughputURL, "throughput-url", "", "url downloaded by quality to measure the throughput, skipped if empty")
+			flag.StringVar(&expectASN, "expect-asn", "", "comma separated autonomous systems the public ip has to belong to in vpn-check")
+			flag.StringVar(&expectInterface, "expect-interface", "", "interface traffic has to egress through in vpn-check")
+		},
+		urls: []*string{&reachableURL, &throughputURL},
+	})
+}
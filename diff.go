@@ -0,0 +1,138 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// diffFormat selects how changes are rendered by diff and watch: text, json or json-patch
+	diffFormat string
+
+	// exitCode makes diff exit with exitChanged if the address sets differ, like git diff --exit-code
+	exitCode bool
+)
+
+// patchOperation is a single operation of a JSON Patch (RFC 6902) document.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value *ip    `json:"value,omitempty"`
+}
+
+// runDiff compares the address list saved using ips -json with the current addresses, or two saved lists, and
+// prints the change in the format selected by diff-format.
+func runDiff(logger *slog.Logger, args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		logger.Error("usage: ips diff <before.json> [after.json]")
+		return exitInternalError
+	}
+	if !validDiffFormat() {
+		logger.Error("unknown diff format", "format", diffFormat)
+		return exitInternalError
+	}
+	before, err := loadSnapshot(args[0])
+	if err != nil {
+		logger.Error("could not read snapshot", "err", err, "file", args[0])
+		return exitInternalError
+	}
+	var after ips
+	if len(args) == 2 {
+		after, err = loadSnapshot(args[1])
+		if err != nil {
+			logger.Error("could not read snapshot", "err", err, "file", args[1])
+			return exitInternalError
+		}
+	} else {
+		after, err = getIpAddresses(logger)
+		if err != nil && (!errors.Is(err, ErrNoPublicProvider) || len(after) == 0) {
+			logger.Error("could not get ip addresses", "err", err)
+			return exitInternalError
+		}
+		if after, err = applyPlugins(logger, after); err != nil {
+			logger.Error("could not apply plugins", "err", err)
+			return exitInternalError
+		}
+	}
+
+	c := diff(before, after)
+	changed := c != nil
+	if c == nil {
+		c = &change{Time: time.Now(), previous: before}
+	}
+	c.Prefix = detectPrefixChange(before, after)
+	if code := printChange(logger, c); code != exitOK {
+		return code
+	}
+	if exitCode && (changed || c.Prefix != nil) {
+		return exitChanged
+	}
+	return exitOK
+}
+
+// loadSnapshot reads an address list written by ips -json.
+func loadSnapshot(name string) (ips, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var list ips
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// validDiffFormat reports whether diff-format names a known format.
+func validDiffFormat() bool {
+	return slices.Contains([]string{"text", "json", "json-patch"}, diffFormat)
+}
+
+// jsonPatch returns the operations transforming the previous address list into the current one. Removals come
+// first, from the end of the list, so the indices of the remaining operations stay valid.
+func jsonPatch(c *change) []patchOperation {
+	removed := make(map[string]bool, len(c.Removed))
+	for _, i := range c.Removed {
+		removed[i.key()] = true
+	}
+	patch := make([]patchOperation, 0, len(c.Removed)+len(c.Added))
+	for idx := len(c.previous) - 1; idx >= 0; idx-- {
+		if removed[c.previous[idx].key()] {
+			patch = append(patch, patchOperation{Op: "remove", Path: "/" + strconv.Itoa(idx)})
+		}
+	}
+	for _, i := range c.Added {
+		patch = append(patch, patchOperation{Op: "add", Path: "/-", Value: i})
+	}
+	return patch
+}
+
+// printChange prints a change in the format selected by diff-format. The text format prints one line per address
+// prefixed with + or -, json the change itself.
+func printChange(logger *slog.Logger, c *change) int {
+	switch {
+	case diffFormat == "json" || (jsonOutput && diffFormat == "text"):
+		return printJSON(logger, c)
+	case diffFormat == "json-patch":
+		return printJSON(logger, jsonPatch(c))
+	}
+	for _, i := range c.Removed {
+		fmt.Printf("-\t%s\n", i)
+	}
+	for _, i := range c.Added {
+		fmt.Printf("+\t%s\n", i)
+	}
+	if c.Prefix != nil {
+		fmt.Printf("prefix\t%s -> %s\n", strings.Join(c.Prefix.Previous, ","), strings.Join(c.Prefix.Current, ","))
+	}
+	return exitOK
+}
@@ -0,0 +1,154 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyPatch applies the operations of a JSON Patch to an address list like an RFC 6902 implementation does.
+func applyPatch(t *testing.T, list ips, patch []patchOperation) ips {
+	t.Helper()
+	result := slices.Clone(list)
+	for _, op := range patch {
+		switch op.Op {
+		case "remove":
+			idx, err := strconv.Atoi(strings.TrimPrefix(op.Path, "/"))
+			if err != nil || idx < 0 || idx >= len(result) {
+				t.Fatalf("invalid path %s for %d addresses", op.Path, len(result))
+			}
+			result = slices.Delete(result, idx, idx+1)
+		case "add":
+			if op.Path != "/-" || op.Value == nil {
+				t.Fatalf("invalid add %+v", op)
+			}
+			result = append(result, op.Value)
+		default:
+			t.Fatalf("unexpected operation %s", op.Op)
+		}
+	}
+	return result
+}
+
+// addressKeys returns the keys of the addresses of a list.
+func addressKeys(list ips) []string {
+	result := make([]string, 0, len(list))
+	for _, i := range list {
+		result = append(result, i.key())
+	}
+	return result
+}
+
+func TestJSONPatch(t *testing.T) {
+	eth0v4 := &ip{Address: "192.0.2.10/24", Interface: "eth0"}
+	eth0v6 := &ip{Address: "2001:db8::10/64", Interface: "eth0"}
+	wg0 := &ip{Address: "10.8.0.2/24", Interface: "wg0"}
+	public := &ip{Address: "203.0.113.7", Interface: "public IPV4"}
+	moved := &ip{Address: "192.0.2.10/24", Interface: "eth1"}
+	tests := []struct {
+		name   string
+		before ips
+		after  ips
+		want   string
+	}{
+		{
+			name:   "unchanged",
+			before: ips{eth0v4, eth0v6},
+			after:  ips{eth0v6, eth0v4},
+			want:   `[]`,
+		},
+		{
+			name:   "added",
+			before: ips{eth0v4},
+			after:  ips{eth0v4, wg0},
+			want:   `[{"op":"add","path":"/-","value":{"Address":"10.8.0.2/24","Interface":"wg0"}}]`,
+		},
+		{
+			name:   "removed from the end first",
+			before: ips{eth0v4, wg0, eth0v6, public},
+			after:  ips{wg0},
+			want:   `[{"op":"remove","path":"/3"},{"op":"remove","path":"/2"},{"op":"remove","path":"/0"}]`,
+		},
+		{
+			name:   "address moved to another interface",
+			before: ips{eth0v4, eth0v6},
+			after:  ips{eth0v6, moved},
+			want:   `[{"op":"remove","path":"/0"},{"op":"add","path":"/-","value":{"Address":"192.0.2.10/24","Interface":"eth1"}}]`,
+		},
+		{
+			name:   "first snapshot",
+			before: nil,
+			after:  ips{eth0v4},
+			want:   `[{"op":"add","path":"/-","value":{"Address":"192.0.2.10/24","Interface":"eth0"}}]`,
+		},
+		{
+			name:   "everything removed",
+			before: ips{eth0v4, eth0v6},
+			after:  ips{},
+			want:   `[{"op":"remove","path":"/1"},{"op":"remove","path":"/0"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := diff(tt.before, tt.after)
+			if c == nil {
+				c = &change{previous: tt.before}
+			}
+			patch := jsonPatch(c)
+			data, err := json.Marshal(patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+			got, want := addressKeys(applyPatch(t, tt.before, patch)), addressKeys(tt.after)
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("patch results in %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &ip{Address: "192.0.2.1/24", Interface: "eth0"}
+	b := &ip{Address: "192.0.2.2/24", Interface: "eth0"}
+	tests := []struct {
+		name        string
+		before      ips
+		after       ips
+		wantNil     bool
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "same", before: ips{a, b}, after: ips{b, a}, wantNil: true},
+		{name: "both empty", before: ips{}, after: ips{}, wantNil: true},
+		{name: "added", before: ips{a}, after: ips{a, b}, wantAdded: []string{b.key()}},
+		{name: "removed", before: ips{a, b}, after: ips{b}, wantRemoved: []string{a.key()}},
+		{name: "replaced", before: ips{a}, after: ips{b}, wantAdded: []string{b.key()}, wantRemoved: []string{a.key()}},
+		{name: "labels are ignored", before: ips{a}, after: ips{{Address: a.Address, Interface: a.Interface, Labels: map[string]string{"x": "y"}}}, wantNil: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := diff(tt.before, tt.after)
+			if tt.wantNil {
+				if c != nil {
+					t.Fatalf("got %+v, want no change", c)
+				}
+				return
+			}
+			if c == nil {
+				t.Fatal("got no change")
+			}
+			if !slices.Equal(addressKeys(c.Added), tt.wantAdded) || !slices.Equal(addressKeys(c.Removed), tt.wantRemoved) {
+				t.Errorf("added %q and removed %q, want %q and %q", addressKeys(c.Added), addressKeys(c.Removed), tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// discoverDomain is the domain searched for an organization internal provider
+var discoverDomain string
+
+// discoverProviders looks up the _ips._tcp SRV records of the domain and returns a provider for every target,
+// ordered by priority and weight. An optional TXT record on the same name may set the "scheme" (default https)
+// and "path" (default /) used to query the targets, e.g. "scheme=http path=/ip".
+func discoverProviders(domain string) ([]*provider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "ips", "tcp", domain)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, path := "https", "/"
+	txt, err := net.DefaultResolver.LookupTXT(ctx, "_ips._tcp."+domain)
+	if err != nil {
+		slog.Debug("no txt record for discovered provider", "err", err, "domain", domain)
+	}
+	for _, record := range txt {
+		for _, field := range strings.Fields(record) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "scheme":
+				scheme = value
+			case "path":
+				path = value
+			}
+		}
+	}
+
+	result := make([]*provider, 0, len(records))
+	for _, r := range records {
+		target := strings.TrimSuffix(r.Target, ".")
+		url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(target, strconv.Itoa(int(r.Port))), path)
+		// the organization points to the provider explicitly, so it is not a third party
+		trustURL(url)
+		result = append(result, &provider{
+			Name: "srv:" + target,
+			URLs: map[string]string{"any": url},
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxDNSMessage is the largest DNS message, its length is sent as 16 bit integer on stream connections
+const maxDNSMessage = 65535
+
+// dnsServer is the resolver all DNS lookups are sent to encrypted, tls://host[:port] for DNS over TLS or an https
+// url for DNS over HTTPS. The system resolver is used if empty.
+var dnsServer string
+
+// bootstrapResolver resolves the host name of dnsServer itself using the system resolver
+var bootstrapResolver = &net.Resolver{}
+
+// dohConn carries the DNS messages the go resolver writes to a stream connection as DNS over HTTPS requests
+// (RFC 8484). Every message is sent as a POST request, the answers are buffered until read.
+type dohConn struct {
+	url    string
+	client *http.Client
+
+	// query collects the length prefixed messages written
+	query bytes.Buffer
+
+	// answer holds the length prefixed answers not read yet
+	answer bytes.Buffer
+
+	deadline time.Time
+}
+
+// readName reads the possibly compressed name at offset and returns it fully qualified together with the offset
+// following it.
+func readName(msg []byte, offset int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("name truncated")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("invalid name compression")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("label truncated")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// setupResolver sends all DNS lookups of the tool to dnsServer by replacing the default resolver, as every lookup
+// and every dialer uses it. With an audit log the resolvers are replaced by ones recording the lookups.
+func setupResolver() error {
+	if auditLog != "" {
+		bootstrapResolver = auditedResolver(bootstrapResolver)
+		defer func() { net.DefaultResolver = auditedResolver(net.DefaultResolver) }()
+	}
+	if dnsServer == "" {
+		return nil
+	}
+	u, err := url.Parse(dnsServer)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("missing host in dns server %s", dnsServer)
+	}
+	dialer := &net.Dialer{Timeout: timeout, Resolver: bootstrapResolver}
+
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	switch u.Scheme {
+	case "tls":
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), "853")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return tlsDialer.DialContext(ctx, "tcp", address)
+		}
+	case "https":
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialer.DialContext
+		client := newHTTPClient(transport)
+		dial = func(_ context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{url: dnsServer, client: client}, nil
+		}
+	default:
+		return fmt.Errorf("unsupported dns server %s, expected tls://host[:port] or an https url", dnsServer)
+	}
+	net.DefaultResolver = &net.Resolver{PreferGo: true, Dial: dial}
+	return nil
+}
+
+// Write sends every complete message written as request and buffers the answer.
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.query.Write(b)
+	for c.query.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.query.Bytes()))
+		if c.query.Len() < 2+size {
+			break
+		}
+		c.query.Next(2)
+		answer, err := c.exchange(c.query.Next(size))
+		if err != nil {
+			return 0, err
+		}
+		_ = binary.Write(&c.answer, binary.BigEndian, uint16(len(answer)))
+		c.answer.Write(answer)
+	}
+	return len(b), nil
+}
+
+// exchange posts a single message to the server and returns its answer.
+func (c *dohConn) exchange(msg []byte) ([]byte, error) {
+	ctx := context.Background()
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns server %s answered %s", c.url, resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessage+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) > maxDNSMessage {
+		return nil, fmt.Errorf("dns server %s answered with more than %d bytes", c.url, maxDNSMessage)
+	}
+	return answer, nil
+}
+
+// Read returns the buffered answers.
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.answer.Read(b)
+}
+
+// Close does nothing, the http client keeps its connections for the next lookup.
+func (c *dohConn) Close() error {
+	return nil
+}
+
+// LocalAddr is not known, the connection is made by the http client.
+func (c *dohConn) LocalAddr() net.Addr {
+	return nil
+}
+
+// RemoteAddr is not known, the connection is made by the http client.
+func (c *dohConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+// SetDeadline limits the time the following requests may take.
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// SetReadDeadline does nothing, answers are read while writing.
+func (c *dohConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline limits the time the following requests may take.
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// framed prefixes every message with its length like DNS over tcp does.
+func framed(messages ...string) []byte {
+	var out []byte
+	for _, m := range messages {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(m)))
+		out = append(out, m...)
+	}
+	return out
+}
+
+func TestDohConnWrite(t *testing.T) {
+	requests := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "not a dns message", http.StatusUnsupportedMediaType)
+			return
+		}
+		requests = append(requests, string(body))
+		switch string(body) {
+		case "fail":
+			http.Error(w, "refused", http.StatusBadGateway)
+		case "large":
+			_, _ = w.Write(bytes.Repeat([]byte{'a'}, maxDNSMessage+1))
+		default:
+			_, _ = w.Write([]byte("answer " + string(body)))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		// writes are the chunks written in order
+		writes       [][]byte
+		wantRequests []string
+		wantAnswers  []byte
+		wantErr      string
+	}{
+		{
+			name:         "single message",
+			writes:       [][]byte{framed("query")},
+			wantRequests: []string{"query"},
+			wantAnswers:  framed("answer query"),
+		},
+		{
+			name:         "messages in one write",
+			writes:       [][]byte{framed("a", "aaaa")},
+			wantRequests: []string{"a", "aaaa"},
+			wantAnswers:  framed("answer a", "answer aaaa"),
+		},
+		{
+			name:         "message split in the length",
+			writes:       [][]byte{framed("split")[:1], framed("split")[1:]},
+			wantRequests: []string{"split"},
+			wantAnswers:  framed("answer split"),
+		},
+		{
+			name:         "message split in the body",
+			writes:       [][]byte{framed("split")[:4], framed("split")[4:]},
+			wantRequests: []string{"split"},
+			wantAnswers:  framed("answer split"),
+		},
+		{
+			name:         "incomplete message",
+			writes:       [][]byte{framed("incomplete")[:6]},
+			wantRequests: []string{},
+		},
+		{
+			name:         "empty message",
+			writes:       [][]byte{framed("")},
+			wantRequests: []string{""},
+			wantAnswers:  framed("answer "),
+		},
+		{
+			name:         "server failure",
+			writes:       [][]byte{framed("fail")},
+			wantRequests: []string{"fail"},
+			wantErr:      "502",
+		},
+		{
+			name:         "answer too large",
+			writes:       [][]byte{framed("large")},
+			wantRequests: []string{"large"},
+			wantErr:      "more than",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = requests[:0]
+			c := &dohConn{url: server.URL, client: server.Client()}
+			var err error
+			for _, chunk := range tt.writes {
+				var n int
+				if n, err = c.Write(chunk); err != nil {
+					break
+				}
+				if n != len(chunk) {
+					t.Errorf("wrote %d of %d bytes", n, len(chunk))
+				}
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(requests, "|") != strings.Join(tt.wantRequests, "|") || len(requests) != len(tt.wantRequests) {
+				t.Errorf("sent %q, want %q", requests, tt.wantRequests)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			answers, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(answers, tt.wantAnswers) {
+				t.Errorf("read %q, want %q", answers, tt.wantAnswers)
+			}
+		})
+	}
+}
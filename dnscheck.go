@@ -0,0 +1,102 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+// runDNSCheck resolves the A and AAAA records of the names given as arguments, or the fully qualified name of
+// the host, and compares them to the local and public addresses. Records pointing to none of them are reported
+// as stale, e.g. after a re-IP the DNS entries were forgotten. Exits with exitCheckFailed if a record is stale
+// or a name can't be resolved.
+func runDNSCheck(logger *slog.Logger, args []string) int {
+	names := args
+	if len(names) == 0 {
+		name, err := hostFQDN()
+		if err != nil {
+			logger.Error("could not determine host name", "err", err)
+			return exitInternalError
+		}
+		names = []string{name}
+	}
+
+	owned, err := ownedAddresses(logger)
+	if err != nil {
+		logger.Error("could not get ip addresses", "err", err)
+		return exitInternalError
+	}
+
+	checks := make([]*check, 0)
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", name)
+		cancel()
+		if err != nil {
+			checks = append(checks, &check{Name: name, Detail: err.Error()})
+			continue
+		}
+		for _, a := range addrs {
+			c := &check{Name: name, Detail: a.String() + " is stale"}
+			if owner, ok := owned[a.String()]; ok {
+				c.OK, c.Detail = true, a.String()+" on "+owner
+			}
+			checks = append(checks, c)
+		}
+	}
+	return printChecks(logger, checks)
+}
+
+// hostFQDN returns the fully qualified name of the host. If the host name is not qualified, the canonical name
+// the resolver returns for it is used.
+func hostFQDN() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(hostname, ".") {
+		return hostname, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, hostname)
+	if err != nil {
+		return hostname, nil
+	}
+	return strings.TrimSuffix(cname, "."), nil
+}
+
+// ownedAddresses maps the addresses of all interfaces and, unless offline or with no-external set, the public
+// addresses to where they are found. Public lookups failing are logged only, as the host may lack a family.
+func ownedAddresses(logger *slog.Logger) (map[string]string, error) {
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]string)
+	for _, i := range local {
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil {
+			continue
+		}
+		owned[address.String()] = i.Interface
+	}
+	if offline || noExternal {
+		return owned, nil
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		public, err := getPublicIp(family)
+		if err != nil {
+			logger.Debug("could not get public ip", "err", err, "family", family)
+			continue
+		}
+		if address := net.ParseIP(public.Address); address != nil {
+			owned[address.String()] = public.Interface
+		}
+	}
+	return owned, nil
+}
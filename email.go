@@ -0,0 +1,148 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailSink mails changes using SMTP.
+type emailSink struct {
+	// address is the host and port of the mail server, implicit is set for smtps
+	address  string
+	host     string
+	implicit bool
+
+	user, password string
+
+	from, subject string
+	to            []string
+}
+
+// newEmailSink creates a sink mailing to the addresses of the to option using the server of the target of spec,
+// smtp://[user:password@]host[:port] using STARTTLS if offered or smtps for TLS. The target may be a secret:// uri.
+func newEmailSink(_ *slog.Logger, spec *sinkSpec) (sink, error) {
+	target, err := resolveSecret(spec.target)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	e := &emailSink{host: u.Hostname(), to: splitList(spec.options["to"]), from: spec.options["from"], subject: spec.options["subject"]}
+	port := "587"
+	switch u.Scheme {
+	case "smtp":
+	case "smtps":
+		e.implicit, port = true, "465"
+	default:
+		return nil, fmt.Errorf("unsupported email scheme %q", u.Scheme)
+	}
+	if e.host == "" {
+		return nil, errors.New("email target needs a host")
+	}
+	if len(e.to) == 0 {
+		return nil, errors.New("email sink needs the to option")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	e.address = net.JoinHostPort(e.host, port)
+	if u.User != nil {
+		e.user = u.User.Username()
+		e.password, _ = u.User.Password()
+	}
+	hostname, _ := os.Hostname()
+	if e.from == "" {
+		e.from = "ips@" + hostname
+	}
+	if e.subject == "" {
+		e.subject = "IP addresses of " + hostname + " changed"
+	}
+	return e, nil
+}
+
+// send mails the payload or a summary of the change listing removed and added addresses like diff, with dry-run
+// set the mail is printed instead.
+func (e *emailSink) send(c *change, payload []byte) error {
+	if payload == nil {
+		payload = []byte(changeSummary(c))
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", e.from, strings.Join(e.to, ", "), e.subject, c.Time.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(string(payload), "\r\n", "\n"), "\n", "\r\n"))
+	if dryRun {
+		fmt.Printf("would mail %s using %s\n%s\n", strings.Join(e.to, ","), e.address, message.String())
+		return nil
+	}
+	start := time.Now()
+	err := e.mail(message.Bytes())
+	audit("smtp", e.address, start, "", err)
+	return err
+}
+
+// mail delivers the message, authenticating if the target has credentials.
+func (e *emailSink) mail(message []byte) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if e.implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.address, &tls.Config{ServerName: e.host})
+	} else {
+		conn, err = dialer.Dial("tcp", e.address)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !e.implicit {
+		if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return err
+		}
+	}
+	if e.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.user, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// close does nothing, every change is mailed using a connection of its own.
+func (e *emailSink) close() {}
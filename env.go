@@ -0,0 +1,56 @@
+package main
+
+import (
+	goflag "flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envVariable is an environment variable as listed by ips env.
+type envVariable struct {
+	Name string
+
+	// Option is the option set by the variable, empty if the variable is not recognized
+	Option string
+
+	// Value is the current value of the option, regardless of where it was set
+	Value string
+
+	// Source tells where the value comes from: flag, env, file or default
+	Source string
+
+	Usage string
+}
+
+// runEnv lists the environment variable of every option with the current value and its source. Variables using
+// the IPS_ prefix not matching an option are listed as unknown, they are usually typos.
+func runEnv(logger *slog.Logger, _ []string) int {
+	variables := make([]*envVariable, 0)
+	known := make(map[string]bool)
+	for _, o := range effectiveOptions() {
+		name := envName(o.Name)
+		known[name] = true
+		variables = append(variables, &envVariable{Name: name, Option: o.Name, Value: o.Value, Source: o.Source, Usage: goflag.Lookup(o.Name).Usage})
+	}
+	unknown := make([]*envVariable, 0)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "IPS_") && !known[name] {
+			logger.Warn("environment variable does not match an option", "name", name)
+			unknown = append(unknown, &envVariable{Name: name, Value: value, Source: "unknown"})
+		}
+	}
+	sort.Slice(unknown, func(a, b int) bool { return unknown[a].Name < unknown[b].Name })
+	variables = append(variables, unknown...)
+
+	if jsonOutput {
+		return printJSON(logger, variables)
+	}
+	for _, v := range variables {
+		fmt.Printf("%s\t%s\t%s\t%s\n", v.Name, v.Value, v.Source, v.Usage)
+	}
+	return exitOK
+}
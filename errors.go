@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrNoPublicProvider is returned when no provider was able to determine the public ip address
+	ErrNoPublicProvider = errors.New("no public ip provider returned an address")
+
+	// ErrProviderTimeout is returned when a public ip provider did not answer within the configured timeout
+	ErrProviderTimeout = errors.New("public ip provider timed out")
+
+	// ErrInvalidResponse is returned when a public ip provider answered with something else than an address of the
+	// requested family, e.g. the HTML page of a captive portal
+	ErrInvalidResponse = errors.New("public ip provider returned an invalid response")
+
+	// ErrExternalDisabled is returned when a request to a third party service was blocked by the no-external switch
+	ErrExternalDisabled = errors.New("contacting third party services is disabled")
+
+	// ErrInterfaceEnumeration is returned when the network interfaces or their addresses could not be listed
+	ErrInterfaceEnumeration = errors.New("could not enumerate interfaces")
+
+	// ErrGatewayUnsupported is returned when the gateway does not answer NAT-PMP or PCP requests
+	ErrGatewayUnsupported = errors.New("gateway does not support NAT-PMP or PCP")
+)
+
+// isTimeout reports whether err was caused by a deadline or a network timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
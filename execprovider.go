@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// providerCommand is an executable printing the public address of the family given as first argument
+var providerCommand string
+
+// queryCommand runs the provider's command with the family as argument and parses the first line of its
+// output as address. The command runs with a minimal environment and is killed once the timeout passed.
+func (p *provider) queryCommand(t string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.Command, t)
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "IPS_FAMILY=" + t}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	start := time.Now()
+	err := cmd.Run()
+	audit("exec", p.Command+" "+t, start, "", err)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w: %s: %w", ErrProviderTimeout, p.Command, err)
+		}
+		return "", fmt.Errorf("provider command %s failed: %w", p.Command, err)
+	}
+
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	return parseAddress("provider command "+p.Command, line, t)
+}
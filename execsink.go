@@ -0,0 +1,67 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// execSink runs a command by the shell for every change. The change is passed as JSON, or as the payload rendered
+// by the template of the sink, on stdin and the addresses in the environment variables IPS_ADDED, IPS_REMOVED and
+// IPS_PREFIX, separated by spaces.
+type execSink struct {
+	command string
+	logger  *slog.Logger
+}
+
+// newExecSink creates a sink running the target of spec.
+func newExecSink(logger *slog.Logger, spec *sinkSpec) (sink, error) {
+	return &execSink{command: spec.target, logger: logger}, nil
+}
+
+// send runs the command, it may take as long as the reload commands of templates before it is killed.
+func (e *execSink) send(c *change, payload []byte) error {
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(c); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Printf("would run %s\n%s\n", e.command, payload)
+		return nil
+	}
+	addresses := func(list ips) string {
+		result := make([]string, 0, len(list))
+		for _, i := range list {
+			result = append(result, i.Address)
+		}
+		return strings.Join(result, " ")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), templateCommandTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, e.command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "IPS_ADDED="+addresses(c.Added), "IPS_REMOVED="+addresses(c.Removed))
+	if c.Prefix != nil {
+		cmd.Env = append(cmd.Env, "IPS_PREFIX="+strings.Join(c.Prefix.Current, " "))
+	}
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	audit("exec", e.command, start, "", err)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	e.logger.Debug("sink command run", "command", e.command, "output", strings.TrimSpace(string(out)))
+	return nil
+}
+
+// close does nothing, the command is started for every change.
+func (e *execSink) close() {}
@@ -0,0 +1,52 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// runExport writes the addresses into an inventory system:
+//
+//   - netbox [name] creates or updates the interfaces and addresses of the device or virtual machine in NetBox,
+//     named like the host unless given. The API is configured using ipam-url and ipam-token
+//
+// With dry-run set the changes are printed without being made.
+func runExport(logger *slog.Logger, args []string) int {
+	if len(args) < 1 || len(args) > 2 || args[0] != "netbox" {
+		logger.Error("usage: ips export netbox [name]")
+		return exitInternalError
+	}
+	if ipamURL == "" || ipamKind != "netbox" {
+		logger.Error("export netbox requires -ipam-url of a NetBox instance")
+		return exitInternalError
+	}
+	name, err := hostFQDN()
+	if err != nil {
+		logger.Error("could not determine host name", "err", err)
+		return exitInternalError
+	}
+	name, _, _ = strings.Cut(name, ".")
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	actions, err := exportNetBox(logger, name)
+	code := exitOK
+	if err != nil {
+		logger.Error("could not export to netbox", "err", err, "name", name)
+		code = exitInternalError
+	}
+	if jsonOutput {
+		if printJSON(logger, actions) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	for _, a := range actions {
+		fmt.Printf("%s\t%s\t%s\t%s\n", a.Action, a.Object, a.Name, a.Interface)
+	}
+	return code
+}
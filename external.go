@@ -0,0 +1,42 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+// externalData renders the addresses as the flat string map Terraform's external data source requires, e.g.
+// {"public_ipv4": "...", "default_ipv4": "...", "eth0_ipv4": "..."}. For every interface the first address of
+// each family is included.
+func externalData(list ips, code int) (string, int, error) {
+	result := map[string]string{}
+	for _, i := range list {
+		if i.isPublic() {
+			result["public_"+i.family()] = i.Address
+			continue
+		}
+		address, _, found := strings.Cut(i.Address, "/")
+		if !found {
+			continue
+		}
+		key := i.Interface + "_" + i.family()
+		if _, ok := result[key]; !ok {
+			result[key] = address
+		}
+	}
+	for family, destination := range map[string]string{"ipv4": "192.0.2.1", "ipv6": "2001:db8::1"} {
+		if r := routeTo(net.ParseIP(destination)); r.Error == "" {
+			result["default_"+family] = r.Source
+			result["default_interface_"+family] = r.Interface
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", code, err
+	}
+	return string(data), code, nil
+}
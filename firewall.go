@@ -0,0 +1,301 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerSubsystem(&subsystem{name: "firewall", commands: map[string]command{"firewall": runFirewall}})
+}
+
+type (
+
+	// portRange is an inclusive range of ports.
+	portRange struct {
+		low, high uint16
+	}
+
+	// portSet is a sorted list of ranges not overlapping each other, nil stands for no port.
+	portSet []portRange
+
+	// firewallRule is a rule of the host firewall deciding on new inbound connections.
+	firewallRule struct {
+		accept bool
+
+		// family is ipv4 or ipv6, empty if the rule applies to both
+		family string
+
+		// protocol is tcp or udp, empty for every protocol
+		protocol string
+
+		// ports are the destination ports, nil for every port
+		ports portSet
+
+		// destination are the local addresses, nil for every address
+		destination []netip.Prefix
+
+		// source describes the remote addresses the rule is limited to, empty for every address
+		source string
+
+		// iface is the inbound interface, a trailing + or * matches every interface starting with the rest
+		iface string
+	}
+
+	// firewallPolicy is a list of rules evaluated in order, the first matching one decides. Rules of firewalls
+	// evaluating otherwise are put in that order when read.
+	firewallPolicy struct {
+
+		// name is the chain or ruleset the rules are read from
+		name string
+
+		// family is ipv4 or ipv6 if the policy applies to one family only
+		family string
+
+		// accept is the decision if no rule matches
+		accept bool
+
+		rules []*firewallRule
+	}
+
+	// firewallPorts are the ports of a protocol a local address accepts connections on.
+	firewallPorts struct {
+		Protocol string
+
+		// Allowed are the ports anyone may connect to, all, none or a comma separated list of ports and ranges
+		Allowed string
+
+		// Restricted are ports only some remote addresses may connect to, e.g. 5432 from 10.0.0.0/8
+		Restricted []string `json:",omitempty"`
+	}
+
+	// firewallReport summarizes the inbound ports permitted by the host firewall for a local address.
+	firewallReport struct {
+		Address   string
+		Interface string
+
+		// Backend is the firewall the rules are read from, none if no firewall is active
+		Backend string
+
+		Ports []*firewallPorts
+	}
+)
+
+// firewallProtocols are the protocols ports are reported for
+var firewallProtocols = []string{"tcp", "udp"}
+
+// allPorts contains every port
+var allPorts = portSet{{1, 65535}}
+
+// runFirewall summarizes per local address which inbound ports the host firewall permits new connections to. The
+// rules are only read: nftables or iptables on Linux, pf on macOS and the Windows Firewall. Rules matching on
+// things other than protocol, ports, addresses and interfaces are skipped, as are rules for established
+// connections, so the report is an approximation of what the firewall does.
+func runFirewall(logger *slog.Logger, _ []string) int {
+	backend, policies, err := firewallPolicies()
+	if err != nil {
+		logger.Error("could not read firewall rules", "err", err)
+		return exitInternalError
+	}
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		logger.Error("could not get ip addresses", "err", err)
+		return exitInternalError
+	}
+
+	reports := make([]*firewallReport, 0)
+	for _, i := range local {
+		prefix, err := netip.ParsePrefix(i.Address)
+		if err != nil || prefix.Addr().IsLoopback() {
+			continue
+		}
+		report := &firewallReport{Address: prefix.Addr().String(), Interface: i.Interface, Backend: backend}
+		for _, protocol := range firewallProtocols {
+			allowed, restricted := allPorts, make([]string, 0)
+			for _, p := range policies {
+				if p.family != "" && p.family != i.family() {
+					continue
+				}
+				a, r := p.evaluate(prefix.Addr(), i.Interface, protocol)
+				allowed = allowed.intersect(a)
+				restricted = append(restricted, r...)
+			}
+			ports := &firewallPorts{Protocol: protocol, Allowed: allowed.String()}
+			if len(restricted) > 0 {
+				ports.Restricted = restricted
+			}
+			report.Ports = append(report.Ports, ports)
+		}
+		reports = append(reports, report)
+	}
+
+	if jsonOutput {
+		return printJSON(logger, reports)
+	}
+	for _, r := range reports {
+		for _, p := range r.Ports {
+			fmt.Printf("%s\t%s\t%s\t%s\n", r.Address, r.Interface, p.Protocol, p.Allowed)
+			for _, restricted := range p.Restricted {
+				fmt.Printf("%s\t%s\t%s\t%s\n", r.Address, r.Interface, p.Protocol, restricted)
+			}
+		}
+	}
+	return exitOK
+}
+
+// evaluate returns the ports of the protocol anyone may connect to on the address arriving on the interface, and
+// those accepted from some remote addresses only. Rules limited to remote addresses do not decide for the others,
+// so evaluation continues after them.
+func (p *firewallPolicy) evaluate(address netip.Addr, iface, protocol string) (portSet, []string) {
+	family := "ipv4"
+	if address.Is6() && !address.Is4In6() {
+		family = "ipv6"
+	}
+	undecided, allowed, restricted := allPorts, portSet(nil), make([]string, 0)
+	for _, r := range p.rules {
+		if !r.matches(address, family, iface, protocol) {
+			continue
+		}
+		ports := allPorts
+		if r.ports != nil {
+			ports = r.ports
+		}
+		decided := undecided.intersect(ports)
+		if len(decided) == 0 {
+			continue
+		}
+		if r.source != "" {
+			if r.accept {
+				restricted = append(restricted, decided.String()+" from "+r.source)
+			}
+			continue
+		}
+		if r.accept {
+			allowed = allowed.union(decided)
+		}
+		undecided = undecided.subtract(decided)
+	}
+	if p.accept {
+		allowed = allowed.union(undecided)
+	}
+	return allowed, restricted
+}
+
+// matches reports whether the rule applies to connections to the address arriving on the interface.
+func (r *firewallRule) matches(address netip.Addr, family, iface, protocol string) bool {
+	if r.family != "" && r.family != family {
+		return false
+	}
+	if r.protocol != "" && r.protocol != protocol {
+		return false
+	}
+	if r.iface != "" {
+		name := strings.TrimRight(r.iface, "+*")
+		if name == r.iface && name != iface || !strings.HasPrefix(iface, name) {
+			return false
+		}
+	}
+	if r.destination == nil {
+		return true
+	}
+	return slices.ContainsFunc(r.destination, func(p netip.Prefix) bool { return p.Contains(address.Unmap()) })
+}
+
+// parsePorts parses ports and ranges separated by commas or spaces, ranges are written as 1000-2000 or 1000:2000.
+func parsePorts(value string) (portSet, error) {
+	var set portSet
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		low, high, found := strings.Cut(field, "-")
+		if !found {
+			low, high, found = strings.Cut(field, ":")
+		}
+		if !found {
+			high = low
+		}
+		l, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		h, err := strconv.ParseUint(high, 10, 16)
+		if err != nil || h < l {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		set = set.union(portSet{{uint16(l), uint16(h)}})
+	}
+	return set, nil
+}
+
+// union returns the ports contained in either set.
+func (s portSet) union(o portSet) portSet {
+	all := append(slices.Clone(s), o...)
+	slices.SortFunc(all, func(a, b portRange) int { return int(a.low) - int(b.low) })
+	var result portSet
+	for _, r := range all {
+		if n := len(result); n > 0 && uint32(r.low) <= uint32(result[n-1].high)+1 {
+			result[n-1].high = max(result[n-1].high, r.high)
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// intersect returns the ports contained in both sets.
+func (s portSet) intersect(o portSet) portSet {
+	var result portSet
+	for _, a := range s {
+		for _, b := range o {
+			if low, high := max(a.low, b.low), min(a.high, b.high); low <= high {
+				result = append(result, portRange{low, high})
+			}
+		}
+	}
+	return result.union(nil)
+}
+
+// subtract returns the ports of s not contained in o.
+func (s portSet) subtract(o portSet) portSet {
+	result := slices.Clone(s)
+	for _, b := range o {
+		var next portSet
+		for _, a := range result {
+			if b.high < a.low || b.low > a.high {
+				next = append(next, a)
+				continue
+			}
+			if a.low < b.low {
+				next = append(next, portRange{a.low, b.low - 1})
+			}
+			if a.high > b.high {
+				next = append(next, portRange{b.high + 1, a.high})
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// String returns the ports as comma separated list of ports and ranges, all or none.
+func (s portSet) String() string {
+	switch {
+	case len(s) == 0:
+		return "none"
+	case len(s) == 1 && s[0] == allPorts[0]:
+		return "all"
+	}
+	parts := make([]string, 0, len(s))
+	for _, r := range s {
+		if r.low == r.high {
+			parts = append(parts, strconv.Itoa(int(r.low)))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d-%d", r.low, r.high))
+	}
+	return strings.Join(parts, ",")
+}
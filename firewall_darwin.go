@@ -0,0 +1,152 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// firewallSupport names the firewall whose rules are read using pfctl
+const firewallSupport = "pf"
+
+// firewallPolicies reads the rules of pf, which passes everything not blocked. Rules in anchors are not listed by
+// pfctl and the application firewall of macOS decides per application, not per port, so both are not covered.
+func firewallPolicies() (string, []*firewallPolicy, error) {
+	info, err := exec.Command("pfctl", "-s", "info").CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("pfctl: %w: %s", err, strings.TrimSpace(string(info)))
+	}
+	if !bytes.Contains(info, []byte("Status: Enabled")) {
+		return "none", nil, nil
+	}
+	out, err := exec.Command("pfctl", "-s", "rules").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("pfctl: %w", err)
+	}
+	return "pf", []*firewallPolicy{parsePF(out)}, nil
+}
+
+// parsePF parses the rules as printed by pfctl. pf decides by the last matching rule unless a quick rule matches
+// first, so quick rules are kept in order and followed by the others in reverse.
+func parsePF(data []byte) *firewallPolicy {
+	quick, last := make([]*firewallRule, 0), make([]*firewallRule, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		r, isQuick := parsePFRule(strings.Fields(scanner.Text()))
+		switch {
+		case r == nil:
+		case isQuick:
+			quick = append(quick, r)
+		default:
+			last = append(last, r)
+		}
+	}
+	slices.Reverse(last)
+	return &firewallPolicy{name: "pf", accept: true, rules: append(quick, last...)}
+}
+
+// parsePFRule parses a pass or block rule for inbound packets, nil if it is none or uses negations, tables or
+// port operators not understood.
+func parsePFRule(fields []string) (*firewallRule, bool) {
+	if len(fields) == 0 || fields[0] != "pass" && fields[0] != "block" {
+		return nil, false
+	}
+	r := &firewallRule{accept: fields[0] == "pass"}
+	isQuick, target := false, ""
+	for idx := 1; idx < len(fields); idx++ {
+		field, value := fields[idx], ""
+		if idx+1 < len(fields) {
+			value = fields[idx+1]
+		}
+		switch field {
+		case "out":
+			return nil, false
+		case "quick":
+			isQuick = true
+		case "inet":
+			r.family = "ipv4"
+		case "inet6":
+			r.family = "ipv6"
+		case "on":
+			r.iface = value
+			idx++
+		case "proto":
+			r.protocol = value
+			idx++
+		case "from", "to":
+			target = field
+			switch {
+			case value == "any", value == "self", value == "(self)":
+			case value == "!", strings.HasPrefix(value, "<"), strings.HasPrefix(value, "("):
+				return nil, false
+			case field == "from":
+				r.source = value
+			default:
+				prefix, err := parsePrefixOrAddr(value)
+				if err != nil {
+					return nil, false
+				}
+				r.destination = []netip.Prefix{prefix}
+			}
+			idx++
+		case "port":
+			ports, consumed := parsePFPort(fields[idx+1:])
+			if ports == nil {
+				return nil, false
+			}
+			// source ports do not limit the local ports, but the remote end
+			if target == "from" {
+				r.source = strings.TrimSpace(r.source + " port " + strings.Join(fields[idx+1:idx+1+consumed], " "))
+			} else {
+				r.ports = ports
+			}
+			idx += consumed
+		case "flags", "keep", "modulate", "synproxy", "label", "tag", "tagged", "queue", "rtable", "probability":
+			// options following the addresses do not limit the match
+			return r, isQuick
+		}
+	}
+	return r, isQuick
+}
+
+// parsePFPort parses the port following the port keyword, e.g. "= 22", "8000:8100" or "> 1024", and returns the
+// number of fields used. Returns nil for operators not understood.
+func parsePFPort(fields []string) (portSet, int) {
+	if len(fields) == 0 {
+		return nil, 0
+	}
+	if ports, err := parsePorts(fields[0]); err == nil {
+		return ports, 1
+	}
+	if len(fields) < 2 {
+		return nil, 0
+	}
+	port, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, 0
+	}
+	switch fields[0] {
+	case "=":
+		return portSet{{uint16(port), uint16(port)}}, 2
+	case ">":
+		if port < 65535 {
+			return portSet{{uint16(port) + 1, 65535}}, 2
+		}
+	case ">=":
+		return portSet{{max(uint16(port), 1), 65535}}, 2
+	case "<":
+		if port > 1 {
+			return portSet{{1, uint16(port) - 1}}, 2
+		}
+	case "<=":
+		return portSet{{1, uint16(port)}}, 2
+	}
+	return nil, 0
+}
@@ -0,0 +1,432 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// firewallSupport names the firewalls whose rules are read
+const firewallSupport = "nftables, iptables"
+
+// maxChainDepth limits following jumps to other chains, loops are rejected by the kernel but not by the parsers
+const maxChainDepth = 16
+
+// chainRule is a rule of a chain before jumps to other chains are followed.
+type chainRule struct {
+	*firewallRule
+
+	// verdict is accept, drop, jump or return
+	verdict string
+
+	// target is the chain jumped to
+	target string
+}
+
+// firewallPolicies reads the input chains of nftables, or of iptables if nft is not installed. iptables using the
+// nftables backend shows up in the nftables ruleset.
+func firewallPolicies() (string, []*firewallPolicy, error) {
+	out, err := firewallCommand("nft", "-j", "list", "ruleset")
+	if err == nil {
+		policies, err := parseNFT(out)
+		if err != nil || len(policies) > 0 {
+			return "nftables", policies, err
+		}
+	} else if !errors.Is(err, exec.ErrNotFound) {
+		return "", nil, err
+	}
+
+	found := false
+	policies := make([]*firewallPolicy, 0)
+	for _, c := range []struct{ command, family string }{{"iptables-save", "ipv4"}, {"ip6tables-save", "ipv6"}} {
+		out, err := firewallCommand(c.command, "-t", "filter")
+		if errors.Is(err, exec.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		found = true
+		if p := parseIptables(out, c.family); p != nil {
+			policies = append(policies, p)
+		}
+	}
+	if !found || len(policies) == 0 {
+		return "none", nil, nil
+	}
+	return "iptables", policies, nil
+}
+
+// firewallCommand runs the command and returns its output, errors carry what it printed to stderr, e.g. that
+// root is required.
+func firewallCommand(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && !errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, err
+}
+
+// parseIptables parses the filter table as printed by iptables-save and returns the rules of the INPUT chain with
+// jumps to other chains followed, nil if there is no INPUT chain.
+func parseIptables(data []byte, family string) *firewallPolicy {
+	var policy *firewallPolicy
+	chains := make(map[string][]*chainRule)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, ":INPUT "):
+			fields := strings.Fields(line)
+			policy = &firewallPolicy{name: "INPUT", family: family, accept: len(fields) > 1 && fields[1] == "ACCEPT"}
+		case strings.HasPrefix(line, "-A "):
+			fields := splitQuoted(line)
+			if len(fields) < 2 {
+				continue
+			}
+			if r := parseIptablesRule(fields[2:]); r != nil {
+				chains[fields[1]] = append(chains[fields[1]], r)
+			}
+		}
+	}
+	if policy == nil {
+		return nil
+	}
+	policy.rules = flattenChain(chains, "INPUT", &firewallRule{}, 0)
+	return policy
+}
+
+// parseIptablesRule parses the options of a rule, nil if it uses options not understood, is negated or only
+// applies to established connections.
+func parseIptablesRule(fields []string) *chainRule {
+	r := &chainRule{firewallRule: &firewallRule{}}
+	for idx := 0; idx < len(fields); idx++ {
+		option, value := fields[idx], ""
+		if idx+1 < len(fields) {
+			value = fields[idx+1]
+		}
+		var err error
+		switch option {
+		case "-m", "--comment", "--reject-with":
+			// modules are loaded for the options following, iptables-save adds how REJECT answers
+		case "-p":
+			if value != "all" {
+				r.protocol = value
+			}
+		case "-i":
+			r.iface = value
+		case "-s":
+			r.source = value
+		case "-d":
+			var prefix netip.Prefix
+			if prefix, err = parsePrefixOrAddr(value); err == nil {
+				r.destination = []netip.Prefix{prefix}
+			}
+		case "--dport", "--dports":
+			r.ports, err = parsePorts(value)
+		case "--ctstate", "--state":
+			if !strings.Contains(value, "NEW") {
+				return nil
+			}
+		case "-j", "-g":
+			switch value {
+			case "ACCEPT":
+				r.verdict = "accept"
+			case "DROP", "REJECT":
+				r.verdict = "drop"
+			case "RETURN":
+				r.verdict = "return"
+			case "LOG", "NFLOG", "MARK", "CONNMARK", "AUDIT":
+				return nil
+			default:
+				r.verdict, r.target = "jump", value
+			}
+		default:
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		idx++
+	}
+	if r.verdict == "" {
+		return nil
+	}
+	return r
+}
+
+// splitQuoted splits the line at spaces, text in double quotes is kept together.
+func splitQuoted(line string) []string {
+	fields := make([]string, 0)
+	var current strings.Builder
+	quoted, started := false, false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted, started = !quoted, true
+		case c == ' ' && !quoted:
+			if started {
+				fields = append(fields, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// flattenChain returns the rules of the chain with jumps replaced by the rules of the chain jumped to, limited by
+// the conditions of the jump. Rules after an unconditional return are not reached.
+func flattenChain(chains map[string][]*chainRule, name string, outer *firewallRule, depth int) []*firewallRule {
+	rules := make([]*firewallRule, 0)
+	if depth > maxChainDepth {
+		return rules
+	}
+	for _, r := range chains[name] {
+		merged := mergeRule(outer, r.firewallRule)
+		switch r.verdict {
+		case "accept", "drop":
+			merged.accept = r.verdict == "accept"
+			rules = append(rules, merged)
+		case "jump":
+			rules = append(rules, flattenChain(chains, r.target, merged, depth+1)...)
+		case "return":
+			if c := r.firewallRule; c.family == "" && c.protocol == "" && c.ports == nil && c.destination == nil &&
+				c.source == "" && c.iface == "" {
+				return rules
+			}
+		}
+	}
+	return rules
+}
+
+// mergeRule returns the inner rule limited by the conditions of the outer one. Conditions set in both are taken from
+// the inner rule, except for ports which have to match both.
+func mergeRule(outer, inner *firewallRule) *firewallRule {
+	merged := *inner
+	if merged.family == "" {
+		merged.family = outer.family
+	}
+	if merged.protocol == "" {
+		merged.protocol = outer.protocol
+	}
+	switch {
+	case merged.ports == nil:
+		merged.ports = outer.ports
+	case outer.ports != nil:
+		merged.ports = merged.ports.intersect(outer.ports)
+	}
+	if merged.destination == nil {
+		merged.destination = outer.destination
+	}
+	if merged.source == "" {
+		merged.source = outer.source
+	}
+	if merged.iface == "" {
+		merged.iface = outer.iface
+	}
+	return &merged
+}
+
+// parseNFT parses the ruleset as printed by nft -j and returns a policy per base chain hooked into input. Packets
+// have to pass every such chain.
+func parseNFT(data []byte) ([]*firewallPolicy, error) {
+	var ruleset struct {
+		Nftables []map[string]json.RawMessage `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &ruleset); err != nil {
+		return nil, err
+	}
+	type nftChain struct {
+		Family string `json:"family"`
+		Table  string `json:"table"`
+		Name   string `json:"name"`
+		Hook   string `json:"hook"`
+		Type   string `json:"type"`
+		Policy string `json:"policy"`
+	}
+	type nftRule struct {
+		Family string           `json:"family"`
+		Table  string           `json:"table"`
+		Chain  string           `json:"chain"`
+		Expr   []map[string]any `json:"expr"`
+	}
+
+	base := make([]*nftChain, 0)
+	chains := make(map[string][]*chainRule)
+	for _, object := range ruleset.Nftables {
+		if raw, ok := object["chain"]; ok {
+			var c nftChain
+			if err := json.Unmarshal(raw, &c); err != nil {
+				return nil, err
+			}
+			if c.Hook == "input" && c.Type == "filter" && (c.Family == "ip" || c.Family == "ip6" || c.Family == "inet") {
+				base = append(base, &c)
+			}
+		}
+		if raw, ok := object["rule"]; ok {
+			var r nftRule
+			if err := json.Unmarshal(raw, &r); err != nil {
+				return nil, err
+			}
+			key := r.Family + " " + r.Table + " " + r.Chain
+			if rule := parseNFTRule(r.Expr, r.Family+" "+r.Table+" "); rule != nil {
+				chains[key] = append(chains[key], rule)
+			}
+		}
+	}
+
+	policies := make([]*firewallPolicy, 0, len(base))
+	for _, c := range base {
+		p := &firewallPolicy{name: c.Family + " " + c.Table + " " + c.Name, accept: c.Policy != "drop"}
+		switch c.Family {
+		case "ip":
+			p.family = "ipv4"
+		case "ip6":
+			p.family = "ipv6"
+		}
+		p.rules = flattenChain(chains, p.name, &firewallRule{}, 0)
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// parseNFTRule parses the expressions of a rule, nil if it uses expressions not understood, negations or only
+// applies to established connections. Chains jumped to are prefixed by the family and table of the rule.
+func parseNFTRule(expressions []map[string]any, table string) *chainRule {
+	r := &chainRule{firewallRule: &firewallRule{}}
+	for _, expression := range expressions {
+		for key, value := range expression {
+			switch key {
+			case "counter", "log", "comment":
+			case "accept":
+				r.verdict = "accept"
+			case "drop", "reject":
+				r.verdict = "drop"
+			case "return":
+				r.verdict = "return"
+			case "jump", "goto":
+				target, _ := value.(map[string]any)["target"].(string)
+				r.verdict, r.target = "jump", table+target
+			case "match":
+				if !parseNFTMatch(r.firewallRule, value) {
+					return nil
+				}
+			default:
+				return nil
+			}
+		}
+	}
+	if r.verdict == "" {
+		return nil
+	}
+	return r
+}
+
+// parseNFTMatch applies a match expression to the rule, false if it is not understood.
+func parseNFTMatch(r *firewallRule, value any) bool {
+	match, _ := value.(map[string]any)
+	if op, _ := match["op"].(string); op != "==" && op != "in" {
+		return false
+	}
+	left, _ := match["left"].(map[string]any)
+	right := match["right"]
+	if payload, ok := left["payload"].(map[string]any); ok {
+		protocol, _ := payload["protocol"].(string)
+		field, _ := payload["field"].(string)
+		switch {
+		case (protocol == "tcp" || protocol == "udp") && field == "dport":
+			ports, err := parsePorts(nftValues(right))
+			if err != nil {
+				return false
+			}
+			r.protocol, r.ports = protocol, ports
+		case (protocol == "ip" || protocol == "ip6") && field == "daddr":
+			for _, v := range strings.Fields(nftValues(right)) {
+				prefix, err := parsePrefixOrAddr(v)
+				if err != nil {
+					return false
+				}
+				r.destination = append(r.destination, prefix)
+			}
+		case (protocol == "ip" || protocol == "ip6") && field == "saddr":
+			r.source = strings.ReplaceAll(nftValues(right), " ", ",")
+		case protocol == "ip" && field == "protocol", protocol == "ip6" && field == "nexthdr":
+			r.protocol = nftProtocol(right)
+		default:
+			return false
+		}
+		return true
+	}
+	if meta, ok := left["meta"].(map[string]any); ok {
+		switch meta["key"] {
+		case "iifname", "iif":
+			r.iface, _ = right.(string)
+		case "l4proto":
+			r.protocol = nftProtocol(right)
+		case "nfproto":
+			r.family = nftValues(right)
+		default:
+			return false
+		}
+		return r.iface != "" || meta["key"] != "iifname" && meta["key"] != "iif"
+	}
+	if ct, ok := left["ct"].(map[string]any); ok && ct["key"] == "state" {
+		return strings.Contains(nftValues(right), "new")
+	}
+	return false
+}
+
+// nftProtocol returns the protocol matched, empty if several are, which makes the rule apply to all of them.
+func nftProtocol(value any) string {
+	protocol := nftValues(value)
+	if strings.Contains(protocol, " ") {
+		return ""
+	}
+	return protocol
+}
+
+// nftValues returns the right hand side of a match as space separated values, ranges are joined by a dash and
+// prefixes written in CIDR notation.
+func nftValues(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.Itoa(int(v))
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, nftValues(item))
+		}
+		return strings.Join(values, " ")
+	case map[string]any:
+		if set, ok := v["set"]; ok {
+			return nftValues(set)
+		}
+		if r, ok := v["range"].([]any); ok && len(r) == 2 {
+			return nftValues(r[0]) + "-" + nftValues(r[1])
+		}
+		if prefix, ok := v["prefix"].(map[string]any); ok {
+			return nftValues(prefix["addr"]) + "/" + nftValues(prefix["len"])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,287 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// firewallCase is a connection evaluated against a policy read from a fixture.
+type firewallCase struct {
+	address, iface, protocol string
+	allowed                  string
+	restricted               []string
+}
+
+// checkPolicy evaluates the cases against the policy.
+func checkPolicy(t *testing.T, p *firewallPolicy, cases []firewallCase) {
+	t.Helper()
+	for _, c := range cases {
+		allowed, restricted := p.evaluate(netip.MustParseAddr(c.address), c.iface, c.protocol)
+		if allowed.String() != c.allowed {
+			t.Errorf("%s: %s %s on %s: allowed %s, want %s", p.name, c.protocol, c.address, c.iface, allowed, c.allowed)
+		}
+		if !slices.Equal(restricted, c.restricted) && len(restricted)+len(c.restricted) > 0 {
+			t.Errorf("%s: %s %s on %s: restricted %v, want %v", p.name, c.protocol, c.address, c.iface, restricted, c.restricted)
+		}
+	}
+}
+
+func TestParseIptables(t *testing.T) {
+	data, err := os.ReadFile("testdata/iptables-save.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parseIptables(data, "ipv4")
+	if p == nil {
+		t.Fatal("no INPUT chain found")
+	}
+	if p.accept || p.family != "ipv4" {
+		t.Errorf("policy accept %t family %s, want drop for ipv4", p.accept, p.family)
+	}
+	// lo, 23, 20:30, 22, 5432, 80 and 443, 60000:61000, 8080 and docker0, rules after the RETURN are not reached
+	if len(p.rules) != 9 {
+		t.Errorf("got %d rules, want 9", len(p.rules))
+	}
+	checkPolicy(t, p, []firewallCase{
+		{"192.0.2.10", "eth0", "tcp", "20-22,24-30,80,443,8080", []string{"5432 from 10.0.0.0/8"}},
+		{"192.0.2.11", "eth0", "tcp", "20-22,24-30,80,443", []string{"5432 from 10.0.0.0/8"}},
+		{"192.0.2.10", "eth0", "udp", "60000-61000", nil},
+		{"127.0.0.1", "lo", "tcp", "all", nil},
+		{"172.17.0.1", "docker0", "udp", "all", nil},
+	})
+
+	if p := parseIptables([]byte("*filter\n:FORWARD DROP [0:0]\nCOMMIT\n"), "ipv4"); p != nil {
+		t.Errorf("got policy %v without INPUT chain", p)
+	}
+}
+
+func TestParseIptablesRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		want     *chainRule
+		rejected bool
+	}{
+		{name: "accept port", rule: "-p tcp -m tcp --dport 22 -j ACCEPT",
+			want: &chainRule{firewallRule: &firewallRule{protocol: "tcp", ports: portSet{{22, 22}}}, verdict: "accept"}},
+		{name: "multiport", rule: "-p udp -m multiport --dports 53,5353,6000:6010 -j ACCEPT",
+			want: &chainRule{firewallRule: &firewallRule{protocol: "udp", ports: portSet{{53, 53}, {5353, 5353}, {6000, 6010}}}, verdict: "accept"}},
+		{name: "protocol all", rule: "-p all -i eth1 -j DROP",
+			want: &chainRule{firewallRule: &firewallRule{iface: "eth1"}, verdict: "drop"}},
+		{name: "reject", rule: "-s 198.51.100.0/24 -d 192.0.2.1/32 -j REJECT --reject-with icmp-port-unreachable",
+			want: &chainRule{firewallRule: &firewallRule{source: "198.51.100.0/24", destination: []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}}, verdict: "drop"}},
+		{name: "destination without length", rule: "-d 192.0.2.1 -j ACCEPT",
+			want: &chainRule{firewallRule: &firewallRule{destination: []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}}, verdict: "accept"}},
+		{name: "jump", rule: "-i eth0 -j ufw-user-input",
+			want: &chainRule{firewallRule: &firewallRule{iface: "eth0"}, verdict: "jump", target: "ufw-user-input"}},
+		{name: "goto", rule: "-g DOCKER",
+			want: &chainRule{firewallRule: &firewallRule{}, verdict: "jump", target: "DOCKER"}},
+		{name: "return", rule: "-j RETURN", want: &chainRule{firewallRule: &firewallRule{}, verdict: "return"}},
+		{name: "new connections", rule: "-p tcp -m state --state NEW,ESTABLISHED --dport 80 -j ACCEPT",
+			want: &chainRule{firewallRule: &firewallRule{protocol: "tcp", ports: portSet{{80, 80}}}, verdict: "accept"}},
+		{name: "quoted comment", rule: `-p tcp -m comment --comment "allow web ui" --dport 8080 -j ACCEPT`,
+			want: &chainRule{firewallRule: &firewallRule{protocol: "tcp", ports: portSet{{8080, 8080}}}, verdict: "accept"}},
+		{name: "established only", rule: "-m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", rejected: true},
+		{name: "log", rule: `-j LOG --log-prefix "[UFW BLOCK] "`, rejected: true},
+		{name: "negation", rule: "! -s 10.0.0.0/8 -j DROP", rejected: true},
+		{name: "unknown match", rule: "-p icmp -m icmp --icmp-type 8 -j ACCEPT", rejected: true},
+		{name: "invalid port", rule: "-p tcp --dport 70000 -j ACCEPT", rejected: true},
+		{name: "no verdict", rule: "-p tcp --dport 22", rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseIptablesRule(splitQuoted(tt.rule))
+			if tt.rejected {
+				if got != nil {
+					t.Fatalf("got %+v, want the rule to be skipped", got.firewallRule)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("rule was skipped")
+			}
+			if got.verdict != tt.want.verdict || got.target != tt.want.target {
+				t.Errorf("verdict %s %s, want %s %s", got.verdict, got.target, tt.want.verdict, tt.want.target)
+			}
+			checkRule(t, got.firewallRule, tt.want.firewallRule)
+		})
+	}
+}
+
+// checkRule compares the conditions of two rules.
+func checkRule(t *testing.T, got, want *firewallRule) {
+	t.Helper()
+	if got.accept != want.accept || got.family != want.family || got.protocol != want.protocol ||
+		got.source != want.source || got.iface != want.iface || !slices.Equal(got.ports, want.ports) ||
+		!slices.Equal(got.destination, want.destination) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"-A INPUT -j ACCEPT", []string{"-A", "INPUT", "-j", "ACCEPT"}},
+		{`-m comment --comment "two words" -j DROP`, []string{"-m", "comment", "--comment", "two words", "-j", "DROP"}},
+		{`--comment ""  -j  DROP`, []string{"--comment", "", "-j", "DROP"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := splitQuoted(tt.line); !slices.Equal(got, tt.want) {
+			t.Errorf("splitQuoted(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFlattenChain(t *testing.T) {
+	rule := func(line string) *chainRule {
+		t.Helper()
+		r := parseIptablesRule(splitQuoted(line))
+		if r == nil {
+			t.Fatalf("rule %q was skipped", line)
+		}
+		return r
+	}
+	tests := []struct {
+		name   string
+		chains map[string][]*chainRule
+		want   []*firewallRule
+	}{
+		{
+			name: "jump conditions limit the rules jumped to",
+			chains: map[string][]*chainRule{
+				"INPUT": {rule("-i eth0 -p tcp --dport 1000:2000 -j web")},
+				"web":   {rule("--dport 1500:3000 -j ACCEPT"), rule("-s 10.0.0.0/8 -j DROP")},
+			},
+			want: []*firewallRule{
+				{accept: true, protocol: "tcp", ports: portSet{{1500, 2000}}, iface: "eth0"},
+				{protocol: "tcp", ports: portSet{{1000, 2000}}, source: "10.0.0.0/8", iface: "eth0"},
+			},
+		},
+		{
+			name: "conditional return continues",
+			chains: map[string][]*chainRule{
+				"INPUT": {rule("-j user"), rule("-p tcp --dport 443 -j ACCEPT")},
+				"user":  {rule("-s 10.0.0.0/8 -j RETURN"), rule("-p tcp --dport 22 -j ACCEPT")},
+			},
+			want: []*firewallRule{
+				{accept: true, protocol: "tcp", ports: portSet{{22, 22}}},
+				{accept: true, protocol: "tcp", ports: portSet{{443, 443}}},
+			},
+		},
+		{
+			name: "unconditional return ends the chain only",
+			chains: map[string][]*chainRule{
+				"INPUT": {rule("-j user"), rule("-p tcp --dport 443 -j ACCEPT")},
+				"user":  {rule("-j RETURN"), rule("-p tcp --dport 22 -j ACCEPT")},
+			},
+			want: []*firewallRule{{accept: true, protocol: "tcp", ports: portSet{{443, 443}}}},
+		},
+		{
+			name: "loops end at the depth limit",
+			chains: map[string][]*chainRule{
+				"INPUT": {rule("-j a")},
+				"a":     {rule("-j b")},
+				"b":     {rule("-j a")},
+			},
+			want: []*firewallRule{},
+		},
+		{
+			name:   "missing chain",
+			chains: map[string][]*chainRule{"INPUT": {rule("-j missing"), rule("-j DROP")}},
+			want:   []*firewallRule{{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := flattenChain(tt.chains, "INPUT", &firewallRule{}, 0)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				checkRule(t, got[i], tt.want[i])
+			}
+		})
+	}
+}
+
+func TestParseNFT(t *testing.T) {
+	data, err := os.ReadFile("testdata/nft-ruleset.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := parseNFT(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(policies))
+	for _, p := range policies {
+		names = append(names, p.name)
+	}
+	// the forward and the nat chains are not hooked into input
+	if want := []string{"inet filter input", "ip6 mail input"}; !slices.Equal(names, want) {
+		t.Fatalf("got policies %q, want %q", names, want)
+	}
+
+	filter, mail := policies[0], policies[1]
+	if filter.accept || filter.family != "" {
+		t.Errorf("inet policy accept %t family %q, want drop for both families", filter.accept, filter.family)
+	}
+	checkPolicy(t, filter, []firewallCase{
+		// 25 is rejected before 20-30 is accepted, 23 is matched negated and skipped
+		{"192.0.2.10", "eth0", "tcp", "20-24,26-30,80,443,3000", []string{"5432 from 10.0.0.0/8"}},
+		{"fd00::10", "eth0", "tcp", "20-24,26-30,80,443,8443", []string{"5432 from 10.0.0.0/8"}},
+		{"fd00::11", "eth0", "tcp", "20-24,26-30,80,443", []string{"5432 from 10.0.0.0/8"}},
+		{"192.0.2.10", "eth0", "udp", "60000-61000", nil},
+		{"::1", "lo", "udp", "all", nil},
+	})
+	if !mail.accept || mail.family != "ipv6" {
+		t.Errorf("ip6 policy accept %t family %q, want accept for ipv6", mail.accept, mail.family)
+	}
+	checkPolicy(t, mail, []firewallCase{
+		{"fd00::10", "eth0", "tcp", "1-586,588-65535", nil},
+		{"fd00::10", "eth0", "udp", "all", nil},
+	})
+
+	if _, err := parseNFT([]byte(`{"nftables": [{"chain": "input"}]}`)); err == nil {
+		t.Error("invalid chain accepted")
+	}
+	if policies, err := parseNFT([]byte(`{"nftables": []}`)); err != nil || len(policies) != 0 {
+		t.Errorf("empty ruleset: got %v, %v", policies, err)
+	}
+}
+
+func TestNFTValues(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"eth0", "eth0"},
+		{float64(22), "22"},
+		{[]any{"new", "established"}, "new established"},
+		{map[string]any{"set": []any{float64(80), float64(443)}}, "80 443"},
+		{map[string]any{"range": []any{float64(1000), float64(2000)}}, "1000-2000"},
+		{map[string]any{"prefix": map[string]any{"addr": "10.0.0.0", "len": float64(8)}}, "10.0.0.0/8"},
+		{map[string]any{"set": []any{map[string]any{"range": []any{float64(1), float64(5)}}, float64(9)}}, "1-5 9"},
+		{map[string]any{"unknown": true}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := nftValues(tt.value); got != tt.want {
+			t.Errorf("nftValues(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	if got := nftProtocol(map[string]any{"set": []any{"tcp", "udp"}}); got != "" {
+		t.Errorf("several protocols give %q, want every protocol", got)
+	}
+	if got := strings.TrimSpace(nftProtocol("tcp")); got != "tcp" {
+		t.Errorf("nftProtocol(tcp) = %q", got)
+	}
+}
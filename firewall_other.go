@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows && (!ips_minimal || ips_full)
+
+package main
+
+import "errors"
+
+// firewallSupport is empty, the host firewall cannot be read on this platform
+const firewallSupport = ""
+
+// firewallPolicies is not implemented on this platform.
+func firewallPolicies() (string, []*firewallPolicy, error) {
+	return "", nil, errors.ErrUnsupported
+}
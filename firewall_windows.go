@@ -0,0 +1,145 @@
+//go:build windows && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// firewallSupport names the firewall whose rules are read using netsh
+const firewallSupport = "windows firewall"
+
+// firewallPolicies reads the inbound rules of the Windows Firewall enabled for the active profile using netsh.
+// Block rules take precedence over allow rules, so they are put first. netsh prints localized labels, only English
+// ones are understood.
+func firewallPolicies() (string, []*firewallPolicy, error) {
+	out, err := exec.Command("netsh", "advfirewall", "show", "currentprofile").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("netsh: %w", err)
+	}
+	profile, settings := "", netshFields(out)
+	if len(settings) > 0 {
+		// the first line names the profile, e.g. Public Profile Settings
+		for key := range settings[0] {
+			if name, ok := strings.CutSuffix(key, " Profile Settings"); ok {
+				profile = name
+			}
+		}
+	}
+	state, policy := "", ""
+	for _, block := range settings {
+		if v, ok := block["State"]; ok {
+			state = v
+		}
+		if v, ok := block["Firewall Policy"]; ok {
+			policy = v
+		}
+	}
+	if state == "" {
+		return "", nil, errors.New("netsh printed no firewall state")
+	}
+	if !strings.EqualFold(state, "ON") {
+		return "none", nil, nil
+	}
+
+	out, err = exec.Command("netsh", "advfirewall", "firewall", "show", "rule", "name=all", "dir=in").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("netsh: %w", err)
+	}
+	p := &firewallPolicy{name: profile, accept: strings.HasPrefix(policy, "AllowInbound")}
+	allow := make([]*firewallRule, 0)
+	for _, fields := range netshFields(out) {
+		r := parseNetshRule(fields, profile)
+		switch {
+		case r == nil:
+		case r.accept:
+			allow = append(allow, r)
+		default:
+			p.rules = append(p.rules, r)
+		}
+	}
+	p.rules = append(p.rules, allow...)
+	return "windows", []*firewallPolicy{p}, nil
+}
+
+// netshFields splits the output of netsh into blocks separated by empty lines and the lines of a block into label
+// and value. Lines without value, like headings, are kept with an empty value.
+func netshFields(data []byte) []map[string]string {
+	blocks := make([]map[string]string, 0)
+	current := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", line == "Ok.":
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = make(map[string]string)
+			}
+		case strings.HasPrefix(line, "---"):
+		default:
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				// settings of the profile are separated by spaces instead of a colon
+				idx := strings.Index(line, "  ")
+				if idx < 0 {
+					current[line] = ""
+					continue
+				}
+				key, value = line[:idx], line[idx:]
+			}
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+// parseNetshRule converts a rule printed by netsh, nil if it is disabled, not enabled for the profile or limited
+// in a way not understood.
+func parseNetshRule(fields map[string]string, profile string) *firewallRule {
+	if fields["Enabled"] != "Yes" {
+		return nil
+	}
+	if profiles := fields["Profiles"]; profiles != "Any" && !strings.Contains(profiles, profile) {
+		return nil
+	}
+	r := &firewallRule{}
+	switch fields["Action"] {
+	case "Allow":
+		r.accept = true
+	case "Block":
+	default:
+		return nil
+	}
+	if protocol := strings.ToLower(fields["Protocol"]); protocol != "any" {
+		r.protocol = protocol
+	}
+	if ports := fields["LocalPort"]; ports != "" && ports != "Any" {
+		set, err := parsePorts(ports)
+		if err != nil {
+			return nil
+		}
+		r.ports = set
+	}
+	if local := fields["LocalIP"]; local != "" && local != "Any" {
+		for _, v := range strings.Split(local, ",") {
+			prefix, err := parsePrefixOrAddr(v)
+			if err != nil {
+				return nil
+			}
+			r.destination = append(r.destination, prefix)
+		}
+	}
+	if remote := fields["RemoteIP"]; remote != "" && remote != "Any" {
+		r.source = remote
+	}
+	return r
+}
@@ -0,0 +1,170 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// fleetZone is the zone the agents are answered in
+	fleetZone = "ips.internal."
+
+	// fleetTTL is the time resolvers cache the answers, short as the addresses of the agents change
+	fleetTTL = 60
+
+	// maxUDPAnswer is the size of a response over udp without EDNS, larger ones are truncated
+	maxUDPAnswer = 512
+
+	// DNS response codes of the fleet responder
+	dnsNameError, dnsRefused = 3, 5
+)
+
+var (
+	// dnsListen is the address the server mode answers DNS queries for the agents on, not served if empty
+	dnsListen string
+
+	// dnsTenant is the tenant whose agents are answered, DNS queries carry no token
+	dnsTenant string
+)
+
+// serveFleetDNS answers A and AAAA queries for <agent>.ips.internal with the addresses last pushed by the agent of
+// dnsTenant over udp and tcp on dnsListen until ctx is cancelled. It returns once listening.
+func serveFleetDNS(ctx context.Context, logger *slog.Logger) error {
+	packets, err := net.ListenPacket("udp", dnsListen)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", dnsListen)
+	if err != nil {
+		_ = packets.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = packets.Close()
+		_ = listener.Close()
+	}()
+	logger.Info("answering DNS", "address", dnsListen, "zone", fleetZone, "tenant", dnsTenant)
+
+	go func() {
+		buf := make([]byte, maxDNSMessage)
+		for {
+			n, from, err := packets.ReadFrom(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				logger.Debug("could not read DNS query", "err", err)
+				continue
+			}
+			response := fleetResponse(buf[:n], maxUDPAnswer)
+			if response == nil {
+				continue
+			}
+			if _, err := packets.WriteTo(response, from); err != nil {
+				logger.Debug("could not send DNS response", "err", err, "to", from.String())
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				logger.Debug("could not accept DNS connection", "err", err)
+				continue
+			}
+			go serveFleetStream(logger, conn)
+		}
+	}()
+	return nil
+}
+
+// serveFleetStream answers the length prefixed queries of a tcp connection until the client closes it or is idle
+// for 10 seconds.
+func serveFleetStream(logger *slog.Logger, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		response := fleetResponse(query, maxDNSMessage)
+		if response == nil {
+			return
+		}
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...)); err != nil {
+			logger.Debug("could not send DNS response", "err", err)
+			return
+		}
+	}
+}
+
+// fleetResponse answers a query, nil if it can't be parsed. Names outside the zone are refused, unknown agents
+// answered with NXDOMAIN. Responses larger than size are sent without answers and marked as truncated, so the
+// client asks again over tcp.
+func fleetResponse(query []byte, size int) []byte {
+	id, questions, err := parseQuery(query)
+	if err != nil || len(questions) != 1 {
+		return nil
+	}
+	q := questions[0]
+	rcode := 0
+	answers := make([]*dnsRecord, 0)
+	name, ok := strings.CutSuffix(strings.ToLower(q.name), "."+fleetZone)
+	switch state := agents.find(dnsTenant, name); {
+	case !ok:
+		rcode = dnsRefused
+	case state == nil:
+		rcode = dnsNameError
+	default:
+		answers = fleetRecords(q, state.Addresses)
+	}
+	response := dnsResponse(id, questions, answers, nil, fleetTTL)
+	if len(response) > size {
+		response = dnsResponse(id, questions, nil, nil, fleetTTL)
+		response[2] |= 0x02
+	}
+	// recursion desired is copied from the query, the response code fills the low bits
+	response[2] |= query[2] & 0x01
+	response[3] |= byte(rcode)
+	return response
+}
+
+// fleetRecords returns the A or AAAA records asked for by the question of the addresses of an agent. Loopback and
+// link-local addresses are left out, they are of no use to other hosts.
+func fleetRecords(q dnsQuestion, addresses ips) []*dnsRecord {
+	result := make([]*dnsRecord, 0)
+	for _, i := range addresses {
+		prefix, err := parsePrefixOrAddr(i.Address)
+		if err != nil {
+			continue
+		}
+		address := prefix.Addr().Unmap()
+		if address.IsLoopback() || address.IsLinkLocalUnicast() {
+			continue
+		}
+		if address.Is4() && (q.rrtype == dnsTypeA || q.rrtype == dnsTypeANY) {
+			result = append(result, &dnsRecord{name: q.name, rrtype: dnsTypeA, data: address.AsSlice()})
+		}
+		if address.Is6() && (q.rrtype == dnsTypeAAAA || q.rrtype == dnsTypeANY) {
+			result = append(result, &dnsRecord{name: q.name, rrtype: dnsTypeAAAA, data: address.AsSlice()})
+		}
+	}
+	return result
+}
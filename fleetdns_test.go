@@ -0,0 +1,55 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestFleetRecords(t *testing.T) {
+	addresses := ips{
+		{Address: "192.0.2.10/24", Interface: "eth0"},
+		{Address: "2001:db8::10/64", Interface: "eth0"},
+		{Address: "198.51.100.7", Interface: "public"},
+		{Address: "2001:db8:ffff::7", Interface: "public"},
+		{Address: "127.0.0.1/8", Interface: "lo"},
+		{Address: "::1/128", Interface: "lo"},
+		{Address: "fe80::1/64", Interface: "eth0"},
+		{Address: "169.254.0.1", Interface: "eth1"},
+		{Address: "not an address", Interface: "eth2"},
+	}
+	tests := []struct {
+		name   string
+		rrtype uint16
+		want   []string
+	}{
+		{name: "a", rrtype: dnsTypeA, want: []string{"192.0.2.10", "198.51.100.7"}},
+		{name: "aaaa", rrtype: dnsTypeAAAA, want: []string{"2001:db8::10", "2001:db8:ffff::7"}},
+		{name: "any", rrtype: dnsTypeANY, want: []string{"192.0.2.10", "2001:db8::10", "198.51.100.7", "2001:db8:ffff::7"}},
+		{name: "txt", rrtype: dnsTypeTXT, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := dnsQuestion{name: "host.ips.internal.", rrtype: tt.rrtype}
+			got := make([]string, 0)
+			for _, record := range fleetRecords(q, addresses) {
+				address, ok := netip.AddrFromSlice(record.data)
+				if !ok {
+					t.Fatalf("record data %x is not an address", record.data)
+				}
+				if record.name != q.name {
+					t.Errorf("record named %s, want %s", record.name, q.name)
+				}
+				if wantType := map[bool]uint16{true: dnsTypeA, false: dnsTypeAAAA}[address.Is4()]; record.rrtype != wantType {
+					t.Errorf("%s in a record of type %d, want %d", address, record.rrtype, wantType)
+				}
+				got = append(got, address.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
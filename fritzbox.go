@@ -0,0 +1,77 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// fritzboxPort is the port the TR-064 interface of the Fritz!Box listens on for plain http
+const fritzboxPort = "49000"
+
+// fritzboxServices are the TR-064 services describing the internet connection, PPPoE connections, e.g. DSL, are
+// asked before IP connections, e.g. cable or fibre
+var fritzboxServices = []struct{ path, serviceType string }{
+	{"/upnp/control/wanpppconn1", "urn:dslforum-org:service:WANPPPConnection:1"},
+	{"/upnp/control/wanipconnection1", "urn:dslforum-org:service:WANIPConnection:1"},
+}
+
+// fritzboxLookup asks a Fritz!Box for the public address of its internet connection using TR-064. The labels
+// carry the connection status, its uptime and for IPv6 the delegated prefix.
+func fritzboxLookup(t string) (string, map[string]string, error) {
+	base, err := routerBase(fritzboxPort)
+	if err != nil {
+		return "", nil, err
+	}
+	var errs []error
+	for _, s := range fritzboxServices {
+		g := &igd{controlURL: base + s.path, serviceType: s.serviceType, transport: &digestTransport{}}
+		address, labels, err := fritzboxConnection(g, t)
+		if err == nil {
+			return address, labels, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.path, err))
+	}
+	return "", nil, errors.Join(errs...)
+}
+
+// fritzboxConnection asks a single connection service of the Fritz!Box.
+func fritzboxConnection(g *igd, t string) (string, map[string]string, error) {
+	status, err := g.call("GetStatusInfo")
+	if err != nil {
+		return "", nil, err
+	}
+	if status["NewConnectionStatus"] != "Connected" {
+		return "", nil, fmt.Errorf("connection is %s", status["NewConnectionStatus"])
+	}
+	uptime, _ := strconv.Atoi(status["NewUptime"])
+
+	var answer, prefix string
+	if t == "ipv4" {
+		values, err := g.call("GetExternalIPAddress")
+		if err != nil {
+			return "", nil, err
+		}
+		answer = values["NewExternalIPAddress"]
+		if answer == "0.0.0.0" {
+			answer = ""
+		}
+	} else {
+		values, err := g.call("X_AVM_DE_GetExternalIPv6Address")
+		if err != nil {
+			return "", nil, err
+		}
+		answer = values["NewExternalIPv6Address"]
+		if values, err := g.call("X_AVM_DE_GetIPv6Prefix"); err == nil && values["NewIPv6Prefix"] != "" {
+			prefix = values["NewIPv6Prefix"] + "/" + values["NewPrefixLength"]
+		}
+	}
+	address, err := parseAddress("fritzbox", answer, t)
+	if err != nil {
+		return "", nil, err
+	}
+	return address, routerLabels("fritzbox", status["NewConnectionStatus"], time.Duration(uptime)*time.Second, prefix), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+// noExternal forbids contacting third party services
+var noExternal bool
+
+var (
+	trustedMu sync.Mutex
+
+	// trustedHosts contains hosts the user configured explicitly, they may be contacted with no-external set
+	trustedHosts = make([]string, 0)
+)
+
+// trustURL marks the host of rawURL as explicitly configured by the user.
+func trustURL(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	if !slices.Contains(trustedHosts, u.Host) {
+		trustedHosts = append(trustedHosts, u.Host)
+	}
+}
+
+// isTrusted reports whether the host was configured explicitly by the user.
+func isTrusted(host string) bool {
+	trustedMu.Lock()
+	defer trustedMu.Unlock()
+	return slices.Contains(trustedHosts, host)
+}
+
+// gateTransport rejects requests to hosts not configured explicitly by the user when no-external is set. All
+// http clients of the tool are created using newHTTPClient, which routes them through the gate.
+type gateTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip passes the request to the wrapped transport if it may leave the machine.
+func (t *gateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if noExternal && !isTrusted(req.URL.Host) {
+		return nil, fmt.Errorf("%w: %s", ErrExternalDisabled, req.URL.Host)
+	}
+	return t.next.RoundTrip(req)
+}
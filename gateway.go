@@ -0,0 +1,27 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// gatewayAddress overrides the detected default gateway contacted for NAT-PMP and PCP
+var gatewayAddress string
+
+// defaultGateway returns the IPv4 gateway of the default route or the one given using the gateway flag.
+func defaultGateway() (net.IP, error) {
+	if gatewayAddress != "" {
+		gw := net.ParseIP(gatewayAddress).To4()
+		if gw == nil {
+			return nil, fmt.Errorf("gateway %q is not an ipv4 address", gatewayAddress)
+		}
+		return gw, nil
+	}
+	gw, err := systemGateway()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine default gateway, use -gateway: %w", err)
+	}
+	return gw, nil
+}
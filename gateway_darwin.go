@@ -0,0 +1,43 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// gatewayDiscoverySupport names where the routing table is dumped from
+const gatewayDiscoverySupport = "routing socket"
+
+// systemGateway dumps the routing table using sysctl and returns the gateway of the IPv4 default route.
+func systemGateway() (net.IP, error) {
+	rib, err := syscall.RouteRIB(syscall.NET_RT_DUMP, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("sysctl", err)
+	}
+	messages, err := syscall.ParseRoutingMessage(rib)
+	if err != nil {
+		return nil, os.NewSyscallError("parse", err)
+	}
+	for _, m := range messages {
+		rm, ok := m.(*syscall.RouteMessage)
+		if !ok || rm.Header.Flags&syscall.RTF_GATEWAY == 0 {
+			continue
+		}
+		addrs, err := syscall.ParseRoutingSockaddr(rm)
+		if err != nil || len(addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+		dst, ok := addrs[syscall.RTAX_DST].(*syscall.SockaddrInet4)
+		if !ok || dst.Addr != [4]byte{} {
+			continue
+		}
+		if gw, ok := addrs[syscall.RTAX_GATEWAY].(*syscall.SockaddrInet4); ok {
+			return net.IPv4(gw.Addr[0], gw.Addr[1], gw.Addr[2], gw.Addr[3]).To4(), nil
+		}
+	}
+	return nil, errors.New("no ipv4 default route")
+}
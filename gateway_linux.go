@@ -0,0 +1,52 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// gatewayDiscoverySupport names the file the default gateway is read from
+const gatewayDiscoverySupport = "/proc/net/route"
+
+// systemGateway reads the gateway of the IPv4 default route from /proc/net/route. Addresses are stored as hex in
+// host byte order there.
+func systemGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		if err != nil || flags&0x2 == 0 { // RTF_GATEWAY
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		gw := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gw, binary.LittleEndian.Uint32(raw))
+		return gw, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no ipv4 default route")
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// gatewayDiscoverySupport is empty, the gateway has to be given using -gateway on this platform
+const gatewayDiscoverySupport = ""
+
+// systemGateway is not implemented on this platform, the gateway has to be given using the gateway flag.
+func systemGateway() (net.IP, error) {
+	return nil, errors.ErrUnsupported
+}
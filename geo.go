@@ -0,0 +1,80 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+// defaultGeoURL is the third party GeoIP service used unless configured otherwise
+const defaultGeoURL = "https://ipinfo.io/%s/json"
+
+// geoURL is the format string of the GeoIP service receiving the address, it has to answer like ipinfo.io. It is
+// trusted by the no-external gate if set, the default service is not.
+var geoURL string
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "geoip",
+		flags: func() {
+			flag.StringVar(&geoURL, "geoip-url", "", "GeoIP service answering like ipinfo.io, %s is replaced by the address, defaults to "+defaultGeoURL)
+		},
+		urls: []*string{&geoURL},
+	})
+}
+
+// geoInfo is the location and network an address belongs to.
+type geoInfo struct {
+
+	// Country is the ISO 3166-1 alpha-2 code of the country
+	Country string
+
+	// ASN is the autonomous system number in the form AS1234
+	ASN string
+
+	// Organization is the name of the autonomous system
+	Organization string
+}
+
+// lookupGeo asks the GeoIP service for the country and autonomous system of address.
+func lookupGeo(address string) (*geoInfo, error) {
+	client := newHTTPClient(nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf(cmp.Or(geoURL, defaultGeoURL), address), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geoip service answered with %s", resp.Status)
+	}
+
+	var answer struct {
+		Country string `json:"country"`
+		Org     string `json:"org"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&answer); err != nil {
+		return nil, err
+	}
+	info := &geoInfo{Country: strings.ToUpper(answer.Country)}
+	// ipinfo reports the network as "AS1234 Name of the organization"
+	if asn, org, found := strings.Cut(answer.Org, " "); found && strings.HasPrefix(asn, "AS") {
+		info.ASN, info.Organization = asn, org
+	} else {
+		info.Organization = answer.Org
+	}
+	return info, nil
+}
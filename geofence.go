@@ -0,0 +1,111 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+var allowedCountries, allowedASNs, alertWebhook string
+
+type (
+
+	// geofence alerts when the country or autonomous system of the public address changes or leaves the allowed set.
+	geofence struct {
+
+		// countries contains the allowed country codes, empty to allow all
+		countries []string
+
+		// asns contains the allowed autonomous systems, empty to allow all
+		asns []string
+
+		// last contains the location of the public address per family
+		last map[string]*geoInfo
+
+		logger *slog.Logger
+	}
+
+	// geoAlert is the payload sent to the alert webhook.
+	geoAlert struct {
+
+		// Time is the moment the alert was raised
+		Time time.Time
+
+		// Reason describes why the alert was raised
+		Reason string
+
+		// Address is the public address the alert is about
+		Address string
+
+		// Current is the location of Address
+		Current *geoInfo
+
+		// Previous is the location of the public address before the change
+		Previous *geoInfo `json:",omitempty"`
+	}
+)
+
+// newGeofence creates a geofence from the allowed-country and allowed-asn flags. It returns nil if geofencing is
+// not enabled, which is the case when neither an allowed set nor an alert webhook is configured.
+func newGeofence(logger *slog.Logger) *geofence {
+	if allowedCountries == "" && allowedASNs == "" && alertWebhook == "" {
+		return nil
+	}
+	return &geofence{
+		countries: splitList(strings.ToUpper(allowedCountries)),
+		asns:      splitList(strings.ToUpper(allowedASNs)),
+		last:      make(map[string]*geoInfo),
+		logger:    logger,
+	}
+}
+
+// check looks up the location of the added public addresses and raises an alert if it changed or is not allowed.
+func (g *geofence) check(added ips) {
+	if g == nil || offline {
+		return
+	}
+	for _, i := range added {
+		if !i.isPublic() {
+			continue
+		}
+		info, err := lookupGeo(i.Address)
+		if err != nil {
+			g.logger.Warn("could not locate public ip", "err", err, "address", i.Address)
+			continue
+		}
+		previous := g.last[i.family()]
+		g.last[i.family()] = info
+
+		reasons := make([]string, 0)
+		if len(g.countries) > 0 && !slices.Contains(g.countries, info.Country) {
+			reasons = append(reasons, "country "+info.Country+" not allowed")
+		}
+		if len(g.asns) > 0 && !slices.Contains(g.asns, info.ASN) {
+			reasons = append(reasons, "asn "+info.ASN+" not allowed")
+		}
+		if previous != nil && previous.Country != info.Country {
+			reasons = append(reasons, "country changed from "+previous.Country+" to "+info.Country)
+		}
+		if previous != nil && previous.ASN != info.ASN {
+			reasons = append(reasons, "asn changed from "+previous.ASN+" to "+info.ASN)
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		g.alert(&geoAlert{Time: time.Now(), Reason: strings.Join(reasons, ", "), Address: i.Address, Current: info, Previous: previous})
+	}
+}
+
+// alert logs the alert and sends it to the alert webhook if configured.
+func (g *geofence) alert(a *geoAlert) {
+	g.logger.Warn("geofence alert", "reason", a.Reason, "address", a.Address, "country", a.Current.Country, "asn", a.Current.ASN)
+	if alertWebhook == "" {
+		return
+	}
+	if err := postWebhook(alertWebhook, a); err != nil {
+		g.logger.Error("could not send alert", "err", err)
+	}
+}
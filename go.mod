@@ -2,4 +2,10 @@ module github.com/sascha-andres/ips
 
 go 1.24.0
 
-require github.com/sascha-andres/reuse v0.7.0
+require (
+	fyne.io/systray v1.12.2
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/sascha-andres/reuse v0.7.0
+	go.starlark.net v0.0.0-20250417143717-f57e51f710eb
+	golang.org/x/sys v0.38.0
+)
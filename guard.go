@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+
+	// providerState keeps track of the requests sent to a single provider endpoint.
+	providerState struct {
+
+		// lastRequest is the time the endpoint was queried last
+		lastRequest time.Time
+
+		// failures counts consecutive failed queries
+		failures uint
+
+		// openUntil disables the endpoint until the given time once the circuit breaker tripped
+		openUntil time.Time
+
+		// recent contains the outcomes of the last providerRecent queries, oldest first
+		recent []queryOutcome
+
+		// lastSuccess and lastResult are the time and address of the last answer, lastFailure and lastError those
+		// of the last failed query
+		lastSuccess, lastFailure time.Time
+		lastResult, lastError    string
+	}
+
+	// queryOutcome is the latency and the success of a single query.
+	queryOutcome struct {
+		latency time.Duration
+		failed  bool
+	}
+
+	// providerReport describes how a provider endpoint fared recently, as reported by /providers.
+	providerReport struct {
+		Name   string
+		Family string
+
+		// State is ok, disabled while the circuit breaker is open or rate-limited until the minimum interval passed
+		State         string
+		DisabledUntil time.Time `json:",omitzero"`
+
+		// Current is set for the provider that answered last for the family
+		Current bool
+
+		// Queries is the number of recent queries, ErrorRate the fraction of them that failed
+		Queries   int
+		ErrorRate float64
+
+		// Latency is the mean latency and LastLatency the latency of the latest of the recent queries
+		Latency     string `json:",omitempty"`
+		LastLatency string `json:",omitempty"`
+
+		LastSuccess time.Time `json:",omitzero"`
+		LastResult  string    `json:",omitempty"`
+		LastFailure time.Time `json:",omitzero"`
+		LastError   string    `json:",omitempty"`
+	}
+
+	// providerGuard rate limits queries per provider endpoint and temporarily disables endpoints that keep failing,
+	// so long-running instances behave politely toward free services. A nil guard allows every query.
+	providerGuard struct {
+		mu sync.Mutex
+
+		// minInterval is the minimum time between two queries to the same endpoint
+		minInterval time.Duration
+
+		// maxFailures is the number of consecutive failures opening the circuit breaker
+		maxFailures uint
+
+		// cooldown is the time an endpoint stays disabled after the circuit breaker opened
+		cooldown time.Duration
+
+		// states is keyed by provider name and family, order contains the keys in the order they were used first
+		states map[string]*providerState
+		order  []string
+
+		// current maps the families to the key of the endpoint that answered last
+		current map[string]string
+
+		logger *slog.Logger
+	}
+)
+
+// providerRecent is the number of queries per provider endpoint the latency and error rate are computed of
+const providerRecent = 20
+
+// guard is consulted before querying providers, it is only set by long-running modes
+var guard *providerGuard
+
+// newProviderGuard creates a guard using the given limits.
+func newProviderGuard(logger *slog.Logger, minInterval time.Duration, maxFailures uint, cooldown time.Duration) *providerGuard {
+	return &providerGuard{
+		minInterval: minInterval,
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+		states:      make(map[string]*providerState),
+		current:     make(map[string]string),
+		logger:      logger,
+	}
+}
+
+// configure changes the limits, the state of the endpoints is kept.
+func (g *providerGuard) configure(minInterval time.Duration, maxFailures uint, cooldown time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.minInterval, g.maxFailures, g.cooldown = minInterval, maxFailures, cooldown
+}
+
+// forget drops the time of the last request to every endpoint, so the next query is not rate limited. Disabled
+// endpoints stay disabled.
+func (g *providerGuard) forget() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, state := range g.states {
+		state.lastRequest = time.Time{}
+	}
+}
+
+// allow reports whether the provider may be queried for the family now and records the request if so.
+func (g *providerGuard) allow(p *provider, family string) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state := g.state(p, family)
+	now := time.Now()
+	if now.Before(state.openUntil) {
+		g.logger.Debug("provider disabled by circuit breaker", "provider", p.Name, "family", family, "until", state.openUntil)
+		return false
+	}
+	if !state.lastRequest.IsZero() && now.Sub(state.lastRequest) < g.minInterval {
+		g.logger.Debug("provider rate limited", "provider", p.Name, "family", family)
+		return false
+	}
+	state.lastRequest = now
+	return true
+}
+
+// record updates the circuit breaker and the statistics of the provider with the outcome of a query.
+func (g *providerGuard) record(p *provider, family, address string, latency time.Duration, err error) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state := g.state(p, family)
+	state.recent = append(state.recent, queryOutcome{latency: latency, failed: err != nil})
+	if len(state.recent) > providerRecent {
+		state.recent = state.recent[len(state.recent)-providerRecent:]
+	}
+	if err == nil {
+		state.failures = 0
+		state.lastSuccess, state.lastResult = time.Now(), address
+		g.current[family] = p.Name + "/" + family
+		return
+	}
+	state.lastFailure, state.lastError = time.Now(), err.Error()
+	state.failures++
+	if g.maxFailures > 0 && state.failures >= g.maxFailures {
+		state.openUntil = time.Now().Add(g.cooldown)
+		state.failures = 0
+		g.logger.Warn("disabling failing provider", "provider", p.Name, "family", family, "until", state.openUntil)
+	}
+}
+
+// state returns the state for the provider and family, creating it on first use. The caller must hold the lock.
+func (g *providerGuard) state(p *provider, family string) *providerState {
+	key := p.Name + "/" + family
+	state, ok := g.states[key]
+	if !ok {
+		state = &providerState{}
+		g.states[key] = state
+		g.order = append(g.order, key)
+	}
+	return state
+}
+
+// report describes the endpoints queried so far in the order they were used first, nil for a nil guard.
+func (g *providerGuard) report() []*providerReport {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	reports := make([]*providerReport, 0, len(g.order))
+	for _, key := range g.order {
+		state := g.states[key]
+		name, family, _ := strings.Cut(key, "/")
+		r := &providerReport{
+			Name:        name,
+			Family:      family,
+			State:       "ok",
+			Current:     g.current[family] == key,
+			Queries:     len(state.recent),
+			LastSuccess: state.lastSuccess,
+			LastResult:  state.lastResult,
+			LastFailure: state.lastFailure,
+			LastError:   state.lastError,
+		}
+		switch {
+		case now.Before(state.openUntil):
+			r.State, r.DisabledUntil = "disabled", state.openUntil
+		case !state.lastRequest.IsZero() && now.Sub(state.lastRequest) < g.minInterval:
+			r.State = "rate-limited"
+		}
+		if len(state.recent) > 0 {
+			var total time.Duration
+			failed := 0
+			for _, o := range state.recent {
+				total += o.latency
+				if o.failed {
+					failed++
+				}
+			}
+			r.ErrorRate = float64(failed) / float64(len(state.recent))
+			r.Latency = (total / time.Duration(len(state.recent))).Round(time.Millisecond).String()
+			r.LastLatency = state.recent[len(state.recent)-1].latency.Round(time.Millisecond).String()
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
@@ -0,0 +1,146 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+
+	// heAttempt is the outcome of a connection attempt to the target using a single address family.
+	heAttempt struct {
+
+		// Family is either ipv4 or ipv6
+		Family string
+
+		// Remote is the address of the target that was connected to
+		Remote string `json:",omitempty"`
+
+		// Local is the source address the operating system chose for the connection
+		Local string `json:",omitempty"`
+
+		// Duration is the time it took to establish the connection
+		Duration string `json:",omitempty"`
+
+		// Error describes why the attempt failed
+		Error string `json:",omitempty"`
+	}
+
+	// heResult is the report of a happy eyeballs diagnostic run.
+	heResult struct {
+
+		// Host is the target that was connected to
+		Host string
+
+		// Port is the tcp port that was connected to
+		Port uint
+
+		// Winner is the family of the connection established by a dual-stack race
+		Winner string
+
+		// Attempts contains the outcome of connecting with each family on its own
+		Attempts []*heAttempt
+	}
+)
+
+// runHappyEyeballs races a dual-stack connection to the host given as first argument and reports which family
+// won, how long each family took to connect and which local source addresses were chosen.
+func runHappyEyeballs(logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		logger.Error("usage: ips he <host>")
+		return exitInternalError
+	}
+	host := args[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		logger.Error("could not resolve host", "err", err, "host", host)
+		return exitInternalError
+	}
+
+	result := &heResult{Host: host, Port: port}
+	var wg sync.WaitGroup
+	for _, family := range []string{"ipv4", "ipv6"} {
+		target := firstOfFamily(addrs, family)
+		attempt := &heAttempt{Family: family}
+		result.Attempts = append(result.Attempts, attempt)
+		if target == nil {
+			attempt.Error = "no address"
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialAttempt(attempt, networkFor(family), net.JoinHostPort(target.String(), strconv.Itoa(int(port))))
+		}()
+	}
+	wg.Wait()
+
+	race := &heAttempt{}
+	dialAttempt(race, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if race.Error != "" {
+		logger.Error("dual-stack connection failed", "err", race.Error, "host", host)
+		return exitInternalError
+	}
+	result.Winner = familyOf(race.Remote)
+
+	if jsonOutput {
+		return printJSON(logger, result)
+	}
+	fmt.Printf("winner\t%s\t%s\n", result.Winner, race.Duration)
+	for _, a := range result.Attempts {
+		if a.Error != "" {
+			fmt.Printf("%s\tfailed\t%s\n", a.Family, a.Error)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s -> %s\n", a.Family, a.Duration, a.Local, a.Remote)
+	}
+	return exitOK
+}
+
+// dialAttempt connects to address and records the timing and the addresses used in attempt.
+func dialAttempt(attempt *heAttempt, network, address string) {
+	dialer := &net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := dialer.Dial(network, address)
+	audit("tcp", address, start, "", err)
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	attempt.Duration = time.Since(start).Round(time.Microsecond).String()
+	attempt.Local = conn.LocalAddr().String()
+	attempt.Remote = conn.RemoteAddr().String()
+}
+
+// firstOfFamily returns the first address of the given family or nil if there is none.
+func firstOfFamily(addrs []net.IPAddr, family string) net.IP {
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) == (family == "ipv4") {
+			return a.IP
+		}
+	}
+	return nil
+}
+
+// familyOf returns the family of a host:port address.
+func familyOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if parsed := net.ParseIP(host); parsed != nil && parsed.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
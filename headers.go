@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerOverride is a request header sent to all providers or to a single one.
+type headerOverride struct {
+
+	// provider limits the header to the named provider, empty for all providers
+	provider string
+
+	// name is the header name
+	name string
+
+	// value is the header value
+	value string
+
+	// flag is the flag value the header was parsed from, before resolving a secret
+	flag string
+}
+
+// headerValues is the flag.Value collecting the header flags.
+type headerValues struct{}
+
+// Set parses and adds a header.
+func (headerValues) Set(value string) error {
+	return parseHeader(value)
+}
+
+// String returns the headers as given, one per line.
+func (headerValues) String() string {
+	return strings.Join(headerValues{}.values(), "\n")
+}
+
+// reset removes all headers.
+func (headerValues) reset() {
+	headers = nil
+}
+
+// values returns the headers as given.
+func (headerValues) values() []string {
+	values := make([]string, 0, len(headers))
+	for _, h := range headers {
+		values = append(values, h.flag)
+	}
+	return values
+}
+
+var (
+	userAgent string
+	headers   []headerOverride
+)
+
+// parseHeader parses a header flag value of the form "Name: value" or "provider=Name: value". The value may be a
+// secret:// uri.
+func parseHeader(value string) error {
+	h := headerOverride{flag: value}
+	if prefix, rest, found := strings.Cut(value, "="); found && !strings.Contains(prefix, ":") {
+		if providerByName(prefix) == nil {
+			return fmt.Errorf("unknown provider %q", prefix)
+		}
+		h.provider, value = prefix, rest
+	}
+	name, headerValue, found := strings.Cut(value, ":")
+	if !found || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q is not of the form Name: value", value)
+	}
+	secret, err := resolveSecret(strings.TrimSpace(headerValue))
+	if err != nil {
+		return err
+	}
+	h.name, h.value = strings.TrimSpace(name), secret
+	headers = append(headers, h)
+	return nil
+}
+
+// applyHeaders sets the user agent and all header overrides applicable to the provider on req.
+func applyHeaders(req *http.Request, p *provider) {
+	req.Header.Set("User-Agent", userAgent)
+	for _, h := range headers {
+		if h.provider == "" || h.provider == p.Name {
+			req.Header.Set(h.name, h.value)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthListen is the address watch serves /healthz and /readyz on, disabled if empty
+var healthListen string
+
+// daemonHealth tracks the progress of the watch loop for the health endpoints.
+type daemonHealth struct {
+	mu sync.Mutex
+
+	// loop is set once a watch loop started, serve alone is healthy as long as it answers
+	loop bool
+
+	// started is the time the watch loop started
+	started time.Time
+
+	// lastPoll is the time the addresses were polled last, zero before the first poll
+	lastPoll time.Time
+
+	// lastErr is the error of the last poll, nil if addresses were found
+	lastErr error
+}
+
+// health is the state reported by the health endpoints of this process
+var health = &daemonHealth{}
+
+// start records that a watch loop started, its progress is reported from now on.
+func (h *daemonHealth) start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loop, h.started = true, time.Now()
+}
+
+// polled records that the watch loop polled the addresses.
+func (h *daemonHealth) polled(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastPoll, h.lastErr = time.Now(), err
+}
+
+// stallAfter is the time without a poll after which the watch loop is considered wedged. It allows for the
+// jittered interval and a poll asking every provider for both families running into the timeout.
+func stallAfter() time.Duration {
+	return 2*interval + 10*timeout
+}
+
+// handleHealthz answers 200 while the process makes progress or polling is paused and 503 if the watch loop
+// stalled, so orchestrators restart the instance.
+func (h *daemonHealth) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	last := h.lastPoll
+	if last.IsZero() {
+		last = h.started
+	}
+	if h.loop && !control.isPaused() && time.Since(last) > stallAfter() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintf(w, "watch loop stalled, no poll for %s\n", time.Since(last).Round(time.Second))
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz answers 200 once the addresses were polled successfully and 503 before the first poll or after a
+// failed one, so no traffic is routed to an instance without data.
+func (h *daemonHealth) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case h.loop && h.lastPoll.IsZero():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, "waiting for the first poll")
+	case h.loop && h.lastErr != nil:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintf(w, "last poll failed: %s\n", h.lastErr)
+	default:
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+// handleAddresses answers the addresses known after the last poll as JSON, each with the time watch observed it
+// first and last.
+func handleAddresses(w http.ResponseWriter, _ *http.Request) {
+	list := sightings.addresses()
+	if list == nil {
+		list = make(ips, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// providersPage renders the provider statistics for browsers
+var providersPage = template.Must(template.New("providers").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="30"><title>ips providers</title>
+<style>body{font-family:sans-serif}td,th{padding:2px 8px;text-align:left}.disabled{color:#b00}.current{font-weight:bold}</style>
+</head><body><h1>Public IP providers</h1><table>
+<tr><th>provider</th><th>family</th><th>state</th><th>queries</th><th>errors</th><th>latency</th><th>last latency</th><th>last result</th><th>last error</th></tr>
+{{- range . }}
+<tr class="{{ .State }}{{ if .Current }} current{{ end }}"><td>{{ .Name }}</td><td>{{ .Family }}</td><td>{{ .State }}{{ if not .DisabledUntil.IsZero }} until {{ .DisabledUntil.Format "15:04:05" }}{{ end }}</td><td>{{ .Queries }}</td><td>{{ percent .ErrorRate }}</td><td>{{ .Latency }}</td><td>{{ .LastLatency }}</td><td>{{ .LastResult }}{{ if not .LastSuccess.IsZero }} at {{ .LastSuccess.Format "15:04:05" }}{{ end }}</td><td>{{ .LastError }}</td></tr>
+{{- end }}
+</table></body></html>
+`))
+
+// handleProviders answers how the public ip providers fared recently: their state, error rate, latency and last
+// result, the provider currently used for each family marked. Browsers receive a table, other clients JSON.
+func handleProviders(w http.ResponseWriter, r *http.Request) {
+	reports := guard.report()
+	if reports == nil {
+		reports = make([]*providerReport, 0)
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = providersPage.Execute(w, reports)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reports)
+}
+
+// registerHealth adds the health endpoints to mux.
+func registerHealth(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", health.handleHealthz)
+	mux.HandleFunc("GET /readyz", health.handleReadyz)
+}
+
+// serveHealth serves the health endpoints on healthListen until ctx is cancelled. Nothing is served if the address
+// is empty, failing to listen is returned.
+func serveHealth(ctx context.Context, logger *slog.Logger) error {
+	if healthListen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", healthListen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	registerHealth(mux)
+	mux.HandleFunc("GET /addresses", handleAddresses)
+	mux.HandleFunc("GET /providers", handleProviders)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("could not serve health endpoints", "err", err)
+		}
+	}()
+	logger.Info("serving health endpoints", "address", listener.Addr().String())
+	return nil
+}
+
+// runHealth checks whether ips would work as a daemon: the interfaces can be enumerated and, unless offline, at
+// least one of the selected providers answers for ipv4 and for ipv6 if the host has a global ipv6 address. Every
+// provider is asked, failures of single providers are listed in the detail. Exits with exitCheckFailed if a check
+// failed.
+func runHealth(logger *slog.Logger, _ []string) int {
+	checks := make([]*check, 0)
+	local, err := getInterfaceAddresses(logger)
+	families := []string{"ipv4"}
+	if err != nil {
+		checks = append(checks, &check{Name: "interfaces", Detail: err.Error()})
+	} else {
+		checks = append(checks, &check{Name: "interfaces", OK: true, Detail: fmt.Sprintf("%d addresses", len(local))})
+		for _, i := range local {
+			if c, err := classifyAddress(i.Address); err == nil && c.Family == "ipv6" && c.Class == "global" {
+				families = append(families, "ipv6")
+				break
+			}
+		}
+	}
+	if offline {
+		return printChecks(logger, checks)
+	}
+
+	providers, err := selectedProviders()
+	if err != nil {
+		checks = append(checks, &check{Name: "providers", Detail: err.Error()})
+		return printChecks(logger, checks)
+	}
+	for _, family := range families {
+		c := &check{Name: "public " + family}
+		answered, failed := make([]string, 0), make([]string, 0)
+		for _, p := range providers {
+			start := time.Now()
+			address, err := p.query(family)
+			if err != nil {
+				logger.Warn("provider failed", "err", err, "provider", p.Name, "family", family)
+				failed = append(failed, p.Name)
+				continue
+			}
+			answered = append(answered, fmt.Sprintf("%s %s in %s", p.Name, address, time.Since(start).Round(time.Millisecond)))
+		}
+		c.OK = len(answered) > 0
+		c.Detail = strings.Join(answered, ", ")
+		if len(failed) > 0 {
+			c.Detail = strings.TrimPrefix(c.Detail+", failed: "+strings.Join(failed, " "), ", ")
+		}
+		checks = append(checks, c)
+	}
+	return printChecks(logger, checks)
+}
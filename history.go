@@ -0,0 +1,399 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+var (
+	// historyFile is the file changes are recorded in by watch, defaults to history.jsonl in the state directory
+	historyFile string
+
+	// historyKeep is the age after which records are compacted, 0 keeps the history forever
+	historyKeep = 90 * 24 * time.Hour
+)
+
+// pruneInterval is the time between two compactions of the history in watch mode
+const pruneInterval = 24 * time.Hour
+
+// historyExportVersion is the version of the export format written
+const historyExportVersion = 1
+
+// historyExport is the document written by ips history export and read by ips history import.
+type historyExport struct {
+
+	// Version of the format, incremented on incompatible changes
+	Version int
+
+	// Host is the name of the exporting host
+	Host string
+
+	// Exported is the time the export was written
+	Exported time.Time
+
+	// Records contains the history, oldest first
+	Records []*historyRecord
+}
+
+// historyRecord is a change as stored in the history file, one JSON document per line.
+type historyRecord struct {
+
+	// Time is the moment the change was detected
+	Time time.Time
+
+	// Snapshot is set for the addresses found when watch started, they replace all addresses known before
+	Snapshot bool `json:",omitempty"`
+
+	// Added contains addresses that appeared
+	Added ips `json:",omitempty"`
+
+	// Removed contains addresses that disappeared
+	Removed ips `json:",omitempty"`
+
+	// Prefix is set when the delegated ipv6 prefix changed
+	Prefix *prefixChange `json:",omitempty"`
+}
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "history",
+		commands: map[string]command{"history": runHistory, "report": runReport, "diff": runDiff},
+		flags: func() {
+			flag.StringVar(&diffFormat, "diff-format", "text", "format of changes printed by diff and watch: text, json or json-patch")
+			flag.BoolVar(&exitCode, "exit-code", false, "exit with 4 if diff found a change")
+			flag.StringVar(&historyFile, "history", "", "file changes are recorded in by watch, defaults to history.jsonl in the state dir")
+			flag.Var(ageValue{&historyKeep}, "history-keep", "age after which history records are compacted, 0 keeps them forever")
+			flag.Var(ageValue{&reportSince}, "since", "period covered by report, e.g. 30d, 2w or 12h")
+		},
+	})
+}
+
+// historyPath returns the location of the history file.
+func historyPath() (string, error) {
+	if historyFile != "" {
+		return historyFile, nil
+	}
+	return statePath("history.jsonl")
+}
+
+// recordHistory appends a change to the history file and syncs it. snapshot marks the initial addresses of a watch
+// run. A record torn by a crash while appending is terminated first, so it does not corrupt the new one.
+func recordHistory(c *change, snapshot bool) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&historyRecord{Time: c.Time, Snapshot: snapshot, Added: c.Added, Removed: c.Removed, Prefix: c.Prefix})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	last := make([]byte, 1)
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadHistory reads all records of the history file, a missing file results in an empty history. Records torn by
+// a crash while appending are skipped.
+func loadHistory() ([]*historyRecord, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make([]*historyRecord, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := &historyRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// pruneHistory compacts the records older than keep into a single snapshot of the addresses assigned at the
+// cutoff, so the history keeps its meaning while not growing unboundedly. The file is replaced atomically.
+// Returns the number of records removed.
+func pruneHistory(keep time.Duration) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	records, err := loadHistory()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-keep)
+	old := 0
+	for old < len(records) && records[old].Time.Before(cutoff) {
+		old++
+	}
+	// a single snapshot at the cutoff is compacted already
+	if old == 0 || old == 1 && records[0].Snapshot && len(records[0].Removed) == 0 && records[0].Prefix == nil {
+		return 0, nil
+	}
+
+	kept := make([]*historyRecord, 0, len(records)-old+1)
+	if state := historyState(records[:old]); len(state) > 0 {
+		kept = append(kept, &historyRecord{Time: cutoff, Snapshot: true, Added: state})
+	}
+	kept = append(kept, records[old:]...)
+	if err := writeHistory(kept); err != nil {
+		return 0, err
+	}
+	return len(records) - len(kept), nil
+}
+
+// historyState replays the records and returns the addresses assigned after the last one.
+func historyState(records []*historyRecord) ips {
+	state := make(ips, 0)
+	for _, r := range records {
+		if r.Snapshot {
+			state = make(ips, 0, len(r.Added))
+		}
+		state = applyChange(state, &change{Added: r.Added, Removed: r.Removed})
+	}
+	// watch restarts report addresses known before as added again
+	seen := make(map[string]bool, len(state))
+	result := make(ips, 0, len(state))
+	for _, i := range state {
+		if !seen[i.key()] {
+			seen[i.key()] = true
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// writeHistory replaces the history file by the records atomically. With dry-run
+// set the file is left untouched.
+func writeHistory(records []*historyRecord) error {
+	if dryRun {
+		return nil
+	}
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return writeState(path, buf.Bytes())
+}
+
+// runHistory manages the history file:
+//
+//   - prune compacts records older than history-keep right away, watch does so once a day
+//   - export [file] writes the history as historyExport to the file or stdout
+//   - import <file> merges an export into the history
+//
+// With dry-run set prune and import print the number of records affected without changing the history.
+func runHistory(logger *slog.Logger, args []string) int {
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "prune":
+		var removed int
+		removed, err = pruneHistory(historyKeep)
+		if err == nil {
+			if jsonOutput {
+				return printJSON(logger, map[string]int{"Removed": removed})
+			}
+			label := "removed"
+			if dryRun {
+				label = "would remove"
+			}
+			fmt.Printf("%s\t%d\n", label, removed)
+		}
+	case len(args) > 0 && len(args) <= 2 && args[0] == "export":
+		target := os.Stdout
+		if len(args) == 2 {
+			if target, err = os.Create(args[1]); err != nil {
+				break
+			}
+			defer target.Close()
+		}
+		err = exportHistory(target)
+	case len(args) == 2 && args[0] == "import":
+		var added int
+		added, err = importHistory(args[1])
+		if err == nil {
+			if jsonOutput {
+				return printJSON(logger, map[string]int{"Added": added})
+			}
+			label := "added"
+			if dryRun {
+				label = "would add"
+			}
+			fmt.Printf("%s\t%d\n", label, added)
+		}
+	default:
+		logger.Error("usage: ips history prune|export [file]|import <file>")
+		return exitInternalError
+	}
+	if err != nil {
+		logger.Error("could not "+args[0]+" history", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// exportHistory writes all records to w.
+func exportHistory(w io.Writer) error {
+	records, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	export := &historyExport{Version: historyExportVersion, Host: hostname, Exported: time.Now(), Records: records}
+	if export.Records == nil {
+		export.Records = make([]*historyRecord, 0)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// importHistory merges the records of an export into the history. Records already present are skipped, the
+// result is ordered by time. Returns the number of records added.
+func importHistory(name string) (int, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	var export historyExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return 0, err
+	}
+	if export.Version != historyExportVersion {
+		return 0, fmt.Errorf("unsupported export version %d", export.Version)
+	}
+	records, err := loadHistory()
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool, len(records))
+	for _, r := range records {
+		key, _ := json.Marshal(r)
+		seen[string(key)] = true
+	}
+	added := 0
+	for _, r := range export.Records {
+		key, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		records = append(records, r)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(records, func(a, b int) bool { return records[a].Time.Before(records[b].Time) })
+	path, err := historyPath()
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, err
+	}
+	return added, writeHistory(records)
+}
+
+// ageValue is a flag.Value setting a duration parsed by parseAge.
+type ageValue struct {
+	d *time.Duration
+}
+
+// Set parses s using parseAge.
+func (a ageValue) Set(s string) error {
+	d, err := parseAge(s)
+	if err != nil {
+		return err
+	}
+	*a.d = d
+	return nil
+}
+
+// String renders whole days as such, e.g. 90d, other durations like time.Duration does.
+func (a ageValue) String() string {
+	if a.d == nil {
+		return ""
+	}
+	if day := 24 * time.Hour; *a.d > 0 && *a.d%day == 0 {
+		return fmt.Sprintf("%dd", *a.d/day)
+	}
+	return a.d.String()
+}
+
+// parseAge parses a duration like time.ParseDuration does, additionally accepting days (d) and weeks (w), e.g. 30d.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.ParseFloat(n, 64)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
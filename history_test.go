@@ -0,0 +1,212 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestImportHistory(t *testing.T) {
+	previousFile, previousDryRun := historyFile, dryRun
+	t.Cleanup(func() { historyFile, dryRun = previousFile, previousDryRun })
+
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	record := func(d int, address string) *historyRecord {
+		return &historyRecord{Time: day(d), Added: ips{{Address: address, Interface: "eth0"}}}
+	}
+	export := func(version int, records ...*historyRecord) string {
+		data, err := json.Marshal(&historyExport{Version: version, Host: "other", Exported: day(28), Records: records})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	tests := []struct {
+		name     string
+		existing []*historyRecord
+		export   string
+		dryRun   bool
+		// wantDays are the days of the records in the history after the import
+		wantDays  []int
+		wantAdded int
+		wantErr   bool
+	}{
+		{
+			name:      "into empty history",
+			export:    export(1, record(1, "192.0.2.1/24"), record(2, "192.0.2.2/24")),
+			wantDays:  []int{1, 2},
+			wantAdded: 2,
+		},
+		{
+			name:      "merged by time",
+			existing:  []*historyRecord{record(1, "192.0.2.1/24"), record(5, "192.0.2.5/24")},
+			export:    export(1, record(3, "192.0.2.3/24"), record(7, "192.0.2.7/24")),
+			wantDays:  []int{1, 3, 5, 7},
+			wantAdded: 2,
+		},
+		{
+			name:      "duplicates skipped",
+			existing:  []*historyRecord{record(1, "192.0.2.1/24")},
+			export:    export(1, record(1, "192.0.2.1/24"), record(2, "192.0.2.2/24"), record(2, "192.0.2.2/24")),
+			wantDays:  []int{1, 2},
+			wantAdded: 1,
+		},
+		{
+			name:      "same time other address",
+			existing:  []*historyRecord{record(1, "192.0.2.1/24")},
+			export:    export(1, record(1, "192.0.2.9/24")),
+			wantDays:  []int{1, 1},
+			wantAdded: 1,
+		},
+		{
+			name:     "nothing new",
+			existing: []*historyRecord{record(1, "192.0.2.1/24")},
+			export:   export(1, record(1, "192.0.2.1/24")),
+			wantDays: []int{1},
+		},
+		{
+			name:     "empty export",
+			existing: []*historyRecord{record(1, "192.0.2.1/24")},
+			export:   export(1),
+			wantDays: []int{1},
+		},
+		{
+			name:      "dry run",
+			existing:  []*historyRecord{record(1, "192.0.2.1/24")},
+			export:    export(1, record(2, "192.0.2.2/24")),
+			dryRun:    true,
+			wantDays:  []int{1},
+			wantAdded: 1,
+		},
+		{
+			name:     "unsupported version",
+			existing: []*historyRecord{record(1, "192.0.2.1/24")},
+			export:   export(2, record(2, "192.0.2.2/24")),
+			wantDays: []int{1},
+			wantErr:  true,
+		},
+		{name: "not json", export: "Time,Address\n", wantErr: true},
+		{name: "history lines instead of an export", export: `{"Time":"2026-01-01T12:00:00Z"}` + "\n" + `{"Time":"2026-01-02T12:00:00Z"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			historyFile, dryRun = filepath.Join(dir, "history.jsonl"), false
+			if tt.existing != nil {
+				if err := writeHistory(tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			name := filepath.Join(dir, "export.json")
+			if err := os.WriteFile(name, []byte(tt.export), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			dryRun = tt.dryRun
+			added, err := importHistory(name)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if added != tt.wantAdded {
+				t.Errorf("added %d records, want %d", added, tt.wantAdded)
+			}
+			records, err := loadHistory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			days := make([]int, 0, len(records))
+			for _, r := range records {
+				days = append(days, r.Time.Day())
+			}
+			if !slices.Equal(days, tt.wantDays) {
+				t.Errorf("history has records of days %v, want %v", days, tt.wantDays)
+			}
+		})
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	previousFile, previousDryRun := historyFile, dryRun
+	t.Cleanup(func() { historyFile, dryRun = previousFile, previousDryRun })
+	dir := t.TempDir()
+	historyFile, dryRun = filepath.Join(dir, "history.jsonl"), false
+
+	var empty bytes.Buffer
+	if err := exportHistory(&empty); err != nil {
+		t.Fatal(err)
+	}
+	var export historyExport
+	if err := json.Unmarshal(empty.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != historyExportVersion || export.Records == nil || len(export.Records) != 0 {
+		t.Errorf("export of a missing history is %s", empty.Bytes())
+	}
+
+	records := []*historyRecord{
+		{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Snapshot: true, Added: ips{{Address: "192.0.2.1/24", Interface: "eth0"}}},
+		{Time: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), Removed: ips{{Address: "192.0.2.1/24", Interface: "eth0"}}},
+		{Time: time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), Prefix: &prefixChange{Previous: []string{"2001:db8:1::/56"}, Current: []string{"2001:db8:2::/56"}}},
+	}
+	if err := writeHistory(records); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "export.json")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportHistory(f); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	// importing the export into another history reproduces it
+	historyFile = filepath.Join(dir, "imported.jsonl")
+	added, err := importHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != len(records) {
+		t.Errorf("added %d records, want %d", added, len(records))
+	}
+	original, _ := os.ReadFile(filepath.Join(dir, "history.jsonl"))
+	imported, _ := os.ReadFile(historyFile)
+	if !bytes.Equal(original, imported) {
+		t.Errorf("imported history\n%s\ndiffers from\n%s", imported, original)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "12h", want: 12 * time.Hour},
+		{value: "0", want: 0},
+		{value: "-1d", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "ten days", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseAge(%q) = %s, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseAge(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
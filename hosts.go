@@ -0,0 +1,248 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+const (
+	// hostsBegin and hostsEnd enclose the block of the hosts file managed by ips hosts sync
+	hostsBegin = "# BEGIN ips managed block, changes are overwritten by ips hosts sync"
+	hostsEnd   = "# END ips managed block"
+)
+
+var (
+	// hostsFile is the hosts file managed by ips hosts sync, defaults to the one of the platform
+	hostsFile string
+
+	// hostsPeers adds the peers known to the running watch to the hosts file
+	hostsPeers bool
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "hosts",
+		commands: map[string]command{"hosts": runHosts},
+		flags: func() {
+			flag.StringVar(&hostsFile, "hosts-file", "", "hosts file maintained by hosts sync, defaults to the one of the platform")
+			flag.BoolVar(&hostsPeers, "hosts-peers", false, "add the peers known to the running watch to the hosts file in hosts sync")
+		},
+	})
+}
+
+// hostsResult is the outcome of ips hosts sync.
+type hostsResult struct {
+	File string
+
+	// Backup is the copy of the hosts file made before it was changed, empty if it was not changed
+	Backup string `json:",omitempty"`
+
+	// Changed is set if the managed block was, or with dry-run would be, changed
+	Changed bool
+
+	// Entries are the lines of the managed block
+	Entries []string
+}
+
+// runHosts maintains a block in the hosts file mapping the names of this host to its addresses. With -hosts-peers
+// the peers the running watch exchanged addresses with are added by their mDNS names. Lines outside the block are
+// left alone. With dry-run the block is printed instead of written.
+func runHosts(logger *slog.Logger, args []string) int {
+	if len(args) != 1 || args[0] != "sync" {
+		logger.Error("usage: ips hosts sync [-hosts-peers] [-hosts-file /etc/hosts]")
+		return exitInternalError
+	}
+	path := hostsFile
+	if path == "" {
+		path = defaultHostsFile()
+	}
+	entries, err := hostsEntries(logger)
+	if err != nil {
+		logger.Error("could not determine hosts entries", "err", err)
+		return exitInternalError
+	}
+	result, err := syncHosts(path, entries)
+	if err != nil {
+		logger.Error("could not update hosts file", "err", err, "file", path)
+		return exitInternalError
+	}
+
+	if jsonOutput {
+		return printJSON(logger, result)
+	}
+	switch {
+	case !result.Changed:
+		fmt.Printf("unchanged\t%s\n", result.File)
+	case dryRun:
+		fmt.Printf("would write\t%s\n", result.File)
+		for _, e := range result.Entries {
+			fmt.Printf("+\t%s\n", e)
+		}
+	default:
+		fmt.Printf("updated\t%s\t%s\n", result.File, result.Backup)
+	}
+	return exitOK
+}
+
+// defaultHostsFile returns the hosts file of the platform.
+func defaultHostsFile() string {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// hostsEntries returns the lines of the managed block: the addresses of the interfaces with the qualified and the
+// short host name and, with -hosts-peers, the addresses of the peers with their mDNS names. Public, loopback and
+// link-local addresses are skipped, the first are usually not assigned to the host and the others not reachable
+// by name.
+func hostsEntries(logger *slog.Logger) ([]string, error) {
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := hostFQDN()
+	if err != nil {
+		return nil, err
+	}
+	names := hostname
+	if short, _, _ := strings.Cut(hostname, "."); short != hostname {
+		names += " " + short
+	}
+
+	entries := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(list ips, names string) {
+		for _, i := range list {
+			if i.isPublic() {
+				continue
+			}
+			c, err := classifyAddress(i.Address)
+			if err != nil || c.Class == "loopback" || c.Class == "link-local" {
+				continue
+			}
+			address, _, _ := strings.Cut(i.Address, "/")
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			entries = append(entries, address+"\t"+names)
+		}
+	}
+	add(local, names)
+
+	if !hostsPeers {
+		return entries, nil
+	}
+	result, err := controlRequest("peers")
+	if err != nil {
+		return nil, err
+	}
+	var peers []*peer
+	if err := json.Unmarshal(result, &peers); err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		if p.Host == "" {
+			continue
+		}
+		if !validPeerHost(p.Host) {
+			logger.Warn("skipping peer with invalid host name", "peer", p.Name, "host", p.Host, "source", p.Source)
+			continue
+		}
+		add(p.Addresses, p.Host)
+	}
+	return entries, nil
+}
+
+// validPeerHost reports whether the host name of a peer may be written to the hosts file: an RFC 1123 host name in
+// the .local domain. The names are announced by anyone on the link, they must not add lines or entries to the hosts
+// file nor claim names outside of it.
+func validPeerHost(name string) bool {
+	if len(name) > 253 || !strings.HasSuffix(strings.ToLower(name), ".local") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// syncHosts replaces the managed block of the hosts file by the entries, appending it if there is none. The hosts
+// file is copied to a backup before it is changed and written in place, as it is often bind mounted into
+// containers, which prevents replacing it.
+func syncHosts(path string, entries []string) (*hostsResult, error) {
+	result := &hostsResult{File: path, Entries: entries}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	updated := replaceHostsBlock(content, entries)
+	if bytes.Equal(content, updated) {
+		return result, nil
+	}
+	result.Changed = true
+	if dryRun {
+		return result, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	result.Backup = path + ".ips-backup"
+	if err := os.WriteFile(result.Backup, content, info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	return result, os.WriteFile(path, updated, info.Mode().Perm())
+}
+
+// replaceHostsBlock returns the content with the managed block replaced by the entries. The line endings of the
+// file are kept.
+func replaceHostsBlock(content []byte, entries []string) []byte {
+	newline := "\n"
+	if bytes.Contains(content, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	block := hostsBegin + newline
+	for _, e := range entries {
+		block += e + newline
+	}
+	block += hostsEnd + newline
+
+	text := string(content)
+	start := strings.Index(text, hostsBegin)
+	end := strings.Index(text, hostsEnd)
+	if start < 0 || end < start {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += newline
+		}
+		return []byte(text + block)
+	}
+	end += len(hostsEnd)
+	if strings.HasPrefix(text[end:], newline) {
+		end += len(newline)
+	}
+	return []byte(text[:start] + block + text[end:])
+}
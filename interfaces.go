@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxInterfaceWorkers limits the number of interfaces whose addresses are queried concurrently
+const maxInterfaceWorkers = 16
+
+// getInterfaceAddresses collects the addresses of all network interfaces. Where the platform offers a fast path
+// it is used, otherwise the addresses are queried per interface using a bounded pool of workers.
+// The result keeps the order of net.Interfaces, so the output is deterministic regardless of scheduling.
+func getInterfaceAddresses(logger *slog.Logger) (ips, error) {
+	result, err := fastInterfaceAddresses()
+	if err == nil {
+		return result, nil
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		logger.Debug("fast path failed, falling back to per interface lookup", "err", err)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logger.Error("could not get interfaces", "err", err)
+		return nil, fmt.Errorf("%w: %w", ErrInterfaceEnumeration, err)
+	}
+
+	results := make([]ips, len(interfaces))
+	errs := make([]error, len(interfaces))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(maxInterfaceWorkers, len(interfaces)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx], errs[idx] = addressesOf(interfaces[idx])
+			}
+		}()
+	}
+	for idx := range interfaces {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	result = make(ips, 0)
+	for idx, i := range interfaces {
+		if errs[idx] != nil {
+			logger.Error("could not get addresses", "err", errs[idx], "interface", i.Name)
+			return result, fmt.Errorf("%w: addresses of %s: %w", ErrInterfaceEnumeration, i.Name, errs[idx])
+		}
+		result = append(result, results[idx]...)
+	}
+	return result, nil
+}
+
+// addressesOf returns the addresses assigned to a single network interface.
+func addressesOf(i net.Interface) (ips, error) {
+	addrs, err := i.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	result := make(ips, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, &ip{
+			Address:   addr.String(),
+			Interface: i.Name,
+		})
+	}
+	return result, nil
+}
+
+// interfaceForIP returns the name of the interface the address is assigned to or an empty string if no
+// interface owns it.
+func interfaceForIP(address net.IP) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, i := range interfaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(address) {
+				return i.Name
+			}
+		}
+	}
+	return ""
+}
+
+// infiniteLifetime is used by the kernel and in router advertisements for lifetimes that never expire
+const infiniteLifetime = 0xffffffff
+
+// formatLifetime renders a lifetime in seconds the way ip(8) does.
+func formatLifetime(seconds uint32) string {
+	if seconds == infiniteLifetime {
+		return "forever"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
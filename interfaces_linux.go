@@ -0,0 +1,156 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// addressFlagsSupport tells ips capabilities that flags and lifetimes of addresses are read from netlink
+const addressFlagsSupport = "netlink"
+
+// ifaFlags is the IFA_FLAGS attribute carrying the full 32 bit address flags
+const ifaFlags = 0x8
+
+// addressFlagNames maps the IFA_F_* flag bits to the names reported for an address
+var addressFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{0x1, "temporary"},
+	{0x2, "nodad"},
+	{0x4, "optimistic"},
+	{0x8, "dadfailed"},
+	{0x10, "homeaddress"},
+	{0x20, "deprecated"},
+	{0x40, "tentative"},
+	{0x80, "permanent"},
+	{0x100, "mngtmpaddr"},
+	{0x200, "noprefixroute"},
+	{0x400, "mcautojoin"},
+	{0x800, "stable-privacy"},
+}
+
+// fastInterfaceAddresses collects the addresses of all interfaces using a single netlink RTM_GETADDR dump.
+// Unlike net.Interface.Addrs it exposes the address flags the kernel keeps for every address.
+func fastInterfaceAddresses() (ips, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(interfaces))
+	for _, i := range interfaces {
+		names[i.Index] = i.Name
+	}
+
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, os.NewSyscallError("netlinkrib", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, os.NewSyscallError("parsenetlinkmessage", err)
+	}
+
+	byIndex := make(map[int]ips, len(interfaces))
+loop:
+	for _, m := range messages {
+		switch m.Header.Type {
+		case syscall.NLMSG_DONE:
+			break loop
+		case syscall.NLMSG_ERROR:
+			return nil, fmt.Errorf("netlink returned an error message")
+		case syscall.RTM_NEWADDR:
+			if len(m.Data) < syscall.SizeofIfAddrmsg {
+				continue
+			}
+			index := int(binary.NativeEndian.Uint32(m.Data[4:8]))
+			name, ok := names[index]
+			if !ok {
+				continue
+			}
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return nil, os.NewSyscallError("parsenetlinkrouteattr", err)
+			}
+			address := parseAddrMessage(m.Data, attrs)
+			if address == nil {
+				continue
+			}
+			address.Interface = name
+			byIndex[index] = append(byIndex[index], address)
+		}
+	}
+
+	result := make(ips, 0)
+	for _, i := range interfaces {
+		result = append(result, byIndex[i.Index]...)
+	}
+	return result, nil
+}
+
+// parseAddrMessage converts a RTM_NEWADDR message into an ip. The interface name is left empty.
+func parseAddrMessage(header []byte, attrs []syscall.NetlinkRouteAttr) *ip {
+	family, prefixLen, flags := header[0], int(header[1]), uint32(header[2])
+
+	var address, local net.IP
+	var preferred, valid string
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case syscall.IFA_ADDRESS:
+			address = net.IP(a.Value)
+		case syscall.IFA_LOCAL:
+			local = net.IP(a.Value)
+		case syscall.IFA_CACHEINFO:
+			if len(a.Value) >= 8 {
+				preferred = formatLifetime(binary.NativeEndian.Uint32(a.Value[0:4]))
+				valid = formatLifetime(binary.NativeEndian.Uint32(a.Value[4:8]))
+			}
+		case ifaFlags:
+			if len(a.Value) >= 4 {
+				flags = binary.NativeEndian.Uint32(a.Value)
+			}
+		}
+	}
+	// on point to point links IFA_ADDRESS is the peer, the own address is kept in IFA_LOCAL
+	if family == syscall.AF_INET && local != nil {
+		address = local
+	}
+	if address == nil {
+		return nil
+	}
+
+	bits := 8 * net.IPv6len
+	if family == syscall.AF_INET {
+		bits = 8 * net.IPv4len
+		address = address.To4()
+	}
+	ipNet := &net.IPNet{IP: address, Mask: net.CIDRMask(prefixLen, bits)}
+
+	return &ip{
+		Address:           ipNet.String(),
+		Flags:             addressFlags(family, flags),
+		ValidLifetime:     valid,
+		PreferredLifetime: preferred,
+	}
+}
+
+// addressFlags returns the names of all flags set for an address of the given family.
+func addressFlags(family uint8, flags uint32) []string {
+	result := make([]string, 0)
+	for _, f := range addressFlagNames {
+		if flags&f.flag == 0 {
+			continue
+		}
+		name := f.name
+		if f.flag == 0x1 && family == syscall.AF_INET {
+			name = "secondary"
+		}
+		result = append(result, name)
+	}
+	return result
+}
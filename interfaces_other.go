@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// addressFlagsSupport is empty, addresses carry no flags and lifetimes on this platform
+const addressFlagsSupport = ""
+
+// fastInterfaceAddresses is only available on Linux, other platforms use the portable per interface lookup.
+func fastInterfaceAddresses() (ips, error) {
+	return nil, errors.ErrUnsupported
+}
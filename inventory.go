@@ -0,0 +1,136 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exportColumns are the columns of an inventory export
+var exportColumns = []string{"host", "interface", "address", "first_seen", "last_seen"}
+
+// xlsxParts are the parts of a workbook besides its sheet, the minimum spreadsheet applications open
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="inventory" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// inventory returns the rows of an inventory export of the agents of the tenant, one per address.
+func inventory(tenant string) [][]string {
+	rows := make([][]string, 0)
+	for _, a := range agents.list(tenant) {
+		for _, i := range a.Addresses {
+			rows = append(rows, []string{a.Name, i.Interface, i.Address, formatSeen(i.FirstSeen), formatSeen(i.LastSeen)})
+		}
+	}
+	return rows
+}
+
+// formatSeen formats a time an address was seen in UTC, empty if it is unknown.
+func formatSeen(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// handleExport answers the addresses of the agents of the tenant of the token as CSV or Excel workbook for audits
+// and spreadsheets, the format is given by the format parameter and defaults to csv.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := authorize(w, r, false)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	var write func(io.Writer, [][]string) error
+	switch format {
+	case "csv":
+		contentType, write = "text/csv; charset=utf-8", writeCSV
+	case "xlsx":
+		contentType, write = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", writeXLSX
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q, expected csv or xlsx", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ips-%s-%s.%s"`, tenant, time.Now().UTC().Format("20060102"), format))
+	if err := write(w, inventory(tenant)); err != nil {
+		agents.logger.Error("could not write export", "err", err, "tenant", tenant)
+	}
+}
+
+// writeCSV writes the rows with a header. Cells starting like a formula are prefixed with a quote, so spreadsheet
+// applications do not evaluate values pushed by agents.
+func writeCSV(out io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(out)
+	if err := writer.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for n, cell := range row {
+			if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+				row[n] = "'" + cell
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeXLSX writes the rows with a header as single sheet of an Office Open XML workbook, cells are inline
+// strings.
+func writeXLSX(out io.Writer, rows [][]string) error {
+	archive := zip.NewWriter(out)
+	for _, part := range xlsxParts {
+		writer, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, part.content); err != nil {
+			return err
+		}
+	}
+	writer, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var sheet strings.Builder
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for _, row := range append([][]string{exportColumns}, rows...) {
+		sheet.WriteString("<row>")
+		for _, cell := range row {
+			sheet.WriteString(`<c t="inlineStr"><is><t>`)
+			if err := xml.EscapeText(&sheet, []byte(cell)); err != nil {
+				return err
+			}
+			sheet.WriteString("</t></is></c>")
+		}
+		sheet.WriteString("</row>")
+	}
+	sheet.WriteString("</sheetData></worksheet>")
+	if _, err := io.WriteString(writer, sheet.String()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,53 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		cell string
+		want string
+	}{
+		{name: "plain", cell: "eth0", want: "eth0"},
+		{name: "empty", cell: "", want: ""},
+		{name: "address", cell: "2001:db8::1/64", want: "2001:db8::1/64"},
+		{name: "formula", cell: "=HYPERLINK(\"http://example.com\")", want: "'=HYPERLINK(\"http://example.com\")"},
+		{name: "plus", cell: "+1+cmd|' /C calc'!A0", want: "'+1+cmd|' /C calc'!A0"},
+		{name: "minus", cell: "-2+3", want: "'-2+3"},
+		{name: "at", cell: "@SUM(A1:A2)", want: "'@SUM(A1:A2)"},
+		{name: "tab", cell: "\t=1", want: "'\t=1"},
+		{name: "carriage return", cell: "\r=1", want: "'\r=1"},
+		{name: "formula character later", cell: "vm=1", want: "vm=1"},
+		{name: "separator and quotes", cell: `a,"b"`, want: `a,"b"`},
+	}
+	rows := make([][]string, 0, len(tests))
+	for _, tt := range tests {
+		rows = append(rows, []string{"host", tt.cell, "192.0.2.1/24", "", ""})
+	}
+	var out bytes.Buffer
+	if err := writeCSV(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(tests)+1 {
+		t.Fatalf("got %d records, want %d", len(records), len(tests)+1)
+	}
+	if !slices.Equal(records[0], exportColumns) {
+		t.Errorf("header is %q, want %q", records[0], exportColumns)
+	}
+	for i, tt := range tests {
+		if got := records[i+1][1]; got != tt.want {
+			t.Errorf("%s: cell %q written as %q, want %q", tt.name, tt.cell, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+var (
+	// ipamKind selects the API of the IPAM system, netbox or phpipam
+	ipamKind string
+
+	// ipamURL is the base url of the API, e.g. https://netbox.example.com or https://ipam.example.com/api/app
+	ipamURL string
+
+	// ipamToken authenticates against the API
+	ipamToken string
+
+	// ipamSync registers addresses missing in the IPAM system
+	ipamSync bool
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "ipam",
+		commands: map[string]command{"export": runExport, "ipam": runIPAM},
+		flags: func() {
+			flag.StringVar(&ipamKind, "ipam", "netbox", "IPAM system ips ipam verifies the addresses against: netbox or phpipam")
+			flag.StringVar(&ipamURL, "ipam-url", "", "base url of the IPAM API, e.g. https://netbox.example.com or https://ipam.example.com/api/app")
+			flag.StringVar(&ipamToken, "ipam-token", "", "API token of the IPAM system")
+			flag.BoolVar(&ipamSync, "ipam-sync", false, "register addresses missing in the IPAM system")
+		},
+		urls: []*string{&ipamURL},
+	})
+}
+
+// ipamEntry is an address as registered in the IPAM system.
+type ipamEntry struct {
+
+	// Prefix is the address with the length of its network, invalid if the system does not store it with the
+	// address
+	Prefix netip.Prefix
+
+	// Hostname is the DNS name the address is registered for
+	Hostname string
+
+	// Owner is the device, virtual machine or person the address is assigned to
+	Owner string
+}
+
+// runIPAM verifies the addresses of the local interfaces against the IPAM system: every address has to be
+// registered, with the length of the local network and for the host name of this host. With -ipam-sync missing
+// addresses are registered. Loopback and link-local addresses are skipped. Exits with exitCheckFailed if an
+// address is missing or registered differently.
+func runIPAM(logger *slog.Logger, _ []string) int {
+	if ipamURL == "" {
+		logger.Error("usage: ips ipam -ipam-url https://netbox.example.com -ipam-token secret://env/TOKEN [-ipam-sync]")
+		return exitInternalError
+	}
+	if ipamKind != "netbox" && ipamKind != "phpipam" {
+		logger.Error("unknown IPAM system", "ipam", ipamKind)
+		return exitInternalError
+	}
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		logger.Error("could not get ip addresses", "err", err)
+		return exitInternalError
+	}
+	hostname, err := hostFQDN()
+	if err != nil {
+		logger.Error("could not determine host name", "err", err)
+		return exitInternalError
+	}
+
+	checks := make([]*check, 0)
+	for _, i := range local {
+		prefix, err := netip.ParsePrefix(i.Address)
+		if err != nil {
+			continue
+		}
+		if c, err := classifyAddress(i.Address); err != nil || c.Class == "loopback" || c.Class == "link-local" {
+			continue
+		}
+		c := &check{Name: prefix.Addr().String()}
+		checks = append(checks, c)
+		entry, err := lookupIPAM(prefix.Addr())
+		switch {
+		case err != nil:
+			c.Detail = err.Error()
+		case entry == nil && ipamSync:
+			if dryRun {
+				c.OK, c.Detail = true, fmt.Sprintf("would register %s for %s", prefix, hostname)
+				continue
+			}
+			if err := registerIPAM(prefix, hostname); err != nil {
+				c.Detail = "could not register: " + err.Error()
+				continue
+			}
+			c.OK, c.Detail = true, "registered for "+hostname
+		case entry == nil:
+			c.Detail = "not registered"
+		default:
+			c.OK, c.Detail = ipamDrift(entry, prefix, hostname)
+		}
+	}
+	return printChecks(logger, checks)
+}
+
+// ipamDrift compares the registered entry with the local address and host name. Host names match if they are equal
+// or one of them is not qualified and equals the first label of the other.
+func ipamDrift(entry *ipamEntry, local netip.Prefix, hostname string) (bool, string) {
+	owner := ""
+	if entry.Owner != "" {
+		owner = " to " + entry.Owner
+	}
+	if entry.Prefix.IsValid() && entry.Prefix.Bits() != local.Bits() {
+		return false, fmt.Sprintf("registered%s as %s, the local network is %s", owner, entry.Prefix, local.Masked())
+	}
+	registered, _, _ := strings.Cut(entry.Hostname, ".")
+	short, _, _ := strings.Cut(hostname, ".")
+	switch {
+	case entry.Hostname == "":
+		return false, "registered" + owner + " without host name"
+	case strings.EqualFold(entry.Hostname, hostname),
+		!strings.Contains(entry.Hostname, ".") && strings.EqualFold(entry.Hostname, short),
+		!strings.Contains(hostname, ".") && strings.EqualFold(registered, hostname):
+		return true, "registered" + owner + " for " + entry.Hostname
+	}
+	return false, fmt.Sprintf("registered%s for %s instead of %s", owner, entry.Hostname, hostname)
+}
+
+// lookupIPAM returns the entry of the address, nil if it is not registered.
+func lookupIPAM(address netip.Addr) (*ipamEntry, error) {
+	if ipamKind == "phpipam" {
+		return lookupPhpIPAM(address)
+	}
+	return lookupNetBox(address)
+}
+
+// registerIPAM registers the address for the host name.
+func registerIPAM(prefix netip.Prefix, hostname string) error {
+	if ipamKind == "phpipam" {
+		return registerPhpIPAM(prefix, hostname)
+	}
+	return registerNetBox(prefix, hostname)
+}
+
+// ipamRequest sends a request to the API of the IPAM system and decodes the JSON answer into out. Returns the
+// status code, answers other than 2xx and 404 are returned as error.
+func ipamRequest(method, path string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(ipamURL, "/")+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch ipamKind {
+	case "netbox":
+		req.Header.Set("Authorization", "Token "+ipamToken)
+	case "phpipam":
+		req.Header.Set("token", ipamToken)
+	}
+
+	resp, err := newHTTPClient(nil).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s answered with %s", ipamKind, resp.Status)
+	}
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,122 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// maxRPCMessage is the largest message accepted on a JSON-RPC stream
+const maxRPCMessage = 1 << 20
+
+type (
+
+	// rpcRequest is a JSON-RPC request, notifications lack an id.
+	rpcRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id,omitempty"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}
+
+	// rpcResponse answers a request, either Result or Error is set.
+	rpcResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result,omitempty"`
+		Error   *rpcError       `json:"error,omitempty"`
+	}
+
+	// rpcNotification is a message sent without being asked for.
+	rpcNotification struct {
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  any    `json:"params,omitempty"`
+	}
+
+	// rpcError describes why a request failed.
+	rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// rpcHandler executes the method of a request and returns its result.
+	rpcHandler func(method string, params json.RawMessage) (any, *rpcError)
+
+	// rpcConn reads newline delimited JSON-RPC messages and serializes writes, so notifications can be sent while
+	// requests are handled.
+	rpcConn struct {
+		mu  sync.Mutex
+		enc *json.Encoder
+	}
+)
+
+// newRPCConn creates a connection writing to out.
+func newRPCConn(out io.Writer) *rpcConn {
+	return &rpcConn{enc: json.NewEncoder(out)}
+}
+
+// serve handles the requests read from in one after another until in is closed. Requests are answered, for
+// notifications the handler is called without sending a response.
+func (c *rpcConn) serve(logger *slog.Logger, in io.Reader, handle rpcHandler) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRPCMessage)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.write(logger, &rpcResponse{ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			if req.ID != nil {
+				c.write(logger, &rpcResponse{ID: req.ID, Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}})
+			}
+			continue
+		}
+		result, rpcErr := handle(req.Method, req.Params)
+		if req.ID == nil {
+			continue
+		}
+		if rpcErr == nil && result == nil {
+			result = struct{}{}
+		}
+		c.write(logger, &rpcResponse{ID: req.ID, Result: result, Error: rpcErr})
+	}
+	return scanner.Err()
+}
+
+// notify sends a notification.
+func (c *rpcConn) notify(logger *slog.Logger, method string, params any) {
+	c.write(logger, &rpcNotification{Method: method, Params: params})
+}
+
+// write encodes a message as a single line, the protocol version is filled in.
+func (c *rpcConn) write(logger *slog.Logger, message any) {
+	switch m := message.(type) {
+	case *rpcResponse:
+		m.JSONRPC = "2.0"
+	case *rpcNotification:
+		m.JSONRPC = "2.0"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(message); err != nil {
+		logger.Error("could not write message", "err", err)
+	}
+}
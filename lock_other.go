@@ -0,0 +1,16 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockSupport is empty, concurrent lookups are not coordinated on this platform
+const lockSupport = ""
+
+// tryLock is not supported on this platform, lookups of concurrent processes are not coordinated.
+func tryLock(_ *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockSupport names how concurrent lookups are coordinated
+const lockSupport = "flock"
+
+// tryLock takes an exclusive lock of f without blocking, it reports false if another process holds it. The lock is
+// released when f is closed.
+func tryLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -1,21 +1,62 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net"
-	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sascha-andres/reuse/flag"
 )
 
+// version is set during the build using ldflags
+var version = "dev"
+
 var (
 	public, all, jsonOutput bool
+	preferredOnly, offline  bool
+	scheduledTask           bool
 	logLevel                uint
+	port                    uint
+	providerNames           string
+	timeout, cacheTTL       time.Duration
+
+	// publicLookupDuration is the time the public ip lookup of this run took
+	publicLookupDuration time.Duration
+
+	// publicFamilies is the number of address families the public ip lookup of this run determined
+	publicFamilies int
+
+	// changedOnly prints the addresses only if a public ip changed since it was looked up last
+	changedOnly bool
+
+	// dryRun prints what would be changed instead of changing it
+	dryRun bool
+)
+
+// exit codes used to signal the outcome of a run to calling scripts
+const (
+	// exitOK signals a successful run
+	exitOK = iota
+
+	// exitInternalError signals an unexpected error, nothing usable was printed
+	exitInternalError
+
+	// exitPublicLookupFailed signals that the public ip lookup failed but local addresses were printed
+	exitPublicLookupFailed
+
+	// exitNoMatch signals that no address matched the given filters
+	exitNoMatch
+
+	// exitChanged signals a public ip changed since the last lookup with changed-only, or a change found by diff
+	// running with exit-code
+	exitChanged
+
+	// exitCheckFailed signals that at least one check of a verification command failed
+	exitCheckFailed
 )
 
 type (
@@ -28,6 +69,23 @@ type (
 
 		// Interface represents the name of the network interface associated with the IP address.
 		Interface string
+
+		// Flags contains the state flags the operating system keeps for the address (e.g. temporary, deprecated).
+		// It is only populated on platforms exposing them.
+		Flags []string `json:",omitempty"`
+
+		// ValidLifetime is the remaining time the address stays assigned, "forever" for static addresses.
+		ValidLifetime string `json:",omitempty"`
+
+		// PreferredLifetime is the remaining time the address is used for new connections, "0s" once deprecated.
+		PreferredLifetime string `json:",omitempty"`
+
+		// Labels contains additional information attached by plugins and providers, e.g. the uptime of a router.
+		Labels map[string]string `json:",omitempty"`
+
+		// FirstSeen and LastSeen are the times watch observed the address first and last, kept across restarts.
+		FirstSeen time.Time `json:",omitzero"`
+		LastSeen  time.Time `json:",omitzero"`
 	}
 
 	// ips represents a collection of ip instances, each containing details about a network interface and its IP address.
@@ -35,8 +93,52 @@ type (
 )
 
 // String returns a formatted string representation of the ip, combining its Address and Interface fields.
+// Addresses that should not be used for new connections are marked with their state, labels are appended as
+// key=value pairs.
 func (i ip) String() string {
-	return fmt.Sprintf("%s\t%s", i.Address, i.Interface)
+	result := fmt.Sprintf("%s\t%s", i.Address, i.Interface)
+	if state := i.state(); state != "" {
+		result += "\t" + state
+	}
+	if len(i.Labels) > 0 {
+		labels := make([]string, 0, len(i.Labels))
+		for k, v := range i.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		result += "\t" + strings.Join(labels, ",")
+	}
+	return result
+}
+
+// state returns the flags marking the address as not preferred, joined by a comma.
+func (i ip) state() string {
+	states := make([]string, 0)
+	for _, f := range i.Flags {
+		if f == "deprecated" || f == "tentative" || f == "dadfailed" {
+			states = append(states, f)
+		}
+	}
+	return strings.Join(states, ",")
+}
+
+// key identifies the address independent of its changing attributes like lifetimes.
+func (i ip) key() string {
+	return i.Address + "\t" + i.Interface
+}
+
+// family returns ipv4 or ipv6 depending on the address.
+func (i ip) family() string {
+	address, _, _ := strings.Cut(i.Address, "/")
+	if strings.Contains(address, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// isPublic reports whether the address was determined by a public ip provider.
+func (i ip) isPublic() bool {
+	return strings.HasPrefix(i.Interface, "public ")
 }
 
 // main is the entry point of the application, parsing flags to determine the mode of operation and executing the run function.
@@ -45,8 +147,46 @@ func main() {
 	flag.BoolVar(&public, "p", false, "print public ip only, exclusive to -a")
 	flag.BoolVar(&all, "a", false, "print all ip, exclusive to -ap")
 	flag.BoolVar(&jsonOutput, "json", false, "output as JSON")
+	flag.StringVar(&outputFormat, "output", "text", "output format: "+strings.Join(outputFormats(), ", "))
+	flag.StringVar(&outputFile, "output-file", "", "file the addresses are written to atomically on every run and change, appended to if ending in .ndjson or .jsonl")
+	flag.UintVar(&rotateSize, "rotate-size", 0, "size in bytes NDJSON logs are rotated at, 0 disables rotating by size")
+	flag.DurationVar(&rotateEvery, "rotate-every", 0, "rotate NDJSON logs when a period of this length began, e.g. 24h, 0 disables rotating by time")
+	flag.UintVar(&rotateKeep, "rotate-keep", 5, "number of rotated NDJSON logs kept")
+	flag.BoolVar(&preferredOnly, "preferred-only", false, "hide deprecated and tentative addresses")
+	flag.Var(purposeValues{}, "purpose", "label addresses of a network or interface with a purpose as 'network=purpose' or 'interface=purpose', may be repeated")
+	flag.StringVar(&purposeFilter, "purpose-filter", "", "comma separated purposes of the addresses shown")
+	flag.BoolVar(&physicalOnly, "physical-only", false, "hide the addresses of loopback and virtual adapters, e.g. of Hyper-V, VirtualBox or VMware")
+	flag.BoolVar(&changedOnly, "changed-only", false, "print the addresses and exit with 4 only if a public ip changed since it was looked up last, print nothing otherwise")
+	flag.BoolVar(&offline, "offline", false, "skip everything touching the network, only local data is used")
 	flag.UintVar(&logLevel, "l", 0, "log level")
+	flag.DurationVar(&timeout, "timeout", 5*time.Second, "timeout for public ip lookups and connections")
+	flag.StringVar(&providerNames, "providers", "wtfismyip,icanhazip,ipify,identme", "comma separated public ip providers, tried in order")
+	flag.StringVar(&discoverDomain, "discover-domain", "", "domain to look up an internal provider at using the _ips._tcp SRV record")
+	flag.BoolVar(&noExternal, "no-external", false, "never contact third party services, only explicitly configured ones")
+	flag.StringVar(&providerURL, "provider-url", "", "dual-stack url of an own provider echoing the client address, tried before the built in ones")
+	flag.StringVar(&dnsServer, "dns", "", "resolver all dns lookups are sent to encrypted: tls://host[:port] for DNS over TLS or an https url for DNS over HTTPS")
+	flag.StringVar(&viaInterface, "via", "", "interface public ip lookups are bound to, to learn the public ip of that uplink")
+	flag.StringVar(&sourceAddress, "source", "", "local address public ip lookups are sent from")
+	flag.BoolVar(&preferV6, "prefer-v6", false, "connect to http services using ipv6 first, falling back to ipv4 after 300ms")
+	flag.BoolVar(&forceV4Transport, "force-v4-transport", false, "connect to http services using ipv4 only")
+	flag.BoolVar(&dryRun, "dry-run", false, "print what would be changed instead of changing it")
+	flag.StringVar(&providerCommand, "provider-command", "", "executable printing the public ip of the family given as first argument")
+	flag.StringVar(&auditLog, "audit-log", "", "file every outbound request is recorded in as JSON lines")
+	flag.UintVar(&maxResponseBytes, "max-response-bytes", 1024, "largest answer read from a public ip provider")
+	flag.StringVar(&userAgent, "user-agent", "ips/"+version, "user agent sent to public ip providers")
+	flag.Var(headerValues{}, "header", "header sent to public ip providers as 'Name: value', prefix with 'provider=' to limit it to one provider, may be repeated")
+	flag.UintVar(&port, "port", 443, "tcp port used by commands connecting to a target")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 5*time.Minute, "time a cached public ip is considered fresh")
+	flag.StringVar(&glyphPublic, "glyph-public", "⇡", "glyph preceding the public ip in prompt mode")
+	flag.StringVar(&glyphLocal, "glyph-local", "⌂", "glyph preceding the local ip in prompt mode")
+	flag.StringVar(&glyphStale, "glyph-stale", "~", "glyph marking an outdated public ip in prompt mode")
+	flag.StringVar(&stateDir, "state-dir", "", "directory the cache and the history are kept in, defaults to ips in the state dir of the platform")
+	flag.BoolVar(&allNotations, "all-notations", false, "print the address in every notation in fmt")
+	flag.StringVar(&configFile, "config", "", "file options are read from, defaults to config in the ips user config dir")
+	flag.BoolVar(&effective, "effective", false, "print the merged configuration of flags, environment and config file in config show")
+	registerSubsystemFlags()
 	flag.Parse()
+	configErr := applyConfig()
 
 	var handlerOpts *slog.HandlerOptions
 	switch logLevel {
@@ -59,118 +199,130 @@ func main() {
 	default:
 		handlerOpts = &slog.HandlerOptions{Level: slog.LevelInfo}
 	}
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, handlerOpts)).With("project", "ips")
+	verbs := flag.GetVerbs()
+	logOutput := os.Stdout
+	if stdio || len(verbs) > 0 && verbs[0] == "mcp" {
+		// stdout carries the protocol
+		logOutput = os.Stderr
+	}
+	logger := slog.New(slog.NewJSONHandler(logOutput, handlerOpts)).With("project", "ips")
 	slog.SetDefault(logger)
 
+	// config check reports the problems itself, config show prints the secret:// uris
+	configCommand := len(verbs) > 0 && verbs[0] == "config"
+	if configErr != nil && !configCommand {
+		logger.Error("invalid configuration", "err", configErr)
+		os.Exit(exitInternalError)
+	}
+	if err := resolveSecretOptions(); err != nil && !configCommand {
+		logger.Error("could not resolve secret", "err", err)
+		os.Exit(exitInternalError)
+	}
+
+	setupTransport()
+	if err := setupResolver(); err != nil {
+		logger.Error("could not set up dns server", "err", err)
+		os.Exit(exitInternalError)
+	}
+
+	// urls given by the user are not considered third party services
+	urls := []string{providerURL, dnsServer}
+	for _, u := range append(urls, subsystemURLs()...) {
+		trustURL(u)
+	}
+
 	logger.Debug(
 		"starting",
 		slog.Any("public", public),
 		slog.Any("all", all),
 		slog.Any("json", jsonOutput),
+		slog.Any("output", outputFormat),
+		slog.Any("preferredOnly", preferredOnly),
+		slog.Any("offline", offline),
+		slog.Any("noExternal", noExternal),
 		slog.Any("logLevel", logLevel),
 	)
 
-	if err := run(logger); err != nil {
-		os.Exit(1)
+	if len(verbs) > 0 {
+		os.Exit(runCommand(logger, verbs))
+	}
+	if stdio {
+		os.Exit(runStdio(logger))
 	}
+	os.Exit(run(logger))
 }
 
 // run retrieves IP addresses, logs errors if retrieval fails, and outputs the addresses in plain text or JSON format.
-func run(logger *slog.Logger) error {
+// It returns the exit code the process should terminate with.
+func run(logger *slog.Logger) int {
+	if changedOnly && (!public && !all || bound()) {
+		return printFailure(exitInternalError, "changed-only needs -p or -a and the public ip cache, which -via and -source bypass")
+	}
 	// get ips
+	code := exitOK
 	ips, err := getIpAddresses(logger)
-	if err != nil {
+	switch {
+	case err == nil:
+	case !errors.Is(err, ErrNoPublicProvider) || len(ips) == 0:
 		logger.Error("could not get ip addresses", "err", err)
-		return err
+		return printFailure(exitInternalError, err.Error())
+	case publicFamilies == 0:
+		logger.Warn("printing local addresses only", "err", err)
+		code = exitPublicLookupFailed
+	default:
+		// e.g. hosts without ipv6 connectivity, the public ip of the other family was determined
+		logger.Info("public ip of a family could not be determined", "err", err)
 	}
-	if jsonOutput {
-		data, err := json.Marshal(ips)
-		if err != nil {
-			logger.Error("could not marshal to json", "err", err)
-			return err
-		}
-		fmt.Println(string(data))
-	} else {
-		for _, i := range ips {
-			fmt.Println(i)
+	if changedOnly && code == exitOK {
+		if !publicChanged(ips) {
+			return exitOK
 		}
+		code = exitChanged
+	}
+	ips, err = applyPlugins(logger, ips)
+	if err != nil {
+		logger.Error("could not apply plugins", "err", err)
+		return printFailure(exitInternalError, err.Error())
 	}
-	return nil
+	if len(ips) == 0 {
+		return printFailure(exitNoMatch, "no addresses matched the filters")
+	}
+	if err := writeOutput(ips, nil); err != nil {
+		logger.Error("could not write output file", "err", err, "file", outputFile)
+		return exitInternalError
+	}
+	return printAddresses(logger, ips, code)
 }
 
 // getIpAddresses retrieves a list of IP addresses for all available network interfaces.
-// If the public flag is set, it includes the public IP address.
-// Returns a collection of IP instances and an error if any occurs during retrieval.
+// If the public flag is set, it includes the public IP address unless running offline.
+// Returns a collection of IP instances and an error if any occurs during retrieval. When the public lookup fails
+// for one address family, the remaining addresses are still returned together with ErrNoPublicProvider.
 func getIpAddresses(logger *slog.Logger) (ips, error) {
 	ips := make(ips, 0)
-	if public || all {
-		publicIpv4, err := getPublicIp("ipv4")
-		if err != nil {
-			logger.Error("could not get public ip", "err", err)
-			return ips, err
-		}
-		if publicIpv4 != nil {
-			ips = append(ips, publicIpv4)
-		}
-		publicIpv6, err := getPublicIp("ipv6")
-		if err != nil {
-			logger.Error("could not get public ip", "err", err)
-			return ips, err
-		}
-		if publicIpv6 != nil {
-			ips = append(ips, publicIpv6)
-		}
+	var publicErr error
+	if offline && (public || all) {
+		logger.Debug("skipping public ip lookup in offline mode")
+	}
+	if !offline && (public || all) {
+		start := time.Now()
+		ips, publicErr = lookupPublic(logger)
+		publicLookupDuration, publicFamilies = time.Since(start), len(ips)
 	}
 	if !all && public {
-		return ips, nil
+		return applyPurposes(ips), publicErr
 	}
-	interfaces, err := net.Interfaces()
+	local, err := getInterfaceAddresses(logger)
 	if err != nil {
-		logger.Error("could not get interfaces", "err", err)
 		return ips, err
 	}
-	for _, i := range interfaces {
-		addrs, err := i.Addrs()
-		if err != nil {
-			logger.Error("could not get addresses", "err", err, "interface", i.Name)
-			return ips, err
-		}
-		if addrs == nil || len(addrs) == 0 {
+	local = labelContainer(logger, labelTethered(logger, labelVirtual(logger, local)))
+	local = addGuests(logger, addHostAddresses(logger, local))
+	for _, i := range local {
+		if preferredOnly && i.state() != "" || physicalOnly && !i.isPhysical() {
 			continue
 		}
-		for _, addr := range addrs {
-			ips = append(ips, &ip{
-				Address:   addr.String(),
-				Interface: i.Name,
-			})
-		}
-	}
-	return ips, nil
-}
-
-// getPublicIp retrieves the public IP address of the system using an external service and returns it as an ip instance.
-// Returns an error if the request fails or the response can't be processed.
-func getPublicIp(t string) (*ip, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s.wtfismyip.com/text", t), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("User-Agent", "curl/8.7.1")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+		ips = append(ips, i)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return &ip{
-		Address:   strings.TrimSpace(string(body)),
-		Interface: fmt.Sprintf("public %s", strings.ToUpper(t)),
-	}, nil
+	return applyPurposes(ips), publicErr
 }
@@ -0,0 +1,201 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+)
+
+// mcpProtocolVersion is the latest Model Context Protocol revision implemented
+const mcpProtocolVersion = "2025-06-18"
+
+// mcpProtocolVersions lists the revisions accepted from clients, the tools subset did not change between them
+var mcpProtocolVersions = []string{mcpProtocolVersion, "2025-03-26", "2024-11-05"}
+
+func init() {
+	registerSubsystem(&subsystem{name: "mcp", commands: map[string]command{"mcp": runMCP}})
+}
+
+type (
+
+	// mcpTool is a tool offered to the assistant.
+	mcpTool struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		InputSchema map[string]any `json:"inputSchema"`
+
+		// call executes the tool, the result has to marshal to a JSON object
+		call func(arguments json.RawMessage) (any, error)
+	}
+
+	// mcpContent is a content block of a tool result.
+	mcpContent struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	// mcpToolResult is the result of tools/call.
+	mcpToolResult struct {
+		Content           []mcpContent `json:"content"`
+		StructuredContent any          `json:"structuredContent,omitempty"`
+		IsError           bool         `json:"isError,omitempty"`
+	}
+)
+
+// runMCP serves the Model Context Protocol on stdin and stdout, so assistants can query the addresses of the host
+// using tools. Only read-only tools are offered, nothing is changed on the host or the router. Logs are written to
+// stderr as stdout carries the protocol.
+func runMCP(logger *slog.Logger, _ []string) int {
+	tools := mcpTools(logger)
+	conn := newRPCConn(os.Stdout)
+	err := conn.serve(logger, os.Stdin, func(method string, params json.RawMessage) (any, *rpcError) {
+		switch method {
+		case "initialize":
+			var p struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			_ = json.Unmarshal(params, &p)
+			negotiated := mcpProtocolVersion
+			if slices.Contains(mcpProtocolVersions, p.ProtocolVersion) {
+				negotiated = p.ProtocolVersion
+			}
+			return map[string]any{
+				"protocolVersion": negotiated,
+				"capabilities":    map[string]any{"tools": map[string]any{}},
+				"serverInfo":      map[string]any{"name": "ips", "version": version},
+			}, nil
+		case "ping", "notifications/initialized", "notifications/cancelled":
+			return nil, nil
+		case "tools/list":
+			return map[string]any{"tools": tools}, nil
+		case "tools/call":
+			return callMCPTool(tools, params)
+		}
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + method}
+	})
+	if err != nil {
+		logger.Error("could not read request", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// callMCPTool executes the tool named in the parameters. Failures of the tool are reported in the result, so the
+// assistant sees them, unknown tools are a protocol error.
+func callMCPTool(tools []*mcpTool, params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	idx := slices.IndexFunc(tools, func(t *mcpTool) bool { return t.Name == p.Name })
+	if idx < 0 {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + p.Name}
+	}
+	if len(p.Arguments) == 0 {
+		p.Arguments = json.RawMessage("{}")
+	}
+	result, err := tools[idx].call(p.Arguments)
+	if err != nil {
+		return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	text, err := json.Marshal(result)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+	}
+	return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(text)}}, StructuredContent: result}, nil
+}
+
+// mcpTools returns the tools offered by runMCP.
+func mcpTools(logger *slog.Logger) []*mcpTool {
+	return []*mcpTool{
+		{
+			Name:        "get_local_ips",
+			Description: "List the IP addresses of all network interfaces of the host with their state flags and lifetimes.",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+			call: func(json.RawMessage) (any, error) {
+				local, err := getInterfaceAddresses(logger)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]any{"addresses": local}, nil
+			},
+		},
+		{
+			Name:        "get_public_ip",
+			Description: "Determine the public IP addresses of the host as seen from the internet using public IP providers.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"family": map[string]any{"type": "string", "enum": []string{"ipv4", "ipv6"}, "description": "address family, both if omitted"},
+				},
+			},
+			call: func(arguments json.RawMessage) (any, error) {
+				var a struct {
+					Family string `json:"family"`
+				}
+				if err := json.Unmarshal(arguments, &a); err != nil {
+					return nil, err
+				}
+				return mcpPublicIPs(a.Family)
+			},
+		},
+		{
+			Name:        "classify_ip",
+			Description: "Classify an IP address as loopback, private, link-local, CGNAT, documentation, global and similar.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"address": map[string]any{"type": "string", "description": "ip address, optionally in CIDR notation"},
+				},
+				"required": []string{"address"},
+			},
+			call: func(arguments json.RawMessage) (any, error) {
+				var a struct {
+					Address string `json:"address"`
+				}
+				if err := json.Unmarshal(arguments, &a); err != nil {
+					return nil, err
+				}
+				return classifyAddress(a.Address)
+			},
+		},
+	}
+}
+
+// mcpPublicIPs looks up the public addresses of the family, or both families if empty. Fails only if no address
+// could be determined.
+func mcpPublicIPs(family string) (any, error) {
+	if offline {
+		return nil, errors.New("public ip lookups are disabled in offline mode")
+	}
+	families := []string{"ipv4", "ipv6"}
+	switch family {
+	case "":
+	case "ipv4", "ipv6":
+		families = []string{family}
+	default:
+		return nil, fmt.Errorf("unknown family %q", family)
+	}
+	addresses := make(ips, 0)
+	errs := make([]error, 0)
+	for _, f := range families {
+		address, err := getPublicIp(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w for %s: %w", ErrNoPublicProvider, f, err))
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return map[string]any{"addresses": addresses}, nil
+}
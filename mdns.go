@@ -0,0 +1,400 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+// mdnsSupport names what advertises the host and finds its peers
+const mdnsSupport = "multicast DNS"
+
+const (
+	// mdnsPort is the port multicast DNS queries and responses are sent to
+	mdnsPort = 5353
+
+	// mdnsTTL is the time other hosts cache the advertised records, legacy unicast queries get at most
+	// mdnsLegacyTTL as required by RFC 6762
+	mdnsTTL, mdnsLegacyTTL = 120, 10
+
+	// DNS record types and class answered by the responder
+	dnsTypeA, dnsTypePTR, dnsTypeTXT, dnsTypeAAAA, dnsTypeSRV, dnsTypeANY = 1, 12, 16, 28, 33, 255
+	dnsClassIN                                                            = 1
+
+	// mdnsCacheFlush marks records this host is the only owner of, mdnsUnicastResponse in a question asks for a
+	// unicast response
+	mdnsCacheFlush, mdnsUnicastResponse = 0x8000, 0x8000
+
+	// mdnsService is the service type the addresses are advertised as, mdnsServices lists all service types
+	mdnsService, mdnsServices = "_ips._tcp.local.", "_services._dns-sd._udp.local."
+)
+
+// advertise makes watch answer mDNS queries for the host and the _ips._tcp service carrying its addresses
+var advertise bool
+
+type (
+
+	// mdnsResponder answers mDNS queries with the addresses of the last poll of the watch loop.
+	mdnsResponder struct {
+		mu sync.Mutex
+
+		// host is the name of the host in the .local domain, instance the name of its _ips._tcp service
+		host, instance string
+
+		// port is announced in the SRV record, it is the port of the health endpoints if they are served
+		port uint16
+
+		// current are the addresses known after the last poll
+		current ips
+
+		// conns are the sockets joined to the mDNS group of each family, keyed by the group address
+		conns map[*net.UDPAddr]*net.UDPConn
+
+		// peers are the other daemons found, keyed by their service instance
+		peers map[string]*peer
+	}
+
+	// dnsQuestion is a question of a DNS message.
+	dnsQuestion struct {
+		name    string
+		rrtype  uint16
+		unicast bool
+	}
+
+	// dnsRecord is a resource record of a DNS message, data is already encoded.
+	dnsRecord struct {
+		name   string
+		rrtype uint16
+		flush  bool
+		data   []byte
+	}
+)
+
+var (
+	// responder answers mDNS queries, nil unless advertise is set
+	responder *mdnsResponder
+
+	// mdnsGroups are the multicast groups of mDNS
+	mdnsGroups = []*net.UDPAddr{
+		{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort},
+		{IP: net.ParseIP("ff02::fb"), Port: mdnsPort},
+	}
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "mdns",
+		commands: map[string]command{"peers": runPeers},
+		flags: func() {
+			flag.BoolVar(&advertise, "mdns", false, "advertise the host and its addresses using mDNS while watch runs")
+			flag.StringVar(&meshPeers, "peers", "", "comma separated hosts running ips watch outside the link to exchange addresses with, implies -mdns")
+		},
+	})
+}
+
+// serveMDNS answers mDNS queries for <hostname>.local and the _ips._tcp service of the host until ctx is cancelled.
+// The service instance is named after the host and its TXT record lists the addresses by interface. The groups are
+// joined on the interface given using -via, otherwise on the one the system picks. Other daemons are searched for
+// on the link and at the mesh peers. Once ctx is cancelled the records are withdrawn.
+func serveMDNS(ctx context.Context, logger *slog.Logger) error {
+	if !advertise && meshPeers == "" {
+		return nil
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	name, _, _ = strings.Cut(name, ".")
+	var port uint16
+	if healthListen != "" {
+		if _, raw, err := net.SplitHostPort(healthListen); err == nil {
+			p, _ := strconv.ParseUint(raw, 10, 16)
+			port = uint16(p)
+		}
+	}
+	var ifi *net.Interface
+	if viaInterface != "" {
+		if ifi, err = net.InterfaceByName(viaInterface); err != nil {
+			return err
+		}
+	}
+
+	r := &mdnsResponder{
+		host:     name + ".local.",
+		instance: name + "." + mdnsService,
+		port:     port,
+		conns:    make(map[*net.UDPAddr]*net.UDPConn),
+		peers:    make(map[string]*peer),
+	}
+	errs := make([]error, 0)
+	for _, group := range mdnsGroups {
+		network := "udp6"
+		if group.IP.To4() != nil {
+			network = "udp4"
+		}
+		conn, err := net.ListenMulticastUDP(network, ifi, group)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not join %s: %w", group.IP, err))
+			continue
+		}
+		r.conns[group] = conn
+	}
+	if len(r.conns) == 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		logger.Warn("could not serve mDNS", "err", err)
+	}
+
+	responder = r
+	go func() {
+		<-ctx.Done()
+		r.announce(logger, 0)
+		for _, conn := range r.conns {
+			_ = conn.Close()
+		}
+	}()
+	for group, conn := range r.conns {
+		go r.serve(logger, conn, group)
+	}
+	go r.queryPeers(ctx, logger)
+	logger.Info("advertising using mDNS", "host", r.host, "service", r.instance)
+	return nil
+}
+
+// update records the addresses known after a poll and announces them if they changed.
+func (r *mdnsResponder) update(logger *slog.Logger, current ips) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	changed := diff(r.current, current) != nil
+	r.current = current
+	r.mu.Unlock()
+	if changed {
+		r.announce(logger, mdnsTTL)
+	}
+}
+
+// announce sends all records unsolicited to the groups, a ttl of 0 withdraws them.
+func (r *mdnsResponder) announce(logger *slog.Logger, ttl uint32) {
+	records := r.answer(dnsQuestion{name: mdnsService, rrtype: dnsTypeANY})
+	records = append(records, r.answer(dnsQuestion{name: r.instance, rrtype: dnsTypeANY})...)
+	records = append(records, r.answer(dnsQuestion{name: r.host, rrtype: dnsTypeANY})...)
+	msg := dnsResponse(0, nil, records, nil, ttl)
+	for group, conn := range r.conns {
+		if _, err := conn.WriteToUDP(msg, group); err != nil {
+			logger.Debug("could not announce using mDNS", "err", err, "group", group.IP.String())
+		}
+	}
+}
+
+// serve reads messages from conn, joined to group, until it is closed. Queries asking for records of the host are
+// answered, queries from a port other than the mDNS port are legacy unicast queries and answered like a DNS server
+// would. Responses are searched for other daemons.
+func (r *mdnsResponder) serve(logger *slog.Logger, conn *net.UDPConn, group *net.UDPAddr) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.Debug("could not read mDNS query", "err", err)
+			continue
+		}
+		if n >= 12 && buf[2]&0x80 != 0 {
+			r.learn(logger, buf[:n], from)
+			continue
+		}
+		id, questions, err := parseQuery(buf[:n])
+		if err != nil {
+			logger.Debug("ignoring mDNS message", "err", err, "from", from.String())
+			continue
+		}
+		legacy := from.Port != mdnsPort
+		answers, additional := make([]*dnsRecord, 0), make([]*dnsRecord, 0)
+		unicast := legacy
+		for _, q := range questions {
+			a := r.answer(q)
+			if len(a) > 0 && q.unicast {
+				unicast = true
+			}
+			answers = append(answers, a...)
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		for _, a := range answers {
+			switch {
+			case a.rrtype == dnsTypePTR && a.name == mdnsService:
+				additional = append(additional, r.answer(dnsQuestion{name: r.instance, rrtype: dnsTypeANY})...)
+				fallthrough
+			case a.rrtype == dnsTypeSRV:
+				additional = append(additional, r.answer(dnsQuestion{name: r.host, rrtype: dnsTypeANY})...)
+			}
+		}
+
+		ttl, to := uint32(mdnsTTL), group
+		if unicast {
+			to = from
+		}
+		if !legacy {
+			id, questions = 0, nil
+		} else {
+			ttl = mdnsLegacyTTL
+		}
+		if _, err := conn.WriteToUDP(dnsResponse(id, questions, answers, additional, ttl), to); err != nil {
+			logger.Debug("could not send mDNS response", "err", err, "to", to.String())
+		}
+	}
+}
+
+// answer returns the records of the host matching the question: PTR records for the service type, SRV and TXT
+// records for the service instance and A and AAAA records for the host name.
+func (r *mdnsResponder) answer(q dnsQuestion) []*dnsRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches := func(name string, rrtype uint16) bool {
+		return strings.EqualFold(q.name, name) && (q.rrtype == rrtype || q.rrtype == dnsTypeANY)
+	}
+	result := make([]*dnsRecord, 0)
+	if matches(mdnsServices, dnsTypePTR) {
+		result = append(result, &dnsRecord{name: mdnsServices, rrtype: dnsTypePTR, data: encodeName(mdnsService)})
+	}
+	if matches(mdnsService, dnsTypePTR) {
+		result = append(result, &dnsRecord{name: mdnsService, rrtype: dnsTypePTR, data: encodeName(r.instance)})
+	}
+	if matches(r.instance, dnsTypeSRV) {
+		data := binary.BigEndian.AppendUint16(make([]byte, 4), r.port)
+		result = append(result, &dnsRecord{name: r.instance, rrtype: dnsTypeSRV, flush: true, data: append(data, encodeName(r.host)...)})
+	}
+	if matches(r.instance, dnsTypeTXT) {
+		result = append(result, &dnsRecord{name: r.instance, rrtype: dnsTypeTXT, flush: true, data: r.txt()})
+	}
+	for _, i := range r.current {
+		if i.isPublic() || viaInterface != "" && i.Interface != viaInterface {
+			continue
+		}
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil || address.IsLoopback() {
+			continue
+		}
+		if v4 := address.To4(); v4 != nil && matches(r.host, dnsTypeA) {
+			result = append(result, &dnsRecord{name: r.host, rrtype: dnsTypeA, flush: true, data: v4})
+		}
+		if address.To4() == nil && matches(r.host, dnsTypeAAAA) {
+			result = append(result, &dnsRecord{name: r.host, rrtype: dnsTypeAAAA, flush: true, data: address.To16()})
+		}
+	}
+	return result
+}
+
+// txt encodes the TXT record of the service instance, one interface=address[,address] string per interface
+// including the public addresses. The caller must hold the lock.
+func (r *mdnsResponder) txt() []byte {
+	byInterface := make(map[string][]string)
+	names := make([]string, 0)
+	for _, i := range r.current {
+		name := strings.ReplaceAll(i.Interface, " ", "-")
+		if _, ok := byInterface[name]; !ok {
+			names = append(names, name)
+		}
+		byInterface[name] = append(byInterface[name], i.Address)
+	}
+	slices.Sort(names)
+	result := make([]byte, 0)
+	for _, name := range names {
+		entry := name + "=" + strings.Join(byInterface[name], ",")
+		// a single string holds at most 255 bytes
+		if len(entry) > 255 {
+			entry = entry[:255]
+		}
+		result = append(append(result, byte(len(entry))), entry...)
+	}
+	if len(result) == 0 {
+		return []byte{0}
+	}
+	return result
+}
+
+// parseQuery returns the id and the questions of a DNS query, responses are rejected.
+func parseQuery(msg []byte) (uint16, []dnsQuestion, error) {
+	if len(msg) < 12 {
+		return 0, nil, errors.New("message too short")
+	}
+	if msg[2]&0x80 != 0 {
+		return 0, nil, errors.New("not a query")
+	}
+	count := int(binary.BigEndian.Uint16(msg[4:6]))
+	questions := make([]dnsQuestion, 0, count)
+	offset := 12
+	for range count {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(msg) < next+4 {
+			return 0, nil, errors.New("question truncated")
+		}
+		class := binary.BigEndian.Uint16(msg[next+2 : next+4])
+		if class&^mdnsUnicastResponse == dnsClassIN {
+			questions = append(questions, dnsQuestion{
+				name:    name,
+				rrtype:  binary.BigEndian.Uint16(msg[next : next+2]),
+				unicast: class&mdnsUnicastResponse != 0,
+			})
+		}
+		offset = next + 4
+	}
+	return binary.BigEndian.Uint16(msg[0:2]), questions, nil
+}
+
+// encodeName encodes a fully qualified name uncompressed.
+func encodeName(name string) []byte {
+	result := make([]byte, 0, len(name)+1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		result = append(append(result, byte(len(label))), label...)
+	}
+	return append(result, 0)
+}
+
+// dnsResponse encodes an authoritative response with the questions, answers and additional records, all records
+// are given the ttl.
+func dnsResponse(id uint16, questions []dnsQuestion, answers, additional []*dnsRecord, ttl uint32) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, 0x8400)
+	for _, count := range []int{len(questions), len(answers), 0, len(additional)} {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(count))
+	}
+	for _, q := range questions {
+		msg = append(msg, encodeName(q.name)...)
+		msg = binary.BigEndian.AppendUint16(msg, q.rrtype)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	}
+	for _, rr := range append(answers, additional...) {
+		class := uint16(dnsClassIN)
+		if rr.flush && len(questions) == 0 {
+			class |= mdnsCacheFlush
+		}
+		msg = append(msg, encodeName(rr.name)...)
+		msg = binary.BigEndian.AppendUint16(msg, rr.rrtype)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+		msg = binary.BigEndian.AppendUint32(msg, ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rr.data)))
+		msg = append(msg, rr.data...)
+	}
+	return msg
+}
@@ -0,0 +1,216 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientIdle is the time after which the rate limit of a client is forgotten
+const clientIdle = 10 * time.Minute
+
+var (
+	// rateLimit is the number of requests per minute a client may send to the server mode, 0 for no limit
+	rateLimit uint
+
+	// corsOrigins are the comma separated origins browsers may call the server mode from, * for all
+	corsOrigins string
+
+	// trustedProxies are the comma separated networks of reverse proxies whose X-Forwarded-For header is used
+	trustedProxies string
+
+	// trusted are the networks of the reverse proxies parsed from trustedProxies
+	trusted []*net.IPNet
+
+	// accessLog logs every request served by the server mode
+	accessLog bool
+)
+
+type (
+
+	// statusRecorder remembers the status and size of a response for the access log.
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+		size   int
+	}
+
+	// clientBucket is the token bucket of a client, refilled continuously up to the rate limit.
+	clientBucket struct {
+		tokens float64
+		last   time.Time
+	}
+
+	// rateLimiter limits the requests per client using a token bucket per address.
+	rateLimiter struct {
+		mu sync.Mutex
+
+		// perMinute is the sustained rate and the burst of a client
+		perMinute float64
+
+		clients map[string]*clientBucket
+		pruned  time.Time
+	}
+)
+
+// WriteHeader records the status.
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size, the status defaults to 200 like for http.ResponseWriter.
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(data)
+	s.size += n
+	return n, err
+}
+
+// withMiddleware wraps the handler of the server mode with CORS, the rate limit and the access log, outermost
+// first: rejected requests are logged and preflight requests do not count against the limit.
+func withMiddleware(logger *slog.Logger, handler http.Handler) (http.Handler, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, p := range splitList(trustedProxies) {
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q", p)
+		}
+		proxies = append(proxies, network)
+	}
+	trusted = proxies
+
+	if rateLimit > 0 {
+		handler = (&rateLimiter{perMinute: float64(rateLimit), clients: make(map[string]*clientBucket)}).wrap(handler)
+	}
+	if origins := splitList(corsOrigins); len(origins) > 0 {
+		handler = withCORS(origins, handler)
+	}
+	if accessLog {
+		handler = withAccessLog(logger, handler)
+	}
+	return handler, nil
+}
+
+// withAccessLog logs every request with the client address, method, path, status, size and latency.
+func withAccessLog(logger *slog.Logger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		logger.Info("request",
+			"client", clientAddress(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"size", recorder.size,
+			"latency", time.Since(start).Round(time.Microsecond).String(),
+			"user_agent", r.UserAgent())
+	})
+}
+
+// withCORS allows browsers on the given origins to call the API, preflight requests are answered directly.
+func withCORS(origins []string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (slices.Contains(origins, "*") || slices.Contains(origins, origin))
+		if allowed {
+			if slices.Contains(origins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// wrap rejects requests of clients that used up their tokens with 429 and the time to wait in Retry-After.
+func (l *rateLimiter) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.take(clientAddress(r), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token of the client and returns 0, or the time until the next token if none is left. Clients idle
+// for clientIdle are forgotten once a minute.
+func (l *rateLimiter) take(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > time.Minute {
+		for address, bucket := range l.clients {
+			if now.Sub(bucket.last) > clientIdle {
+				delete(l.clients, address)
+			}
+		}
+		l.pruned = now
+	}
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &clientBucket{tokens: l.perMinute, last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens = min(l.perMinute, bucket.tokens+now.Sub(bucket.last).Minutes()*l.perMinute)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.perMinute * float64(time.Minute))
+	}
+	bucket.tokens--
+	return 0
+}
+
+// isTrustedProxy reports whether address belongs to a trusted reverse proxy.
+func isTrustedProxy(address string) bool {
+	parsed := net.ParseIP(address)
+	for _, n := range trusted {
+		if parsed != nil && n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient returns the client a trusted proxy forwarded the request for, the last address of
+// X-Forwarded-For not belonging to a trusted proxy. It returns peer if the header is missing.
+func forwardedClient(r *http.Request, peer string) string {
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		if !isTrustedProxy(address) {
+			return address
+		}
+		peer = address
+	}
+	return peer
+}
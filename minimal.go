@@ -0,0 +1,79 @@
+//go:build ips_minimal && !ips_full
+
+package main
+
+import (
+	"log/slog"
+)
+
+// The minimal build enumerates local addresses and looks up the public ip, every other subsystem is left out. The
+// declarations below stand in for the ones the core relies on.
+
+const (
+	// traySupport is empty, the tray is left out of the minimal build
+	traySupport = ""
+
+	// dbusSupport is empty, the D-Bus service is left out of the minimal build
+	dbusSupport = ""
+
+	// desktopNotificationSupport is empty, desktop notifications are left out of the minimal build
+	desktopNotificationSupport = ""
+
+	// pluginSupport is empty, plugins are left out of the minimal build
+	pluginSupport = ""
+
+	// firewallSupport is empty, the firewall command is left out of the minimal build
+	firewallSupport = ""
+
+	// multihomeSupport and traceSupport are empty, the diagnostics are left out of the minimal build
+	multihomeSupport = ""
+	traceSupport     = ""
+
+	// raSupport is empty, the ra command is left out of the minimal build
+	raSupport = ""
+
+	// upnpSupport is empty, nat and portmap are left out of the minimal build
+	upnpSupport = ""
+
+	// modemSupport is empty, the modem command is left out of the minimal build
+	modemSupport = ""
+
+	// wakeOnLANSupport is empty, the wol command is left out of the minimal build
+	wakeOnLANSupport = ""
+
+	// changeNotificationSupport and serviceSupport are empty, watch is left out of the minimal build
+	changeNotificationSupport = ""
+	serviceSupport            = ""
+
+	// natPMPSupport is empty, the gateway provider is left out of the minimal build
+	natPMPSupport = ""
+
+	// mdnsSupport is empty, peers are left out of the minimal build
+	mdnsSupport = ""
+
+	// gatewayDiscoverySupport is empty, the gateway is only needed by subsystems left out of the minimal build
+	gatewayDiscoverySupport = ""
+
+	// stdio is false, JSON-RPC is left out of the minimal build
+	stdio = false
+)
+
+// applyPlugins returns the address list unchanged, plugins are left out of the minimal build.
+func applyPlugins(_ *slog.Logger, list ips) (ips, error) {
+	return list, nil
+}
+
+// runStdio is never called, JSON-RPC is left out of the minimal build.
+func runStdio(_ *slog.Logger) int {
+	return exitInternalError
+}
+
+// addHostAddresses returns the address list unchanged, the control socket is left out of the minimal build.
+func addHostAddresses(_ *slog.Logger, list ips) ips {
+	return list
+}
+
+// addGuests returns the address list unchanged, virtual machines are left out of the minimal build.
+func addGuests(_ *slog.Logger, list ips) ips {
+	return list
+}
@@ -0,0 +1,259 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// modemSupport names the service the modem command asks
+const modemSupport = "ModemManager"
+
+func init() {
+	registerSubsystem(&subsystem{name: "modem", commands: map[string]command{"modem": runModem}})
+}
+
+const (
+	// modemManagerName is the bus name and interface prefix of ModemManager
+	modemManagerName = "org.freedesktop.ModemManager1"
+
+	// modemManagerPath is the object managing the modem objects
+	modemManagerPath = dbus.ObjectPath("/org/freedesktop/ModemManager1")
+)
+
+// modemStates describe the values of the State property of a modem, starting at -1 (MMModemState)
+var modemStates = []string{"failed", "unknown", "initializing", "locked", "disabled", "disabling", "enabling", "enabled", "searching", "registered", "disconnecting", "connecting", "connected"}
+
+// accessTechnologies name the bits of the AccessTechnologies property of a modem (MMModemAccessTechnology)
+var accessTechnologies = []string{"pots", "gsm", "gsm-compact", "gprs", "edge", "umts", "hsdpa", "hsupa", "hspa", "hspa+", "1xrtt", "evdo0", "evdoa", "evdob", "lte", "5gnr", "lte-cat-m", "lte-nb-iot"}
+
+type (
+
+	// modem is a mobile broadband modem as reported by ModemManager.
+	modem struct {
+		Manufacturer string
+		Model        string
+		State        string
+
+		// Technologies are the access technologies currently used, e.g. lte or 5gnr
+		Technologies []string
+
+		// Operator is the name of the network the modem is registered in
+		Operator string `json:",omitempty"`
+
+		// Signal is the signal quality in percent
+		Signal uint32
+
+		Bearers []*bearer
+	}
+
+	// bearer is a data connection of a modem, the addresses are assigned by the carrier.
+	bearer struct {
+
+		// Interface is the network interface carrying the connection, e.g. wwan0
+		Interface string `json:",omitempty"`
+
+		APN       string
+		Connected bool
+
+		// IPv4 and IPv6 are the addresses assigned to the bearer in CIDR notation
+		IPv4 string `json:",omitempty"`
+		IPv6 string `json:",omitempty"`
+
+		Gateway string   `json:",omitempty"`
+		DNS     []string `json:",omitempty"`
+
+		// CarrierNAT is set if the carrier translates the IPv4 address of the bearer, e.g. an address of the
+		// cgnat range or a public address differing from the one assigned
+		CarrierNAT bool
+
+		// Public is the public IPv4 address seen through the interface, empty when offline
+		Public string `json:",omitempty"`
+	}
+)
+
+// runModem lists the mobile broadband modems known to ModemManager with their data connections: the APN, the
+// addresses the carrier assigned, the signal quality and whether the carrier uses NAT. A kernel wwan interface
+// alone does not explain addresses like 100.64.0.0/10 or a point-to-point IPv4 without gateway. The public
+// address is looked up through the interface of every connected bearer unless offline is set.
+func runModem(logger *slog.Logger, _ []string) int {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		logger.Error("could not connect to system bus", "err", err)
+		return exitInternalError
+	}
+	defer conn.Close()
+
+	modems, err := listModems(conn)
+	if err != nil {
+		logger.Error("could not query ModemManager", "err", err)
+		return exitInternalError
+	}
+	code := exitOK
+	defer func(previous string) { viaInterface = previous }(viaInterface)
+	for _, m := range modems {
+		for _, b := range m.Bearers {
+			if !b.Connected || b.IPv4 == "" {
+				continue
+			}
+			if c, err := classifyAddress(b.IPv4); err == nil && !c.Public {
+				b.CarrierNAT = true
+			}
+			if offline || b.Interface == "" {
+				continue
+			}
+			viaInterface = b.Interface
+			public, err := getPublicIp("ipv4")
+			if err != nil {
+				logger.Warn("could not get public ip", "err", err, "interface", b.Interface)
+				code = exitPublicLookupFailed
+				continue
+			}
+			b.Public = public.Address
+			if address, _, _ := strings.Cut(b.IPv4, "/"); address != b.Public {
+				b.CarrierNAT = true
+			}
+		}
+	}
+
+	if jsonOutput {
+		if printJSON(logger, modems) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	fmt.Println("interface\tapn\tipv4\tipv6\tcarrier-nat\tsignal\ttechnology\toperator")
+	for _, m := range modems {
+		technologies := strings.Join(m.Technologies, ",")
+		if len(m.Bearers) == 0 {
+			fmt.Printf("-\t-\t-\t-\t-\t%d%%\t%s\t%s\n", m.Signal, orDash(technologies), orDash(m.Operator))
+		}
+		for _, b := range m.Bearers {
+			nat := "no"
+			if b.CarrierNAT {
+				nat = "yes"
+			}
+			if !b.Connected {
+				nat = "-"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%d%%\t%s\t%s\n", orDash(b.Interface), orDash(b.APN), orDash(b.IPv4), orDash(b.IPv6), nat, m.Signal, orDash(technologies), orDash(m.Operator))
+		}
+	}
+	return code
+}
+
+// orDash returns s, or - if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// listModems returns the modems managed by ModemManager sorted by their object path.
+func listModems(conn *dbus.Conn) ([]*modem, error) {
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	if err := conn.Object(modemManagerName, modemManagerPath).Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects); err != nil {
+		return nil, err
+	}
+	paths := make([]dbus.ObjectPath, 0, len(objects))
+	for path := range objects {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
+
+	modems := make([]*modem, 0, len(paths))
+	for _, path := range paths {
+		properties, ok := objects[path][modemManagerName+".Modem"]
+		if !ok {
+			continue
+		}
+		m := &modem{Bearers: make([]*bearer, 0)}
+		storeProperty(properties, "Manufacturer", &m.Manufacturer)
+		storeProperty(properties, "Model", &m.Model)
+		var state int32
+		if storeProperty(properties, "State", &state) && state >= -1 && int(state+1) < len(modemStates) {
+			m.State = modemStates[state+1]
+		}
+		var technologies uint32
+		storeProperty(properties, "AccessTechnologies", &technologies)
+		m.Technologies = make([]string, 0)
+		for bit, name := range accessTechnologies {
+			if technologies&(1<<bit) != 0 {
+				m.Technologies = append(m.Technologies, name)
+			}
+		}
+		var quality []any
+		if storeProperty(properties, "SignalQuality", &quality) && len(quality) > 0 {
+			m.Signal, _ = quality[0].(uint32)
+		}
+		if gpp, ok := objects[path][modemManagerName+".Modem.Modem3gpp"]; ok {
+			storeProperty(gpp, "OperatorName", &m.Operator)
+		}
+
+		var bearers []dbus.ObjectPath
+		storeProperty(properties, "Bearers", &bearers)
+		for _, bearerPath := range bearers {
+			b, err := getBearer(conn, bearerPath)
+			if err != nil {
+				return nil, fmt.Errorf("could not read bearer %s: %w", bearerPath, err)
+			}
+			m.Bearers = append(m.Bearers, b)
+		}
+		modems = append(modems, m)
+	}
+	return modems, nil
+}
+
+// getBearer reads the properties of a bearer, bearers are not part of the objects managed by ModemManager.
+func getBearer(conn *dbus.Conn, path dbus.ObjectPath) (*bearer, error) {
+	var properties map[string]dbus.Variant
+	if err := conn.Object(modemManagerName, path).Call("org.freedesktop.DBus.Properties.GetAll", 0, modemManagerName+".Bearer").Store(&properties); err != nil {
+		return nil, err
+	}
+	b := &bearer{}
+	storeProperty(properties, "Interface", &b.Interface)
+	storeProperty(properties, "Connected", &b.Connected)
+	var settings map[string]dbus.Variant
+	if storeProperty(properties, "Properties", &settings) {
+		storeProperty(settings, "apn", &b.APN)
+	}
+	for _, family := range []string{"Ip4Config", "Ip6Config"} {
+		var config map[string]dbus.Variant
+		if !storeProperty(properties, family, &config) {
+			continue
+		}
+		var address string
+		var prefix uint32
+		if !storeProperty(config, "address", &address) || address == "" {
+			continue
+		}
+		storeProperty(config, "prefix", &prefix)
+		address += "/" + strconv.FormatUint(uint64(prefix), 10)
+		if family == "Ip4Config" {
+			b.IPv4 = address
+			storeProperty(config, "gateway", &b.Gateway)
+		} else {
+			b.IPv6 = address
+		}
+		for _, key := range []string{"dns1", "dns2", "dns3"} {
+			var server string
+			if storeProperty(config, key, &server) && server != "" {
+				b.DNS = append(b.DNS, server)
+			}
+		}
+	}
+	return b, nil
+}
+
+// storeProperty stores the property key into dest, it reports false if the property is missing or of another type.
+func storeProperty(properties map[string]dbus.Variant, key string, dest any) bool {
+	value, ok := properties[key]
+	return ok && value.Store(dest) == nil
+}
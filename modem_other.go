@@ -0,0 +1,18 @@
+//go:build !linux && (!ips_minimal || ips_full)
+
+package main
+
+import "log/slog"
+
+// modemSupport is empty, ModemManager is only available on Linux
+const modemSupport = ""
+
+func init() {
+	registerSubsystem(&subsystem{name: "modem", commands: map[string]command{"modem": runModem}})
+}
+
+// runModem is only supported on linux.
+func runModem(logger *slog.Logger, _ []string) int {
+	logger.Error("the modem command is not supported on this platform")
+	return exitInternalError
+}
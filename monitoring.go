@@ -0,0 +1,99 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "monitoring",
+		formatters: map[string]formatter{
+			"ansible-facts": ansibleFacts,
+			"checkmk":       checkmkLocal,
+			"external-data": externalData,
+			"nagios":        nagios,
+			"telegraf":      telegrafMetrics,
+			"zabbix-lld":    zabbixDiscovery,
+		},
+		failures: map[string]func(reason string) int{"nagios": nagiosFailure},
+		flags: func() {
+			flag.StringVar(&expectIP, "expect-ip", "", "comma separated public ips expected by the nagios and checkmk output formats")
+		},
+	})
+}
+
+// zabbixDiscovery renders the addresses as Zabbix low-level discovery JSON. Every address becomes an entry with
+// the macros {#IFNAME}, {#IPADDR}, {#PREFIX} and {#FAMILY}, public addresses use "public" as interface name. The
+// interface name is made a valid part of an item key using checkName.
+func zabbixDiscovery(list ips, code int) (string, int, error) {
+	entries := make([]map[string]string, 0, len(list))
+	for _, i := range list {
+		address, prefix, _ := strings.Cut(i.Address, "/")
+		name := checkName(i.Interface)
+		if i.isPublic() {
+			name = "public"
+		}
+		entries = append(entries, map[string]string{
+			"{#IFNAME}": name,
+			"{#IPADDR}": address,
+			"{#PREFIX}": prefix,
+			"{#FAMILY}": i.family(),
+		})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", code, err
+	}
+	return string(data), code, nil
+}
+
+// checkmkLocal renders check_mk local check lines, one service per interface listing its addresses and one per
+// public address family. Public addresses not contained in expect-ip are reported as CRITICAL. Service names are
+// separated by spaces in the line, so the interface name is passed through checkName.
+func checkmkLocal(list ips, code int) (string, int, error) {
+	public, local := splitPublic(list)
+	expected := splitList(expectIP)
+	lines := make([]string, 0)
+
+	for _, i := range public {
+		state := 0
+		if len(expected) > 0 && familyOfList(expected, i.family()) != "" && !slices.Contains(expected, i.Address) {
+			state = 2
+		}
+		lines = append(lines, fmt.Sprintf("%d IPS_public_%s - %s", state, i.family(), i.Address))
+	}
+
+	byInterface := make(map[string][]string)
+	names := make([]string, 0)
+	for _, i := range local {
+		name := checkName(i.Interface)
+		if _, ok := byInterface[name]; !ok {
+			names = append(names, name)
+		}
+		byInterface[name] = append(byInterface[name], i.Address)
+	}
+	for _, name := range names {
+		addresses := byInterface[name]
+		lines = append(lines, fmt.Sprintf("0 IPS_%s addresses=%d %s", name, len(addresses), strings.Join(addresses, ", ")))
+	}
+	return strings.Join(lines, "\n"), code, nil
+}
+
+// checkName replaces every character of an interface name not allowed in check_mk service names and Zabbix item
+// keys by an underscore, e.g. "vEthernet (Default Switch)" becomes "vEthernet__Default_Switch_".
+func checkName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
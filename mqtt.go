@@ -0,0 +1,223 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// mqttSink publishes changes to a topic of an MQTT broker using MQTT 3.1.1. Changes are rare, so every change uses
+// a connection of its own instead of keeping one alive.
+type mqttSink struct {
+	// address is the host and port of the broker, tls is set for mqtts
+	address string
+	tls     bool
+
+	topic, user, password, clientID string
+	qos                             byte
+	retain                          bool
+}
+
+// newMQTTSink creates a sink publishing to the target of spec, mqtt://[user:password@]host[:port]/topic or mqtts
+// for TLS. The target may be a secret:// uri. Messages are published with QoS 1 unless the qos option is 0.
+func newMQTTSink(_ *slog.Logger, spec *sinkSpec) (sink, error) {
+	target, err := resolveSecret(spec.target)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	m := &mqttSink{topic: strings.TrimPrefix(u.Path, "/"), qos: 1, retain: spec.options["retain"] == "true"}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt":
+	case "mqtts":
+		m.tls, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("unsupported mqtt scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || m.topic == "" {
+		return nil, errors.New("mqtt target needs a host and a topic")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	m.address = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		m.user = u.User.Username()
+		m.password, _ = u.User.Password()
+	}
+	if qos, ok := spec.options["qos"]; ok {
+		if qos != "0" && qos != "1" {
+			return nil, fmt.Errorf("unsupported mqtt qos %q", qos)
+		}
+		m.qos = qos[0] - '0'
+	}
+	m.clientID = spec.options["client-id"]
+	if m.clientID == "" {
+		hostname, _ := os.Hostname()
+		m.clientID = "ips-" + hostname
+	}
+	return m, nil
+}
+
+// send publishes the payload or the change as JSON, with dry-run set the message is printed instead.
+func (m *mqttSink) send(c *change, payload []byte) error {
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(c); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Printf("would publish to %s on %s\n%s\n", m.topic, m.address, payload)
+		return nil
+	}
+	start := time.Now()
+	err := m.publish(payload)
+	audit("mqtt", m.address+"/"+m.topic, start, "", err)
+	return err
+}
+
+// publish connects to the broker, publishes the message and disconnects.
+func (m *mqttSink) publish(payload []byte) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if m.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.address, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", m.address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+
+	// CONNECT with clean session and a keep alive of 60 seconds
+	var flags byte = 0x02
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4)
+	if m.user != "" {
+		flags |= 0x80
+	}
+	if m.password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags, 0, 60)
+	body = mqttString(body, m.clientID)
+	if m.user != "" {
+		body = mqttString(body, m.user)
+	}
+	if m.password != "" {
+		body = mqttString(body, m.password)
+	}
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		return err
+	}
+	kind, answer, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if kind != 0x20 || len(answer) != 2 {
+		return fmt.Errorf("unexpected mqtt packet %#x instead of CONNACK", kind)
+	}
+	if answer[1] != 0 {
+		return fmt.Errorf("mqtt broker refused connection with code %d", answer[1])
+	}
+
+	header := byte(0x30) | m.qos<<1
+	if m.retain {
+		header |= 0x01
+	}
+	body = mqttString(nil, m.topic)
+	if m.qos == 1 {
+		body = append(body, 0, 1)
+	}
+	body = append(body, payload...)
+	if _, err := conn.Write(mqttPacket(header, body)); err != nil {
+		return err
+	}
+	if m.qos == 1 {
+		kind, answer, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		if kind != 0x40 || len(answer) != 2 || binary.BigEndian.Uint16(answer) != 1 {
+			return fmt.Errorf("unexpected mqtt packet %#x instead of PUBACK", kind)
+		}
+	}
+	_, err = conn.Write([]byte{0xe0, 0})
+	return err
+}
+
+// mqttString appends s prefixed by its length to b.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket returns a packet with the fixed header and the remaining length encoded as variable byte integer.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// readMQTTPacket reads a packet, returning its type with the flags removed and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, errors.New("invalid mqtt remaining length")
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
+
+// close does nothing, every change is published using a connection of its own.
+func (m *mqttSink) close() {}
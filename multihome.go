@@ -0,0 +1,179 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+type (
+
+	// defaultRoute is a route to the internet as found in the routing tables.
+	defaultRoute struct {
+
+		// Family is either ipv4 or ipv6
+		Family string
+
+		// Interface is the uplink the route uses
+		Interface string
+
+		// Gateway is the next hop, empty for point to point links
+		Gateway string `json:",omitempty"`
+
+		// Metric is the priority of the route, lower values win
+		Metric uint32
+
+		// Table is the routing table containing the route, 254 is the main table
+		Table uint32
+	}
+
+	// sourceRoute describes which uplink traffic from a local address leaves through.
+	sourceRoute struct {
+
+		// Source is the local address
+		Source string
+
+		// Family is either ipv4 or ipv6
+		Family string
+
+		// Interface is the interface owning Source
+		Interface string
+
+		// Egress is the interface traffic from Source to the internet leaves through
+		Egress string `json:",omitempty"`
+
+		// Gateway is the next hop of that traffic
+		Gateway string `json:",omitempty"`
+
+		// Error describes why no route could be determined
+		Error string `json:",omitempty"`
+	}
+
+	// multihomeReport is the result of ips multihome.
+	multihomeReport struct {
+
+		// Routes contains all default routes of all tables
+		Routes []*defaultRoute
+
+		// Sources contains the uplink selected for every local address
+		Sources []*sourceRoute
+
+		// Problems lists configurations likely to break connections
+		Problems []string `json:",omitempty"`
+	}
+)
+
+// runMultihome summarizes the default routes per family and which uplink traffic from each local address
+// leaves through, honoring policy routing. Asymmetric and broken configurations are flagged, in that case
+// exitCheckFailed is returned.
+func runMultihome(logger *slog.Logger, _ []string) int {
+	routes, err := defaultRoutes()
+	if err != nil {
+		logger.Error("could not read routing table", "err", err)
+		return exitInternalError
+	}
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		logger.Error("could not get ip addresses", "err", err)
+		return exitInternalError
+	}
+
+	report := &multihomeReport{Routes: routes, Sources: make([]*sourceRoute, 0)}
+	for _, i := range local {
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil || !address.IsGlobalUnicast() {
+			continue
+		}
+		s := &sourceRoute{Source: address.String(), Family: i.family(), Interface: i.Interface}
+		destination := net.ParseIP("192.0.2.1")
+		if s.Family == "ipv6" {
+			destination = net.ParseIP("2001:db8::1")
+		}
+		s.Egress, s.Gateway, err = egressFor(address, destination)
+		if err != nil {
+			s.Error = err.Error()
+		}
+		report.Sources = append(report.Sources, s)
+	}
+	report.Problems = multihomeProblems(report)
+
+	code := exitOK
+	if len(report.Problems) > 0 {
+		code = exitCheckFailed
+	}
+	if jsonOutput {
+		if printJSON(logger, report) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	for _, r := range report.Routes {
+		via := r.Gateway
+		if via == "" {
+			via = "direct"
+		}
+		fmt.Printf("route\t%s\t%s\tvia %s\tmetric %d\ttable %d\n", r.Family, r.Interface, via, r.Metric, r.Table)
+	}
+	for _, s := range report.Sources {
+		if s.Error != "" {
+			fmt.Printf("source\t%s\t%s\tunroutable\t%s\n", s.Source, s.Interface, s.Error)
+			continue
+		}
+		fmt.Printf("source\t%s\t%s\tegress %s\n", s.Source, s.Interface, s.Egress)
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("WARN\t%s\n", p)
+	}
+	return code
+}
+
+// multihomeProblems flags default routes competing with the same metric, default routes through interfaces
+// lacking an address of the family, and asymmetric routing: traffic from the address of one uplink leaving
+// through another one, which the upstream networks usually drop.
+func multihomeProblems(report *multihomeReport) []string {
+	problems := make([]string, 0)
+	hasAddress := make(map[string]bool)
+	for _, s := range report.Sources {
+		hasAddress[s.Family+" "+s.Interface] = true
+	}
+	uplinks := make(map[string]bool)
+	for idx, r := range report.Routes {
+		uplinks[r.Family+" "+r.Interface] = true
+		if !hasAddress[r.Family+" "+r.Interface] {
+			problems = append(problems, fmt.Sprintf("%s default route via %s, which has no global %s address", r.Family, r.Interface, r.Family))
+		}
+		for _, other := range report.Routes[idx+1:] {
+			if other.Family == r.Family && other.Table == r.Table && other.Metric == r.Metric && other.Interface != r.Interface {
+				problems = append(problems, fmt.Sprintf("%s default routes via %s and %s share metric %d in table %d", r.Family, r.Interface, other.Interface, r.Metric, r.Table))
+			}
+		}
+	}
+	for _, s := range report.Sources {
+		if s.Error != "" || s.Egress == s.Interface || !uplinks[s.Family+" "+s.Interface] {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("traffic from %s of uplink %s leaves through %s", s.Source, s.Interface, s.Egress))
+	}
+	families := make(map[string]bool)
+	for _, r := range report.Routes {
+		families[r.Family] = true
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if families[family] {
+			continue
+		}
+		sources := make([]string, 0)
+		for _, s := range report.Sources {
+			if s.Family == family && !net.ParseIP(s.Source).IsPrivate() {
+				sources = append(sources, s.Source)
+			}
+		}
+		if len(sources) > 0 {
+			problems = append(problems, fmt.Sprintf("no %s default route, but global addresses %s", family, strings.Join(sources, ", ")))
+		}
+	}
+	return problems
+}
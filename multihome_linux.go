@@ -0,0 +1,189 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// multihomeSupport names where default routes and egress interfaces are asked
+const multihomeSupport = "netlink"
+
+// routing attributes missing in package syscall
+const (
+	rtaTable     = 15
+	rtTableLocal = 255
+)
+
+// defaultRoutes dumps the routing tables using netlink and returns the unicast default routes of all tables.
+// Multipath routes are reported once per next hop.
+func defaultRoutes() ([]*defaultRoute, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, os.NewSyscallError("netlinkrib", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, os.NewSyscallError("parsenetlinkmessage", err)
+	}
+
+	routes := make([]*defaultRoute, 0)
+	for _, m := range messages {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		family, dstLen, table, kind := m.Data[0], m.Data[1], uint32(m.Data[4]), m.Data[7]
+		if dstLen != 0 || kind != syscall.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, os.NewSyscallError("parsenetlinkrouteattr", err)
+		}
+		r := &defaultRoute{Family: "ipv4", Table: table}
+		if family == syscall.AF_INET6 {
+			r.Family = "ipv6"
+		}
+		var oif uint32
+		var multipath []byte
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_OIF:
+				oif = binary.NativeEndian.Uint32(a.Value)
+			case syscall.RTA_GATEWAY:
+				r.Gateway = net.IP(a.Value).String()
+			case syscall.RTA_PRIORITY:
+				r.Metric = binary.NativeEndian.Uint32(a.Value)
+			case rtaTable:
+				r.Table = binary.NativeEndian.Uint32(a.Value)
+			case syscall.RTA_MULTIPATH:
+				multipath = a.Value
+			}
+		}
+		if r.Table == rtTableLocal {
+			continue
+		}
+		if multipath == nil {
+			r.Interface = interfaceName(oif)
+			routes = append(routes, r)
+			continue
+		}
+		// struct rtnexthop: length (2), flags (1), hops (1), ifindex (4), followed by attributes
+		for len(multipath) >= 8 {
+			length := int(binary.NativeEndian.Uint16(multipath[0:2]))
+			if length < 8 || length > len(multipath) {
+				break
+			}
+			hop := *r
+			hop.Interface = interfaceName(binary.NativeEndian.Uint32(multipath[4:8]))
+			hop.Gateway = ""
+			for attrs := multipath[8:length]; len(attrs) >= 4; {
+				attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+				if attrLen < 4 || attrLen > len(attrs) {
+					break
+				}
+				if binary.NativeEndian.Uint16(attrs[2:4]) == syscall.RTA_GATEWAY {
+					hop.Gateway = net.IP(attrs[4:attrLen]).String()
+				}
+				attrs = attrs[(attrLen+3)&^3:]
+			}
+			routes = append(routes, &hop)
+			multipath = multipath[(length+3)&^3:]
+		}
+	}
+	return routes, nil
+}
+
+// egressFor asks the kernel which interface and gateway traffic from source to destination uses, like
+// "ip route get <destination> from <source>" does. Unlike connecting a socket this honors policy routing rules
+// matching the source.
+func egressFor(source, destination net.IP) (string, string, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return "", "", os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return "", "", os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", "", os.NewSyscallError("bind", err)
+	}
+
+	family, bits := byte(syscall.AF_INET), byte(32)
+	src, dst := source.To4(), destination.To4()
+	if src == nil {
+		family, bits = syscall.AF_INET6, 128
+		src, dst = source.To16(), destination.To16()
+	}
+	rtmsg := []byte{family, bits, bits, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	request := append(rtmsg, routeAttr(syscall.RTA_DST, dst)...)
+	request = append(request, routeAttr(syscall.RTA_SRC, src)...)
+	header := make([]byte, syscall.NLMSG_HDRLEN)
+	binary.NativeEndian.PutUint32(header[0:4], uint32(syscall.NLMSG_HDRLEN+len(request)))
+	binary.NativeEndian.PutUint16(header[4:6], syscall.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(header[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(header[8:12], 1)
+	if err := syscall.Sendto(fd, append(header, request...), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", "", os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return "", "", os.NewSyscallError("recvfrom", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return "", "", os.NewSyscallError("parsenetlinkmessage", err)
+	}
+	for _, m := range messages {
+		switch m.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(m.Data) >= 4 {
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return "", "", syscall.Errno(errno)
+				}
+			}
+		case syscall.RTM_NEWROUTE:
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return "", "", os.NewSyscallError("parsenetlinkrouteattr", err)
+			}
+			var egress, gateway string
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case syscall.RTA_OIF:
+					egress = interfaceName(binary.NativeEndian.Uint32(a.Value))
+				case syscall.RTA_GATEWAY:
+					gateway = net.IP(a.Value).String()
+				}
+			}
+			return egress, gateway, nil
+		}
+	}
+	return "", "", fmt.Errorf("no route returned")
+}
+
+// routeAttr encodes a netlink route attribute padded to four bytes.
+func routeAttr(kind uint16, value []byte) []byte {
+	attr := make([]byte, (syscall.SizeofRtAttr+len(value)+3)&^3)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(attr[2:4], kind)
+	copy(attr[syscall.SizeofRtAttr:], value)
+	return attr
+}
+
+// interfaceName returns the name of the interface with the given index or the index itself.
+func interfaceName(index uint32) string {
+	i, err := net.InterfaceByIndex(int(index))
+	if err != nil {
+		return fmt.Sprintf("if%d", index)
+	}
+	return i.Name
+}
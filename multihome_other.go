@@ -0,0 +1,21 @@
+//go:build !linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// multihomeSupport is empty, default routes cannot be listed on this platform
+const multihomeSupport = ""
+
+// defaultRoutes is not implemented on this platform.
+func defaultRoutes() ([]*defaultRoute, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// egressFor is not implemented on this platform.
+func egressFor(_, _ net.IP) (string, string, error) {
+	return "", "", errors.ErrUnsupported
+}
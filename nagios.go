@@ -0,0 +1,116 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// exit codes defined by the nagios plugin guidelines
+const (
+	nagiosOK = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+// expectIP contains the public addresses expected by the nagios output format
+var expectIP string
+
+// nagiosFailure prints the reason no address list is available as UNKNOWN status line.
+func nagiosFailure(reason string) int {
+	fmt.Printf("IPS UNKNOWN - %s\n", reason)
+	return nagiosUnknown
+}
+
+// nagios renders a status line following the nagios plugin guidelines including perfdata. The state is CRITICAL
+// when a public address changed since the last run of the check or does not match the expected ones, WARNING when
+// the public address of a family seen by a previous run could not be determined and UNKNOWN when no public address
+// could be determined. A family never seen, e.g. on hosts without ipv6, is not a problem.
+func nagios(list ips, _ int) (string, int, error) {
+	public, local := splitPublic(list)
+	perfdata := fmt.Sprintf("addresses=%d public=%d lookup_time=%.3fs", len(local), len(public), publicLookupDuration.Seconds())
+
+	if len(public) == 0 {
+		return fmt.Sprintf("IPS UNKNOWN - public ip could not be determined | %s", perfdata), nagiosUnknown, nil
+	}
+	previousCheck, err := swapNagiosState(public)
+	if err != nil {
+		return "", nagiosUnknown, err
+	}
+
+	expected := splitList(expectIP)
+	problems := make([]string, 0)
+	current := make([]string, 0, len(public))
+	for _, i := range public {
+		current = append(current, i.Address)
+		if len(expected) > 0 && i.family() == familyOfList(expected, i.family()) && !slices.Contains(expected, i.Address) {
+			problems = append(problems, fmt.Sprintf("public %s %s is not expected", i.family(), i.Address))
+		}
+		if previous, ok := previousCheck[i.family()]; ok && previous != i.Address {
+			problems = append(problems, fmt.Sprintf("public %s changed from %s to %s", i.family(), previous, i.Address))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Sprintf("IPS CRITICAL - %s | %s", strings.Join(problems, ", "), perfdata), nagiosCritical, nil
+	}
+
+	// the state keeps the families not found, so they are reported until they are back
+	missing := make([]string, 0)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if previous, ok := previousCheck[family]; ok && !slices.ContainsFunc(public, func(i *ip) bool { return i.family() == family }) {
+			missing = append(missing, fmt.Sprintf("public %s could not be determined, was %s", family, previous))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("IPS WARNING - %s | %s", strings.Join(missing, ", "), perfdata), nagiosWarning, nil
+	}
+	return fmt.Sprintf("IPS OK - public ip %s | %s", strings.Join(current, ", "), perfdata), nagiosOK, nil
+}
+
+// familyOfList returns family if list contains an address of that family, otherwise an empty string. This keeps
+// a list of expected ipv4 addresses from flagging the public ipv6 address.
+func familyOfList(list []string, family string) string {
+	for _, address := range list {
+		if (&ip{Address: address}).family() == family {
+			return family
+		}
+	}
+	return ""
+}
+
+// swapNagiosState records the public addresses seen by this run of the check and returns the ones seen by the
+// previous run, keyed by family. The check keeps its own state file, as the public ip cache is updated by every
+// lookup and a change seen by watch or a prompt first would not be reported otherwise.
+func swapNagiosState(public ips) (map[string]string, error) {
+	path, err := statePath("nagios.json")
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]string)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return nil, fmt.Errorf("invalid nagios state %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	current := make(map[string]string, len(previous))
+	for family, address := range previous {
+		current[family] = address
+	}
+	for _, i := range public {
+		current[i.family()] = i.Address
+	}
+	if data, err = json.Marshal(current); err != nil {
+		return nil, err
+	}
+	return previous, writeState(path, data)
+}
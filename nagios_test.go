@@ -0,0 +1,105 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNagios(t *testing.T) {
+	previousStateDir, previousExpect, previousDuration := stateDir, expectIP, publicLookupDuration
+	t.Cleanup(func() { stateDir, expectIP, publicLookupDuration = previousStateDir, previousExpect, previousDuration })
+	publicLookupDuration = 1234 * time.Millisecond
+
+	local := ips{{Address: "192.0.2.10/24", Interface: "eth0"}, {Address: "2001:db8::10/64", Interface: "eth0"}}
+	v4 := &ip{Address: "203.0.113.7", Interface: "public IPV4"}
+	v4Changed := &ip{Address: "203.0.113.8", Interface: "public IPV4"}
+	v6 := &ip{Address: "2001:db8:ffff::7", Interface: "public IPV6"}
+	type run struct {
+		public    ips
+		wantState int
+		wantLine  string
+	}
+	tests := []struct {
+		name   string
+		expect string
+		runs   []run
+	}{
+		{
+			name: "ok",
+			runs: []run{
+				{public: ips{v4, v6}, wantState: nagiosOK, wantLine: "IPS OK - public ip 203.0.113.7, 2001:db8:ffff::7 | addresses=2 public=2 lookup_time=1.234s"},
+				{public: ips{v4, v6}, wantState: nagiosOK},
+			},
+		},
+		{
+			name: "host without ipv6",
+			runs: []run{{public: ips{v4}, wantState: nagiosOK}, {public: ips{v4}, wantState: nagiosOK}},
+		},
+		{
+			name: "unknown",
+			runs: []run{{wantState: nagiosUnknown, wantLine: "IPS UNKNOWN - public ip could not be determined | addresses=2 public=0 lookup_time=1.234s"}},
+		},
+		{
+			name: "unknown keeps the state",
+			runs: []run{{public: ips{v4}, wantState: nagiosOK}, {wantState: nagiosUnknown}, {public: ips{v4Changed}, wantState: nagiosCritical}},
+		},
+		{
+			name: "changed",
+			runs: []run{
+				{public: ips{v4, v6}, wantState: nagiosOK},
+				{public: ips{v4Changed, v6}, wantState: nagiosCritical, wantLine: "IPS CRITICAL - public ipv4 changed from 203.0.113.7 to 203.0.113.8 | addresses=2 public=2 lookup_time=1.234s"},
+				{public: ips{v4Changed, v6}, wantState: nagiosOK},
+			},
+		},
+		{
+			name:   "not expected",
+			expect: "203.0.113.7",
+			runs: []run{
+				{public: ips{v4, v6}, wantState: nagiosOK},
+				{public: ips{v4Changed}, wantState: nagiosCritical, wantLine: "IPS CRITICAL - public ipv4 203.0.113.8 is not expected, public ipv4 changed from 203.0.113.7 to 203.0.113.8 | addresses=2 public=1 lookup_time=1.234s"},
+			},
+		},
+		{
+			name:   "expected addresses of the other family",
+			expect: "2001:db8:ffff::7",
+			runs:   []run{{public: ips{v4, v6}, wantState: nagiosOK}},
+		},
+		{
+			name: "family missing",
+			runs: []run{
+				{public: ips{v4, v6}, wantState: nagiosOK},
+				{public: ips{v4}, wantState: nagiosWarning, wantLine: "IPS WARNING - public ipv6 could not be determined, was 2001:db8:ffff::7 | addresses=2 public=1 lookup_time=1.234s"},
+				{public: ips{v4}, wantState: nagiosWarning},
+				{public: ips{v4, v6}, wantState: nagiosOK},
+			},
+		},
+		{
+			name: "critical before warning",
+			runs: []run{{public: ips{v4, v6}, wantState: nagiosOK}, {public: ips{v4Changed}, wantState: nagiosCritical}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir, expectIP = t.TempDir(), tt.expect
+			for n, r := range tt.runs {
+				line, state, err := nagios(append(local, r.public...), 0)
+				if err != nil {
+					t.Fatalf("run %d: %v", n+1, err)
+				}
+				if state != r.wantState {
+					t.Errorf("run %d: state %d, want %d: %s", n+1, state, r.wantState, line)
+				}
+				if r.wantLine != "" && line != r.wantLine {
+					t.Errorf("run %d: got\n%s\nwant\n%s", n+1, line, r.wantLine)
+				}
+				status, perfdata, found := strings.Cut(line, " | ")
+				if !found || !strings.HasPrefix(status, "IPS ") || len(strings.Fields(perfdata)) != 3 {
+					t.Errorf("run %d: %q is not a status line with perfdata", n+1, line)
+				}
+			}
+		})
+	}
+}
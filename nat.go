@@ -0,0 +1,185 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// natReport is the result of ips nat.
+type natReport struct {
+
+	// Local is the source address used to reach the internet
+	Local string
+
+	// GatewayExternal is the external address reported by the router using NAT-PMP or UPnP
+	GatewayExternal string `json:",omitempty"`
+
+	// Public is the public address as seen by the providers
+	Public string `json:",omitempty"`
+
+	// PrivateHops lists the non-global routers in front of the first global hop of the path to the provider
+	PrivateHops []string `json:",omitempty"`
+
+	// FirstPublicHop is the first router with a global address on the path to the provider
+	FirstPublicHop string `json:",omitempty"`
+
+	// Layers is the number of address translations in front of the host
+	Layers int
+
+	// Evidence explains how the layers were determined
+	Evidence []string
+}
+
+// runNAT determines how many layers of NAT sit in front of the host by combining the local address, the
+// external address of the router (NAT-PMP or UPnP), the public address seen by the providers and the
+// private hops in front of the first public one on the path to the provider. Exits with exitCheckFailed if the
+// host is behind more than one layer.
+func runNAT(logger *slog.Logger, _ []string) int {
+	if offline {
+		logger.Error("nat needs the network")
+		return exitInternalError
+	}
+	local := routeTo(net.IPv4(192, 0, 2, 1))
+	if local.Error != "" {
+		logger.Error("could not determine local address", "err", local.Error)
+		return exitInternalError
+	}
+	report := &natReport{Local: local.Source, Evidence: make([]string, 0)}
+
+	if external, protocol, err := gatewayExternal(); err != nil {
+		logger.Warn("could not get external address of the router", "err", err)
+	} else {
+		report.GatewayExternal = external
+		report.Evidence = append(report.Evidence, fmt.Sprintf("router reports external address %s using %s", external, protocol))
+	}
+	if address, err := getPublicIp("ipv4"); err != nil {
+		logger.Warn("could not get public ip", "err", err)
+	} else {
+		report.Public = address.Address
+	}
+	if trace, err := tracePublic("ipv4"); err != nil {
+		logger.Warn("could not trace path to provider", "err", err)
+	} else {
+		for _, h := range trace.Hops {
+			if h.Class == "global" {
+				report.FirstPublicHop = h.Address
+				break
+			}
+			if h.Address != "" {
+				report.PrivateHops = append(report.PrivateHops, h.Address)
+			}
+		}
+	}
+	if report.Public == "" && report.GatewayExternal == "" && report.FirstPublicHop == "" {
+		logger.Error("neither the router, the providers nor the path revealed the public side")
+		return exitInternalError
+	}
+	natLayers(report)
+
+	code := exitOK
+	if report.Layers > 1 {
+		code = exitCheckFailed
+	}
+	if jsonOutput {
+		if printJSON(logger, report) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	fmt.Printf("local\t%s\n", report.Local)
+	if report.GatewayExternal != "" {
+		fmt.Printf("router external\t%s\n", report.GatewayExternal)
+	}
+	if report.Public != "" {
+		fmt.Printf("public\t%s\n", report.Public)
+	}
+	for _, h := range report.PrivateHops {
+		fmt.Printf("private hop\t%s\n", h)
+	}
+	if report.FirstPublicHop != "" {
+		fmt.Printf("first public hop\t%s\n", report.FirstPublicHop)
+	}
+	for _, e := range report.Evidence {
+		fmt.Printf("evidence\t%s\n", e)
+	}
+	layers := "layers"
+	if report.Layers == 1 {
+		layers = "layer"
+	}
+	fmt.Printf("you are behind %d %s of NAT\n", report.Layers, layers)
+	return code
+}
+
+// natLayers counts the layers of NAT from the evidence in the report. A host using its public address directly is
+// behind none, otherwise the router in front of it translates once. Another layer is present if the router's
+// external address is not global or differs from the public address, and for every cgnat range or private
+// network other than the local one the path crosses before reaching the internet. ISPs using private
+// addresses on their routers make the path count too many.
+func natLayers(report *natReport) {
+	if report.Local == report.Public {
+		report.Evidence = append(report.Evidence, "local address is the public address")
+		return
+	}
+	report.Layers = 1
+	if c, err := classifyAddress(report.Local); err == nil {
+		report.Evidence = append(report.Evidence, fmt.Sprintf("local address is %s and differs from the public address", c.Class))
+	}
+	if report.GatewayExternal != "" {
+		c, err := classifyAddress(report.GatewayExternal)
+		switch {
+		case err == nil && !c.Public:
+			report.Layers = 2
+			report.Evidence = append(report.Evidence, fmt.Sprintf("router's external address is %s, the upstream network translates again", c.Class))
+		case report.Public != "" && report.GatewayExternal != report.Public:
+			report.Layers = 2
+			report.Evidence = append(report.Evidence, fmt.Sprintf("router's external address differs from the public address %s", report.Public))
+		}
+	}
+
+	// every private /24 or cgnat range in front of the internet other than the local network is a realm of its
+	// own, the gateway of the local network counts as the first layer
+	localNet := net.ParseIP(report.Local).Mask(net.CIDRMask(24, 32))
+	realms := make([]string, 0)
+	for _, h := range report.PrivateHops {
+		c, err := classifyAddress(h)
+		if err != nil || c.Class != "private" && c.Class != "cgnat" {
+			continue
+		}
+		realm := "cgnat"
+		if c.Class == "private" {
+			realm = net.ParseIP(h).Mask(net.CIDRMask(24, 32)).String()
+			if realm == localNet.String() {
+				continue
+			}
+		}
+		if len(realms) == 0 || realms[len(realms)-1] != realm {
+			realms = append(realms, realm)
+			report.Evidence = append(report.Evidence, fmt.Sprintf("path crosses %s hop %s", c.Class, h))
+		}
+	}
+	if layers := 1 + len(realms); layers > report.Layers {
+		report.Layers = layers
+	}
+}
+
+// gatewayExternal returns the external address the router reports and the protocol it answered. Routers only
+// speaking PCP are asked using UPnP, if they offer it, instead of mapping a port.
+func gatewayExternal() (string, string, error) {
+	status, err := queryGateway(false)
+	if err == nil {
+		return status.ExternalAddress, status.Protocol, nil
+	}
+	g, upnpErr := discoverIGD()
+	if upnpErr != nil {
+		return "", "", errors.Join(err, upnpErr)
+	}
+	external, err := g.externalAddress()
+	if err != nil {
+		return "", "", err
+	}
+	return external, "UPnP", nil
+}
@@ -0,0 +1,308 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// natPMPSupport names the protocols the gateway provider and portmap speak
+const natPMPSupport = "NAT-PMP, PCP"
+
+const (
+	// gatewayPort is the udp port NAT-PMP and PCP servers listen on
+	gatewayPort = 5351
+
+	// natpmpVersion and pcpVersion are the protocol versions sent in the first byte of every request
+	natpmpVersion = 0
+	pcpVersion    = 2
+
+	// pcpOpMap is the PCP opcode creating, refreshing and deleting mappings
+	pcpOpMap = 1
+)
+
+// natpmpResults and pcpResults describe the result codes of NAT-PMP (RFC 6886) and PCP (RFC 6887) responses
+var (
+	natpmpResults = []string{"success", "unsupported version", "not authorized", "network failure", "out of resources", "unsupported opcode"}
+	pcpResults    = []string{"success", "unsupported version", "not authorized", "malformed request", "unsupported opcode", "unsupported option", "malformed option", "network failure", "no resources", "unsupported protocol", "user exceeded quota", "cannot provide external", "address mismatch", "excessive remote peers"}
+)
+
+// protocolNumbers maps the transport protocols to the numbers used by PCP
+var protocolNumbers = map[string]byte{"tcp": 6, "udp": 17}
+
+type (
+
+	// gatewayStatus is the state of the local router as reported by NAT-PMP, PCP or UPnP.
+	gatewayStatus struct {
+
+		// Gateway is the address of the router that was asked
+		Gateway string
+
+		// Protocol is the protocol the router answered, NAT-PMP, PCP or UPnP
+		Protocol string
+
+		// ExternalAddress is the public ipv4 address of the router
+		ExternalAddress string
+
+		// Epoch is the number of seconds since the router started or lost its mappings
+		Epoch uint32 `json:",omitempty"`
+
+		// Mappings contains the port mappings of the router, only UPnP allows listing them
+		Mappings []*portMapping `json:",omitempty"`
+	}
+
+	// portMapping is a port forward on the router.
+	portMapping struct {
+
+		// Protocol is the transport protocol, tcp or udp
+		Protocol string
+
+		// ExternalAddress is the public address the port is reachable at, if known
+		ExternalAddress string `json:",omitempty"`
+
+		// ExternalPort is the port opened on the router
+		ExternalPort uint16
+
+		// InternalClient is the local address traffic is forwarded to
+		InternalClient string
+
+		// InternalPort is the local port traffic is forwarded to
+		InternalPort uint16
+
+		// Lease is the remaining lifetime of the mapping, 0s for permanent UPnP mappings
+		Lease string
+
+		// Description is the label of a UPnP mapping
+		Description string `json:",omitempty"`
+
+		// Method is the protocol used to create the mapping, NAT-PMP, PCP or UPnP
+		Method string
+	}
+)
+
+// queryGateway asks the default gateway for its external address. NAT-PMP is tried first, routers only speaking
+// PCP reject the request with an unsupported version. They are asked using PCP only if mapPCP is set, as that
+// creates a temporary mapping on the router, otherwise errPCPOnly is returned.
+func queryGateway(mapPCP bool) (*gatewayStatus, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := natpmpRequest(gw, []byte{natpmpVersion, 0}, 12)
+	if errors.Is(err, errPCPOnly) && mapPCP {
+		return queryGatewayPCP(gw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayStatus{
+		Gateway:         gw.String(),
+		Protocol:        "NAT-PMP",
+		ExternalAddress: net.IP(resp[8:12]).String(),
+		Epoch:           binary.BigEndian.Uint32(resp[4:8]),
+	}, nil
+}
+
+// queryGatewayPCP determines the external address using PCP. The protocol lacks an operation returning the
+// address only, so a short lived mapping of the discard port is requested and deleted right away.
+func queryGatewayPCP(gw net.IP) (*gatewayStatus, error) {
+	m, epoch, err := pcpMap(gw, "tcp", 9, 0, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := pcpMap(gw, "tcp", 9, 0, 0); err != nil {
+		return nil, fmt.Errorf("could not delete temporary mapping: %w", err)
+	}
+	return &gatewayStatus{
+		Gateway:         gw.String(),
+		Protocol:        "PCP",
+		ExternalAddress: m.ExternalAddress,
+		Epoch:           epoch,
+	}, nil
+}
+
+// gatewayMap creates, or with a lease of 0 deletes, a mapping of the local port to the same external port using
+// NAT-PMP or PCP.
+func gatewayMap(gw net.IP, protocol string, port uint16, lease time.Duration) (*portMapping, error) {
+	op := byte(1)
+	if protocol == "tcp" {
+		op = 2
+	}
+	req := make([]byte, 12)
+	req[0] = natpmpVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], port)
+	if lease > 0 {
+		binary.BigEndian.PutUint16(req[6:8], port)
+	}
+	binary.BigEndian.PutUint32(req[8:12], uint32(lease/time.Second))
+
+	resp, err := natpmpRequest(gw, req, 16)
+	if errors.Is(err, errPCPOnly) {
+		m, _, err := pcpMap(gw, protocol, port, port, lease)
+		return m, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := localAddressFor(gw)
+	if err != nil {
+		return nil, err
+	}
+	return &portMapping{
+		Protocol:       protocol,
+		ExternalPort:   binary.BigEndian.Uint16(resp[10:12]),
+		InternalClient: client.String(),
+		InternalPort:   binary.BigEndian.Uint16(resp[8:10]),
+		Lease:          (time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second).String(),
+		Method:         "NAT-PMP",
+	}, nil
+}
+
+// errPCPOnly signals that the gateway rejected a NAT-PMP request because it only speaks PCP
+var errPCPOnly = errors.New("gateway only supports PCP")
+
+// natpmpRequest sends a NAT-PMP request and returns the response of at least size bytes. errPCPOnly is returned
+// if the gateway answered with an unsupported version.
+func natpmpRequest(gw net.IP, req []byte, size int) ([]byte, error) {
+	op := req[1]
+	resp, err := gatewayExchange(gw, "natpmp", req, func(b []byte) bool {
+		return len(b) >= 4 && (b[0] == pcpVersion || (b[0] == natpmpVersion && b[1] == 128+op))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] == pcpVersion {
+		return nil, errPCPOnly
+	}
+	code := int(binary.BigEndian.Uint16(resp[2:4]))
+	switch {
+	case code == 1:
+		return nil, errPCPOnly
+	case code >= len(natpmpResults):
+		return nil, fmt.Errorf("NAT-PMP request refused by %s: result %d", gw, code)
+	case code != 0:
+		return nil, fmt.Errorf("NAT-PMP request refused by %s: %s", gw, natpmpResults[code])
+	case len(resp) < size:
+		return nil, fmt.Errorf("short NAT-PMP response from %s", gw)
+	}
+	return resp, nil
+}
+
+// pcpMap sends a PCP MAP request for the internal port and returns the mapping together with the epoch of the
+// router. A lease of 0 deletes the mapping, a suggested external port of 0 lets the router choose. The nonce is
+// derived from the mapping, so a later run is able to refresh or delete it.
+func pcpMap(gw net.IP, protocol string, internalPort, externalPort uint16, lease time.Duration) (*portMapping, uint32, error) {
+	client, err := localAddressFor(gw)
+	if err != nil {
+		return nil, 0, err
+	}
+	nonce := sha256.Sum256([]byte(fmt.Sprintf("ips %s %s %d", client, protocol, internalPort)))
+
+	req := make([]byte, 60)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:8], uint32(lease/time.Second))
+	copy(req[8:24], client.To16())
+	copy(req[24:36], nonce[:12])
+	req[36] = protocolNumbers[protocol]
+	binary.BigEndian.PutUint16(req[40:42], internalPort)
+	binary.BigEndian.PutUint16(req[42:44], externalPort)
+	// the suggested external address is left as the ipv4-mapped unspecified address
+	copy(req[44:60], net.IPv4zero.To16())
+
+	resp, err := gatewayExchange(gw, "pcp", req, func(b []byte) bool {
+		return len(b) >= 60 && b[0] == pcpVersion && b[1] == 0x80|pcpOpMap && [12]byte(b[24:36]) == [12]byte(nonce[:12])
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if code := int(resp[3]); code != 0 {
+		if code < len(pcpResults) {
+			return nil, 0, fmt.Errorf("PCP request refused by %s: %s", gw, pcpResults[code])
+		}
+		return nil, 0, fmt.Errorf("PCP request refused by %s: result %d", gw, code)
+	}
+	return &portMapping{
+		Protocol:        protocol,
+		ExternalAddress: net.IP(resp[44:60]).String(),
+		ExternalPort:    binary.BigEndian.Uint16(resp[42:44]),
+		InternalClient:  client.String(),
+		InternalPort:    internalPort,
+		Lease:           (time.Duration(binary.BigEndian.Uint32(resp[4:8])) * time.Second).String(),
+		Method:          "PCP",
+	}, binary.BigEndian.Uint32(resp[8:12]), nil
+}
+
+// gatewayExchange sends req to the gateway and returns the first response accepted by valid. Requests are
+// retransmitted starting after 250ms with doubling intervals as both protocols demand, until the timeout passed.
+func gatewayExchange(gw net.IP, kind string, req []byte, valid func([]byte) bool) ([]byte, error) {
+	start := time.Now()
+	resp, err := exchange(gw, req, valid)
+	audit(kind, net.JoinHostPort(gw.String(), strconv.Itoa(gatewayPort)), start, "", err)
+	return resp, err
+}
+
+// exchange implements gatewayExchange without auditing.
+func exchange(gw net.IP, req []byte, valid func([]byte) bool) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: gatewayPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1100)
+	for wait := 250 * time.Millisecond; time.Now().Before(deadline); wait *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		readDeadline := time.Now().Add(wait)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		_ = conn.SetReadDeadline(readDeadline)
+		for {
+			n, err := conn.Read(buf)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				// an icmp port unreachable surfaces as connection refused
+				return nil, fmt.Errorf("%w: %s: %w", ErrGatewayUnsupported, gw, err)
+			}
+			if valid(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no answer from %s", ErrGatewayUnsupported, gw)
+}
+
+// localAddressFor returns the local address used to reach the gateway.
+func localAddressFor(gw net.IP) (net.IP, error) {
+	r := routeTo(gw)
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+	return net.ParseIP(r.Source), nil
+}
+
+// gatewayLookup is the public ip lookup of the gateway provider, only ipv4 is supported by NAT-PMP. Routers only
+// speaking PCP are not asked, the lookup would open a port on every poll of watch.
+func gatewayLookup(t string) (string, map[string]string, error) {
+	if t != "ipv4" {
+		return "", nil, fmt.Errorf("provider gateway does not support %s", t)
+	}
+	status, err := queryGateway(false)
+	if err != nil {
+		return "", nil, err
+	}
+	return status.ExternalAddress, nil, nil
+}
@@ -0,0 +1,235 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+type (
+
+	// netboxName is an object NetBox refers to by name.
+	netboxName struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	// netboxAddress is an ip address object of NetBox.
+	netboxAddress struct {
+		ID                 int         `json:"id"`
+		Address            string      `json:"address"`
+		DNSName            string      `json:"dns_name"`
+		Tenant             *netboxName `json:"tenant"`
+		AssignedObjectType string      `json:"assigned_object_type"`
+		AssignedObjectID   int         `json:"assigned_object_id"`
+		AssignedObject     *struct {
+			Name           string      `json:"name"`
+			Device         *netboxName `json:"device"`
+			VirtualMachine *netboxName `json:"virtual_machine"`
+		} `json:"assigned_object"`
+	}
+
+	// netboxHost is a device or virtual machine of NetBox together with the endpoints of its interfaces.
+	netboxHost struct {
+		netboxName
+
+		// interfaces is the API path of the interfaces, kind the field of an interface referring to the host
+		interfaces, kind string
+
+		// objectType is the type of its interfaces when addresses are assigned to them
+		objectType string
+	}
+
+	// exportAction is a change made to NetBox by ips export netbox.
+	exportAction struct {
+
+		// Action is created, updated or unchanged, "would create" and "would update" in dry-run mode
+		Action string
+
+		// Object is interface or address
+		Object string
+
+		Name      string
+		Interface string `json:",omitempty"`
+	}
+)
+
+// lookupNetBox returns the NetBox entry of the address, the owner is the device or virtual machine and interface
+// it is assigned to, otherwise its tenant.
+func lookupNetBox(address netip.Addr) (*ipamEntry, error) {
+	result, err := findNetBoxAddress(address)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	entry := &ipamEntry{Hostname: result.DNSName}
+	entry.Prefix, _ = netip.ParsePrefix(result.Address)
+	switch o := result.AssignedObject; {
+	case o != nil && o.Device != nil:
+		entry.Owner = o.Device.Name + "/" + o.Name
+	case o != nil && o.VirtualMachine != nil:
+		entry.Owner = o.VirtualMachine.Name + "/" + o.Name
+	case result.Tenant != nil:
+		entry.Owner = result.Tenant.Name
+	}
+	if entry.Hostname == "" && result.AssignedObject != nil && result.AssignedObject.Device != nil {
+		entry.Hostname = result.AssignedObject.Device.Name
+	}
+	return entry, nil
+}
+
+// findNetBoxAddress returns the address object of NetBox, nil if the address is not registered.
+func findNetBoxAddress(address netip.Addr) (*netboxAddress, error) {
+	var answer struct {
+		Results []*netboxAddress `json:"results"`
+	}
+	if err := netboxRead("/api/ipam/ip-addresses/?address="+url.QueryEscape(address.String()), &answer); err != nil {
+		return nil, err
+	}
+	if len(answer.Results) == 0 {
+		return nil, nil
+	}
+	return answer.Results[0], nil
+}
+
+// registerNetBox creates an active address in NetBox with the host name as DNS name.
+func registerNetBox(prefix netip.Prefix, hostname string) error {
+	body := map[string]string{
+		"address":     prefix.String(),
+		"dns_name":    hostname,
+		"status":      "active",
+		"description": "registered by ips",
+	}
+	return netboxWrite("POST", "/api/ipam/ip-addresses/", body, nil)
+}
+
+// exportNetBox creates the interfaces of the host missing at the device or virtual machine named in NetBox and
+// assigns the addresses of the interfaces to them, creating addresses not registered yet and moving those
+// registered elsewhere or with another network length. The device or virtual machine itself has to exist, as
+// creating it requires a site, role and type only the operator knows. Loopback and link-local addresses are
+// skipped. With dry-run set nothing is changed.
+func exportNetBox(logger *slog.Logger, name string) ([]*exportAction, error) {
+	host, err := findNetBoxHost(name)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make(map[string]int)
+	var answer struct {
+		Results []*netboxName `json:"results"`
+	}
+	if err := netboxRead(host.interfaces+"?limit=1000&"+host.kind+"_id="+strconv.Itoa(host.ID), &answer); err != nil {
+		return nil, err
+	}
+	for _, i := range answer.Results {
+		interfaces[i.Name] = i.ID
+	}
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]*exportAction, 0)
+	for _, i := range local {
+		address, err := netip.ParsePrefix(i.Address)
+		if err != nil {
+			continue
+		}
+		if c, err := classifyAddress(i.Address); err != nil || c.Class == "loopback" || c.Class == "link-local" {
+			continue
+		}
+
+		if _, ok := interfaces[i.Interface]; !ok {
+			actions = append(actions, &exportAction{Action: exportVerb("create"), Object: "interface", Name: i.Interface})
+			body := map[string]any{"name": i.Interface, host.kind: host.ID}
+			if host.objectType == "dcim.interface" {
+				body["type"] = "other"
+			}
+			var created netboxName
+			if err := netboxWrite("POST", host.interfaces, body, &created); err != nil {
+				return actions, fmt.Errorf("could not create interface %s: %w", i.Interface, err)
+			}
+			interfaces[i.Interface] = created.ID
+		}
+
+		action := &exportAction{Action: "unchanged", Object: "address", Name: address.String(), Interface: i.Interface}
+		actions = append(actions, action)
+		existing, err := findNetBoxAddress(address.Addr())
+		if err != nil {
+			return actions, err
+		}
+		body := map[string]any{
+			"address":              address.String(),
+			"assigned_object_type": host.objectType,
+			"assigned_object_id":   interfaces[i.Interface],
+		}
+		switch {
+		case existing == nil:
+			action.Action = exportVerb("create")
+			body["status"], body["description"] = "active", "registered by ips"
+			err = netboxWrite("POST", "/api/ipam/ip-addresses/", body, nil)
+		case existing.Address != address.String() || existing.AssignedObjectType != host.objectType ||
+			existing.AssignedObjectID != interfaces[i.Interface]:
+			action.Action = exportVerb("update")
+			err = netboxWrite("PATCH", "/api/ipam/ip-addresses/"+strconv.Itoa(existing.ID)+"/", body, nil)
+		}
+		if err != nil {
+			return actions, fmt.Errorf("could not export %s: %w", address, err)
+		}
+	}
+	return actions, nil
+}
+
+// exportVerb returns the past tense of the verb, or what would happen in dry-run mode.
+func exportVerb(verb string) string {
+	if dryRun {
+		return "would " + verb
+	}
+	return verb + "d"
+}
+
+// findNetBoxHost returns the device named in NetBox, or the virtual machine if there is no such device.
+func findNetBoxHost(name string) (*netboxHost, error) {
+	candidates := []*netboxHost{
+		{interfaces: "/api/dcim/interfaces/", kind: "device", objectType: "dcim.interface"},
+		{interfaces: "/api/virtualization/interfaces/", kind: "virtual_machine", objectType: "virtualization.vminterface"},
+	}
+	for idx, path := range []string{"/api/dcim/devices/", "/api/virtualization/virtual-machines/"} {
+		var answer struct {
+			Results []*netboxName `json:"results"`
+		}
+		if err := netboxRead(path+"?name="+url.QueryEscape(name), &answer); err != nil {
+			return nil, err
+		}
+		if len(answer.Results) > 0 {
+			candidates[idx].netboxName = *answer.Results[0]
+			return candidates[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("neither a device nor a virtual machine named %s exists in netbox", name)
+}
+
+// netboxRead sends a GET request to NetBox and decodes the answer into out.
+func netboxRead(path string, out any) error {
+	status, err := ipamRequest("GET", path, nil, out)
+	if err == nil && status == http.StatusNotFound {
+		return errors.New("netbox answered with 404, check -ipam-url")
+	}
+	return err
+}
+
+// netboxWrite sends a request changing NetBox and decodes the answer into out, with dry-run set nothing is sent.
+func netboxWrite(method, path string, body, out any) error {
+	if dryRun {
+		return nil
+	}
+	status, err := ipamRequest(method, path, body, out)
+	if err == nil && status == http.StatusNotFound {
+		return errors.New("netbox answered with 404, check -ipam-url")
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// changeNotificationSupport names what wakes watch on address changes, sleep and roaming
+const changeNotificationSupport = "routing socket"
+
+// networkChanges subscribes to the routing socket and sends a notification whenever an address is added or
+// removed or an interface changes its state, which also covers sleep/wake and Wi-Fi roaming. The routing socket
+// delivers the same events as SystemConfiguration without requiring cgo.
+func networkChanges(ctx context.Context, logger *slog.Logger) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	// a non-blocking descriptor is served by the poller, so closing it unblocks the pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	sock := os.NewFile(uintptr(fd), "route")
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		_ = sock.Close()
+	}()
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := sock.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("could not read from routing socket", "err", err)
+				}
+				return
+			}
+			// struct rt_msghdr starts with the message length (2 bytes), the version and the type
+			if n < 4 {
+				continue
+			}
+			switch buf[3] {
+			case syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_IFINFO:
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,65 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// changeNotificationSupport names what wakes watch on address changes
+const changeNotificationSupport = "netlink"
+
+// networkChanges subscribes to the RTNLGRP_IPV4_IFADDR and RTNLGRP_IPV6_IFADDR netlink groups and sends a
+// notification whenever the kernel reports an address being added or removed.
+func networkChanges(ctx context.Context, logger *slog.Logger) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	groups := uint32(1<<(syscall.RTNLGRP_IPV4_IFADDR-1) | 1<<(syscall.RTNLGRP_IPV6_IFADDR-1))
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// a non-blocking descriptor is served by the poller, so closing it unblocks the pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	sock := os.NewFile(uintptr(fd), "netlink")
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		_ = sock.Close()
+	}()
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := sock.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("could not read from netlink socket", "err", err)
+				}
+				return
+			}
+			messages, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range messages {
+				if m.Header.Type != syscall.RTM_NEWADDR && m.Header.Type != syscall.RTM_DELADDR {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux && !windows && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// changeNotificationSupport is empty, watch relies on polling on this platform
+const changeNotificationSupport = ""
+
+// networkChanges is not supported on this platform, watch mode relies on polling.
+func networkChanges(_ context.Context, _ *slog.Logger) (<-chan struct{}, error) {
+	return nil, errors.ErrUnsupported
+}
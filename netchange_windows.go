@@ -0,0 +1,46 @@
+//go:build windows && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// changeNotificationSupport names the IP helper callback waking watch
+const changeNotificationSupport = "NotifyUnicastIpAddressChange"
+
+var (
+	// addressChanges receives a notification from the NotifyUnicastIpAddressChange callback
+	addressChanges = make(chan struct{}, 1)
+
+	// addressChangeCallback is created once, as windows callbacks cannot be released
+	addressChangeCallback = sync.OnceValue(func() uintptr {
+		return windows.NewCallback(func(_ uintptr, _ *windows.MibUnicastIpAddressRow, _ uint32) uintptr {
+			select {
+			case addressChanges <- struct{}{}:
+			default:
+			}
+			return 0
+		})
+	})
+)
+
+// networkChanges registers for NotifyUnicastIpAddressChange and sends a notification whenever a unicast address
+// is added, removed or changes its state.
+func networkChanges(ctx context.Context, logger *slog.Logger) (<-chan struct{}, error) {
+	var handle windows.Handle
+	if err := windows.NotifyUnicastIpAddressChange(windows.AF_UNSPEC, addressChangeCallback(), nil, false, &handle); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		if err := windows.CancelMibChangeNotify2(handle); err != nil {
+			logger.Debug("could not cancel address change notifications", "err", err)
+		}
+	}()
+	return addressChanges, nil
+}
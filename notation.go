@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+// allNotations makes fmt print the address in every notation instead of the canonical one
+var allNotations bool
+
+// notation is an address written in a single form.
+type notation struct {
+
+	// Name identifies the form: canonical, dotted, compressed, expanded, integer, hex, reverse, mapped, mapped-url
+	// or url
+	Name string
+
+	Value string
+}
+
+// runFmt prints the address given as argument, or the first non-loopback address ips would print, in canonical
+// form. With -all-notations every notation useful when writing firewall rules and configurations is printed.
+func runFmt(logger *slog.Logger, args []string) int {
+	if len(args) > 1 {
+		logger.Error("usage: ips fmt [address] [-all-notations]")
+		return exitInternalError
+	}
+	var raw string
+	if len(args) == 1 {
+		raw = args[0]
+	} else {
+		list, err := getIpAddresses(logger)
+		if err != nil && len(list) == 0 {
+			logger.Error("could not get ip addresses", "err", err)
+			return exitInternalError
+		}
+		for _, i := range list {
+			if c, err := classifyAddress(i.Address); err == nil && c.Class != "loopback" {
+				raw = i.Address
+				break
+			}
+		}
+		if raw == "" {
+			return printFailure(exitNoMatch, "no addresses matched the filters")
+		}
+	}
+	address, err := parseNotation(raw)
+	if err != nil {
+		logger.Error("invalid address", "err", err)
+		return exitInternalError
+	}
+
+	notations := []*notation{{Name: "canonical", Value: address.String()}}
+	if allNotations {
+		notations = addressNotations(address)
+	}
+	if jsonOutput {
+		return printJSON(logger, notations)
+	}
+	if !allNotations {
+		fmt.Println(address)
+		return exitOK
+	}
+	for _, n := range notations {
+		fmt.Printf("%s\t%s\n", n.Name, n.Value)
+	}
+	return exitOK
+}
+
+// parseNotation parses an address, which may be given in CIDR notation or in URL brackets.
+func parseNotation(raw string) (netip.Addr, error) {
+	raw = strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")
+	if prefix, err := netip.ParsePrefix(raw); err == nil {
+		return prefix.Addr(), nil
+	}
+	return netip.ParseAddr(raw)
+}
+
+// addressNotations returns the address as dotted quad or expanded and compressed ipv6, as integer and hex, as
+// reverse DNS name and bracketed for URLs. ipv4 addresses are given as ipv4-mapped ipv6 address instead of
+// bracketed.
+func addressNotations(address netip.Addr) []*notation {
+	address = address.Unmap()
+	bytes := address.AsSlice()
+	integer := new(big.Int).SetBytes(bytes)
+	notations := make([]*notation, 0, 7)
+	if address.Is4() {
+		mapped := netip.AddrFrom16(address.As16())
+		return append(notations,
+			&notation{Name: "dotted", Value: address.String()},
+			&notation{Name: "integer", Value: integer.String()},
+			&notation{Name: "hex", Value: fmt.Sprintf("0x%08X", integer)},
+			&notation{Name: "reverse", Value: fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", bytes[3], bytes[2], bytes[1], bytes[0])},
+			&notation{Name: "mapped", Value: mapped.String()},
+			&notation{Name: "mapped-url", Value: "[" + mapped.String() + "]"},
+		)
+	}
+
+	nibbles := make([]string, 0, 32)
+	for idx := len(bytes) - 1; idx >= 0; idx-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", bytes[idx]&0x0f), fmt.Sprintf("%x", bytes[idx]>>4))
+	}
+	return append(notations,
+		&notation{Name: "compressed", Value: address.String()},
+		&notation{Name: "expanded", Value: address.StringExpanded()},
+		&notation{Name: "integer", Value: integer.String()},
+		&notation{Name: "hex", Value: fmt.Sprintf("0x%032X", integer)},
+		&notation{Name: "reverse", Value: strings.Join(nibbles, ".") + ".ip6.arpa"},
+		&notation{Name: "url", Value: "[" + address.String() + "]"},
+	)
+}
@@ -0,0 +1,18 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import "os/exec"
+
+// desktopNotificationSupport names where osascript shows notifications
+const desktopNotificationSupport = "notification center"
+
+// notifyDesktop shows a notification using the notification center. Title and body are passed as arguments
+// instead of being embedded into the script, so they need no quoting.
+func notifyDesktop(title, body string) error {
+	return exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body).Run()
+}
@@ -0,0 +1,20 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import "github.com/godbus/dbus/v5"
+
+// desktopNotificationSupport names the service notifications are sent to
+const desktopNotificationSupport = "freedesktop notifications"
+
+// notifyDesktop shows a notification using the freedesktop notification service of the session bus.
+func notifyDesktop(title, body string) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	notifications := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	return notifications.Call("org.freedesktop.Notifications.Notify", 0,
+		"ips", uint32(0), "network-wired", title, body, []string{}, map[string]dbus.Variant{}, int32(-1)).Err
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows && (!ips_minimal || ips_full)
+
+package main
+
+import "errors"
+
+// desktopNotificationSupport is empty, there are no desktop notifications on this platform
+const desktopNotificationSupport = ""
+
+// notifyDesktop is not implemented on this platform.
+func notifyDesktop(_, _ string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,30 @@
+//go:build windows && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// desktopNotificationSupport names how PowerShell shows notifications
+const desktopNotificationSupport = "toast notifications"
+
+// toastScript shows a toast notification on behalf of PowerShell, which is registered as application on every
+// installation. Title and body are read from the environment, so they need no quoting.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:NOTIFY_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:NOTIFY_BODY)) > $null
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($template))
+`
+
+// notifyDesktop shows a toast notification.
+func notifyDesktop(title, body string) error {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_BODY="+body)
+	return cmd.Run()
+}
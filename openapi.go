@@ -0,0 +1,307 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// apiDocs serves Swagger UI at /docs in server mode
+var apiDocs bool
+
+type (
+
+	// apiEndpoint is an endpoint of the server mode, the OpenAPI document is generated from them.
+	apiEndpoint struct {
+
+		// method defaults to GET
+		method string
+
+		path, operation, summary, description string
+
+		parameters []apiParameter
+
+		// body is a value of the type accepted as JSON request body, nil for none
+		body any
+
+		// authenticated is set for endpoints requiring a token of a tenant as bearer token
+		authenticated bool
+
+		// contentType and result describe the successful answer, result is a value of the type answered as JSON
+		contentType string
+		result      any
+
+		// status is the status of the successful answer, 200 if not set, errors maps the other status codes
+		// answered to their description
+		status string
+		errors map[string]string
+
+		handler http.HandlerFunc
+	}
+
+	// apiParameter is a parameter of an endpoint, given in the query unless path is set.
+	apiParameter struct {
+		name, description, schemaType string
+		required, repeated, path      bool
+	}
+)
+
+// serveEndpoints returns the endpoints of the server mode, those receiving pushes only if tokens are set.
+func serveEndpoints() []*apiEndpoint {
+	endpoints := []*apiEndpoint{
+		{
+			path:        "/",
+			operation:   "echo",
+			summary:     "Echo the client address",
+			description: "Answers the address the request came from, so the server can be used as public ip provider.",
+			contentType: "text/plain",
+			handler:     handleEcho,
+		},
+		{
+			path:        "/reachable",
+			operation:   "reachable",
+			summary:     "Connect back to the client",
+			description: "Connects to the address the request came from on every port given and reports the outcome.",
+			parameters: []apiParameter{
+				{name: "port", description: fmt.Sprintf("tcp port to connect to, 1 to %d times", maxProbePorts), schemaType: "integer", required: true, repeated: true},
+			},
+			contentType: "application/json",
+			result:      []*probeResult{},
+			errors:      map[string]string{"400": "no, too many or invalid ports"},
+			handler:     handleReachable,
+		},
+		{
+			path:        "/healthz",
+			operation:   "healthz",
+			summary:     "Liveness",
+			description: "Answers ok while the server runs.",
+			contentType: "text/plain",
+			errors:      map[string]string{"503": "the process stalled"},
+			handler:     health.handleHealthz,
+		},
+		{
+			path:        "/readyz",
+			operation:   "readyz",
+			summary:     "Readiness",
+			description: "Answers ok while the server runs.",
+			contentType: "text/plain",
+			errors:      map[string]string{"503": "the process is not ready"},
+			handler:     health.handleReadyz,
+		},
+	}
+	if !pushEnabled() {
+		return endpoints
+	}
+	agent := apiParameter{name: "agent", description: "name of the agent", schemaType: "string", required: true, path: true}
+	return append(endpoints,
+		&apiEndpoint{
+			method:        "POST",
+			path:          "/push/{agent}",
+			operation:     "push",
+			summary:       "Push a change of an agent",
+			description:   "Accepts a change as printed by ips watch -json and posted by webhook sinks. The agent belongs to the tenant of the token. A snapshot replaces the addresses of the agent, other changes are applied to them.",
+			parameters:    []apiParameter{agent},
+			body:          &change{},
+			authenticated: true,
+			status:        "204",
+			errors:        map[string]string{"400": "invalid change or agent name", "401": "missing or wrong token", "403": "the token may not push", "413": "change too large"},
+			handler:       handlePush,
+		},
+		&apiEndpoint{
+			path:          "/agents",
+			operation:     "agents",
+			summary:       "List the agents",
+			description:   "Answers every agent of the tenant of the token with its addresses.",
+			authenticated: true,
+			contentType:   "application/json",
+			result:        []*agentState{},
+			errors:        map[string]string{"401": "missing or wrong token", "403": "the token may not read"},
+			handler:       handleAgents,
+		},
+		&apiEndpoint{
+			path:          "/agents/{agent}",
+			operation:     "agent",
+			summary:       "Get an agent",
+			description:   "Answers the addresses of an agent.",
+			parameters:    []apiParameter{agent},
+			authenticated: true,
+			contentType:   "application/json",
+			result:        &agentState{},
+			errors:        map[string]string{"401": "missing or wrong token", "403": "the token may not read", "404": "the agent of the tenant never pushed"},
+			handler:       handleAgent,
+		},
+		&apiEndpoint{
+			path:          "/export",
+			operation:     "export",
+			summary:       "Export the inventory",
+			description:   "Answers every address of the agents of the tenant of the token with host, interface and the times it was seen first and last, as CSV or, with format xlsx, as Excel workbook.",
+			parameters:    []apiParameter{{name: "format", description: "csv, the default, or xlsx", schemaType: "string"}},
+			authenticated: true,
+			contentType:   "text/csv",
+			errors:        map[string]string{"400": "unknown format", "401": "missing or wrong token", "403": "the token may not read"},
+			handler:       handleExport,
+		},
+	)
+}
+
+// openAPIDocument generates an OpenAPI 3 document describing the endpoints, the schemas of JSON answers are
+// derived from the types answered.
+func openAPIDocument(endpoints []*apiEndpoint) map[string]any {
+	paths := make(map[string]any)
+	authenticated := false
+	for _, e := range endpoints {
+		parameters := make([]any, 0, len(e.parameters))
+		for _, p := range e.parameters {
+			schema := map[string]any{"type": p.schemaType}
+			if p.repeated {
+				schema = map[string]any{"type": "array", "items": schema}
+			}
+			parameter := map[string]any{"name": p.name, "in": "query", "description": p.description, "required": p.required, "schema": schema}
+			if p.path {
+				parameter["in"] = "path"
+			} else {
+				parameter["style"], parameter["explode"] = "form", true
+			}
+			parameters = append(parameters, parameter)
+		}
+		success := map[string]any{"description": "success"}
+		if e.contentType != "" {
+			content := map[string]any{"schema": map[string]any{"type": "string"}}
+			if e.result != nil {
+				content = map[string]any{"schema": jsonSchema(reflect.TypeOf(e.result))}
+			}
+			success["content"] = map[string]any{e.contentType: content}
+		}
+		status := e.status
+		if status == "" {
+			status = "200"
+		}
+		responses := map[string]any{status: success}
+		for status, description := range e.errors {
+			responses[status] = map[string]any{"description": description}
+		}
+		if rateLimit > 0 {
+			responses["429"] = map[string]any{"description": "rate limit exceeded, retry after the seconds given in Retry-After"}
+		}
+		operation := map[string]any{
+			"operationId": e.operation,
+			"summary":     e.summary,
+			"description": e.description,
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if e.body != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(e.body))}},
+			}
+		}
+		if e.authenticated {
+			operation["security"] = []any{map[string]any{"bearer": []string{}}}
+			authenticated = true
+		}
+		method := strings.ToLower(e.method)
+		if method == "" {
+			method = "get"
+		}
+		path, ok := paths[e.path].(map[string]any)
+		if !ok {
+			path = make(map[string]any)
+			paths[e.path] = path
+		}
+		path[method] = operation
+	}
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "ips",
+			"description": "Server mode of ips, used by other instances as public ip provider and to test reachability.",
+			"version":     version,
+		},
+		"paths": paths,
+	}
+	if authenticated {
+		document["components"] = map[string]any{
+			"securitySchemes": map[string]any{"bearer": map[string]any{"type": "http", "scheme": "bearer"}},
+		}
+	}
+	return document
+}
+
+// jsonSchema returns the JSON schema of values of type t as encoding/json marshals them.
+func jsonSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		if t.PkgPath() == "time" && t.Name() == "Time" {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		properties := make(map[string]any)
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type)
+			if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
+
+// handleOpenAPI answers the OpenAPI document of the server mode.
+func handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(openAPIDocument(serveEndpoints()))
+}
+
+// docsPage loads Swagger UI from a CDN and points it to the OpenAPI document
+const docsPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>ips API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head><body><div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body></html>
+`
+
+// handleDocs answers Swagger UI rendering the OpenAPI document.
+func handleDocs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprint(w, docsPage)
+}
@@ -0,0 +1,154 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ubusAnonymousSession is the session id of calls made without logging in, its access depends on the ACLs
+// configured on the router
+const ubusAnonymousSession = "00000000000000000000000000000000"
+
+// ubusStatusCodes describe the status codes returned by ubus calls
+var ubusStatusCodes = []string{"ok", "invalid command", "invalid argument", "method not found", "not found", "no data", "permission denied", "timeout", "not supported", "unknown error", "connection failed"}
+
+// ubusInterface is the part of the status of a network interface of OpenWrt used by the openwrt provider.
+type ubusInterface struct {
+	Up     bool         `json:"up"`
+	Uptime int          `json:"uptime"`
+	IPv4   []ubusPrefix `json:"ipv4-address"`
+	IPv6   []ubusPrefix `json:"ipv6-address"`
+	Prefix []ubusPrefix `json:"ipv6-prefix"`
+}
+
+// ubusPrefix is an address of an interface with its prefix length.
+type ubusPrefix struct {
+	Address string `json:"address"`
+	Mask    int    `json:"mask"`
+}
+
+// openwrtLookup asks an OpenWrt router for the address of its wan interface, or wan6 for IPv6, using the ubus
+// JSON-RPC interface of LuCI. Logging in requires the rpcd-mod-luci ACLs allowing network.interface status calls.
+// The labels carry the state of the interface, its uptime and for IPv6 the delegated prefix.
+func openwrtLookup(t string) (string, map[string]string, error) {
+	base, err := routerBase("")
+	if err != nil {
+		return "", nil, err
+	}
+	endpoint := base + "/ubus"
+
+	session := ubusAnonymousSession
+	if routerPassword != "" {
+		user := routerUser
+		if user == "" {
+			user = "root"
+		}
+		var login struct {
+			Session string `json:"ubus_rpc_session"`
+		}
+		if err := ubusCall(endpoint, session, "session", "login", map[string]string{"username": user, "password": routerPassword}, &login); err != nil {
+			return "", nil, fmt.Errorf("could not log in: %w", err)
+		}
+		session = login.Session
+	}
+
+	names := []string{"wan"}
+	if t == "ipv6" {
+		names = []string{"wan6", "wan"}
+	}
+	var status ubusInterface
+	for _, name := range names {
+		if err = ubusCall(endpoint, session, "network.interface."+name, "status", map[string]string{}, &status); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !status.Up {
+		return "", nil, errors.New("wan interface is down")
+	}
+
+	addresses, prefix := status.IPv4, ""
+	if t == "ipv6" {
+		addresses = status.IPv6
+		if len(status.Prefix) > 0 {
+			prefix = status.Prefix[0].Address + "/" + strconv.Itoa(status.Prefix[0].Mask)
+		}
+	}
+	answer := ""
+	if len(addresses) > 0 {
+		answer = addresses[0].Address
+	}
+	address, err := parseAddress("openwrt", answer, t)
+	if err != nil {
+		return "", nil, err
+	}
+	return address, routerLabels("openwrt", "up", time.Duration(status.Uptime)*time.Second, prefix), nil
+}
+
+// ubusCall invokes method of a ubus object and decodes the data returned into result.
+func ubusCall(endpoint, session, object, method string, args any, result any) error {
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []any{session, object, method, args},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := newHTTPClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ubus answered with %s", resp.Status)
+	}
+
+	var answer struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&answer); err != nil {
+		return fmt.Errorf("%w: could not parse ubus answer: %s", ErrInvalidResponse, err)
+	}
+	if answer.Error != nil {
+		return fmt.Errorf("%s %s failed: %s", object, method, answer.Error.Message)
+	}
+	if len(answer.Result) == 0 {
+		return fmt.Errorf("%w: ubus answer without result", ErrInvalidResponse)
+	}
+	var code int
+	if err := json.Unmarshal(answer.Result[0], &code); err != nil {
+		return fmt.Errorf("%w: could not parse ubus status: %s", ErrInvalidResponse, err)
+	}
+	if code != 0 {
+		description := "unknown status"
+		if code < len(ubusStatusCodes) {
+			description = ubusStatusCodes[code]
+		}
+		return fmt.Errorf("%s %s failed: %s", object, method, description)
+	}
+	if len(answer.Result) < 2 {
+		return fmt.Errorf("%w: %s %s returned no data", ErrInvalidResponse, object, method)
+	}
+	return json.Unmarshal(answer.Result[1], result)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// outputFormat selects how the address list is rendered, see formatters
+var outputFormat string
+
+// formatter renders the address list. It receives the exit code determined so far and returns the exit code
+// to terminate with, which allows formats following foreign exit code conventions.
+type formatter func(list ips, code int) (string, int, error)
+
+// formatters render the address list for the values of the output flag besides text and json, subsystems add
+// theirs when registering
+var formatters = make(map[string]formatter)
+
+// failures render the reason no address list is available for formats that have to produce output in any case
+var failures = make(map[string]func(reason string) int)
+
+// outputFormats returns the values of the output flag known to this build, sorted.
+func outputFormats() []string {
+	names := []string{"text", "json"}
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names[2:])
+	return names
+}
+
+// prefixChange reports a rotation of the delegated ipv6 prefix.
+type prefixChange struct {
+
+	// Previous contains the prefixes derived before the change
+	Previous []string
+
+	// Current contains the prefixes derived after the change, empty if ipv6 connectivity was lost
+	Current []string
+}
+
+// change is the difference between two consecutive observations of the address set.
+type change struct {
+
+	// Time is the moment the change was detected
+	Time time.Time
+
+	// Added contains addresses that appeared since the last observation
+	Added ips `json:",omitempty"`
+
+	// Removed contains addresses that disappeared since the last observation
+	Removed ips `json:",omitempty"`
+
+	// Prefix is set when the delegated ipv6 prefix changed
+	Prefix *prefixChange `json:",omitempty"`
+
+	// Snapshot is set for the addresses found when watch started, they replace all addresses known before
+	Snapshot bool `json:",omitempty"`
+
+	// previous is the address set the change applies to
+	previous ips
+}
+
+// printAddresses renders the address list in the selected output format and returns the exit code, or
+// exitInternalError if rendering failed.
+func printAddresses(logger *slog.Logger, list ips, code int) int {
+	switch {
+	case outputFormat == "json" || (jsonOutput && outputFormat == "text"):
+		if printJSON(logger, list) != exitOK {
+			return exitInternalError
+		}
+	case outputFormat == "text":
+		for _, i := range list {
+			fmt.Println(i)
+		}
+	default:
+		formatter, ok := formatters[outputFormat]
+		if !ok {
+			logger.Error("unknown output format", "output", outputFormat)
+			return exitInternalError
+		}
+		out, formatted, err := formatter(list, code)
+		if err != nil {
+			logger.Error("could not render output", "err", err, "output", outputFormat)
+			return exitInternalError
+		}
+		fmt.Println(out)
+		return formatted
+	}
+	return code
+}
+
+// printFailure is called instead of printAddresses when no address list is available. Formats that have to
+// produce output in any case print the reason, the exit code is returned accordingly.
+func printFailure(code int, reason string) int {
+	if failure, ok := failures[outputFormat]; ok {
+		return failure(reason)
+	}
+	return code
+}
+
+// printJSON prints v as JSON to stdout and returns the exit code to terminate with.
+func printJSON(logger *slog.Logger, v any) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("could not marshal to json", "err", err)
+		return exitInternalError
+	}
+	fmt.Println(string(data))
+	return exitOK
+}
+
+// check is the outcome of a single verification step.
+type check struct {
+
+	// Name identifies the check
+	Name string
+
+	// OK is set when the check passed
+	OK bool
+
+	// Detail explains the outcome
+	Detail string
+}
+
+// printChecks prints the outcome of all checks and returns exitCheckFailed if any of them failed.
+func printChecks(logger *slog.Logger, checks []*check) int {
+	code := exitOK
+	for _, c := range checks {
+		if !c.OK {
+			code = exitCheckFailed
+		}
+	}
+	if jsonOutput {
+		if printJSON(logger, checks) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	for _, c := range checks {
+		state := "OK"
+		if !c.OK {
+			state = "FAIL"
+		}
+		fmt.Printf("%s\t%s\t%s\n", state, c.Name, c.Detail)
+	}
+	return code
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotationSuffix is the layout of the time appended to the name of rotated logs
+const rotationSuffix = "20060102-150405"
+
+var (
+	// outputFile receives the addresses of every run and every change of watch, disabled if empty
+	outputFile string
+
+	// rotateSize is the size in bytes an NDJSON log may grow to before it is rotated, 0 disables rotating by size
+	rotateSize uint
+
+	// rotateEvery starts a new NDJSON log whenever a period of this length began since the last write, e.g. 24h
+	// rotates daily, 0 disables rotating by time
+	rotateEvery time.Duration
+
+	// rotateKeep is the number of rotated logs kept, older ones are removed
+	rotateKeep uint
+)
+
+// isLogFile reports whether the path names an NDJSON log, which is appended to instead of being replaced.
+func isLogFile(path string) bool {
+	return strings.HasSuffix(path, ".ndjson") || strings.HasSuffix(path, ".jsonl")
+}
+
+// writeOutput writes the addresses to the output file, replacing it atomically so readers never see a partial
+// file. If the output file is an NDJSON log the change is appended instead, or the addresses if there is none.
+func writeOutput(current ips, c *change) error {
+	if outputFile == "" || dryRun {
+		return nil
+	}
+	var v any = current
+	if c != nil && isLogFile(outputFile) {
+		v = c
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if isLogFile(outputFile) {
+		return appendLog(outputFile, data)
+	}
+	return writeAtomic(outputFile, append(data, '\n'), 0o644)
+}
+
+// writeAtomic replaces the file by a temporary file in the same directory, so the rename is atomic. The file is
+// readable by other users, as it is meant to be consumed by other processes.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendLog appends a line to the NDJSON log, rotating it first if it grew too large or a new period began.
+func appendLog(path string, line []byte) error {
+	if err := rotateLog(path, len(line)+1); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// rotateLog renames the log to its name followed by the time of its last write if appending n bytes would exceed
+// rotateSize or the last write happened in a previous period of rotateEvery. Only rotateKeep rotated logs are kept.
+func rotateLog(path string, n int) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bySize := rotateSize > 0 && info.Size() > 0 && uint(info.Size())+uint(n) > rotateSize
+	byTime := rotateEvery > 0 && !info.ModTime().Truncate(rotateEvery).Equal(time.Now().Truncate(rotateEvery))
+	if !bySize && !byTime {
+		return nil
+	}
+	// logs rotated by size within the same second are numbered
+	rotated := path + "." + info.ModTime().Format(rotationSuffix)
+	for idx := 1; ; idx++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = path + "." + info.ModTime().Format(rotationSuffix) + "-" + strconv.Itoa(idx)
+	}
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+
+	previous, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return err
+	}
+	// the time suffix sorts chronologically
+	slices.Sort(previous)
+	for len(previous) > int(rotateKeep) {
+		if err := os.Remove(previous[0]); err != nil {
+			return err
+		}
+		previous = previous[1:]
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// payloadTemplates are the payload templates shipped with ips, a sink uses one by giving its name as template
+var payloadTemplates = map[string]string{
+	// slack renders a message of Block Kit blocks for incoming webhooks
+	"slack": `{
+  "text": {{ toJson (printf "IP addresses of %s changed" .Hostname) }},
+  "blocks": [
+    {"type": "header", "text": {"type": "plain_text", "text": {{ toJson (printf "IP addresses of %s changed" .Hostname) }}}},
+    {"type": "section", "text": {"type": "mrkdwn", "text": {{ toJson (printf "` + "```\\n" + `%s` + "```" + `" .Summary) }}}},
+    {"type": "context", "elements": [{"type": "mrkdwn", "text": {{ toJson (date "2006-01-02 15:04:05 MST" .Time) }}}]}
+  ]
+}
+`,
+	// discord renders an embed for Discord webhooks, green if addresses were only added, orange otherwise
+	"discord": `{
+  "embeds": [{
+    "title": {{ toJson (printf "IP addresses of %s changed" .Hostname) }},
+    "description": {{ toJson (printf "` + "```diff\\n" + `%s` + "```" + `" .Summary) }},
+    "color": {{ if .Removed }}15105570{{ else }}3066993{{ end }},
+    "timestamp": {{ toJson .Time }}
+  }]
+}
+`,
+	// text renders a plain text message, e.g. for the exec and email sinks
+	"text": `IP addresses of {{ .Hostname }} changed at {{ date "2006-01-02 15:04:05 MST" .Time }}
+
+{{ .Summary }}`,
+}
+
+// Summary returns the change as text like diff prints it, removed addresses prefixed by -, added ones by +.
+func (e *eventData) Summary() string {
+	return changeSummary(e.change)
+}
+
+// changeSummary returns the change as text like diff prints it.
+func changeSummary(c *change) string {
+	var text bytes.Buffer
+	for _, i := range c.Removed {
+		fmt.Fprintf(&text, "-\t%s\n", i)
+	}
+	for _, i := range c.Added {
+		fmt.Fprintf(&text, "+\t%s\n", i)
+	}
+	if c.Prefix != nil {
+		fmt.Fprintf(&text, "prefix\t%s -> %s\n", strings.Join(c.Prefix.Previous, ","), strings.Join(c.Prefix.Current, ","))
+	}
+	return text.String()
+}
+
+// parsePayloadTemplate parses the payload template of a sink, the name of a shipped template or a file.
+func parsePayloadTemplate(name string) (*template.Template, error) {
+	source, ok := payloadTemplates[name]
+	if !ok {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		source = string(data)
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(source)
+}
+
+// runPayloadTemplate lists the payload templates shipped with ips, or prints the one named to start a template of
+// one's own from.
+func runPayloadTemplate(logger *slog.Logger, args []string) int {
+	switch len(args) {
+	case 0:
+		names := make([]string, 0, len(payloadTemplates))
+		for name := range payloadTemplates {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return exitOK
+	case 1:
+		source, ok := payloadTemplates[args[0]]
+		if !ok {
+			logger.Error("unknown payload template", "name", args[0])
+			return exitInternalError
+		}
+		fmt.Print(source)
+		return exitOK
+	}
+	logger.Error("usage: ips payload-template [name]")
+	return exitInternalError
+}
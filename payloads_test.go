@@ -0,0 +1,220 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// renderPayload renders a shipped payload template for a change on the host.
+func renderPayload(t *testing.T, name string, c *change, hostname string) string {
+	t.Helper()
+	tmpl, err := parsePayloadTemplate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, &eventData{change: c, Hostname: hostname}); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestPayloadTemplates(t *testing.T) {
+	at := time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)
+	added := &change{Time: at, Added: ips{{Address: "203.0.113.7", Interface: "public IPV4"}}}
+	replaced := &change{
+		Time:    at,
+		Added:   ips{{Address: "203.0.113.7", Interface: "public IPV4"}},
+		Removed: ips{{Address: "198.51.100.1", Interface: "public IPV4"}},
+		Prefix:  &prefixChange{Previous: []string{"2001:db8:1::/56"}, Current: []string{"2001:db8:2::/56"}},
+	}
+	summary := "-\t198.51.100.1\tpublic IPV4\n+\t203.0.113.7\tpublic IPV4\nprefix\t2001:db8:1::/56 -> 2001:db8:2::/56\n"
+	if got := changeSummary(replaced); got != summary {
+		t.Fatalf("summary is %q, want %q", got, summary)
+	}
+	// the host name is quoted to check it is escaped in the JSON payloads
+	hostname := `nas "1"`
+
+	t.Run("slack", func(t *testing.T) {
+		var payload struct {
+			Text   string
+			Blocks []struct {
+				Type string
+				Text struct {
+					Type string
+					Text string
+				}
+				Elements []struct{ Text string }
+			}
+		}
+		if err := json.Unmarshal([]byte(renderPayload(t, "slack", replaced, hostname)), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Text != `IP addresses of nas "1" changed` || len(payload.Blocks) != 3 {
+			t.Fatalf("got %+v", payload)
+		}
+		if payload.Blocks[0].Type != "header" || payload.Blocks[0].Text.Text != payload.Text {
+			t.Errorf("header is %+v", payload.Blocks[0])
+		}
+		if want := "```\n" + summary + "```"; payload.Blocks[1].Text.Type != "mrkdwn" || payload.Blocks[1].Text.Text != want {
+			t.Errorf("section is %+v, want text %q", payload.Blocks[1], want)
+		}
+		if len(payload.Blocks[2].Elements) != 1 || payload.Blocks[2].Elements[0].Text != "2026-03-01 08:30:00 UTC" {
+			t.Errorf("context is %+v", payload.Blocks[2])
+		}
+	})
+
+	t.Run("discord", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			change    *change
+			wantColor int
+		}{
+			{name: "added", change: added, wantColor: 3066993},
+			{name: "removed", change: replaced, wantColor: 15105570},
+		}
+		for _, tt := range tests {
+			var payload struct {
+				Embeds []struct {
+					Title       string
+					Description string
+					Color       int
+					Timestamp   time.Time
+				}
+			}
+			if err := json.Unmarshal([]byte(renderPayload(t, "discord", tt.change, hostname)), &payload); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if len(payload.Embeds) != 1 {
+				t.Fatalf("%s: got %+v", tt.name, payload)
+			}
+			embed := payload.Embeds[0]
+			if embed.Title != `IP addresses of nas "1" changed` || !embed.Timestamp.Equal(at) || embed.Color != tt.wantColor {
+				t.Errorf("%s: got %+v, want color %d", tt.name, embed, tt.wantColor)
+			}
+			if want := "```diff\n" + changeSummary(tt.change) + "```"; embed.Description != want {
+				t.Errorf("%s: description is %q, want %q", tt.name, embed.Description, want)
+			}
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		want := "IP addresses of nas \"1\" changed at 2026-03-01 08:30:00 UTC\n\n" + summary
+		if got := renderPayload(t, "text", replaced, hostname); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestParsePayloadTemplate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.tmpl")
+	invalid := filepath.Join(dir, "invalid.tmpl")
+	if err := os.WriteFile(valid, []byte(`{{ .Hostname | upper }}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte(`{{ .Hostname `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "text", want: "IP addresses of host changed"},
+		{name: valid, want: "HOST"},
+		{name: invalid, wantErr: true},
+		{name: filepath.Join(dir, "missing.tmpl"), wantErr: true},
+		{name: "teams", wantErr: true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parsePayloadTemplate(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, &eventData{change: &change{}, Hostname: "host"}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !strings.HasPrefix(out.String(), tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]any{
+		"Addresses": ips{
+			{Address: "192.0.2.10/24", Interface: "eth0"},
+			{Address: "2001:db8::10/64", Interface: "eth0"},
+			{Address: "203.0.113.7", Interface: "public IPV4"},
+		},
+		"Empty": "",
+		"Name":  "  Wg0  ",
+		"Time":  time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{source: `{{ .Addresses | addresses | join "," }}`, want: "192.0.2.10/24,2001:db8::10/64,203.0.113.7"},
+		{source: `{{ .Addresses | family "ipv6" | addresses | join "," }}`, want: "2001:db8::10/64"},
+		{source: `{{ .Addresses | public | addresses | join "," }}`, want: "203.0.113.7"},
+		{source: `{{ range .Addresses | local }}{{ .Address | addr }} {{ end }}`, want: "192.0.2.10 2001:db8::10 "},
+		{source: `{{ .Addresses | iface "eth1" | addresses | join "," }}`, want: ""},
+		{source: `{{ network "192.0.2.10/24" }}`, want: "192.0.2.0/24"},
+		{source: `{{ network "192.0.2.10" }}`, wantErr: true},
+		{source: `{{ .Empty | default "none" }}`, want: "none"},
+		{source: `{{ .Name | default "none" }}`, want: "  Wg0  "},
+		{source: `{{ .Missing | default "none" }}`, want: "none"},
+		{source: `{{ .Name | trim | lower }}`, want: "wg0"},
+		{source: `{{ .Name | trim | upper }}`, want: "WG0"},
+		{source: `{{ .Name | contains "Wg" }} {{ .Name | trim | hasPrefix "Wg" }} {{ .Name | hasSuffix "0" }}`, want: "true true false"},
+		{source: `{{ "a-b-c" | replace "-" "." }}`, want: "a.b.c"},
+		{source: `{{ "a,b" | split "," | join " " }}`, want: "a b"},
+		{source: `{{ "say \"hi\"" | quote }}`, want: `"say \"hi\""`},
+		{source: `{{ "a\nb" | indent 2 }}`, want: "  a\n  b"},
+		{source: `{{ toJson "tab\tand \"quotes\"" }}`, want: `"tab\tand \"quotes\""`},
+		{source: `{{ .Addresses | addresses | toJson }}`, want: `["192.0.2.10/24","2001:db8::10/64","203.0.113.7"]`},
+		{source: `{{ .Time | date "2006-01-02T15:04" }}`, want: "2026-03-01T08:30"},
+		{source: `{{ if now.IsZero }}zero{{ else }}set{{ end }}`, want: "set"},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("test").Funcs(templateFuncs).Parse(tt.source)
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+		var out bytes.Buffer
+		err = tmpl.Execute(&out, data)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: rendered %q, want an error", tt.source, out.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.source, out.String(), tt.want)
+		}
+	}
+}
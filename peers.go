@@ -0,0 +1,268 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// peerQueryInterval is the time between two searches for other daemons, short enough to refresh the peers before
+// their records expire
+const peerQueryInterval = mdnsTTL / 2 * time.Second
+
+// meshPeers lists hosts running ips watch that are not on the link, e.g. reachable over a VPN mesh, comma separated
+var meshPeers string
+
+type (
+
+	// peer is another ips daemon advertising its addresses.
+	peer struct {
+
+		// Name is the name of its service instance, usually the host name
+		Name string
+
+		// Host is the host name the daemon advertises
+		Host string `json:",omitempty"`
+
+		// Source is the address the last answer came from
+		Source string
+
+		// Port is the port of its health endpoints, 0 if they are not served
+		Port uint16 `json:",omitempty"`
+
+		// Addresses are the addresses the daemon knows
+		Addresses ips
+
+		// LastSeen is the time the last answer arrived
+		LastSeen time.Time
+
+		// expires is the time the records of the last answer expire
+		expires time.Time
+	}
+
+	// dnsAnswer is a resource record read from a DNS response.
+	dnsAnswer struct {
+		name   string
+		rrtype uint16
+		ttl    uint32
+		data   []byte
+
+		// target is the host name of SRV records
+		target string
+	}
+)
+
+// runPeers prints the addresses of the other daemons the running watch exchanged addresses with, one line per
+// address with the name of the peer, the address and the interface. Exits with exitNoMatch if no peer was found.
+func runPeers(logger *slog.Logger, _ []string) int {
+	result, err := controlRequest("peers")
+	if err != nil {
+		logger.Error("could not send control request", "err", err)
+		return exitInternalError
+	}
+	var peers []*peer
+	if err := json.Unmarshal(result, &peers); err != nil {
+		logger.Error("could not parse peers", "err", err)
+		return exitInternalError
+	}
+	if len(peers) == 0 {
+		return printFailure(exitNoMatch, "no peers found")
+	}
+
+	if jsonOutput {
+		return printJSON(logger, peers)
+	}
+	for _, p := range peers {
+		for _, i := range p.Addresses {
+			fmt.Printf("%s\t%s\t%s\n", p.Name, i.Address, i.Interface)
+		}
+	}
+	return exitOK
+}
+
+// queryPeers searches for other daemons right away and every peerQueryInterval until ctx is cancelled. The search
+// is sent to the mDNS groups and, asking for a unicast response, to every mesh peer.
+func (r *mdnsResponder) queryPeers(ctx context.Context, logger *slog.Logger) {
+	multicast := dnsQuery(dnsQuestion{name: mdnsService, rrtype: dnsTypePTR})
+	unicast := dnsQuery(dnsQuestion{name: mdnsService, rrtype: dnsTypePTR, unicast: true})
+	for {
+		for group, conn := range r.conns {
+			if _, err := conn.WriteToUDP(multicast, group); err != nil {
+				logger.Debug("could not search for peers", "err", err, "group", group.IP.String())
+			}
+		}
+		for _, host := range splitList(meshPeers) {
+			to, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, "5353"))
+			if err != nil {
+				logger.Warn("could not resolve peer", "err", err, "peer", host)
+				continue
+			}
+			for group, conn := range r.conns {
+				if (group.IP.To4() == nil) != (to.IP.To4() == nil) {
+					continue
+				}
+				if _, err := conn.WriteToUDP(unicast, to); err != nil {
+					logger.Debug("could not query peer", "err", err, "peer", host)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(peerQueryInterval):
+		}
+	}
+}
+
+// learn records the daemons advertised in a response, records with a ttl of 0 remove a daemon.
+func (r *mdnsResponder) learn(logger *slog.Logger, msg []byte, from *net.UDPAddr) {
+	answers, err := parseResponse(msg)
+	if err != nil {
+		logger.Debug("ignoring mDNS response", "err", err, "from", from.String())
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range answers {
+		name, ok := strings.CutSuffix(strings.ToLower(a.name), "."+mdnsService)
+		if !ok || strings.EqualFold(a.name, r.instance) || a.rrtype != dnsTypeSRV && a.rrtype != dnsTypeTXT {
+			continue
+		}
+		if a.ttl == 0 {
+			delete(r.peers, name)
+			continue
+		}
+		p, ok := r.peers[name]
+		if !ok {
+			p = &peer{Name: name}
+			r.peers[name] = p
+			logger.Info("found peer", "peer", name, "source", from.IP.String())
+		}
+		p.Source, p.LastSeen = from.IP.String(), time.Now()
+		p.expires = p.LastSeen.Add(time.Duration(a.ttl) * time.Second)
+		switch a.rrtype {
+		case dnsTypeSRV:
+			p.Port, p.Host = binary.BigEndian.Uint16(a.data[4:6]), strings.TrimSuffix(a.target, ".")
+		case dnsTypeTXT:
+			p.Addresses = parsePeerAddresses(a.data)
+		}
+	}
+}
+
+// list returns the peers whose records did not expire, ordered by name.
+func (r *mdnsResponder) list() []*peer {
+	result := make([]*peer, 0)
+	if r == nil {
+		return result
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, p := range r.peers {
+		if time.Now().After(p.expires) {
+			delete(r.peers, name)
+			continue
+		}
+		result = append(result, p)
+	}
+	slices.SortFunc(result, func(a, b *peer) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+// parsePeerAddresses decodes the interface=address[,address] strings of a TXT record as written by txt.
+func parsePeerAddresses(data []byte) ips {
+	result := make(ips, 0)
+	for len(data) > 0 {
+		length := int(data[0])
+		if 1+length > len(data) {
+			break
+		}
+		entry := string(data[1 : 1+length])
+		data = data[1+length:]
+		name, addresses, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		if family, ok := strings.CutPrefix(name, "public-"); ok {
+			name = "public " + family
+		}
+		for _, address := range strings.Split(addresses, ",") {
+			result = append(result, &ip{Address: address, Interface: name})
+		}
+	}
+	return result
+}
+
+// parseResponse returns the answers and additional records of a DNS response.
+func parseResponse(msg []byte) ([]*dnsAnswer, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("message too short")
+	}
+	offset := 12
+	for range binary.BigEndian.Uint16(msg[4:6]) {
+		_, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:8])) + int(binary.BigEndian.Uint16(msg[8:10])) + int(binary.BigEndian.Uint16(msg[10:12]))
+	result := make([]*dnsAnswer, 0, count)
+	for range count {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) < next+10 {
+			return nil, errors.New("record truncated")
+		}
+		a := &dnsAnswer{
+			name:   name,
+			rrtype: binary.BigEndian.Uint16(msg[next : next+2]),
+			ttl:    binary.BigEndian.Uint32(msg[next+4 : next+8]),
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		offset = next + 10 + length
+		if len(msg) < offset {
+			return nil, errors.New("record data truncated")
+		}
+		a.data = msg[next+10 : offset]
+		if a.rrtype == dnsTypeSRV {
+			if length < 7 {
+				return nil, errors.New("invalid SRV record")
+			}
+			if a.target, _, err = readName(msg, next+16); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+// dnsQuery encodes a query with the questions.
+func dnsQuery(questions ...dnsQuestion) []byte {
+	msg := make([]byte, 4, 12)
+	for _, count := range []int{len(questions), 0, 0, 0} {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(count))
+	}
+	for _, q := range questions {
+		class := uint16(dnsClassIN)
+		if q.unicast {
+			class |= mdnsUnicastResponse
+		}
+		msg = append(msg, encodeName(q.name)...)
+		msg = binary.BigEndian.AppendUint16(msg, q.rrtype)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+	}
+	return msg
+}
@@ -0,0 +1,83 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+)
+
+// phpipamAnswer is the envelope of every answer of the phpIPAM API.
+type phpipamAnswer struct {
+	Code    int             `json:"code"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// lookupPhpIPAM returns the phpIPAM entry of the address. phpIPAM stores the network with the subnet only, so the
+// prefix of the entry is left invalid.
+func lookupPhpIPAM(address netip.Addr) (*ipamEntry, error) {
+	var answer phpipamAnswer
+	status, err := ipamRequest("GET", "/addresses/search/"+address.String()+"/", nil, &answer)
+	if err != nil {
+		return nil, err
+	}
+	// phpIPAM answers 404 for addresses not found
+	if status == http.StatusNotFound || answer.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if !answer.Success {
+		return nil, fmt.Errorf("phpipam: %s", answer.Message)
+	}
+	var addresses []struct {
+		Hostname string `json:"hostname"`
+		Owner    string `json:"owner"`
+	}
+	if err := json.Unmarshal(answer.Data, &addresses); err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	return &ipamEntry{Hostname: addresses[0].Hostname, Owner: addresses[0].Owner}, nil
+}
+
+// registerPhpIPAM creates the address in the subnet of the prefix, which has to exist in phpIPAM.
+func registerPhpIPAM(prefix netip.Prefix, hostname string) error {
+	var answer phpipamAnswer
+	status, err := ipamRequest("GET", "/subnets/cidr/"+prefix.Masked().String()+"/", nil, &answer)
+	if err != nil {
+		return err
+	}
+	var subnets []struct {
+		ID json.Number `json:"id"`
+	}
+	if status != http.StatusNotFound && answer.Success {
+		if err := json.Unmarshal(answer.Data, &subnets); err != nil {
+			return err
+		}
+	}
+	if len(subnets) == 0 {
+		return fmt.Errorf("subnet %s is not managed by phpipam", prefix.Masked())
+	}
+
+	body := map[string]string{
+		"subnetId":    subnets[0].ID.String(),
+		"ip":          prefix.Addr().String(),
+		"hostname":    hostname,
+		"description": "registered by ips",
+	}
+	answer = phpipamAnswer{}
+	status, err = ipamRequest("POST", "/addresses/", body, &answer)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound || !answer.Success {
+		return errors.New("phpipam: " + answer.Message)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sascha-andres/reuse/flag"
+	"go.starlark.net/starlark"
+)
+
+// pluginSupport names the language plugins are written in
+const pluginSupport = "starlark"
+
+// pluginDir contains the starlark scripts transforming the address list, defaults to the ips config directory
+var pluginDir string
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "plugins",
+		flags: func() {
+			flag.StringVar(&pluginDir, "plugin-dir", "", "directory of starlark scripts transforming the output, defaults to the plugins directory in the user config dir")
+		},
+	})
+}
+
+// pluginDirectory returns the directory plugins are loaded from.
+func pluginDirectory() (string, error) {
+	if pluginDir != "" {
+		return pluginDir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ips", "plugins"), nil
+}
+
+// applyPlugins passes the address list through every *.star script of the plugin directory in lexical order.
+// A script has to define transform(addresses) receiving a list of dicts and returning the list to continue with,
+// which allows filtering, labelling and reshaping without changing ips itself. A missing directory is no error.
+func applyPlugins(logger *slog.Logger, list ips) (ips, error) {
+	dir, err := pluginDirectory()
+	if err != nil {
+		return list, err
+	}
+	scripts, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return list, err
+	}
+	sort.Strings(scripts)
+	for _, script := range scripts {
+		logger.Debug("applying plugin", "script", script)
+		list, err = applyPlugin(logger, script, list)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", filepath.Base(script), err)
+		}
+	}
+	return list, nil
+}
+
+// applyPlugin runs the transform function of a single script.
+func applyPlugin(logger *slog.Logger, script string, list ips) (ips, error) {
+	thread := &starlark.Thread{
+		Name:  filepath.Base(script),
+		Print: func(t *starlark.Thread, msg string) { logger.Info(msg, "plugin", t.Name) },
+	}
+	globals, err := starlark.ExecFile(thread, script, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("no transform function defined")
+	}
+	addresses := make([]starlark.Value, 0, len(list))
+	for _, i := range list {
+		addresses = append(addresses, toStarlark(i))
+	}
+	result, err := starlark.Call(thread, transform, starlark.Tuple{starlark.NewList(addresses)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	iterable, ok := result.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("transform returned %s instead of a list", result.Type())
+	}
+	transformed := make(ips, 0)
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var element starlark.Value
+	for iter.Next(&element) {
+		i, err := fromStarlark(element)
+		if err != nil {
+			return nil, err
+		}
+		transformed = append(transformed, i)
+	}
+	return transformed, nil
+}
+
+// toStarlark converts an address to the dict handed to plugins. family and public are informational, they are
+// ignored when converting back.
+func toStarlark(i *ip) *starlark.Dict {
+	flags := make([]starlark.Value, 0, len(i.Flags))
+	for _, f := range i.Flags {
+		flags = append(flags, starlark.String(f))
+	}
+	labels := starlark.NewDict(len(i.Labels))
+	for k, v := range i.Labels {
+		_ = labels.SetKey(starlark.String(k), starlark.String(v))
+	}
+	d := starlark.NewDict(8)
+	_ = d.SetKey(starlark.String("address"), starlark.String(i.Address))
+	_ = d.SetKey(starlark.String("interface"), starlark.String(i.Interface))
+	_ = d.SetKey(starlark.String("flags"), starlark.NewList(flags))
+	_ = d.SetKey(starlark.String("valid_lifetime"), starlark.String(i.ValidLifetime))
+	_ = d.SetKey(starlark.String("preferred_lifetime"), starlark.String(i.PreferredLifetime))
+	_ = d.SetKey(starlark.String("labels"), labels)
+	_ = d.SetKey(starlark.String("family"), starlark.String(i.family()))
+	_ = d.SetKey(starlark.String("public"), starlark.Bool(i.isPublic()))
+	return d
+}
+
+// fromStarlark converts a dict returned by a plugin back to an address. address and interface are required.
+func fromStarlark(v starlark.Value) (*ip, error) {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("transform returned %s instead of a dict", v.Type())
+	}
+	i := &ip{}
+	var err error
+	if i.Address, err = dictString(d, "address", true); err != nil {
+		return nil, err
+	}
+	if i.Interface, err = dictString(d, "interface", true); err != nil {
+		return nil, err
+	}
+	if i.ValidLifetime, err = dictString(d, "valid_lifetime", false); err != nil {
+		return nil, err
+	}
+	if i.PreferredLifetime, err = dictString(d, "preferred_lifetime", false); err != nil {
+		return nil, err
+	}
+	if flags, found, _ := d.Get(starlark.String("flags")); found {
+		list, ok := flags.(*starlark.List)
+		if !ok {
+			return nil, fmt.Errorf("flags of %s is %s instead of a list", i.Address, flags.Type())
+		}
+		for n := 0; n < list.Len(); n++ {
+			f, ok := starlark.AsString(list.Index(n))
+			if !ok {
+				return nil, fmt.Errorf("flags of %s contain %s instead of a string", i.Address, list.Index(n).Type())
+			}
+			i.Flags = append(i.Flags, f)
+		}
+	}
+	if labels, found, _ := d.Get(starlark.String("labels")); found {
+		dict, ok := labels.(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("labels of %s is %s instead of a dict", i.Address, labels.Type())
+		}
+		for _, item := range dict.Items() {
+			k, kok := starlark.AsString(item[0])
+			v, vok := starlark.AsString(item[1])
+			if !kok || !vok {
+				return nil, fmt.Errorf("labels of %s have to map strings to strings", i.Address)
+			}
+			if i.Labels == nil {
+				i.Labels = make(map[string]string)
+			}
+			i.Labels[k] = v
+		}
+	}
+	return i, nil
+}
+
+// dictString returns the string stored at key, an error is returned for other types or a missing required key.
+func dictString(d *starlark.Dict, key string, required bool) (string, error) {
+	v, found, _ := d.Get(starlark.String(key))
+	if !found || v == starlark.None {
+		if required {
+			return "", fmt.Errorf("%s missing in %s", key, d)
+		}
+		return "", nil
+	}
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return "", fmt.Errorf("%s is %s instead of a string", key, v.Type())
+	}
+	return s, nil
+}
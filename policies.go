@@ -0,0 +1,229 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// agentPoliciesFile names a file of policies the addresses pushed by agents must meet, policyWebhook is posted
+// to when an agent violates a policy or meets it again
+var agentPoliciesFile, policyWebhook string
+
+type (
+
+	// agentPolicy is a line of the policies file.
+	agentPolicy struct {
+
+		// pattern matches tenant and name of the agents the policy applies to, separated by a slash
+		pattern string
+
+		// expect contains the networks the addresses of the agents must be in, addresses of a family without
+		// network are allowed
+		expect []netip.Prefix
+
+		// forbid contains the classes of addresses the agents must not have
+		forbid []string
+	}
+
+	// policyViolation is an address of an agent violating a policy.
+	policyViolation struct {
+		Address string
+
+		// Rule is the rule violated, either expect or forbid
+		Rule string
+
+		// Reason describes the violation
+		Reason string
+	}
+
+	// policyAlert is the payload sent to the policy webhook.
+	policyAlert struct {
+
+		// Time is the moment the alert was raised
+		Time time.Time
+
+		Tenant string
+		Agent  string
+
+		// Violations are the violations found by the push, Resolved those found before that are gone
+		Violations []*policyViolation `json:",omitempty"`
+		Resolved   []*policyViolation `json:",omitempty"`
+	}
+
+	// policyFile holds the policies of the policies file, it is read again once it changed.
+	policyFile struct {
+		mu       sync.Mutex
+		file     *watchedFile
+		policies []*agentPolicy
+		logger   *slog.Logger
+	}
+)
+
+// policies is set by the server mode if agent-policies is given
+var policies *policyFile
+
+// loadPolicies reads the file of the agent-policies option, it returns nil if the option is not set.
+func loadPolicies(logger *slog.Logger) (*policyFile, error) {
+	if agentPoliciesFile == "" {
+		return nil, nil
+	}
+	p := &policyFile{logger: logger}
+	p.file = &watchedFile{path: agentPoliciesFile, logger: logger, parse: func(data []byte) error {
+		parsed, err := parsePolicies(data)
+		if err == nil {
+			p.policies = parsed
+		}
+		return err
+	}}
+	if err := p.file.read(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parsePolicies parses lines of the form "pattern rule=value ...". The pattern is matched against tenant and name
+// of an agent separated by a slash, see path.Match. The rules are expect, a comma separated list of networks, and
+// forbid, a comma separated list of address classes. Empty lines and lines starting with # are ignored.
+func parsePolicies(data []byte) ([]*agentPolicy, error) {
+	result := make([]*agentPolicy, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected pattern and rules", line)
+		}
+		p := &agentPolicy{pattern: fields[0]}
+		if _, err := path.Match(p.pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", line, p.pattern)
+		}
+		for _, field := range fields[1:] {
+			rule, value, _ := strings.Cut(field, "=")
+			switch rule {
+			case "expect":
+				for _, network := range splitList(value) {
+					prefix, err := parsePrefixOrAddr(network)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: invalid network %q", line, network)
+					}
+					p.expect = append(p.expect, prefix.Masked())
+				}
+			case "forbid":
+				for _, class := range splitList(value) {
+					if !slices.Contains(addressClasses, class) {
+						return nil, fmt.Errorf("line %d: unknown address class %q", line, class)
+					}
+					p.forbid = append(p.forbid, class)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown rule %q", line, field)
+			}
+		}
+		result = append(result, p)
+	}
+	return result, scanner.Err()
+}
+
+// check returns the violations of the policies matching the agent by its addresses. Loopback and link-local
+// addresses are not expected to be in a network of expect.
+func (p *policyFile) check(tenant, name string, addresses ips) []*policyViolation {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file.refresh()
+	result := make([]*policyViolation, 0)
+	for _, policy := range p.policies {
+		if matched, _ := path.Match(policy.pattern, tenant+"/"+name); !matched {
+			continue
+		}
+		for _, i := range addresses {
+			prefix, err := parsePrefixOrAddr(i.Address)
+			if err != nil {
+				continue
+			}
+			class, err := classifyAddress(i.Address)
+			if err != nil {
+				continue
+			}
+			if slices.Contains(policy.forbid, class.Class) {
+				result = appendViolation(result, &policyViolation{Address: i.Address, Rule: "forbid", Reason: class.Class + " address forbidden"})
+			}
+			// the public addresses are the ones of the uplink and a family without expected network is not restricted,
+			// so expect=10.0.0.0/8 does not flag the global ipv6 addresses of the agent
+			if i.isPublic() || class.Class == "loopback" || class.Class == "link-local" {
+				continue
+			}
+			sameFamily := func(network netip.Prefix) bool { return network.Addr().Is4() == prefix.Addr().Is4() }
+			if !slices.ContainsFunc(policy.expect, sameFamily) {
+				continue
+			}
+			if !slices.ContainsFunc(policy.expect, func(network netip.Prefix) bool { return network.Contains(prefix.Addr()) }) {
+				result = appendViolation(result, &policyViolation{Address: i.Address, Rule: "expect", Reason: "not in an expected network"})
+			}
+		}
+	}
+	return result
+}
+
+// appendViolation appends v unless the address violates the same rule already, e.g. of another policy.
+func appendViolation(list []*policyViolation, v *policyViolation) []*policyViolation {
+	if containsViolation(list, v) {
+		return list
+	}
+	return append(list, v)
+}
+
+// containsViolation reports whether the address of v violates the rule of v in list.
+func containsViolation(list []*policyViolation, v *policyViolation) bool {
+	return slices.ContainsFunc(list, func(o *policyViolation) bool { return o.Address == v.Address && o.Rule == v.Rule })
+}
+
+// compare raises an alert if the violations of the agent differ from the ones before its push.
+func (p *policyFile) compare(tenant, name string, previous, current []*policyViolation) {
+	if p == nil {
+		return
+	}
+	a := &policyAlert{Time: time.Now(), Tenant: tenant, Agent: name}
+	for _, v := range current {
+		if !containsViolation(previous, v) {
+			a.Violations = append(a.Violations, v)
+		}
+	}
+	for _, v := range previous {
+		if !containsViolation(current, v) {
+			a.Resolved = append(a.Resolved, v)
+		}
+	}
+	if len(a.Violations) == 0 && len(a.Resolved) == 0 {
+		return
+	}
+	for _, v := range a.Violations {
+		p.logger.Warn("policy violated", "tenant", tenant, "agent", name, "address", v.Address, "reason", v.Reason)
+	}
+	for _, v := range a.Resolved {
+		p.logger.Info("policy met again", "tenant", tenant, "agent", name, "address", v.Address, "rule", v.Rule)
+	}
+	if policyWebhook == "" {
+		return
+	}
+	// the push is answered without waiting for the webhook
+	go func() {
+		if err := postWebhook(policyWebhook, a); err != nil {
+			p.logger.Error("could not send policy alert", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+// lease is the lifetime requested for port mappings
+var lease time.Duration
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:      "nat",
+		commands:  map[string]command{"nat": runNAT, "portmap": runPortmap},
+		providers: []*provider{{Name: "gateway", Lookup: gatewayLookup}},
+		flags: func() {
+			flag.StringVar(&gatewayAddress, "gateway", "", "router asked using NAT-PMP or PCP, defaults to the gateway of the default route")
+			flag.DurationVar(&lease, "lease", time.Hour, "lifetime of port mappings created by portmap add")
+		},
+	})
+}
+
+// runPortmap manages port mappings on the local router using NAT-PMP, PCP or UPnP:
+//
+//	ips portmap list
+//	ips portmap add <tcp|udp> <port>
+//	ips portmap remove <tcp|udp> <port>
+func runPortmap(logger *slog.Logger, args []string) int {
+	if offline {
+		logger.Error("portmap requires network access")
+		return exitInternalError
+	}
+	if len(args) == 1 && args[0] == "list" {
+		return listPortmap(logger)
+	}
+	if len(args) != 3 || (args[0] != "add" && args[0] != "remove") {
+		logger.Error("usage: ips portmap list|add|remove [<tcp|udp> <port>]")
+		return exitInternalError
+	}
+	protocol := args[1]
+	if _, ok := protocolNumbers[protocol]; !ok {
+		logger.Error("protocol has to be tcp or udp", "protocol", protocol)
+		return exitInternalError
+	}
+	port, err := strconv.ParseUint(args[2], 10, 16)
+	if err != nil || port == 0 {
+		logger.Error("invalid port", "port", args[2])
+		return exitInternalError
+	}
+	requested := lease
+	if args[0] == "remove" {
+		requested = 0
+	} else if lease < time.Second {
+		logger.Error("lease has to be at least one second", "lease", lease)
+		return exitInternalError
+	}
+
+	gw, err := defaultGateway()
+	if err != nil {
+		logger.Error("could not determine gateway", "err", err)
+		return exitInternalError
+	}
+	if dryRun {
+		client, err := localAddressFor(gw)
+		if err != nil {
+			logger.Error("could not determine local address", "err", err)
+			return exitInternalError
+		}
+		if requested == 0 {
+			fmt.Printf("would remove the %s mapping of port %d to %s at %s\n", protocol, port, client, gw)
+		} else {
+			fmt.Printf("would map %s port %d to %s for %s at %s\n", protocol, port, client, requested, gw)
+		}
+		return exitOK
+	}
+
+	m, err := mapPort(gw, protocol, uint16(port), requested)
+	if err != nil {
+		logger.Error("could not change port mapping", "err", err)
+		return exitInternalError
+	}
+	if jsonOutput {
+		return printJSON(logger, m)
+	}
+	printMapping(m)
+	return exitOK
+}
+
+// mapPort creates, or with a lease of 0 deletes, a mapping using NAT-PMP or PCP. UPnP is used if the router
+// speaks neither of them.
+func mapPort(gw net.IP, protocol string, port uint16, lease time.Duration) (*portMapping, error) {
+	m, err := gatewayMap(gw, protocol, port, lease)
+	if !errors.Is(err, ErrGatewayUnsupported) {
+		return m, err
+	}
+	g, upnpErr := discoverIGD()
+	if upnpErr != nil {
+		return nil, errors.Join(err, upnpErr)
+	}
+	client, err := localAddressFor(gw)
+	if err != nil {
+		return nil, err
+	}
+	if lease > 0 {
+		err = g.addPortMapping(protocol, port, client.String(), lease)
+	} else {
+		err = g.deletePortMapping(protocol, port)
+	}
+	if err != nil {
+		return nil, err
+	}
+	external, _ := g.externalAddress()
+	return &portMapping{
+		Protocol:        protocol,
+		ExternalAddress: external,
+		ExternalPort:    port,
+		InternalClient:  client.String(),
+		InternalPort:    port,
+		Lease:           lease.String(),
+		Description:     "ips",
+		Method:          "UPnP",
+	}, nil
+}
+
+// listPortmap prints the external address reported by the gateway. The mappings are listed if the router offers
+// UPnP, NAT-PMP and PCP lack an operation to enumerate them.
+func listPortmap(logger *slog.Logger) int {
+	status, err := queryGateway(true)
+	if err != nil && !errors.Is(err, ErrGatewayUnsupported) {
+		logger.Error("could not query gateway", "err", err)
+		return exitInternalError
+	}
+	g, upnpErr := discoverIGD()
+	if upnpErr == nil {
+		if status == nil {
+			external, err := g.externalAddress()
+			if err != nil {
+				logger.Warn("could not get external address using UPnP", "err", err)
+			}
+			host := g.location
+			if u, err := url.Parse(g.location); err == nil {
+				host = u.Hostname()
+			}
+			status = &gatewayStatus{Gateway: host, Protocol: "UPnP", ExternalAddress: external}
+		}
+		status.Mappings, err = g.portMappings()
+		if err != nil {
+			logger.Warn("could not list port mappings", "err", err)
+		}
+	}
+	if status == nil {
+		logger.Error("could not query gateway", "err", errors.Join(err, upnpErr))
+		return exitInternalError
+	}
+
+	if jsonOutput {
+		return printJSON(logger, status)
+	}
+	fmt.Printf("gateway\t%s\n", status.Gateway)
+	fmt.Printf("protocol\t%s\n", status.Protocol)
+	fmt.Printf("external\t%s\n", status.ExternalAddress)
+	if status.Epoch > 0 {
+		fmt.Printf("uptime\t%s\n", time.Duration(status.Epoch)*time.Second)
+	}
+	for _, m := range status.Mappings {
+		printMapping(m)
+	}
+	return exitOK
+}
+
+// printMapping prints a mapping as a single tab separated line.
+func printMapping(m *portMapping) {
+	target := net.JoinHostPort(m.InternalClient, strconv.Itoa(int(m.InternalPort)))
+	line := fmt.Sprintf("%s\t%d\t%s\t%s\t%s", m.Protocol, m.ExternalPort, target, m.Lease, m.Method)
+	if m.Description != "" {
+		line += "\t" + m.Description
+	}
+	fmt.Println(line)
+}
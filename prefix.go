@@ -0,0 +1,43 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"net"
+	"slices"
+)
+
+// delegatedPrefixLength is the length of the prefix the ISP delegates, used to derive it from the addresses
+var delegatedPrefixLength uint
+
+// delegatedPrefixes derives the delegated prefixes from the global ipv6 addresses of the interfaces. Unique
+// local, link-local and the public addresses returned by providers are ignored.
+func delegatedPrefixes(list ips) []string {
+	prefixes := make([]string, 0)
+	mask := net.CIDRMask(int(delegatedPrefixLength), 8*net.IPv6len)
+	for _, i := range list {
+		if i.isPublic() || i.family() != "ipv6" {
+			continue
+		}
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil || !address.IsGlobalUnicast() || address.IsPrivate() {
+			continue
+		}
+		prefix := (&net.IPNet{IP: address.Mask(mask), Mask: mask}).String()
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	slices.Sort(prefixes)
+	return prefixes
+}
+
+// detectPrefixChange compares the delegated prefixes of two address sets and returns nil if they are equal.
+// Rotations break far more than a changed ipv4 address, e.g. firewall rules and DNS records of every host.
+func detectPrefixChange(previous, current ips) *prefixChange {
+	before, after := delegatedPrefixes(previous), delegatedPrefixes(current)
+	if slices.Equal(before, after) {
+		return nil
+	}
+	return &prefixChange{Previous: before, Current: after}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// promptRefreshInterval is the minimum time between two background refreshes started by the prompt, so a failing
+// lookup is not retried at every prompt
+const promptRefreshInterval = time.Minute
+
+var glyphPublic, glyphLocal, glyphStale string
+
+// runPrompt prints a compact single line for shell prompts. It never waits for the network: the public address
+// is taken from the cache and refreshed in a detached background process once it is stale.
+// "ips prompt refresh" performs that refresh synchronously.
+func runPrompt(logger *slog.Logger, args []string) int {
+	if len(args) == 1 && args[0] == "refresh" {
+		return refreshPublicCache(logger)
+	}
+
+	parts := make([]string, 0, 2)
+	cache, err := loadPublicCache()
+	if err != nil {
+		logger.Debug("could not read cache", "err", err)
+		cache = make(publicCache)
+	}
+	// the first family cached is shown, a family missing from the cache is not applicable to the host
+	stale := true
+	for _, family := range []string{"ipv4", "ipv6"} {
+		entry, fresh := cache.fresh(family)
+		if entry == nil {
+			continue
+		}
+		stale = !fresh
+		marker := ""
+		if !fresh {
+			marker = glyphStale
+		}
+		parts = append(parts, glyphPublic+marker+entry.Address)
+		break
+	}
+	if stale && !offline {
+		spawnRefresh(logger)
+	}
+
+	// the documentation address is routed via the default route, which yields the primary local address
+	if local := routeTo(net.IPv4(192, 0, 2, 1)); local.Error == "" {
+		parts = append(parts, glyphLocal+local.Source)
+	}
+	fmt.Println(strings.Join(parts, " "))
+	return exitOK
+}
+
+// refreshPublicCache looks up the public addresses and stores them in the cache. A lookup already running in
+// another process is waited for instead of starting a second one.
+func refreshPublicCache(logger *slog.Logger) int {
+	found, err := lookupPublic(logger)
+	if len(found) == 0 {
+		logger.Debug("could not get public ip", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// spawnRefresh starts "ips prompt refresh" in the background without waiting for it. The flags of the current
+// invocation are passed on, so providers and headers apply to the refresh as well. A refresh is started at most
+// once per promptRefreshInterval, the time of the last one is kept in the state directory.
+func spawnRefresh(logger *slog.Logger) {
+	path, err := statePath("prompt.refresh")
+	if err != nil {
+		logger.Debug("could not determine refresh timestamp", "err", err)
+		return
+	}
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < promptRefreshInterval {
+		return
+	}
+	if err := writeState(path, nil); err != nil {
+		logger.Debug("could not write refresh timestamp", "err", err)
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Debug("could not determine executable", "err", err)
+		return
+	}
+	cmd := exec.Command(executable, append(os.Args[1:], "refresh")...)
+	if err := cmd.Start(); err != nil {
+		logger.Debug("could not start background refresh", "err", err)
+		return
+	}
+	_ = cmd.Process.Release()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// viaInterface is the interface provider requests are bound to, the public address of that uplink is looked up
+	viaInterface string
+
+	// sourceAddress is the local address provider requests are sent from
+	sourceAddress string
+)
+
+// maxResponseBytes limits the answer read from a provider, an address with a trailing newline needs a fraction
+var maxResponseBytes uint
+
+// providerContentTypes lists the media types providers may answer with, an answer without content type is accepted
+// as well
+var providerContentTypes = []string{"text/plain", "application/octet-stream"}
+
+// provider is a http service echoing the public ip address of the caller as plain text, an executable printing it
+// or a lookup using another protocol.
+type provider struct {
+
+	// Name identifies the provider in the providers flag and in reports
+	Name string
+
+	// Command is an executable printing the public address, used instead of URLs if set
+	Command string
+
+	// Lookup determines the public address without asking a public service, used instead of URLs if set. It may
+	// return labels with further information about the uplink, e.g. the connection uptime reported by a router.
+	// Such providers only talk to the local network and are not considered third party services.
+	Lookup func(t string) (string, map[string]string, error)
+
+	// URLs maps the address families (ipv4, ipv6) to the endpoint only reachable using that family. The key "any"
+	// denotes a dual-stack endpoint, the family is then selected by the transport.
+	URLs map[string]string
+}
+
+// knownProviders contains all built in public ip providers
+var knownProviders = []*provider{
+	{Name: "wtfismyip", URLs: map[string]string{"ipv4": "https://ipv4.wtfismyip.com/text", "ipv6": "https://ipv6.wtfismyip.com/text"}},
+	{Name: "icanhazip", URLs: map[string]string{"ipv4": "https://ipv4.icanhazip.com", "ipv6": "https://ipv6.icanhazip.com"}},
+	{Name: "ipify", URLs: map[string]string{"ipv4": "https://api.ipify.org", "ipv6": "https://api6.ipify.org"}},
+	{Name: "identme", URLs: map[string]string{"ipv4": "https://v4.ident.me", "ipv6": "https://v6.ident.me"}},
+}
+
+// providerURL is the dual-stack url of a user specified provider
+var providerURL string
+
+// selectedProviders returns the providers named in the providers flag in the given order. Providers discovered
+// using DNS are tried first, followed by the ones given using provider-url and provider-command. With no-external
+// set the built in providers contacting third party services are left out.
+func selectedProviders() ([]*provider, error) {
+	result := make([]*provider, 0)
+	if discoverDomain != "" {
+		discovered, err := discoverProviders(discoverDomain)
+		if err != nil {
+			slog.Warn("could not discover providers", "err", err, "domain", discoverDomain)
+		}
+		result = append(result, discovered...)
+	}
+	if providerURL != "" {
+		result = append(result, &provider{Name: "custom", URLs: map[string]string{"any": providerURL}})
+	}
+	if providerCommand != "" {
+		result = append(result, &provider{Name: "command", Command: providerCommand})
+	}
+	for _, name := range strings.Split(providerNames, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p := providerByName(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+		if noExternal && p.Lookup == nil {
+			continue
+		}
+		result = append(result, p)
+	}
+	if noExternal && len(result) == 0 {
+		return nil, fmt.Errorf("%w: no internal provider configured", ErrExternalDisabled)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no providers selected")
+	}
+	return result, nil
+}
+
+// providerByName returns the known provider with the given name or nil.
+func providerByName(name string) *provider {
+	for _, p := range knownProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// getPublicIp retrieves the public IP address of the system using the selected providers and returns it as an ip
+// instance. Providers are tried in order, the first answer wins, providers held back by the guard are skipped.
+// Returns the errors of all providers if none of them succeeded.
+func getPublicIp(t string) (*ip, error) {
+	providers, err := selectedProviders()
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, 0, len(providers))
+	for _, p := range providers {
+		if !guard.allow(p, t) {
+			continue
+		}
+		start := time.Now()
+		address, labels, err := p.queryLabeled(t)
+		guard.record(p, t, address, time.Since(start), err)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return &ip{
+			Address:   address,
+			Interface: fmt.Sprintf("public %s", strings.ToUpper(t)),
+			Labels:    labels,
+		}, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("all providers are rate limited or disabled")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// query asks the provider for the public address of the given family.
+// Returns an error if the request fails or the response can't be processed, wrapping ErrProviderTimeout when the
+// provider did not answer in time.
+func (p *provider) query(t string) (string, error) {
+	if p.Command != "" {
+		return p.queryCommand(t)
+	}
+	if p.Lookup != nil {
+		address, _, err := p.Lookup(t)
+		return address, err
+	}
+	var transport http.RoundTripper
+	url, ok := p.URLs[t]
+	if !ok {
+		url, ok = p.URLs["any"]
+		if !ok {
+			return "", fmt.Errorf("provider %s does not support %s", p.Name, t)
+		}
+		transport = familyTransport(t)
+	} else if bound() {
+		transport = familyTransport(t)
+	}
+	client := newHTTPClient(transport)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	applyHeaders(req, p)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return "", fmt.Errorf("%w: %s: %w", ErrProviderTimeout, url, err)
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(url, resp); err != nil {
+		return "", err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxResponseBytes)+1))
+	if err != nil {
+		if isTimeout(err) {
+			return "", fmt.Errorf("%w: %s: %w", ErrProviderTimeout, url, err)
+		}
+		return "", err
+	}
+	if uint(len(body)) > maxResponseBytes {
+		return "", fmt.Errorf("%w: %s answered with more than %d bytes", ErrInvalidResponse, url, maxResponseBytes)
+	}
+	return parseAddress(url, string(body), t)
+}
+
+// queryLabeled asks the provider like query and also returns the labels a lookup attaches to the address.
+func (p *provider) queryLabeled(t string) (string, map[string]string, error) {
+	if p.Command == "" && p.Lookup != nil {
+		return p.Lookup(t)
+	}
+	address, err := p.query(t)
+	return address, nil, err
+}
+
+// checkResponse rejects answers of a provider before reading the body: error status codes, content types other
+// than providerContentTypes and bodies announced to exceed maxResponseBytes.
+func checkResponse(url string, resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s answered %s", ErrInvalidResponse, url, resp.Status)
+	}
+	if value := resp.Header.Get("Content-Type"); value != "" {
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil || !slices.Contains(providerContentTypes, mediaType) {
+			return fmt.Errorf("%w: %s answered with content type %q", ErrInvalidResponse, url, value)
+		}
+	}
+	if resp.ContentLength > int64(maxResponseBytes) {
+		return fmt.Errorf("%w: %s answered with %d bytes, more than %d", ErrInvalidResponse, url, resp.ContentLength, maxResponseBytes)
+	}
+	return nil
+}
+
+// parseAddress validates the answer of a provider, it has to be a single address of the requested family. HTML
+// pages, as served by captive portals, and any other garbage are reported as ErrInvalidResponse.
+func parseAddress(source, answer, t string) (string, error) {
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return "", fmt.Errorf("%w: %s returned no %s address", ErrInvalidResponse, source, t)
+	}
+	if strings.HasPrefix(answer, "<") || strings.Contains(strings.ToLower(answer), "<html") {
+		return "", fmt.Errorf("%w: %s returned an HTML page, possibly from a captive portal", ErrInvalidResponse, source)
+	}
+	address, err := netip.ParseAddr(answer)
+	if err != nil || address.Zone() != "" {
+		if len(answer) > 64 {
+			answer = answer[:64] + "..."
+		}
+		return "", fmt.Errorf("%w: %s returned %q, which is not an address", ErrInvalidResponse, source, answer)
+	}
+	address = address.Unmap()
+	if address.Is4() != (t == "ipv4") {
+		return "", fmt.Errorf("%w: %s returned %s for %s", ErrInvalidResponse, source, address, t)
+	}
+	return address.String(), nil
+}
+
+// familyTransport returns a http transport only connecting using the given address family, from the interface or
+// source address given by -via and -source.
+func familyTransport(family string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+		dialer, err := boundDialer(family)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, networkFor(family), address)
+	}
+	return transport
+}
+
+// bound reports whether provider requests are bound to an interface or source address.
+func bound() bool {
+	return viaInterface != "" || sourceAddress != ""
+}
+
+// boundDialer returns a dialer connecting from sourceAddress and through viaInterface if set. The source address
+// has to be of the given family.
+func boundDialer(family string) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if sourceAddress != "" {
+		address, err := netip.ParseAddr(sourceAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source address %q", sourceAddress)
+		}
+		if address.Is4() != (family == "ipv4") {
+			return nil, fmt.Errorf("source address %s is not an %s address", address, family)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: address.AsSlice(), Zone: address.Zone()}
+	}
+	if viaInterface != "" {
+		if err := bindInterface(dialer, viaInterface, family); err != nil {
+			return nil, err
+		}
+	}
+	return dialer, nil
+}
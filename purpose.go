@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// purposeRule assigns a purpose to the addresses of an interface or network.
+type purposeRule struct {
+
+	// network matches addresses it contains, iface the addresses of the interface if network is not valid
+	network netip.Prefix
+	iface   string
+
+	purpose string
+
+	// flag is the flag value the rule was parsed from
+	flag string
+}
+
+// purposeValues is the flag.Value collecting the purpose flags.
+type purposeValues struct{}
+
+var (
+	// purposes label addresses with the purpose of their interface or network, the first matching rule wins
+	purposes []*purposeRule
+
+	// purposeFilter lists the purposes shown, comma separated, all addresses are shown if empty
+	purposeFilter string
+)
+
+// Set parses and adds a purpose rule.
+func (purposeValues) Set(value string) error {
+	return parsePurpose(value)
+}
+
+// String returns the purpose rules as given, one per line.
+func (purposeValues) String() string {
+	return strings.Join(purposeValues{}.values(), "\n")
+}
+
+// reset removes all purpose rules.
+func (purposeValues) reset() {
+	purposes = nil
+}
+
+// values returns the purpose rules as given.
+func (purposeValues) values() []string {
+	values := make([]string, 0, len(purposes))
+	for _, p := range purposes {
+		values = append(values, p.flag)
+	}
+	return values
+}
+
+// parsePurpose parses a purpose flag value of the form "network=purpose" or "interface=purpose", e.g.
+// 10.10.0.0/16=mgmt or wg0=vpn.
+func parsePurpose(value string) error {
+	target, purpose, found := strings.Cut(value, "=")
+	target, purpose = strings.TrimSpace(target), strings.TrimSpace(purpose)
+	if !found || target == "" || purpose == "" {
+		return fmt.Errorf("purpose %q is not of the form network=purpose or interface=purpose", value)
+	}
+	p := &purposeRule{purpose: purpose, flag: value}
+	if network, err := parsePrefixOrAddr(target); err == nil {
+		p.network = network.Masked()
+	} else {
+		p.iface = target
+	}
+	purposes = append(purposes, p)
+	return nil
+}
+
+// applyPurposes labels the addresses with the purpose of the first matching rule and, with -purpose-filter set,
+// drops addresses whose purpose is not listed.
+func applyPurposes(list ips) ips {
+	if len(purposes) == 0 && purposeFilter == "" {
+		return list
+	}
+	shown := splitList(purposeFilter)
+	result := make(ips, 0, len(list))
+	for _, i := range list {
+		if purpose := purposeOf(i); purpose != "" {
+			if i.Labels == nil {
+				i.Labels = make(map[string]string)
+			}
+			i.Labels["purpose"] = purpose
+		}
+		if len(shown) > 0 && !slices.Contains(shown, i.Labels["purpose"]) {
+			continue
+		}
+		result = append(result, i)
+	}
+	return result
+}
+
+// purposeOf returns the purpose of the first rule matching the address, an empty string if none matches.
+func purposeOf(i *ip) string {
+	address, _ := parsePrefixOrAddr(i.Address)
+	for _, p := range purposes {
+		switch {
+		case p.network.IsValid():
+			if address.IsValid() && p.network.Contains(address.Addr()) {
+				return p.purpose
+			}
+		case p.iface == i.Interface:
+			return p.purpose
+		}
+	}
+	return ""
+}
@@ -0,0 +1,317 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPushSize limits the body of a push
+	maxPushSize = 1 << 20
+
+	// maxAgentAddresses limits the addresses kept per agent
+	maxAgentAddresses = 4096
+
+	// maxAgentHistory limits the changes kept per agent
+	maxAgentHistory = 50
+)
+
+// pushToken authenticates agents of the default tenant pushing their addresses to the server mode and clients
+// querying them, the endpoints are not served if neither it nor push-tokens is set
+var pushToken string
+
+// agentName is the form of the names agents push under
+var agentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type (
+
+	// agentState is what the server mode knows about an agent pushing its changes.
+	agentState struct {
+		Name string
+
+		// Tenant is the tenant of the token the agent pushed with
+		Tenant string
+
+		// Addresses are the addresses of the agent after the last push
+		Addresses ips
+
+		// Prefix is the delegated prefix pushed last
+		Prefix []string `json:",omitempty"`
+
+		// Updated is the time the last push arrived, Changed the time the agent detected the change
+		Updated time.Time
+		Changed time.Time
+
+		// Source is the address the last push came from
+		Source string
+
+		// Violations are the addresses violating the policies of agent-policies
+		Violations []*policyViolation `json:",omitempty"`
+
+		// History contains the last changes pushed, oldest first
+		History []*change `json:",omitempty"`
+	}
+
+	// agentStore keeps the state of the agents in agents.json of the state directory.
+	agentStore struct {
+		mu sync.Mutex
+
+		// agents is keyed by tenant and name separated by a slash
+		agents map[string]*agentState
+		logger *slog.Logger
+	}
+)
+
+// agents is set by the server mode if pushes are accepted
+var agents *agentStore
+
+// loadAgents reads the agents known from the state directory, a missing file starts empty.
+func loadAgents(logger *slog.Logger) (*agentStore, error) {
+	s := &agentStore{agents: make(map[string]*agentState), logger: logger}
+	path, err := statePath("agents.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]*agentState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	for _, a := range stored {
+		// agents stored before tenants existed belong to the default tenant
+		if a.Tenant == "" {
+			a.Tenant = defaultTenant
+		}
+		s.agents[a.Tenant+"/"+a.Name] = a
+	}
+	return s, nil
+}
+
+// pushEnabled reports whether the server mode accepts pushes.
+func pushEnabled() bool {
+	return pushToken != "" || pushTokensFile != ""
+}
+
+// apply updates the agent of the tenant with a pushed change and writes the agents. A snapshot replaces the
+// addresses known. The addresses are checked against the policies, changed violations raise an alert.
+func (s *agentStore) apply(tenant, name, source string, c *change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.agents[tenant+"/"+name]
+	var previous []*policyViolation
+	var history []*change
+	known := make(map[string]*ip)
+	if ok {
+		previous, history = state.Violations, state.History
+		for _, i := range state.Addresses {
+			known[i.key()] = i
+		}
+	}
+	if !ok || c.Snapshot {
+		state = &agentState{Name: name, Tenant: tenant, Addresses: make(ips, 0)}
+	}
+	// addresses added again replace the known ones, their labels may have changed. The stored agent is only
+	// changed once the change was accepted.
+	addresses := applyChange(state.Addresses, &change{Removed: slices.Concat(c.Removed, c.Added), Added: c.Added})
+	if len(addresses) > maxAgentAddresses {
+		return fmt.Errorf("agent would have more than %d addresses", maxAgentAddresses)
+	}
+	state.Addresses = addresses
+	if c.Prefix != nil {
+		state.Prefix = c.Prefix.Current
+	}
+	now := time.Now()
+	entry := historyEntry(c, now)
+	stampAddresses(state.Addresses, known, now)
+	state.Updated, state.Changed, state.Source = now, c.Time, source
+	state.History = append(history, entry)
+	if len(state.History) > maxAgentHistory {
+		state.History = state.History[len(state.History)-maxAgentHistory:]
+	}
+	state.Violations = policies.check(tenant, name, state.Addresses)
+	policies.compare(tenant, name, previous, state.Violations)
+	s.agents[tenant+"/"+name] = state
+
+	data, err := json.Marshal(s.agents)
+	if err != nil {
+		return err
+	}
+	path, err := statePath("agents.json")
+	if err != nil {
+		return err
+	}
+	return writeState(path, data)
+}
+
+// stampAddresses sets the time the addresses of an agent were seen first to the earliest known, given by the agent
+// or a push before, and the time they were seen last to now, a push tells all addresses of the agent.
+func stampAddresses(addresses ips, known map[string]*ip, now time.Time) {
+	for _, i := range addresses {
+		if before, ok := known[i.key()]; ok && !before.FirstSeen.IsZero() && (i.FirstSeen.IsZero() || before.FirstSeen.Before(i.FirstSeen)) {
+			i.FirstSeen = before.FirstSeen
+		}
+		if i.FirstSeen.IsZero() || i.FirstSeen.After(now) {
+			i.FirstSeen = now
+		}
+		i.LastSeen = now
+	}
+}
+
+// historyEntry copies a pushed change for the history of the agent, the addresses of the agent are stamped with
+// the times they were seen and must not alter it. Changes without time are recorded at now.
+func historyEntry(c *change, now time.Time) *change {
+	entry := &change{Time: c.Time, Prefix: c.Prefix, Snapshot: c.Snapshot}
+	if entry.Time.IsZero() {
+		entry.Time = now
+	}
+	for _, i := range c.Added {
+		a := *i
+		entry.Added = append(entry.Added, &a)
+	}
+	for _, i := range c.Removed {
+		r := *i
+		entry.Removed = append(entry.Removed, &r)
+	}
+	return entry
+}
+
+// list returns the agents of the tenant sorted by name.
+func (s *agentStore) list(tenant string) []*agentState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]*agentState, 0)
+	for _, a := range s.agents {
+		if a.Tenant == tenant {
+			result = append(result, a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// get returns the agent of the tenant, nil if it never pushed.
+func (s *agentStore) get(tenant, name string) *agentState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.agents[tenant+"/"+name]
+}
+
+// find returns the agent of the tenant whose name equals name ignoring case, as host names do, nil if none does.
+func (s *agentStore) find(tenant, name string) *agentState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.agents {
+		if a.Tenant == tenant && strings.EqualFold(a.Name, name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// validatePush checks that the addresses of a pushed change are addresses, with or without network length.
+func validatePush(c *change) error {
+	if len(c.Added)+len(c.Removed) > maxAgentAddresses {
+		return fmt.Errorf("more than %d addresses", maxAgentAddresses)
+	}
+	for _, list := range []ips{c.Added, c.Removed} {
+		for _, i := range list {
+			if i == nil {
+				return errors.New("empty address")
+			}
+			if _, err := netip.ParsePrefix(i.Address); err != nil {
+				if _, err := netip.ParseAddr(i.Address); err != nil {
+					return fmt.Errorf("invalid address %q", i.Address)
+				}
+			}
+		}
+	}
+	if c.Prefix != nil {
+		for _, p := range c.Prefix.Current {
+			if _, err := netip.ParsePrefix(p); err != nil {
+				return fmt.Errorf("invalid prefix %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// handlePush accepts a change pushed by an agent, the JSON watch prints and webhook sinks post. The agent is named
+// by the path and belongs to the tenant of the token, a snapshot replaces its addresses, other changes are applied
+// to them.
+func handlePush(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := authorize(w, r, true)
+	if !ok {
+		return
+	}
+	name := r.PathValue("agent")
+	if !agentName.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid agent name %q", name), http.StatusBadRequest)
+		return
+	}
+	var c change
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize))
+	if err := decoder.Decode(&c); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "push too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid push: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := validatePush(&c); err != nil {
+		http.Error(w, fmt.Sprintf("invalid push: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := agents.apply(tenant, name, clientAddress(r), &c); err != nil {
+		agents.logger.Error("could not store push", "err", err, "tenant", tenant, "agent", name)
+		http.Error(w, "could not store push", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAgents answers the agents of the tenant of the token with their addresses.
+func handleAgents(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := authorize(w, r, false)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(agents.list(tenant))
+}
+
+// handleAgent answers a single agent of the tenant of the token with its addresses.
+func handleAgent(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := authorize(w, r, false)
+	if !ok {
+		return
+	}
+	state := agents.get(tenant, r.PathValue("agent"))
+	if state == nil {
+		http.Error(w, "unknown agent", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,105 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// addressList returns count addresses of the network 10.0.0.0/8 on eth0.
+func addressList(count int) ips {
+	list := make(ips, 0, count)
+	for i := range count {
+		list = append(list, &ip{Address: fmt.Sprintf("10.%d.%d.%d/8", i>>16&0xff, i>>8&0xff, i&0xff), Interface: "eth0"})
+	}
+	return list
+}
+
+func TestAgentStoreApply(t *testing.T) {
+	previousStateDir, previousPolicies := stateDir, policies
+	t.Cleanup(func() { stateDir, policies = previousStateDir, previousPolicies })
+	stateDir, policies = t.TempDir(), nil
+
+	a := &ip{Address: "192.0.2.1/24", Interface: "eth0"}
+	b := &ip{Address: "192.0.2.2/24", Interface: "eth0"}
+	c := &ip{Address: "2001:db8::1/64", Interface: "eth0"}
+	tests := []struct {
+		name    string
+		changes []*change
+		// want are the addresses of the agent after the changes
+		want    []string
+		wantErr bool
+	}{
+		{name: "snapshot", changes: []*change{{Snapshot: true, Added: ips{a, b}}}, want: []string{a.key(), b.key()}},
+		{name: "first change without snapshot", changes: []*change{{Added: ips{a}}}, want: []string{a.key()}},
+		{
+			name:    "changes applied",
+			changes: []*change{{Snapshot: true, Added: ips{a, b}}, {Added: ips{c}, Removed: ips{a}}},
+			want:    []string{b.key(), c.key()},
+		},
+		{
+			name:    "snapshot replaces",
+			changes: []*change{{Snapshot: true, Added: ips{a, b}}, {Snapshot: true, Added: ips{c}}},
+			want:    []string{c.key()},
+		},
+		{
+			name:    "added again",
+			changes: []*change{{Snapshot: true, Added: ips{a}}, {Added: ips{a}}},
+			want:    []string{a.key()},
+		},
+		{
+			name:    "too many addresses added",
+			changes: []*change{{Snapshot: true, Added: ips{a, b}}, {Added: addressList(maxAgentAddresses - 1)}},
+			want:    []string{a.key(), b.key()},
+			wantErr: true,
+		},
+		{
+			name:    "snapshot with too many addresses",
+			changes: []*change{{Snapshot: true, Added: ips{a}}, {Snapshot: true, Added: addressList(maxAgentAddresses + 1)}},
+			want:    []string{a.key()},
+			wantErr: true,
+		},
+		{
+			name:    "as many addresses as allowed",
+			changes: []*change{{Snapshot: true, Added: addressList(maxAgentAddresses)}},
+			want:    addressKeys(addressList(maxAgentAddresses)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &agentStore{agents: make(map[string]*agentState), logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			var err error
+			for _, change := range tt.changes {
+				change.Time = time.Now()
+				err = s.apply(defaultTenant, "host", "192.0.2.100", change)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			state := s.get(defaultTenant, "host")
+			if state == nil {
+				t.Fatal("agent not stored")
+			}
+			if got := addressKeys(state.Addresses); !slices.Equal(got, tt.want) {
+				t.Errorf("agent has %d addresses starting with %q, want %d starting with %q", len(got), got[:min(len(got), 3)], len(tt.want), tt.want[:min(len(tt.want), 3)])
+			}
+			if wantHistory := len(tt.changes) - map[bool]int{true: 1}[tt.wantErr]; len(state.History) != wantHistory {
+				t.Errorf("agent has %d history entries, want %d", len(state.History), wantHistory)
+			}
+
+			// the agents written are the ones served
+			loaded, err := loadAgents(s.logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := addressKeys(loaded.get(defaultTenant, "host").Addresses); !slices.Equal(got, tt.want) {
+				t.Errorf("stored agent has %d addresses, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultQualityTargets are anycast DNS resolvers answering on port 443 close to almost every network
+var defaultQualityTargets = []string{
+	"1.1.1.1:443", "8.8.8.8:443", "9.9.9.9:443",
+	"[2606:4700:4700::1111]:443", "[2001:4860:4860::8888]:443", "[2620:fe::fe]:443",
+}
+
+// qualityTargets and throughputURL configure ips quality, the built in targets are used if no targets are given
+var qualityTargets, throughputURL string
+
+type (
+
+	// qualityReport is the connection health snapshot printed by ips quality.
+	qualityReport struct {
+
+		// Targets contains the latency measurements per target
+		Targets []*latencyResult
+
+		// Throughput is the outcome of the download probe, if requested
+		Throughput *throughputResult `json:",omitempty"`
+	}
+
+	// latencyResult summarizes the tcp connect times to a single target.
+	latencyResult struct {
+
+		// Target is the address and port connected to
+		Target string
+
+		// Family is either ipv4 or ipv6
+		Family string
+
+		// Sent is the number of connection attempts
+		Sent uint
+
+		// Loss is the fraction of failed attempts
+		Loss float64
+
+		// Min, Avg and Max are the connect times of successful attempts
+		Min, Avg, Max time.Duration
+
+		// Jitter is the mean difference between consecutive connect times
+		Jitter time.Duration
+
+		// Error describes the last failure
+		Error string `json:",omitempty"`
+	}
+
+	// throughputResult is the outcome of downloading from the throughput url.
+	throughputResult struct {
+
+		// Bytes is the amount of data received
+		Bytes int64
+
+		// Duration is the time the download took
+		Duration time.Duration
+
+		// Mbps is the download rate in megabit per second
+		Mbps float64
+
+		// Error describes why the download failed
+		Error string `json:",omitempty"`
+	}
+)
+
+// runQuality measures latency, jitter and loss of tcp connections to a few anycast targets per family, runs times
+// each, and optionally the download rate from throughput-url. TCP is used instead of ICMP as it needs no
+// privileges and passes firewalls dropping ping.
+func runQuality(logger *slog.Logger, _ []string) int {
+	if offline {
+		logger.Error("quality requires network access")
+		return exitInternalError
+	}
+	targets := defaultQualityTargets
+	if qualityTargets != "" {
+		targets = splitList(qualityTargets)
+	} else if noExternal {
+		logger.Error("no quality targets configured", "err", ErrExternalDisabled)
+		return exitInternalError
+	}
+
+	report := &qualityReport{Targets: make([]*latencyResult, len(targets))}
+	var wg sync.WaitGroup
+	for idx, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Targets[idx] = measureLatency(target)
+		}()
+	}
+	wg.Wait()
+	if throughputURL != "" {
+		report.Throughput = measureThroughput(throughputURL)
+	}
+
+	if jsonOutput {
+		return printJSON(logger, report)
+	}
+	for _, r := range report.Targets {
+		if r.Loss == 1 {
+			fmt.Printf("%s\t%s\tunreachable\t%s\n", r.Family, r.Target, r.Error)
+			continue
+		}
+		fmt.Printf("%s\t%s\tmin %s\tavg %s\tmax %s\tjitter %s\tloss %.0f%%\n", r.Family, r.Target, r.Min, r.Avg, r.Max, r.Jitter, 100*r.Loss)
+	}
+	if t := report.Throughput; t != nil {
+		if t.Error != "" {
+			fmt.Printf("throughput\tfailed\t%s\n", t.Error)
+		} else {
+			fmt.Printf("throughput\t%.1f Mbit/s\t%d bytes in %s\n", t.Mbps, t.Bytes, t.Duration)
+		}
+	}
+	return exitOK
+}
+
+// measureLatency connects to target runs times in sequence and summarizes the connect times.
+func measureLatency(target string) *latencyResult {
+	r := &latencyResult{Target: target, Family: familyOf(target), Sent: runs}
+	latencies := make([]time.Duration, 0, runs)
+	for range runs {
+		attempt := &heAttempt{}
+		start := time.Now()
+		dialAttempt(attempt, networkFor(r.Family), target)
+		if attempt.Error != "" {
+			r.Error = attempt.Error
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	if runs > 0 {
+		r.Loss = 1 - float64(len(latencies))/float64(runs)
+	}
+	if len(latencies) == 0 {
+		return r
+	}
+
+	var sum, deviation time.Duration
+	r.Min, r.Max = latencies[0], latencies[0]
+	for idx, l := range latencies {
+		sum += l
+		r.Min = min(r.Min, l)
+		r.Max = max(r.Max, l)
+		if idx > 0 {
+			deviation += (l - latencies[idx-1]).Abs()
+		}
+	}
+	r.Avg = (sum / time.Duration(len(latencies))).Round(time.Microsecond)
+	if len(latencies) > 1 {
+		r.Jitter = (deviation / time.Duration(len(latencies)-1)).Round(time.Microsecond)
+	}
+	r.Min = r.Min.Round(time.Microsecond)
+	r.Max = r.Max.Round(time.Microsecond)
+	return r
+}
+
+// measureThroughput downloads the url and computes the rate. The download is cut off after the timeout, the
+// data received until then still counts.
+func measureThroughput(url string) *throughputResult {
+	r := &throughputResult{}
+	start := time.Now()
+	resp, err := newHTTPClient(nil).Get(url)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.Error = resp.Status
+		return r
+	}
+	r.Bytes, err = io.Copy(io.Discard, resp.Body)
+	r.Duration = time.Since(start).Round(time.Millisecond)
+	if err != nil && !isTimeout(err) && !errors.Is(err, net.ErrClosed) {
+		r.Error = err.Error()
+	}
+	if r.Duration > 0 {
+		r.Mbps = float64(r.Bytes) * 8 / r.Duration.Seconds() / 1e6
+	}
+	if r.Bytes == 0 && r.Error == "" {
+		r.Error = "no data received"
+	}
+	return r
+}
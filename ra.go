@@ -0,0 +1,277 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// ICMPv6 message and option types used by neighbor discovery (RFC 4861, RFC 8106)
+const (
+	icmpRouterSolicitation  = 133
+	icmpRouterAdvertisement = 134
+
+	ndOptPrefixInfo = 3
+	ndOptMTU        = 5
+	ndOptRDNSS      = 25
+	ndOptDNSSL      = 31
+)
+
+// routerPreferences names the two bit default router preference of RFC 4191
+var routerPreferences = []string{"medium", "high", "reserved", "low"}
+
+func init() {
+	registerSubsystem(&subsystem{name: "ra", commands: map[string]command{"ra": runRA}})
+}
+
+type (
+
+	// routerAdvertisement is the content of an ICMPv6 router advertisement.
+	routerAdvertisement struct {
+
+		// Router is the link-local address of the advertising router
+		Router string
+
+		// Interface is the interface the advertisement was received on
+		Interface string
+
+		// HopLimit is the hop limit hosts should use, 0 if unspecified
+		HopLimit uint8
+
+		// Managed (M flag) tells hosts to get addresses using DHCPv6
+		Managed bool
+
+		// Other (O flag) tells hosts to get other configuration like DNS servers using DHCPv6
+		Other bool
+
+		// Preference is the default router preference: low, medium or high
+		Preference string
+
+		// RouterLifetime is the time the router may be used as default router, 0s if it must not be used
+		RouterLifetime string
+
+		// MTU is the link mtu advertised by the router
+		MTU uint32 `json:",omitempty"`
+
+		// Prefixes contains the advertised prefixes
+		Prefixes []*raPrefix `json:",omitempty"`
+
+		// RDNSS contains the advertised recursive DNS servers
+		RDNSS []string `json:",omitempty"`
+
+		// RDNSSLifetime is the time the DNS servers may be used
+		RDNSSLifetime string `json:",omitempty"`
+
+		// DNSSL contains the advertised DNS search domains
+		DNSSL []string `json:",omitempty"`
+	}
+
+	// raPrefix is a prefix information option of a router advertisement.
+	raPrefix struct {
+
+		// Prefix is the advertised prefix in CIDR notation
+		Prefix string
+
+		// OnLink (L flag) marks addresses of the prefix as reachable without router
+		OnLink bool
+
+		// Autonomous (A flag) allows hosts to configure addresses of the prefix using SLAAC
+		Autonomous bool
+
+		// ValidLifetime is the time addresses of the prefix stay valid
+		ValidLifetime string
+
+		// PreferredLifetime is the time addresses of the prefix are preferred for new connections
+		PreferredLifetime string
+	}
+)
+
+// runRA solicits router advertisements on all interfaces, or the one given as argument, and prints the ones
+// received until the timeout passed. Receiving ICMPv6 requires root or CAP_NET_RAW.
+func runRA(logger *slog.Logger, args []string) int {
+	if len(args) > 1 {
+		logger.Error("usage: ips ra [interface]")
+		return exitInternalError
+	}
+	only := ""
+	if len(args) == 1 {
+		only = args[0]
+	}
+
+	conn, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		logger.Error("could not open ICMPv6 socket, root privileges are required", "err", err)
+		return exitInternalError
+	}
+	defer conn.Close()
+	solicitRouters(logger, conn.(*net.IPConn), only)
+
+	advertisements := make([]*routerAdvertisement, 0)
+	seen := make(map[string]int)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if isTimeout(err) {
+			break
+		}
+		if err != nil {
+			logger.Error("could not read from ICMPv6 socket", "err", err)
+			return exitInternalError
+		}
+		source := from.(*net.IPAddr)
+		if only != "" && source.Zone != only {
+			continue
+		}
+		ra := parseRouterAdvertisement(buf[:n])
+		if ra == nil {
+			continue
+		}
+		ra.Router, ra.Interface = source.IP.String(), source.Zone
+		// routers answering the solicitation also send unsolicited advertisements, the last one wins
+		key := ra.Router + "%" + ra.Interface
+		if idx, ok := seen[key]; ok {
+			advertisements[idx] = ra
+			continue
+		}
+		seen[key] = len(advertisements)
+		advertisements = append(advertisements, ra)
+	}
+	if len(advertisements) == 0 {
+		logger.Warn("no router advertisement received")
+	}
+
+	if jsonOutput {
+		return printJSON(logger, advertisements)
+	}
+	for _, ra := range advertisements {
+		flags := make([]string, 0, 2)
+		if ra.Managed {
+			flags = append(flags, "M")
+		}
+		if ra.Other {
+			flags = append(flags, "O")
+		}
+		router := ra.Router
+		if ra.Interface != "" {
+			router += "%" + ra.Interface
+		}
+		fmt.Printf("router\t%s\tlifetime %s\tpreference %s\tflags %s\n", router, ra.RouterLifetime, ra.Preference, strings.Join(flags, ","))
+		for _, p := range ra.Prefixes {
+			fmt.Printf("prefix\t%s\ton-link %t\tautonomous %t\tvalid %s\tpreferred %s\n", p.Prefix, p.OnLink, p.Autonomous, p.ValidLifetime, p.PreferredLifetime)
+		}
+		for _, server := range ra.RDNSS {
+			fmt.Printf("rdnss\t%s\tlifetime %s\n", server, ra.RDNSSLifetime)
+		}
+		for _, domain := range ra.DNSSL {
+			fmt.Printf("dnssl\t%s\n", domain)
+		}
+		if ra.MTU > 0 {
+			fmt.Printf("mtu\t%d\n", ra.MTU)
+		}
+	}
+	return exitOK
+}
+
+// solicitRouters sends a router solicitation to all routers on every multicast capable interface, so the
+// routers answer right away instead of at their next periodic advertisement. Failures are logged only, as
+// unsolicited advertisements are still received.
+func solicitRouters(logger *slog.Logger, conn *net.IPConn, only string) {
+	if err := setMulticastHops(conn, 255); err != nil {
+		// routers discard solicitations with a hop limit other than 255
+		logger.Debug("could not set hop limit, not soliciting", "err", err)
+		return
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logger.Debug("could not list interfaces", "err", err)
+		return
+	}
+	solicitation := []byte{icmpRouterSolicitation, 0, 0, 0, 0, 0, 0, 0}
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagMulticast == 0 || i.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if only != "" && i.Name != only {
+			continue
+		}
+		allRouters := &net.IPAddr{IP: net.ParseIP("ff02::2"), Zone: i.Name}
+		if _, err := conn.WriteTo(solicitation, allRouters); err != nil {
+			logger.Debug("could not send router solicitation", "err", err, "interface", i.Name)
+		}
+	}
+}
+
+// parseRouterAdvertisement decodes an ICMPv6 message, nil is returned for other messages or malformed ones.
+func parseRouterAdvertisement(b []byte) *routerAdvertisement {
+	if len(b) < 16 || b[0] != icmpRouterAdvertisement || b[1] != 0 {
+		return nil
+	}
+	ra := &routerAdvertisement{
+		HopLimit:       b[4],
+		Managed:        b[5]&0x80 != 0,
+		Other:          b[5]&0x40 != 0,
+		Preference:     routerPreferences[(b[5]>>3)&0x3],
+		RouterLifetime: (time.Duration(binary.BigEndian.Uint16(b[6:8])) * time.Second).String(),
+	}
+	for options := b[16:]; len(options) >= 8; {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			return nil
+		}
+		option := options[:length]
+		options = options[length:]
+		switch option[0] {
+		case ndOptPrefixInfo:
+			if length < 32 {
+				continue
+			}
+			prefix := &net.IPNet{IP: net.IP(option[16:32]), Mask: net.CIDRMask(int(option[2]), 128)}
+			ra.Prefixes = append(ra.Prefixes, &raPrefix{
+				Prefix:            prefix.String(),
+				OnLink:            option[3]&0x80 != 0,
+				Autonomous:        option[3]&0x40 != 0,
+				ValidLifetime:     formatLifetime(binary.BigEndian.Uint32(option[4:8])),
+				PreferredLifetime: formatLifetime(binary.BigEndian.Uint32(option[8:12])),
+			})
+		case ndOptMTU:
+			ra.MTU = binary.BigEndian.Uint32(option[4:8])
+		case ndOptRDNSS:
+			ra.RDNSSLifetime = formatLifetime(binary.BigEndian.Uint32(option[4:8]))
+			for servers := option[8:]; len(servers) >= 16; servers = servers[16:] {
+				ra.RDNSS = append(ra.RDNSS, net.IP(servers[:16]).String())
+			}
+		case ndOptDNSSL:
+			ra.DNSSL = append(ra.DNSSL, parseDomainNames(option[8:])...)
+		}
+	}
+	return ra
+}
+
+// parseDomainNames decodes the uncompressed DNS names of a DNSSL option, the option is padded with zeros.
+func parseDomainNames(b []byte) []string {
+	names := make([]string, 0)
+	labels := make([]string, 0)
+	for len(b) > 0 {
+		length := int(b[0])
+		b = b[1:]
+		if length == 0 {
+			if len(labels) > 0 {
+				names = append(names, strings.Join(labels, "."))
+				labels = labels[:0]
+			}
+			continue
+		}
+		if length > len(b) {
+			break
+		}
+		labels = append(labels, string(b[:length]))
+		b = b[length:]
+	}
+	return names
+}
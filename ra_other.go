@@ -0,0 +1,16 @@
+//go:build !darwin && !linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// raSupport is empty, router advertisements cannot be solicited on this platform
+const raSupport = ""
+
+// setMulticastHops is not implemented on this platform, so no router solicitations are sent.
+func setMulticastHops(_ *net.IPConn, _ int) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,27 @@
+//go:build (darwin || linux) && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// raSupport names the socket router solicitations are sent on
+const raSupport = "icmpv6"
+
+// setMulticastHops sets the hop limit of multicast packets sent using conn.
+func setMulticastHops(conn *net.IPConn, hops int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, hops)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,74 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// reachableURL is the server asked to connect back, usually another host running ips serve
+var reachableURL string
+
+// runReachable asks the server given by reachable-url to connect back to the public address of this host on the
+// ports given as arguments, or the port flag, using both address families. Exits with exitCheckFailed if any
+// port is unreachable from the internet.
+func runReachable(logger *slog.Logger, args []string) int {
+	if reachableURL == "" {
+		logger.Error("usage: ips reachable -reachable-url https://probe.example.com [port...]")
+		return exitInternalError
+	}
+	if offline {
+		logger.Error("reachable requires network access")
+		return exitInternalError
+	}
+	ports := args
+	if len(ports) == 0 {
+		ports = []string{strconv.Itoa(int(port))}
+	}
+
+	checks := make([]*check, 0)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		results, err := requestProbe(family, ports)
+		if err != nil {
+			checks = append(checks, &check{Name: family, Detail: err.Error()})
+			continue
+		}
+		for _, r := range results {
+			c := &check{Name: fmt.Sprintf("%s %d", family, r.Port), OK: r.Reachable, Detail: r.Address}
+			if r.Error != "" {
+				c.Detail += ": " + r.Error
+			}
+			checks = append(checks, c)
+		}
+	}
+	return printChecks(logger, checks)
+}
+
+// requestProbe asks the server to probe the ports, connecting to it using the given family.
+func requestProbe(family string, ports []string) ([]*probeResult, error) {
+	u, err := url.Parse(reachableURL)
+	if err != nil {
+		return nil, err
+	}
+	u = u.JoinPath("reachable")
+	u.RawQuery = url.Values{"port": ports}.Encode()
+
+	resp, err := newHTTPClient(familyTransport(family)).Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("probe failed: %s", resp.Status)
+	}
+	var results []*probeResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,281 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// reportSince is the period covered by report, set using the since flag
+var reportSince = 30 * 24 * time.Hour
+
+type (
+
+	// historyReport summarizes the history of the addresses over a period.
+	historyReport struct {
+
+		// From is the start of the covered period, the first record if the history is shorter
+		From time.Time
+
+		// To is the end of the covered period
+		To time.Time
+
+		// PublicChanges counts the changes of the public ip per family
+		PublicChanges map[string]int
+
+		// PrefixChanges counts the rotations of the delegated ipv6 prefix
+		PrefixChanges int
+
+		// Addresses contains the time every address was assigned, longest first
+		Addresses []*addressUptime
+
+		// Interfaces contains the churn per interface, the public addresses are not included
+		Interfaces []*interfaceChurn
+	}
+
+	// addressUptime is the time an address was assigned during the period.
+	addressUptime struct {
+		Address   string
+		Interface string
+		Uptime    string
+
+		// Share is the percentage of the period the address was assigned
+		Share float64
+
+		// uptime is the unformatted Uptime used for sorting
+		uptime time.Duration
+	}
+
+	// interfaceChurn counts the addresses that appeared on or disappeared from an interface.
+	interfaceChurn struct {
+		Interface string
+		Added     int
+		Removed   int
+	}
+)
+
+// markdownReport renders a report as Markdown, e.g. for mails
+var markdownReport = template.Must(template.New("markdown").Parse(`# IP address report
+
+{{ .From.Format "2006-01-02 15:04" }} to {{ .To.Format "2006-01-02 15:04" }}
+
+## Public IP
+
+| Family | Changes |
+|--------|---------|
+{{ range $family, $count := .PublicChanges }}| {{ $family }} | {{ $count }} |
+{{ end }}
+Delegated prefix changes: {{ .PrefixChanges }}
+
+## Uptime per address
+
+| Address | Interface | Uptime | Share |
+|---------|-----------|--------|-------|
+{{ range .Addresses }}| {{ .Address }} | {{ .Interface }} | {{ .Uptime }} | {{ printf "%.1f" .Share }}% |
+{{ end }}
+## Churn per interface
+
+| Interface | Added | Removed |
+|-----------|-------|---------|
+{{ range .Interfaces }}| {{ .Interface }} | {{ .Added }} | {{ .Removed }} |
+{{ end }}`))
+
+// htmlReport renders a report as standalone HTML page
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>IP address report</title></head>
+<body>
+<h1>IP address report</h1>
+<p>{{ .From.Format "2006-01-02 15:04" }} to {{ .To.Format "2006-01-02 15:04" }}</p>
+<h2>Public IP</h2>
+<table>
+<tr><th>Family</th><th>Changes</th></tr>
+{{ range $family, $count := .PublicChanges }}<tr><td>{{ $family }}</td><td>{{ $count }}</td></tr>
+{{ end }}</table>
+<p>Delegated prefix changes: {{ .PrefixChanges }}</p>
+<h2>Uptime per address</h2>
+<table>
+<tr><th>Address</th><th>Interface</th><th>Uptime</th><th>Share</th></tr>
+{{ range .Addresses }}<tr><td>{{ .Address }}</td><td>{{ .Interface }}</td><td>{{ .Uptime }}</td><td>{{ printf "%.1f" .Share }}%</td></tr>
+{{ end }}</table>
+<h2>Churn per interface</h2>
+<table>
+<tr><th>Interface</th><th>Added</th><th>Removed</th></tr>
+{{ range .Interfaces }}<tr><td>{{ .Interface }}</td><td>{{ .Added }}</td><td>{{ .Removed }}</td></tr>
+{{ end }}</table>
+</body>
+</html>`))
+
+// runReport summarizes the history recorded by watch over the period given by since: how often the public ip
+// changed, how long every address was assigned and the churn per interface. The output flag selects text, json,
+// markdown or html.
+func runReport(logger *slog.Logger, _ []string) int {
+	records, err := loadHistory()
+	if err != nil {
+		logger.Error("could not read history", "err", err)
+		return exitInternalError
+	}
+	if len(records) == 0 {
+		logger.Warn("history is empty, it is recorded by watch")
+	}
+	report := buildReport(records, time.Now().Add(-reportSince), time.Now())
+
+	switch {
+	case outputFormat == "json" || (jsonOutput && outputFormat == "text"):
+		return printJSON(logger, report)
+	case outputFormat == "markdown":
+		err = markdownReport.Execute(os.Stdout, report)
+	case outputFormat == "html":
+		err = htmlReport.Execute(os.Stdout, report)
+	case outputFormat == "text":
+		printReport(report)
+	default:
+		logger.Error("unknown output format", "output", outputFormat)
+		return exitInternalError
+	}
+	if err != nil {
+		logger.Error("could not render report", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// buildReport replays the records to compute the report for the period between from and to. Records before
+// the period determine the addresses assigned at its start.
+func buildReport(records []*historyRecord, from, to time.Time) *historyReport {
+	report := &historyReport{From: from, To: to, PublicChanges: map[string]int{"ipv4": 0, "ipv6": 0}}
+	if len(records) > 0 && records[0].Time.After(from) {
+		report.From = records[0].Time
+	}
+	inPeriod := func(t time.Time) bool { return !t.Before(from) && !t.After(to) }
+
+	since := make(map[string]time.Time)
+	known := make(map[string]*ip)
+	uptime := make(map[string]time.Duration)
+	lastPublic := make(map[string]string)
+	churn := make(map[string]*interfaceChurn)
+	countChurn := func(i *ip) *interfaceChurn {
+		if churn[i.Interface] == nil {
+			churn[i.Interface] = &interfaceChurn{Interface: i.Interface}
+		}
+		return churn[i.Interface]
+	}
+	end := func(key string, at time.Time) {
+		start := since[key]
+		if start.Before(from) {
+			start = from
+		}
+		if at.After(to) {
+			at = to
+		}
+		if at.After(start) {
+			uptime[key] += at.Sub(start)
+		}
+		delete(since, key)
+	}
+
+	for _, r := range records {
+		if r.Time.After(to) {
+			break
+		}
+		if r.Snapshot {
+			present := make(map[string]bool, len(r.Added))
+			for _, i := range r.Added {
+				present[i.key()] = true
+			}
+			for key := range since {
+				if !present[key] {
+					end(key, r.Time)
+				}
+			}
+		}
+		for _, i := range r.Removed {
+			if _, ok := since[i.key()]; !ok {
+				continue
+			}
+			end(i.key(), r.Time)
+			if !i.isPublic() && inPeriod(r.Time) {
+				countChurn(i).Removed++
+			}
+		}
+		for _, i := range r.Added {
+			if i.isPublic() {
+				if last := lastPublic[i.family()]; last != "" && last != i.Address && inPeriod(r.Time) {
+					report.PublicChanges[i.family()]++
+				}
+				lastPublic[i.family()] = i.Address
+			}
+			if _, ok := since[i.key()]; ok {
+				continue
+			}
+			since[i.key()], known[i.key()] = r.Time, i
+			if !i.isPublic() && !r.Snapshot && inPeriod(r.Time) {
+				countChurn(i).Added++
+			}
+		}
+		if r.Prefix != nil && inPeriod(r.Time) {
+			report.PrefixChanges++
+		}
+	}
+	for key := range since {
+		end(key, to)
+	}
+
+	period := to.Sub(report.From)
+	report.Addresses = make([]*addressUptime, 0, len(uptime))
+	for key, d := range uptime {
+		u := &addressUptime{Address: known[key].Address, Interface: known[key].Interface, Uptime: formatAge(d), uptime: d}
+		if period > 0 {
+			u.Share = 100 * float64(d) / float64(period)
+		}
+		report.Addresses = append(report.Addresses, u)
+	}
+	sort.Slice(report.Addresses, func(a, b int) bool {
+		if report.Addresses[a].uptime != report.Addresses[b].uptime {
+			return report.Addresses[a].uptime > report.Addresses[b].uptime
+		}
+		return report.Addresses[a].Address < report.Addresses[b].Address
+	})
+	report.Interfaces = make([]*interfaceChurn, 0, len(churn))
+	for _, c := range churn {
+		report.Interfaces = append(report.Interfaces, c)
+	}
+	sort.Slice(report.Interfaces, func(a, b int) bool {
+		return report.Interfaces[a].Interface < report.Interfaces[b].Interface
+	})
+	return report
+}
+
+// printReport prints the report as tab separated lines.
+func printReport(report *historyReport) {
+	fmt.Printf("period\t%s\t%s\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	fmt.Printf("public changes\tipv4 %d\tipv6 %d\n", report.PublicChanges["ipv4"], report.PublicChanges["ipv6"])
+	fmt.Printf("prefix changes\t%d\n", report.PrefixChanges)
+	for _, u := range report.Addresses {
+		fmt.Printf("uptime\t%s\t%s\t%s\t%.1f%%\n", u.Address, u.Interface, u.Uptime, u.Share)
+	}
+	for _, c := range report.Interfaces {
+		fmt.Printf("churn\t%s\t+%d\t-%d\n", c.Interface, c.Added, c.Removed)
+	}
+}
+
+// formatAge renders a duration rounded to minutes, durations of a day or longer start with the days.
+func formatAge(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	rest := strings.TrimSuffix((d % (24 * time.Hour)).String(), "0s")
+	if rest == "" {
+		rest = "0m"
+	}
+	if days == 0 {
+		return rest
+	}
+	return fmt.Sprintf("%dd%s", days, rest)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+// route describes the local end the operating system selects to reach a destination.
+type route struct {
+
+	// Destination is the address of the target
+	Destination string
+
+	// Source is the local address chosen as source for packets to Destination
+	Source string `json:",omitempty"`
+
+	// Interface is the name of the interface owning Source
+	Interface string `json:",omitempty"`
+
+	// Error describes why no route could be determined
+	Error string `json:",omitempty"`
+}
+
+// runRouteTo reports the local interface and source address the operating system would pick for every address
+// of the destination given as first argument. No traffic is sent, connecting a udp socket only performs the
+// routing lookup and source address selection.
+func runRouteTo(logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		logger.Error("usage: ips route-to <destination>")
+		return exitInternalError
+	}
+
+	var destinations []net.IP
+	if parsed := net.ParseIP(args[0]); parsed != nil {
+		destinations = []net.IP{parsed}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, args[0])
+		if err != nil {
+			logger.Error("could not resolve destination", "err", err, "destination", args[0])
+			return exitInternalError
+		}
+		for _, a := range addrs {
+			destinations = append(destinations, a.IP)
+		}
+	}
+
+	routes := make([]*route, 0, len(destinations))
+	for _, d := range destinations {
+		routes = append(routes, routeTo(d))
+	}
+
+	if jsonOutput {
+		return printJSON(logger, routes)
+	}
+	for _, r := range routes {
+		if r.Error != "" {
+			fmt.Printf("%s\tunreachable\t%s\n", r.Destination, r.Error)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", r.Destination, r.Source, r.Interface)
+	}
+	return exitOK
+}
+
+// routeTo determines the source address and interface used to reach destination.
+func routeTo(destination net.IP) *route {
+	r := &route{Destination: destination.String()}
+	conn, err := net.Dial("udp", net.JoinHostPort(destination.String(), strconv.Itoa(int(port))))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer conn.Close()
+	source := conn.LocalAddr().(*net.UDPAddr).IP
+	r.Source = source.String()
+	r.Interface = interfaceForIP(source)
+	return r
+}
@@ -0,0 +1,160 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+var (
+	// routerURL is the base url of the admin API of the router, defaults to the default gateway
+	routerURL string
+
+	// routerUser and routerPassword log in to the admin API of the router
+	routerUser, routerPassword string
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "routers",
+		flags: func() {
+			flag.StringVar(&routerURL, "router-url", "", "admin API of the router asked by the fritzbox and openwrt providers, defaults to the default gateway")
+			flag.StringVar(&routerUser, "router-user", "", "user logging in to the admin API of the router, root for openwrt if empty")
+			flag.StringVar(&routerPassword, "router-password", "", "password of the router user")
+		},
+		urls: []*string{&routerURL},
+		providers: []*provider{
+			{Name: "fritzbox", Lookup: fritzboxLookup},
+			{Name: "openwrt", Lookup: openwrtLookup},
+		},
+	})
+}
+
+// routerBase returns the base url of the admin API of the router, the default gateway using plain http on port if
+// -router-url is not set. The router is part of the local network, it is not considered a third party service.
+func routerBase(port string) (string, error) {
+	if routerURL != "" {
+		return strings.TrimSuffix(routerURL, "/"), nil
+	}
+	gw, err := defaultGateway()
+	if err != nil {
+		return "", err
+	}
+	base := "http://" + gw.String()
+	if port != "" {
+		base += ":" + port
+	}
+	trustURL(base)
+	return base, nil
+}
+
+// routerLabels returns the labels describing the uplink of a router, empty values are left out.
+func routerLabels(router, connection string, uptime time.Duration, prefix string) map[string]string {
+	labels := map[string]string{"router": router}
+	if connection != "" {
+		labels["connection"] = connection
+	}
+	if uptime > 0 {
+		labels["uptime"] = uptime.String()
+	}
+	if prefix != "" {
+		labels["prefix"] = prefix
+	}
+	return labels
+}
+
+// digestTransport answers HTTP digest challenges (RFC 7616, MD5) using the router credentials, as required by
+// the TR-064 interface of the Fritz!Box.
+type digestTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip sends the request and repeats it with credentials if the server asks for digest authentication.
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || routerUser == "" && routerPassword == "" {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "Digest ") || req.GetBody == nil && req.Body != nil {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	authorization, err := digestAuthorization(challenge, req.Method, req.URL.RequestURI())
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", authorization)
+	return next.RoundTrip(retry)
+}
+
+// digestAuthorization returns the Authorization header answering the digest challenge for a request.
+func digestAuthorization(challenge, method, uri string) (string, error) {
+	params := make(map[string]string)
+	for _, part := range digestParams(strings.TrimPrefix(challenge, "Digest ")) {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash(routerUser + ":" + params["realm"] + ":" + routerPassword)
+	ha2 := hash(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, routerUser, params["realm"], params["nonce"], uri)
+	if qop := params["qop"]; qop == "" {
+		header += fmt.Sprintf(`, response="%s"`, hash(ha1+":"+params["nonce"]+":"+ha2))
+	} else {
+		nonce := make([]byte, 8)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		cnonce := hex.EncodeToString(nonce)
+		response := hash(ha1 + ":" + params["nonce"] + ":00000001:" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`, cnonce, response)
+	}
+	if opaque := params["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return header, nil
+}
+
+// digestParams splits the parameters of a digest challenge at the commas outside of quoted values.
+func digestParams(challenge string) []string {
+	parts := make([]string, 0)
+	quoted := false
+	start := 0
+	for i, r := range challenge {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, challenge[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, challenge[start:])
+}
@@ -0,0 +1,124 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDigestParams(t *testing.T) {
+	tests := []struct {
+		challenge string
+		want      []string
+	}{
+		{`realm="router", nonce="abc"`, []string{`realm="router"`, ` nonce="abc"`}},
+		{`realm="a, b", qop="auth,auth-int", nonce="x"`, []string{`realm="a, b"`, ` qop="auth,auth-int"`, ` nonce="x"`}},
+		{`algorithm=MD5,stale=false`, []string{`algorithm=MD5`, `stale=false`}},
+		{`realm="unterminated, nonce="x"`, []string{`realm="unterminated, nonce="x"`}},
+		{``, []string{``}},
+	}
+	for _, tt := range tests {
+		if got := digestParams(tt.challenge); !slices.Equal(got, tt.want) {
+			t.Errorf("digestParams(%q) = %q, want %q", tt.challenge, got, tt.want)
+		}
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	previousUser, previousPassword := routerUser, routerPassword
+	t.Cleanup(func() { routerUser, routerPassword = previousUser, previousPassword })
+	// the example of RFC 2617 section 3.5
+	routerUser, routerPassword = "Mufasa", "Circle Of Life"
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5hex("Mufasa:testrealm@host.com:Circle Of Life")
+	ha2 := md5hex("GET:/dir/index.html")
+	nonce := "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+
+	tests := []struct {
+		name      string
+		challenge string
+		// want lists parameters the header must contain, response is checked against the cnonce sent
+		want    []string
+		qop     bool
+		wantErr bool
+	}{
+		{
+			name:      "rfc 2617",
+			challenge: `Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="` + nonce + `", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+			want: []string{`username="Mufasa"`, `realm="testrealm@host.com"`, `nonce="` + nonce + `"`, `uri="/dir/index.html"`,
+				`qop=auth`, `nc=00000001`, `opaque="5ccc069c403ebaf9f0171e9517f40e41"`},
+			qop: true,
+		},
+		{
+			name:      "rfc 2069 without qop",
+			challenge: `Digest realm="testrealm@host.com", nonce="` + nonce + `"`,
+			want: []string{`username="Mufasa"`, `realm="testrealm@host.com"`, `uri="/dir/index.html"`,
+				`response="` + md5hex(ha1+":"+nonce+":"+ha2) + `"`},
+		},
+		{
+			name:      "algorithm md5 in any case",
+			challenge: `Digest realm="testrealm@host.com", nonce="` + nonce + `", algorithm=md5`,
+			want:      []string{`response="` + md5hex(ha1+":"+nonce+":"+ha2) + `"`},
+		},
+		{
+			name:      "keys in any case",
+			challenge: `Digest Realm="testrealm@host.com", NONCE="` + nonce + `"`,
+			want:      []string{`response="` + md5hex(ha1+":"+nonce+":"+ha2) + `"`},
+		},
+		{name: "sha-256", challenge: `Digest realm="r", nonce="n", algorithm=SHA-256`, wantErr: true},
+		{name: "md5-sess", challenge: `Digest realm="r", nonce="n", algorithm=MD5-sess`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := digestAuthorization(tt.challenge, "GET", "/dir/index.html")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(header, "Digest ") {
+				t.Errorf("header %s is not a digest", header)
+			}
+			params := make(map[string]string)
+			for _, part := range digestParams(strings.TrimPrefix(header, "Digest ")) {
+				key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+				params[key] = value
+			}
+			for _, want := range tt.want {
+				key, value, _ := strings.Cut(want, "=")
+				if params[key] != value {
+					t.Errorf("%s is %s, want %s in %s", key, params[key], value, header)
+				}
+			}
+			if !tt.qop {
+				if _, ok := params["cnonce"]; ok {
+					t.Errorf("cnonce sent without qop: %s", header)
+				}
+				return
+			}
+			cnonce := strings.Trim(params["cnonce"], `"`)
+			if cnonce == "" {
+				t.Fatalf("cnonce missing: %s", header)
+			}
+			want := md5hex(ha1 + ":" + nonce + ":00000001:" + cnonce + ":auth:" + ha2)
+			if params["response"] != `"`+want+`"` {
+				t.Errorf("response is %s, want %s", params["response"], want)
+			}
+		})
+	}
+	// the RFC gives the response for its client nonce
+	if got := md5hex(ha1 + ":" + nonce + ":00000001:0a4f113b:auth:" + ha2); got != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("rfc 2617 response is %s", got)
+	}
+}
@@ -0,0 +1,290 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ruleFields are the fields of an event expressions of the rule language can test, labels are tested as label.name
+var ruleFields = []string{"event", "action", "address", "interface", "family", "prefix"}
+
+type (
+
+	// ruleExpr is a compiled expression of the rule language, it reports whether the fields of an event match.
+	ruleExpr func(fields map[string]string) bool
+
+	// ruleToken is a token of an expression, quoted is set for string literals.
+	ruleToken struct {
+		text   string
+		quoted bool
+	}
+
+	// ruleParser is a recursive descent parser of the rule language.
+	ruleParser struct {
+		tokens []ruleToken
+		pos    int
+	}
+
+	// routingRule routes the events matching an expression to sinks, given as "expression -> sink[,sink...]".
+	routingRule struct {
+		match ruleExpr
+		sinks []string
+
+		// flag is the flag value the rule was parsed from
+		flag string
+	}
+
+	// routeValues is the flag.Value collecting the route flags.
+	routeValues struct{}
+)
+
+// routingRules are the rules given using the route option
+var routingRules []*routingRule
+
+// Set parses and adds a routing rule.
+func (routeValues) Set(value string) error {
+	index := strings.LastIndex(value, "->")
+	if index < 0 {
+		return fmt.Errorf("route %q is not of the form 'expression -> sink[,sink...]'", value)
+	}
+	match, err := parseRuleExpr(value[:index])
+	if err != nil {
+		return fmt.Errorf("route %q: %w", value, err)
+	}
+	sinks := splitList(value[index+2:])
+	if len(sinks) == 0 {
+		return fmt.Errorf("route %q names no sink", value)
+	}
+	routingRules = append(routingRules, &routingRule{match: match, sinks: sinks, flag: value})
+	return nil
+}
+
+// String returns the routing rules as given, one per line.
+func (routeValues) String() string {
+	return strings.Join(routeValues{}.values(), "\n")
+}
+
+// reset removes all routing rules.
+func (routeValues) reset() {
+	routingRules = nil
+}
+
+// values returns the routing rules as given.
+func (routeValues) values() []string {
+	values := make([]string, 0, len(routingRules))
+	for _, r := range routingRules {
+		values = append(values, r.flag)
+	}
+	return values
+}
+
+// addressFields returns the fields of the event of an address being added or removed.
+func addressFields(i *ip, action string) map[string]string {
+	event := "interface"
+	if i.isPublic() {
+		event = "public"
+	}
+	fields := map[string]string{"event": event, "action": action, "address": i.Address, "interface": i.Interface, "family": i.family()}
+	for k, v := range i.Labels {
+		fields["label."+k] = v
+	}
+	return fields
+}
+
+// prefixFields returns the fields of the event of the delegated prefix changing.
+func prefixFields(p *prefixChange) map[string]string {
+	return map[string]string{"event": "prefix", "action": "changed", "prefix": strings.Join(p.Current, ","), "family": "ipv6"}
+}
+
+// parseRuleExpr compiles an expression of the rule language. Comparisons test a field against a value using ==,
+// !=, ~ (a shell pattern like interface filters use) or in (comma separated networks), a field alone tests for
+// being set. Comparisons are combined using !, && and || and grouped using parentheses. Values containing spaces
+// or operators are enclosed in double quotes:
+//
+//	event == public && family == ipv4
+//	interface ~ "wg*" || label.purpose == vpn
+//	action == added && address in 10.0.0.0/8,fd00::/8
+func parseRuleExpr(source string) (ruleExpr, error) {
+	tokens, err := tokenizeRule(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &ruleParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// tokenizeRule splits an expression into operators, words and quoted strings.
+func tokenizeRule(source string) ([]ruleToken, error) {
+	tokens := make([]ruleToken, 0)
+	for i := 0; i < len(source); {
+		switch c := source[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := strings.IndexByte(source[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			tokens = append(tokens, ruleToken{text: source[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case strings.HasPrefix(source[i:], "&&"), strings.HasPrefix(source[i:], "||"),
+			strings.HasPrefix(source[i:], "=="), strings.HasPrefix(source[i:], "!="):
+			tokens = append(tokens, ruleToken{text: source[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()!~", rune(c)):
+			tokens = append(tokens, ruleToken{text: string(c)})
+			i++
+		default:
+			end := i
+			for end < len(source) && !strings.ContainsRune(" \t\"()!~&|=", rune(source[end])) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected %q", source[i:i+1])
+			}
+			tokens = append(tokens, ruleToken{text: source[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// peek reports whether the next token is the operator text.
+func (p *ruleParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text
+}
+
+// or parses terms combined using ||.
+func (p *ruleParser) or() (ruleExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(fields map[string]string) bool { return l(fields) || right(fields) }
+	}
+	return left, nil
+}
+
+// and parses terms combined using &&.
+func (p *ruleParser) and() (ruleExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(fields map[string]string) bool { return l(fields) && right(fields) }
+	}
+	return left, nil
+}
+
+// unary parses a negation, a group or a comparison.
+func (p *ruleParser) unary() (ruleExpr, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(fields map[string]string) bool { return !expr(fields) }, nil
+	case p.peek("("):
+		p.pos++
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a field, optionally compared to a value.
+func (p *ruleParser) comparison() (ruleExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	field := p.tokens[p.pos]
+	if field.quoted || (!slices.Contains(ruleFields, field.text) && !strings.HasPrefix(field.text, "label.")) {
+		return nil, fmt.Errorf("unknown field %q", field.text)
+	}
+	p.pos++
+	name := field.text
+	if p.pos >= len(p.tokens) || !(p.peek("==") || p.peek("!=") || p.peek("~") || p.peek("in")) {
+		return func(fields map[string]string) bool { return fields[name] != "" }, nil
+	}
+	operator := p.tokens[p.pos].text
+	p.pos++
+	if p.pos >= len(p.tokens) || (!p.tokens[p.pos].quoted && strings.ContainsAny(p.tokens[p.pos].text, "()!~&|=")) {
+		return nil, fmt.Errorf("missing value after %s", operator)
+	}
+	value := p.tokens[p.pos].text
+	p.pos++
+
+	switch operator {
+	case "==":
+		return func(fields map[string]string) bool { return fields[name] == value }, nil
+	case "!=":
+		return func(fields map[string]string) bool { return fields[name] != value }, nil
+	case "~":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", value, err)
+		}
+		return func(fields map[string]string) bool {
+			matched, _ := path.Match(value, fields[name])
+			return matched
+		}, nil
+	}
+	networks := make([]*net.IPNet, 0)
+	for _, n := range splitList(value) {
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", n)
+		}
+		networks = append(networks, network)
+	}
+	return func(fields map[string]string) bool {
+		for _, v := range splitList(fields[name]) {
+			address, _, _ := strings.Cut(v, "/")
+			parsed := net.ParseIP(address)
+			for _, n := range networks {
+				if parsed != nil && n.Contains(parsed) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
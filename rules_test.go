@@ -0,0 +1,116 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRuleExpr(t *testing.T) {
+	public := map[string]string{"event": "public", "action": "added", "address": "203.0.113.7", "interface": "public IPV4", "family": "ipv4"}
+	vpn := map[string]string{"event": "interface", "action": "removed", "address": "fd00::7/64", "interface": "wg0", "family": "ipv6", "label.purpose": "vpn"}
+	prefix := map[string]string{"event": "prefix", "action": "changed", "prefix": "2001:db8:1::/56,2001:db8:2::/56", "family": "ipv6"}
+	tests := []struct {
+		name   string
+		source string
+		// want lists whether the expression matches public, vpn and prefix
+		want    []bool
+		wantErr bool
+	}{
+		{name: "equal", source: "event == public", want: []bool{true, false, false}},
+		{name: "not equal", source: "family != ipv4", want: []bool{false, true, true}},
+		{name: "pattern", source: `interface ~ "wg*"`, want: []bool{false, true, false}},
+		{name: "pattern with class", source: "interface ~ wg[0-9]", want: []bool{false, true, false}},
+		{name: "field set", source: "label.purpose", want: []bool{false, true, false}},
+		{name: "field not set", source: "!prefix", want: []bool{true, true, false}},
+		{name: "label compared", source: "label.purpose == vpn", want: []bool{false, true, false}},
+		{name: "unset label", source: "label.owner == alice", want: []bool{false, false, false}},
+		{name: "in network", source: "address in 203.0.113.0/24", want: []bool{true, false, false}},
+		{name: "in networks with length", source: "address in 10.0.0.0/8,fd00::/8", want: []bool{false, true, false}},
+		{name: "prefix in network", source: "prefix in 2001:db8:2::/48", want: []bool{false, false, true}},
+		{name: "and", source: "family == ipv6 && action == removed", want: []bool{false, true, false}},
+		{name: "or", source: "event == public || event == prefix", want: []bool{true, false, true}},
+		{name: "and binds tighter than or", source: "event == prefix || event == interface && family == ipv4", want: []bool{false, false, true}},
+		{name: "and binds tighter than or on the left", source: "event == interface && family == ipv4 || event == prefix", want: []bool{false, false, true}},
+		{name: "parentheses", source: "(event == prefix || event == interface) && family == ipv6", want: []bool{false, true, true}},
+		{name: "nested parentheses", source: "((event == public))", want: []bool{true, false, false}},
+		{name: "negation binds tighter than and", source: "!event == public && family == ipv4", want: []bool{false, false, false}},
+		{name: "negated group", source: "!(event == public || event == prefix)", want: []bool{false, true, false}},
+		{name: "double negation", source: "!!label.purpose", want: []bool{false, true, false}},
+		{name: "quoted value with spaces", source: `interface == "public IPV4"`, want: []bool{true, false, false}},
+		{name: "quoted operators", source: `label.purpose != "a && b"`, want: []bool{true, true, true}},
+		{name: "no spaces", source: "event==public&&family!=ipv6", want: []bool{true, false, false}},
+		{name: "tabs", source: "event\t==\tpublic", want: []bool{true, false, false}},
+		{name: "empty", source: "", wantErr: true},
+		{name: "blank", source: "   ", wantErr: true},
+		{name: "unknown field", source: "host == a", wantErr: true},
+		{name: "quoted field", source: `"event" == public`, wantErr: true},
+		{name: "missing value", source: "event ==", wantErr: true},
+		{name: "operator as value", source: "event == &&", wantErr: true},
+		{name: "missing right operand", source: "event == public &&", wantErr: true},
+		{name: "missing left operand", source: "|| event == public", wantErr: true},
+		{name: "lone negation", source: "!", wantErr: true},
+		{name: "missing )", source: "(event == public", wantErr: true},
+		{name: "unexpected )", source: "event == public)", wantErr: true},
+		{name: "empty group", source: "()", wantErr: true},
+		{name: "unterminated quote", source: `interface == "wg0`, wantErr: true},
+		{name: "single ampersand", source: "event == public & family == ipv4", wantErr: true},
+		{name: "single equals", source: "event = public", wantErr: true},
+		{name: "trailing value", source: "event == public ipv4", wantErr: true},
+		{name: "invalid pattern", source: "interface ~ wg[", wantErr: true},
+		{name: "invalid network", source: "address in 10.0.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parseRuleExpr(tt.source)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %q, want an error", tt.source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []bool{expr(public), expr(vpn), expr(prefix)}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%q matches %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteValuesSet(t *testing.T) {
+	t.Cleanup(routeValues{}.reset)
+	tests := []struct {
+		value     string
+		wantSinks []string
+		wantErr   bool
+	}{
+		{value: "event == public -> mail", wantSinks: []string{"mail"}},
+		{value: "family == ipv6->chat, hook", wantSinks: []string{"chat", "hook"}},
+		{value: `interface == "a->b" -> hook`, wantSinks: []string{"hook"}},
+		{value: "event == public", wantErr: true},
+		{value: "event == public ->", wantErr: true},
+		{value: " -> mail", wantErr: true},
+		{value: "host == a -> mail", wantErr: true},
+	}
+	for _, tt := range tests {
+		routeValues{}.reset()
+		err := routeValues{}.Set(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) succeeded, want an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): %v", tt.value, err)
+			continue
+		}
+		if len(routingRules) != 1 || !slices.Equal(routingRules[0].sinks, tt.wantSinks) || routingRules[0].flag != tt.value {
+			t.Errorf("Set(%q) added %+v, want sinks %q", tt.value, routingRules, tt.wantSinks)
+		}
+	}
+}
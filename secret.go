@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	goflag "flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// secretScheme prefixes values that are read from a secret store instead of being given literally
+const secretScheme = "secret://"
+
+// secretOptions lists the options whose value may be a secret:// uri, the values of header are resolved on parsing.
+// URLs may carry credentials, e.g. a token in the path of a webhook.
+var secretOptions = []string{"provider-url", "alert-webhook", "reachable-url", "throughput-url", "geoip-url", "ipam-url", "ipam-token", "proxmox-url", "proxmox-token", "router-password", "push-token", "policy-webhook"}
+
+// secretRefs maps options whose value was resolved from a secret to the secret:// uri given
+var secretRefs = make(map[string]string)
+
+// resolveSecretOptions replaces the values of secretOptions referencing a secret by the secret.
+func resolveSecretOptions() error {
+	for _, name := range secretOptions {
+		f := goflag.Lookup(name)
+		if f == nil {
+			// the option belongs to a subsystem left out of the build
+			continue
+		}
+		ref := f.Value.String()
+		secret, err := resolveSecret(ref)
+		if err != nil {
+			return err
+		}
+		if secret == ref {
+			continue
+		}
+		if err := f.Value.Set(secret); err != nil {
+			return err
+		}
+		secretRefs[name] = ref
+	}
+	return nil
+}
+
+// redactSecret returns the secret:// uri an option holding value was resolved from, value itself otherwise.
+func redactSecret(value string) string {
+	for name, ref := range secretRefs {
+		if goflag.Lookup(name).Value.String() == value {
+			return ref
+		}
+	}
+	return value
+}
+
+// resolveSecret returns value unchanged unless it is a secret:// uri, which is replaced by the secret it
+// references. Supported backends are
+//
+//   - secret://env/NAME reads the environment variable NAME
+//   - secret://file/path/to/file reads the file /path/to/file
+//   - secret://systemd/NAME reads the credential NAME passed by systemd (LoadCredential=)
+//   - secret://pass/path/in/store reads the first line of the pass entry
+//   - secret://keychain/service/account reads a generic password from the macOS keychain
+//   - secret://keyring/service/account reads a secret from the keyring using secret-tool (libsecret)
+//
+// A trailing newline of the secret is removed.
+func resolveSecret(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, secretScheme)
+	if !ok {
+		return value, nil
+	}
+	backend, ref, _ := strings.Cut(rest, "/")
+	if ref == "" {
+		return "", fmt.Errorf("secret %q does not name a secret", value)
+	}
+
+	var (
+		secret []byte
+		err    error
+	)
+	switch backend {
+	case "env":
+		s, found := os.LookupEnv(ref)
+		if !found {
+			return "", fmt.Errorf("environment variable %s of secret %q is not set", ref, value)
+		}
+		secret = []byte(s)
+	case "file":
+		if !filepath.IsAbs(ref) {
+			ref = "/" + ref
+		}
+		secret, err = os.ReadFile(ref)
+	case "systemd":
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return "", fmt.Errorf("secret %q requires a systemd credential, CREDENTIALS_DIRECTORY is not set", value)
+		}
+		secret, err = os.ReadFile(filepath.Join(dir, filepath.Base(ref)))
+	case "pass":
+		secret, err = secretCommand("pass", "show", ref)
+		secret, _, _ = bytes.Cut(secret, []byte("\n"))
+	case "keychain", "keyring":
+		service, account, found := strings.Cut(ref, "/")
+		if !found || service == "" || account == "" {
+			return "", fmt.Errorf("secret %q is not of the form secret://%s/service/account", value, backend)
+		}
+		if backend == "keychain" {
+			secret, err = secretCommand("security", "find-generic-password", "-w", "-s", service, "-a", account)
+		} else {
+			secret, err = secretCommand("secret-tool", "lookup", "service", service, "account", account)
+		}
+	default:
+		return "", fmt.Errorf("unknown secret backend %q in %q", backend, value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read secret %q: %w", value, err)
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// secretCommand runs a secret store's command line tool and returns its output. The error contains the message
+// printed by the tool, the secret never is.
+func secretCommand(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("%s: %s", name, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,548 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+const (
+	// defaultUpdateURL is the GitHub API of the repository releases are published in
+	defaultUpdateURL = "https://api.github.com/repos/sascha-andres/ips"
+
+	// maxArchiveBytes limits the size of a downloaded release archive
+	maxArchiveBytes = 200 << 20
+
+	// downloadTimeout is the time downloading a release archive may take
+	downloadTimeout = 5 * time.Minute
+)
+
+// releaseKey is the base64 encoded PKIX public key release checksums are signed with, set during the build using
+// ldflags
+var releaseKey = ""
+
+var (
+	// updateChannel selects the releases self-update considers, stable or prerelease
+	updateChannel string
+
+	// updateURL is the base url of a GitHub compatible releases API, defaults to the ips repository on GitHub
+	updateURL string
+
+	// updateKey is a PEM file holding the public key release checksums are signed with, replacing releaseKey
+	updateKey string
+
+	// skipSignature allows to install releases without a release key, the checksums published with the release
+	// prove nothing about who published it
+	skipSignature bool
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "self-update",
+		commands: map[string]command{"self-update": runSelfUpdate},
+		flags: func() {
+			flag.StringVar(&updateChannel, "channel", "stable", "releases considered by self-update: stable or prerelease")
+			flag.StringVar(&updateURL, "update-url", "", "base url of a GitHub compatible releases API self-update uses, defaults to the ips repository")
+			flag.StringVar(&updateKey, "update-key", "", "PEM file of the public key release checksums are signed with, defaults to the key built in")
+			flag.BoolVar(&skipSignature, "insecure-skip-signature", false, "let self-update install releases without verifying the signature of their checksums if no release key is known")
+		},
+		urls: []*string{&updateURL},
+	})
+}
+
+type (
+
+	// release is a release as listed by the GitHub API.
+	release struct {
+		Tag        string          `json:"tag_name"`
+		Draft      bool            `json:"draft"`
+		Prerelease bool            `json:"prerelease"`
+		Assets     []*releaseAsset `json:"assets"`
+	}
+
+	// releaseAsset is a file attached to a release.
+	releaseAsset struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	}
+
+	// updateResult is the outcome of ips self-update.
+	updateResult struct {
+		Current string
+		Latest  string
+		Channel string
+
+		// Asset is the archive the new binary was taken from, empty if no update was installed
+		Asset string `json:",omitempty"`
+
+		// Signed is set if the signature of the checksums was verified
+		Signed bool
+
+		// Updated is set if the binary was, or with dry-run would have been, replaced
+		Updated bool
+	}
+)
+
+// runSelfUpdate replaces the running binary by the newest release of the channel:
+//
+//	ips self-update [-channel stable|prerelease]
+//	ips self-update check
+//
+// The archive is verified against the checksums published with the release, the checksums against their
+// signature. Without a release key nothing is installed unless insecure-skip-signature is set. The binary is
+// replaced atomically by renaming. check only reports whether a newer release exists and exits with
+// exitCheckFailed if so. With dry-run set the update is downloaded and verified without replacing the binary.
+func runSelfUpdate(logger *slog.Logger, args []string) int {
+	if len(args) > 1 || len(args) == 1 && args[0] != "check" {
+		logger.Error("usage: ips self-update [check] [-channel stable|prerelease]")
+		return exitInternalError
+	}
+	if updateChannel != "stable" && updateChannel != "prerelease" {
+		logger.Error("unknown channel, use stable or prerelease", "channel", updateChannel)
+		return exitInternalError
+	}
+	if offline {
+		logger.Error("self-update requires network access")
+		return exitInternalError
+	}
+
+	latest, err := latestRelease()
+	if err != nil {
+		logger.Error("could not list releases", "err", err)
+		return exitInternalError
+	}
+	result := &updateResult{Current: version, Latest: latest.Tag, Channel: updateChannel}
+	code := exitOK
+	switch {
+	case compareVersions(latest.Tag, version) <= 0:
+	case len(args) == 1:
+		code = exitCheckFailed
+	default:
+		if result.Asset, result.Signed, err = installRelease(logger, latest); err != nil {
+			logger.Error("could not update", "err", err, "release", latest.Tag)
+			return exitInternalError
+		}
+		result.Updated = true
+	}
+
+	if jsonOutput {
+		if printJSON(logger, result) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	switch {
+	case code == exitCheckFailed:
+		fmt.Printf("update available\t%s\t%s\n", result.Current, result.Latest)
+	case !result.Updated:
+		fmt.Printf("up to date\t%s\n", result.Current)
+	case dryRun:
+		fmt.Printf("would update\t%s\t%s\t%s\n", result.Current, result.Latest, result.Asset)
+	default:
+		fmt.Printf("updated\t%s\t%s\t%s\n", result.Current, result.Latest, result.Asset)
+	}
+	return code
+}
+
+// latestRelease returns the release with the highest version of the channel.
+func latestRelease() (*release, error) {
+	base := cmp.Or(updateURL, defaultUpdateURL)
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+"/releases?per_page=50", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := newHTTPClient(nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("releases answered with %s", resp.Status)
+	}
+	var releases []*release
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&releases); err != nil {
+		return nil, err
+	}
+
+	var latest *release
+	for _, r := range releases {
+		if r.Draft || r.Prerelease && updateChannel != "prerelease" {
+			continue
+		}
+		if latest == nil || compareVersions(r.Tag, latest.Tag) > 0 {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no %s release found", updateChannel)
+	}
+	return latest, nil
+}
+
+// installRelease downloads the archive of the release built for this platform, verifies it and replaces the
+// running binary by the one contained. It returns the name of the archive and whether the checksums were signed.
+func installRelease(logger *slog.Logger, r *release) (string, bool, error) {
+	name := archiveName()
+	archive, checksums := r.asset(name), r.assetSuffix("checksums.txt")
+	if archive == nil {
+		return "", false, fmt.Errorf("release has no archive %s", name)
+	}
+	if checksums == nil {
+		return "", false, errors.New("release has no checksums")
+	}
+	signature := r.asset(checksums.Name + ".sig")
+
+	sums, err := download(checksums.URL)
+	if err != nil {
+		return "", false, fmt.Errorf("could not download checksums: %w", err)
+	}
+	key, err := releasePublicKey()
+	if err != nil {
+		return "", false, err
+	}
+	signed := false
+	switch {
+	case key == nil && !skipSignature:
+		return "", false, errors.New("no release key known to verify the release with, use -update-key or -insecure-skip-signature")
+	case key == nil:
+		logger.Warn("no release key known, the checksums are not verified against a signature")
+	case signature == nil:
+		return "", false, errors.New("release checksums are not signed")
+	default:
+		sig, err := download(signature.URL)
+		if err != nil {
+			return "", false, fmt.Errorf("could not download signature: %w", err)
+		}
+		if err := verifySignature(key, sums, sig); err != nil {
+			return "", false, err
+		}
+		signed = true
+	}
+
+	data, err := download(archive.URL)
+	if err != nil {
+		return "", false, fmt.Errorf("could not download %s: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	if expected, found := checksumOf(sums, name); !found {
+		return "", false, fmt.Errorf("checksums do not list %s", name)
+	} else if expected != hex.EncodeToString(sum[:]) {
+		return "", false, fmt.Errorf("checksum mismatch of %s", name)
+	}
+	binary, err := extractBinary(name, data)
+	if err != nil {
+		return "", false, err
+	}
+	if dryRun {
+		return name, signed, nil
+	}
+	return name, signed, replaceExecutable(binary)
+}
+
+// asset returns the asset of the release with the given name, nil if there is none.
+func (r *release) asset(name string) *releaseAsset {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// assetSuffix returns the first asset of the release whose name ends with suffix, nil if there is none.
+func (r *release) assetSuffix(suffix string) *releaseAsset {
+	for _, a := range r.Assets {
+		if strings.HasSuffix(a.Name, suffix) {
+			return a
+		}
+	}
+	return nil
+}
+
+// archiveName returns the name of the release archive built for this platform, following the name template of
+// the release configuration, e.g. ips_Linux_x86_64.tar.gz or ips_Windows_arm64.zip.
+func archiveName() string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "386":
+		arch = "i386"
+	}
+	extension := ".tar.gz"
+	if runtime.GOOS == "windows" {
+		extension = ".zip"
+	}
+	return "ips_" + strings.ToUpper(runtime.GOOS[:1]) + runtime.GOOS[1:] + "_" + arch + extension
+}
+
+// download returns the body of the answer to a GET request of u.
+func download(u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := newHTTPClient(nil)
+	client.Timeout = downloadTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download answered with %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveBytes {
+		return nil, errors.New("download is too large")
+	}
+	return data, nil
+}
+
+// checksumOf returns the hex encoded SHA-256 checksum listed for name in a sha256sum style file.
+func checksumOf(sums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// releasePublicKey returns the key release checksums are signed with, read from -update-key or built in, nil if
+// neither is set.
+func releasePublicKey() (any, error) {
+	var der []byte
+	switch {
+	case updateKey != "":
+		data, err := os.ReadFile(updateKey)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM encoded key", updateKey)
+		}
+		der = block.Bytes
+	case releaseKey != "":
+		var err error
+		if der, err = base64.StdEncoding.DecodeString(releaseKey); err != nil {
+			return nil, fmt.Errorf("invalid built in release key: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid release key: %w", err)
+	}
+	return key, nil
+}
+
+// verifySignature checks the signature of data, an ECDSA signature over its SHA-256 digest as created by cosign
+// sign-blob or an Ed25519 signature. Signatures may be base64 encoded.
+func verifySignature(key any, data, signature []byte) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		signature = decoded
+	}
+	valid := false
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, data, signature)
+	default:
+		return fmt.Errorf("unsupported release key type %T", key)
+	}
+	if !valid {
+		return errors.New("invalid signature of the release checksums")
+	}
+	return nil
+}
+
+// extractBinary returns the ips executable contained in the release archive.
+func extractBinary(name string, data []byte) ([]byte, error) {
+	binary := "ips"
+	if runtime.GOOS == "windows" {
+		binary = "ips.exe"
+	}
+	if strings.HasSuffix(name, ".zip") {
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range archive.File {
+			if filepath.Base(f.Name) == binary {
+				r, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return io.ReadAll(io.LimitReader(r, maxArchiveBytes))
+			}
+		}
+		return nil, fmt.Errorf("%s does not contain %s", name, binary)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s does not contain %s", name, binary)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == binary {
+			return io.ReadAll(io.LimitReader(archive, maxArchiveBytes))
+		}
+	}
+}
+
+// replaceExecutable writes the binary next to the running executable and renames it over the executable, so the
+// executable is either the old or the new one. Windows does not allow replacing a running executable, it is moved
+// aside to a file ending in .old first, which is removed by the next update.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".new-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			_ = os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// parseVersion splits a semantic version like v1.2.3-rc.1 into its numeric core and pre-release identifiers.
+func parseVersion(v string) ([3]int, []string, bool) {
+	var core [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "+")
+	v, pre, hasPre := strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return core, nil, false
+	}
+	for idx, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return core, nil, false
+		}
+		core[idx] = n
+	}
+	if !hasPre {
+		return core, nil, true
+	}
+	return core, strings.Split(pre, "."), true
+}
+
+// compareVersions compares two semantic versions, returning -1, 0 or 1. Versions that are not semantic versions,
+// like dev for builds without version, are older than all others.
+func compareVersions(a, b string) int {
+	aCore, aPre, aValid := parseVersion(a)
+	bCore, bPre, bValid := parseVersion(b)
+	switch {
+	case !aValid || !bValid:
+		if aValid == bValid {
+			return 0
+		}
+		if aValid {
+			return 1
+		}
+		return -1
+	case aCore != bCore:
+		return slices.Compare(aCore[:], bCore[:])
+	case len(aPre) == 0 || len(bPre) == 0:
+		// a release is newer than its pre-releases
+		return cmp.Compare(len(bPre), len(aPre))
+	}
+	for idx := 0; idx < len(aPre) && idx < len(bPre); idx++ {
+		aNum, aErr := strconv.Atoi(aPre[idx])
+		bNum, bErr := strconv.Atoi(bPre[idx])
+		c := 0
+		switch {
+		case aErr == nil && bErr == nil:
+			c = cmp.Compare(aNum, bNum)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(aPre[idx], bPre[idx])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(aPre), len(bPre))
+}
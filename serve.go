@@ -0,0 +1,233 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sascha-andres/reuse/flag"
+)
+
+const (
+	// maxProbePorts limits the ports a single reachability request may ask for
+	maxProbePorts = 10
+
+	// probeTimeout is the time a connection back to a client may take, it is shorter than the default timeout
+	// of the clients so they receive the answer
+	probeTimeout = 3 * time.Second
+)
+
+// listenAddress is the address the server mode listens on
+var listenAddress string
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "serve",
+		commands: map[string]command{"serve": runServe, "token": runToken},
+		flags: func() {
+			flag.StringVar(&listenAddress, "listen", ":8080", "address the server mode listens on")
+			flag.UintVar(&rateLimit, "rate-limit", 0, "requests per minute a client may send to the server mode, 0 for no limit")
+			flag.StringVar(&corsOrigins, "cors-origins", "", "comma separated origins browsers may call the server mode from, * for all")
+			flag.StringVar(&trustedProxies, "trusted-proxies", "", "comma separated networks of reverse proxies whose X-Forwarded-For header names the client in server mode")
+			flag.BoolVar(&accessLog, "access-log", true, "log every request served by the server mode")
+			flag.BoolVar(&apiDocs, "api-docs", false, "serve Swagger UI for the OpenAPI document at /docs in server mode")
+			flag.StringVar(&pushToken, "push-token", "", "bearer token agents of the default tenant push their changes to the server mode with and clients query them with")
+			flag.StringVar(&pushTokensFile, "push-tokens", "", "file of tokens granting access to the agents of a tenant in server mode, one 'tenant token [push|read|all]' per line")
+			flag.StringVar(&agentPoliciesFile, "agent-policies", "", "file of policies for the addresses pushed by agents in server mode, one 'tenant/agent expect=networks forbid=classes' per line")
+			flag.StringVar(&dnsListen, "dns-listen", "", "address the server mode answers DNS queries for <agent>.ips.internal on over udp and tcp, e.g. :5353")
+			flag.StringVar(&dnsTenant, "dns-tenant", defaultTenant, "tenant whose agents are answered by dns-listen")
+			flag.StringVar(&policyWebhook, "policy-webhook", "", "URL to post alerts to when an agent violates a policy of agent-policies or meets it again")
+		},
+		urls: []*string{&policyWebhook},
+	})
+}
+
+// probeResult is the outcome of connecting back to a client port.
+type probeResult struct {
+
+	// Address is the client address that was connected to
+	Address string
+
+	// Port is the tcp port that was connected to
+	Port uint16
+
+	// Reachable is set when the connection was established
+	Reachable bool
+
+	// Duration is the time it took to establish the connection
+	Duration string `json:",omitempty"`
+
+	// Error describes why the connection failed
+	Error string `json:",omitempty"`
+}
+
+// runServe runs ips as a service for other instances until interrupted:
+//
+//	/              echoes the client address as plain text, so the server can be used as provider
+//	/reachable     connects back to the client on the ports given as port parameters and reports the outcome
+//	/healthz       answers ok while the server runs
+//	/readyz        answers ok while the server runs
+//	/openapi.json  describes the endpoints as OpenAPI 3 document
+//	/docs          renders the document using Swagger UI if api-docs is set
+//	/push/{agent}  accepts changes pushed by agents if push-token or push-tokens is set
+//	/agents        answers the agents of a tenant and their addresses if push-token or push-tokens is set
+//	/export        answers the addresses of the agents of a tenant as CSV or Excel workbook
+//	/ui            shows the agents of a tenant, their addresses and changes to a browser logged in with a token
+//
+// With dns-listen set the addresses of the agents are answered as <agent>.ips.internal as well.
+func runServe(logger *slog.Logger, _ []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	if pushEnabled() {
+		var err error
+		if agents, err = loadAgents(logger); err != nil {
+			logger.Error("could not load agents", "err", err)
+			return exitInternalError
+		}
+		if tokens, err = loadTokens(logger); err != nil {
+			logger.Error("could not read tokens", "err", err)
+			return exitInternalError
+		}
+		if policies, err = loadPolicies(logger); err != nil {
+			logger.Error("could not read policies", "err", err)
+			return exitInternalError
+		}
+	}
+	for _, e := range serveEndpoints() {
+		method := e.method
+		if method == "" {
+			method = "GET"
+		}
+		pattern := method + " " + e.path
+		if e.path == "/" {
+			pattern += "{$}"
+		}
+		mux.HandleFunc(pattern, e.handler)
+	}
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	if apiDocs {
+		mux.HandleFunc("GET /docs", handleDocs)
+	}
+	if pushEnabled() {
+		mux.HandleFunc("GET /ui", handleUI)
+		mux.HandleFunc("GET /ui/agents/{agent}", handleUIAgent)
+		mux.HandleFunc("POST /ui/login", handleUILogin)
+		mux.HandleFunc("POST /ui/logout", handleUILogout)
+	}
+	if dnsListen != "" {
+		if !pushEnabled() {
+			logger.Error("dns-listen needs push-token or push-tokens")
+			return exitInternalError
+		}
+		if err := serveFleetDNS(ctx, logger); err != nil {
+			logger.Error("could not answer DNS", "err", err)
+			return exitInternalError
+		}
+	}
+	handler, err := withMiddleware(logger, mux)
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		return exitInternalError
+	}
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	logger.Info("listening", "address", listenAddress)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("could not serve", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// clientAddress returns the address of the peer of the request, or the client it was forwarded for if the peer is
+// a trusted proxy.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if isTrustedProxy(host) {
+		return forwardedClient(r, host)
+	}
+	return host
+}
+
+// handleEcho answers with the client address.
+func handleEcho(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, clientAddress(r))
+}
+
+// handleReachable connects back to the client on every requested port. Only the address the request came from
+// is probed, so the server can't be used to scan third parties.
+func handleReachable(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()["port"]
+	if len(values) == 0 || len(values) > maxProbePorts {
+		http.Error(w, fmt.Sprintf("between 1 and %d port parameters required", maxProbePorts), http.StatusBadRequest)
+		return
+	}
+	ports := make([]uint16, 0, len(values))
+	for _, v := range values {
+		p, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || p == 0 {
+			http.Error(w, fmt.Sprintf("invalid port %q", v), http.StatusBadRequest)
+			return
+		}
+		ports = append(ports, uint16(p))
+	}
+	address := clientAddress(r)
+	results := make([]*probeResult, len(ports))
+	var wg sync.WaitGroup
+	for idx, p := range ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[idx] = probe(r.Context(), address, p)
+		}()
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// probe tries to establish a tcp connection to address and port.
+func probe(ctx context.Context, address string, port uint16) *probeResult {
+	result := &probeResult{Address: address, Port: port}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(int(port))))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	_ = conn.Close()
+	result.Reachable = true
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	return result
+}
@@ -0,0 +1,128 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// serviceSupport names what ips service installs
+const serviceSupport = "launchd"
+
+// launchdLabel is the label of the launchd job
+const launchdLabel = "com.github.sascha-andres.ips"
+
+// runService manages the launchd job running ips in watch mode. It supports install, uninstall and status.
+// When run as root a system wide daemon is installed, otherwise an agent for the current user.
+func runService(logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		logger.Error("usage: ips service install|uninstall|status")
+		return exitInternalError
+	}
+	path, err := plistPath()
+	if err != nil {
+		logger.Error("could not determine plist location", "err", err)
+		return exitInternalError
+	}
+	switch args[0] {
+	case "install":
+		err = installLaunchd(path)
+	case "uninstall":
+		_ = exec.Command("launchctl", "unload", "-w", path).Run()
+		err = os.Remove(path)
+	case "status":
+		out, statusErr := exec.Command("launchctl", "list", launchdLabel).CombinedOutput()
+		if statusErr != nil {
+			fmt.Println("not loaded")
+		} else {
+			fmt.Print(string(out))
+		}
+	default:
+		err = fmt.Errorf("unknown service action %q", args[0])
+	}
+	if err != nil {
+		logger.Error("service action failed", "err", err, "action", args[0])
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// plistPath returns the location of the launchd plist.
+func plistPath() (string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// installLaunchd writes the plist starting ips in watch mode with all flags of the current invocation and loads it.
+func installLaunchd(path string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logDir := "/Library/Logs"
+	if os.Geteuid() != 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		logDir = filepath.Join(home, "Library", "Logs")
+	}
+
+	args := slices.Clone(os.Args[1:])
+	for _, verb := range []string{"service", "install"} {
+		if idx := slices.Index(args, verb); idx >= 0 {
+			args = slices.Delete(args, idx, idx+1)
+		}
+	}
+	args = append([]string{executable}, append(args, "watch")...)
+
+	var programArguments strings.Builder
+	for _, a := range args {
+		programArguments.WriteString("\t\t<string>")
+		if err := xml.EscapeText(&programArguments, []byte(a)); err != nil {
+			return err
+		}
+		programArguments.WriteString("</string>\n")
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, programArguments.String(), filepath.Join(logDir, "ips.log"), filepath.Join(logDir, "ips.log"))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(plist), 0o644); err != nil {
+		return err
+	}
+	return exec.Command("launchctl", "load", "-w", path).Run()
+}
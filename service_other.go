@@ -0,0 +1,14 @@
+//go:build !windows && !darwin && (!ips_minimal || ips_full)
+
+package main
+
+import "log/slog"
+
+// serviceSupport is empty, the units of the init system have to be used on this platform
+const serviceSupport = ""
+
+// runService is only supported on windows.
+func runService(logger *slog.Logger, _ []string) int {
+	logger.Error("the service command is not supported on this platform")
+	return exitInternalError
+}
@@ -0,0 +1,275 @@
+//go:build windows && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceSupport names what ips service registers with
+const serviceSupport = "windows service, scheduled task"
+
+// serviceName is the name of the windows service, the scheduled task and the event log source
+const serviceName = "ips"
+
+// runService manages the windows service running ips in watch mode. It supports install, uninstall, status and
+// run, the latter being invoked by the service control manager. With -scheduled-task a scheduled task started
+// at boot is registered instead of a service.
+func runService(logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		logger.Error("usage: ips service install|uninstall|status")
+		return exitInternalError
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		if scheduledTask {
+			err = installTask()
+		} else {
+			err = installService()
+		}
+	case "uninstall":
+		if scheduledTask {
+			err = uninstallTask()
+		} else {
+			err = uninstallService()
+		}
+	case "status":
+		var status string
+		status, err = serviceStatus()
+		if err == nil {
+			fmt.Println(status)
+		}
+	case "run":
+		err = runAsService()
+	default:
+		err = fmt.Errorf("unknown service action %q", args[0])
+	}
+	if err != nil {
+		logger.Error("service action failed", "err", err, "action", args[0])
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// installService registers ips as automatically started service together with its event log source.
+// All flags of the current invocation are passed on to the service.
+func installService() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err = m.CreateService(serviceName, executable, mgr.Config{
+		DisplayName: "ips",
+		Description: "Watches local and public ip addresses",
+		StartType:   mgr.StartAutomatic,
+	}, append(serviceArgs(), "service", "run")...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("could not register event log source: %w", err)
+	}
+	return nil
+}
+
+// uninstallService removes the service and its event log source.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	return eventlog.Remove(serviceName)
+}
+
+// serviceStatus returns the state of the service.
+func serviceStatus() (string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return "not installed", nil
+	}
+	defer s.Close()
+	status, err := s.Query()
+	if err != nil {
+		return "", err
+	}
+	switch status.State {
+	case svc.Stopped:
+		return "stopped", nil
+	case svc.StartPending:
+		return "starting", nil
+	case svc.StopPending:
+		return "stopping", nil
+	case svc.Running:
+		return "running", nil
+	case svc.Paused:
+		return "paused", nil
+	default:
+		return fmt.Sprintf("state %d", status.State), nil
+	}
+}
+
+// installTask registers a scheduled task starting ips in watch mode at boot as SYSTEM.
+func installTask() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf(`"%s" %s watch`, executable, strings.Join(serviceArgs(), " "))
+	return exec.Command("schtasks.exe", "/Create", "/F", "/SC", "ONSTART", "/RU", "SYSTEM", "/TN", serviceName, "/TR", command).Run()
+}
+
+// uninstallTask removes the scheduled task.
+func uninstallTask() error {
+	return exec.Command("schtasks.exe", "/Delete", "/F", "/TN", serviceName).Run()
+}
+
+// serviceArgs returns the flags of the current invocation without the service verbs.
+func serviceArgs() []string {
+	args := slices.Clone(os.Args[1:])
+	for _, verb := range []string{"service", "install"} {
+		if idx := slices.Index(args, verb); idx >= 0 {
+			args = slices.Delete(args, idx, idx+1)
+		}
+	}
+	return args
+}
+
+// runAsService is invoked by the service control manager and runs watch mode logging to the event log.
+func runAsService() error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return errors.New("not started by the service control manager")
+	}
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return err
+	}
+	defer elog.Close()
+
+	logger := slog.New(&eventLogHandler{elog: elog, level: slog.LevelInfo}).With("project", "ips")
+	slog.SetDefault(logger)
+	return svc.Run(serviceName, &watchService{logger: logger})
+}
+
+// watchService adapts watch mode to the service control manager.
+type watchService struct {
+	logger *slog.Logger
+}
+
+// Execute runs watch mode until the service is stopped.
+func (w *watchService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		done <- watch(ctx, w.logger, printChange)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case code := <-done:
+			cancel()
+			return false, uint32(code)
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				return false, uint32(<-done)
+			}
+		}
+	}
+}
+
+// eventLogHandler is a slog handler writing records to the windows event log.
+type eventLogHandler struct {
+	elog  *eventlog.Log
+	level slog.Level
+	attrs []slog.Attr
+}
+
+// Enabled reports whether records of the level are written.
+func (h *eventLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// Handle writes the record as a single event log entry with its attributes as key=value pairs.
+func (h *eventLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	write := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.elog.Error(1, b.String())
+	case r.Level >= slog.LevelWarn:
+		return h.elog.Warning(1, b.String())
+	default:
+		return h.elog.Info(1, b.String())
+	}
+}
+
+// WithAttrs returns a handler adding attrs to every record.
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{elog: h.elog, level: h.level, attrs: append(slices.Clone(h.attrs), attrs...)}
+}
+
+// WithGroup is not supported, groups are flattened.
+func (h *eventLogHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
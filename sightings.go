@@ -0,0 +1,146 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// sightingsFlush is the time between two writes of the sightings while only the last seen times changed
+const sightingsFlush = 5 * time.Minute
+
+type (
+
+	// sighting is the time an address was observed first and last.
+	sighting struct {
+		FirstSeen time.Time
+		LastSeen  time.Time
+	}
+
+	// addressSightings tracks when the watch loop observed each address, keyed like diff compares addresses.
+	// The times survive restarts in seen.json of the state directory, so an address seen for months is told
+	// apart from a new one.
+	addressSightings struct {
+		mu sync.Mutex
+
+		// seen maps the keys of the addresses to their sighting
+		seen map[string]*sighting
+
+		// current contains the addresses after the last poll, stamped with their sighting
+		current ips
+
+		// saved is the time the sightings were written last, dirty is set if they changed since
+		saved time.Time
+		dirty bool
+	}
+)
+
+// sightings is set once the watch loop started
+var sightings *addressSightings
+
+// loadSightings reads the sightings kept in the state directory, a missing file starts empty.
+func loadSightings() (*addressSightings, error) {
+	s := &addressSightings{seen: make(map[string]*sighting), saved: time.Now()}
+	path, err := statePath("seen.json")
+	if err != nil {
+		return s, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.seen); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// observe stamps the addresses of list with the time they were seen first and now as the time they were seen last.
+// Sightings of addresses not seen for longer than keep are forgotten, none if keep is 0. The sightings are written
+// when an address was seen for the first time and otherwise every sightingsFlush.
+func (s *addressSightings) observe(list ips, now time.Time, keep time.Duration) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := false
+	for _, i := range list {
+		seen, ok := s.seen[i.key()]
+		if !ok {
+			seen = &sighting{FirstSeen: now}
+			s.seen[i.key()] = seen
+			added = true
+		}
+		seen.LastSeen = now
+		i.FirstSeen, i.LastSeen = seen.FirstSeen, seen.LastSeen
+	}
+	for key, seen := range s.seen {
+		if keep > 0 && now.Sub(seen.LastSeen) > keep {
+			delete(s.seen, key)
+		}
+	}
+	s.dirty = true
+	if !added && now.Sub(s.saved) < sightingsFlush {
+		return nil
+	}
+	return s.save(now)
+}
+
+// save writes the sightings to the state directory. The caller must hold the lock.
+func (s *addressSightings) save(now time.Time) error {
+	if !s.dirty {
+		return nil
+	}
+	data, err := json.Marshal(s.seen)
+	if err != nil {
+		return err
+	}
+	path, err := statePath("seen.json")
+	if err != nil {
+		return err
+	}
+	if err := writeState(path, data); err != nil {
+		return err
+	}
+	s.saved, s.dirty = now, false
+	return nil
+}
+
+// update records the addresses known after a poll, their sightings were observed before.
+func (s *addressSightings) update(current ips) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = current
+}
+
+// close writes sightings not written yet, e.g. when watch stops.
+func (s *addressSightings) close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(time.Now())
+}
+
+// addresses returns the addresses after the last poll with their sightings.
+func (s *addressSightings) addresses() ips {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
@@ -0,0 +1,432 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// sinkEvents lists the parts of a change a sink can be limited to
+var sinkEvents = []string{"public", "interface", "prefix"}
+
+type (
+
+	// sink delivers change events to a system outside of ips.
+	sink interface {
+
+		// send delivers the change, payload is the change rendered by the template of the sink or nil if it has
+		// none, the sink then uses its own format
+		send(c *change, payload []byte) error
+
+		// close releases the connections held by the sink
+		close()
+	}
+
+	// sinkSpec is a sink as given using the sink option: "type target [option=value ...]".
+	sinkSpec struct {
+		kind, target string
+
+		// name identifies the sink in logs, defaults to the type
+		name string
+
+		// options are the options not interpreted by the bus, they configure the sink
+		options map[string]string
+
+		// events, interfaces and families limit the changes passed to the sink, empty to pass all
+		events, interfaces, families []string
+
+		// match is an expression of the rule language events must match, nil to pass all
+		match ruleExpr
+
+		// template renders the payload, nil to use the format of the sink
+		template *template.Template
+
+		// flag is the flag value the sink was parsed from
+		flag string
+	}
+
+	// sinkValues is the flag.Value collecting the sink flags.
+	sinkValues struct{}
+
+	// sinkRoute connects a sink with its specification.
+	sinkRoute struct {
+		spec *sinkSpec
+		sink sink
+
+		// rules are the expressions of the routing rules naming the sink, an event has to match one of them. Sinks
+		// not named by any rule receive all events.
+		rules []ruleExpr
+	}
+
+	// eventBus passes every change to all sinks, each receiving the part of the change matching its filters.
+	// With debounce set changes are collected until no change arrived for the quiet period.
+	eventBus struct {
+		routes []*sinkRoute
+		logger *slog.Logger
+
+		// mu guards batch and closed, delivering waits for batches delivered by the timer
+		mu         sync.Mutex
+		batch      batch
+		closed     bool
+		delivering sync.WaitGroup
+	}
+
+	// eventData is passed to the templates of sinks.
+	eventData struct {
+		*change
+		Hostname string
+	}
+)
+
+// sinkSpecs are the sinks given using the sink option
+var sinkSpecs []*sinkSpec
+
+// sinkFactories create the sinks of a type from their specification
+var sinkFactories = map[string]func(logger *slog.Logger, spec *sinkSpec) (sink, error){
+	"webhook": newWebhookSink,
+	"mqtt":    newMQTTSink,
+	"exec":    newExecSink,
+	"syslog": func(logger *slog.Logger, spec *sinkSpec) (sink, error) {
+		return newSyslogSink(logger, spec.target)
+	},
+	"desktop": func(logger *slog.Logger, spec *sinkSpec) (sink, error) {
+		return &desktopSink{logger: logger}, nil
+	},
+	"email": newEmailSink,
+}
+
+// sinkOptions are the options understood by the sinks of a type
+var sinkOptions = map[string][]string{
+	"webhook": {"content-type", "token"},
+	"mqtt":    {"retain", "qos", "client-id"},
+	"email":   {"to", "from", "subject"},
+}
+
+// Set parses and adds a sink.
+func (sinkValues) Set(value string) error {
+	spec, err := parseSink(value)
+	if err != nil {
+		return err
+	}
+	sinkSpecs = append(sinkSpecs, spec)
+	return nil
+}
+
+// String returns the sinks as given, one per line.
+func (sinkValues) String() string {
+	return strings.Join(sinkValues{}.values(), "\n")
+}
+
+// reset removes all sinks.
+func (sinkValues) reset() {
+	sinkSpecs = nil
+}
+
+// values returns the sinks as given.
+func (sinkValues) values() []string {
+	values := make([]string, 0, len(sinkSpecs))
+	for _, s := range sinkSpecs {
+		values = append(values, s.flag)
+	}
+	return values
+}
+
+// parseSink parses a sink flag value of the form "type target [option=value ...]". Fields containing spaces, like
+// the command of an exec sink, are enclosed in double quotes. The options events, interface, family and match
+// filter the changes, template names a shipped payload template or a file rendered as payload and name identifies
+// the sink, all others are passed to the sink.
+func parseSink(value string) (*sinkSpec, error) {
+	fields, err := splitQuotedFields(value)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", value, err)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("empty sink")
+	}
+	spec := &sinkSpec{kind: fields[0], name: fields[0], options: make(map[string]string), flag: value}
+	if _, ok := sinkFactories[spec.kind]; !ok {
+		return nil, fmt.Errorf("unknown sink type %q", spec.kind)
+	}
+	fields = fields[1:]
+	if spec.kind != "desktop" {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("sink %q has no target", value)
+		}
+		spec.target, fields = fields[0], fields[1:]
+	}
+	for _, field := range fields {
+		key, v, found := strings.Cut(field, "=")
+		if !found {
+			return nil, fmt.Errorf("sink option %q is not of the form option=value", field)
+		}
+		switch key {
+		case "name":
+			spec.name = v
+		case "events":
+			spec.events = splitList(v)
+			for _, e := range spec.events {
+				if !slices.Contains(sinkEvents, e) {
+					return nil, fmt.Errorf("unknown sink event %q", e)
+				}
+			}
+		case "interface":
+			spec.interfaces = splitList(v)
+			for _, pattern := range spec.interfaces {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+				}
+			}
+		case "family":
+			spec.families = splitList(v)
+			for _, f := range spec.families {
+				if f != "ipv4" && f != "ipv6" {
+					return nil, fmt.Errorf("unknown family %q", f)
+				}
+			}
+		case "match":
+			if spec.match, err = parseRuleExpr(v); err != nil {
+				return nil, fmt.Errorf("sink option match: %w", err)
+			}
+		case "template":
+			if spec.template, err = parsePayloadTemplate(v); err != nil {
+				return nil, err
+			}
+		default:
+			if !slices.Contains(sinkOptions[spec.kind], key) {
+				return nil, fmt.Errorf("unknown option %q of %s sink", key, spec.kind)
+			}
+			spec.options[key] = v
+		}
+	}
+	return spec, nil
+}
+
+// splitQuotedFields splits value at spaces outside of double quotes, the quotes are removed.
+func splitQuotedFields(value string) ([]string, error) {
+	fields := make([]string, 0)
+	var field strings.Builder
+	quoted, started := false, false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+		case (r == ' ' || r == '\t') && !quoted:
+			if started {
+				fields = append(fields, field.String())
+				field.Reset()
+				started = false
+			}
+		default:
+			field.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if started {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// newEventBus creates the sinks given using the sink option and those of the syslog and notify options, and routes
+// the events matching the routing rules to the sinks they name.
+func newEventBus(logger *slog.Logger) (*eventBus, error) {
+	specs := slices.Clone(sinkSpecs)
+	if syslogTarget != "" {
+		specs = append(specs, &sinkSpec{kind: "syslog", name: "syslog", target: syslogTarget})
+	}
+	if events := splitList(notifyEvents); len(events) > 0 {
+		if slices.Contains(events, "all") {
+			events = sinkEvents
+		}
+		for _, e := range events {
+			if !slices.Contains(sinkEvents, e) {
+				return nil, fmt.Errorf("unknown notification event %q", e)
+			}
+		}
+		specs = append(specs, &sinkSpec{kind: "desktop", name: "desktop", events: events})
+	}
+
+	bus := &eventBus{logger: logger}
+	for _, spec := range specs {
+		s, err := sinkFactories[spec.kind](logger, spec)
+		if err != nil {
+			bus.close()
+			return nil, fmt.Errorf("could not set up sink %s: %w", spec.name, err)
+		}
+		bus.routes = append(bus.routes, &sinkRoute{spec: spec, sink: s})
+	}
+	for _, rule := range routingRules {
+		for _, name := range rule.sinks {
+			found := false
+			for _, r := range bus.routes {
+				if r.spec.name == name {
+					r.rules = append(r.rules, rule.match)
+					found = true
+				}
+			}
+			if !found {
+				bus.close()
+				return nil, fmt.Errorf("route %q names unknown sink %s", rule.flag, name)
+			}
+		}
+	}
+	return bus, nil
+}
+
+// publish passes the change to the sinks. With debounce set it is merged into the pending batch instead, which is
+// delivered once no change arrived for the quiet period, the initial addresses are delivered right away.
+func (b *eventBus) publish(c *change) {
+	if b == nil {
+		return
+	}
+	if debounce <= 0 || c.previous == nil {
+		b.deliver(c)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wait := b.batch.add(c, time.Now())
+	if b.batch.timer == nil {
+		b.batch.timer = time.AfterFunc(wait, b.flush)
+	} else {
+		b.batch.timer.Reset(wait)
+	}
+}
+
+// flush delivers the pending batch.
+func (b *eventBus) flush() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	c := b.batch.take()
+	if c == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.delivering.Add(1)
+	b.mu.Unlock()
+	defer b.delivering.Done()
+	b.logger.Debug("delivering batch of changes", "added", len(c.Added), "removed", len(c.Removed))
+	b.deliver(c)
+}
+
+// deliver passes the change to every sink concurrently and waits for all of them. Sinks whose filters leave nothing
+// of the change are skipped, failures are logged.
+func (b *eventBus) deliver(c *change) {
+	hostname, _ := os.Hostname()
+	var wg sync.WaitGroup
+	for _, r := range b.routes {
+		filtered := r.filter(c)
+		if filtered == nil {
+			continue
+		}
+		var payload []byte
+		if r.spec.template != nil {
+			var buf bytes.Buffer
+			if err := r.spec.template.Execute(&buf, &eventData{change: filtered, Hostname: hostname}); err != nil {
+				b.logger.Error("could not render sink template", "err", err, "sink", r.spec.name)
+				continue
+			}
+			payload = buf.Bytes()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.sink.send(filtered, payload); err != nil {
+				b.logger.Error("could not send change", "err", err, "sink", r.spec.name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// close delivers the pending batch and closes all sinks.
+func (b *eventBus) close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	c := b.batch.take()
+	b.closed = true
+	b.mu.Unlock()
+	if c != nil {
+		b.deliver(c)
+	}
+	b.delivering.Wait()
+	for _, r := range b.routes {
+		r.sink.close()
+	}
+}
+
+// filter returns the part of the change the sink receives, nil if nothing is left. The prefix and the addresses are
+// passed if they match the events, interfaces, families and match expression of the sink and one of its routing
+// rules.
+func (r *sinkRoute) filter(c *change) *change {
+	if len(r.spec.events) == 0 && len(r.spec.interfaces) == 0 && len(r.spec.families) == 0 && r.spec.match == nil && len(r.rules) == 0 {
+		return c
+	}
+	filtered := &change{Time: c.Time, Snapshot: c.Snapshot, previous: c.previous}
+	if c.Prefix != nil && (len(r.spec.events) == 0 || slices.Contains(r.spec.events, "prefix")) && r.matches(prefixFields(c.Prefix)) {
+		filtered.Prefix = c.Prefix
+	}
+	filtered.Added = filterAddresses(c.Added, func(i *ip) bool { return r.spec.matches(i) && r.matches(addressFields(i, "added")) })
+	filtered.Removed = filterAddresses(c.Removed, func(i *ip) bool { return r.spec.matches(i) && r.matches(addressFields(i, "removed")) })
+	if len(filtered.Added) == 0 && len(filtered.Removed) == 0 && filtered.Prefix == nil {
+		return nil
+	}
+	return filtered
+}
+
+// matches reports whether the fields of an event match the expression of the sink and one of its routing rules.
+func (r *sinkRoute) matches(fields map[string]string) bool {
+	if r.spec.match != nil && !r.spec.match(fields) {
+		return false
+	}
+	if len(r.rules) == 0 {
+		return true
+	}
+	for _, rule := range r.rules {
+		if rule(fields) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether an address passes the events, interfaces and families of the sink.
+func (s *sinkSpec) matches(i *ip) bool {
+	event := "interface"
+	if i.isPublic() {
+		event = "public"
+	}
+	if len(s.events) > 0 && !slices.Contains(s.events, event) {
+		return false
+	}
+	if len(s.families) > 0 && !slices.Contains(s.families, i.family()) {
+		return false
+	}
+	if len(s.interfaces) == 0 {
+		return true
+	}
+	for _, pattern := range s.interfaces {
+		if matched, _ := path.Match(pattern, i.Interface); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// stateDir overrides the directory ips keeps its state in
+var stateDir string
+
+// stateFile is a file ips keeps in the state directory.
+type stateFile struct {
+	name string
+
+	// disposable files are recreated when missing and removed by ips state clean
+	disposable bool
+}
+
+// stateFiles lists the files of the state directory, the control socket is kept there if there is no runtime dir
+var stateFiles = []*stateFile{
+	{name: "public.json", disposable: true},
+	{name: "public.lock", disposable: true},
+	{name: "prompt.refresh", disposable: true},
+	{name: "history.jsonl"},
+	{name: "seen.json"},
+	{name: "agents.json"},
+	{name: "nagios.json"},
+}
+
+// stateDirectory returns the directory ips keeps its state in: $XDG_STATE_HOME/ips, defaulting to
+// ~/.local/state/ips, on Linux and other unix systems, %LocalAppData%\ips on Windows and
+// ~/Library/Application Support/ips on macOS.
+func stateDirectory() (string, error) {
+	if stateDir != "" {
+		return stateDir, nil
+	}
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LocalAppData")
+		if dir == "" {
+			return "", errors.New("%LocalAppData% is not set")
+		}
+		return filepath.Join(dir, "ips"), nil
+	case "darwin", "ios":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "ips"), nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ips"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "ips"), nil
+}
+
+// statePath returns the location of a file of the state directory. Files left in the user cache directory by
+// earlier versions are moved to the state directory first, if that fails they keep being used where they are.
+func statePath(name string) (string, error) {
+	dir, err := stateDirectory()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return path, nil
+	}
+	legacy := filepath.Join(cache, "ips", name)
+	if legacy == path {
+		return path, nil
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return path, nil
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return legacy, nil
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		return legacy, nil
+	}
+	return path, nil
+}
+
+// writeState replaces a file of the state directory atomically, the file is synced before it is renamed so a
+// crash leaves either the old or the new content.
+func writeState(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeAtomic(path, data, 0o600)
+}
+
+// runState manages the state directory:
+//
+//   - path prints the directory
+//   - clean removes the disposable files, like the cache of the public addresses, and the temporary files of
+//     interrupted writes. The history is kept
+//
+// With dry-run set clean prints what would be removed.
+func runState(logger *slog.Logger, args []string) int {
+	if len(args) != 1 || args[0] != "path" && args[0] != "clean" {
+		logger.Error("usage: ips state path|clean")
+		return exitInternalError
+	}
+	dir, err := stateDirectory()
+	if err != nil {
+		logger.Error("could not determine state directory", "err", err)
+		return exitInternalError
+	}
+	if args[0] == "path" {
+		if jsonOutput {
+			return printJSON(logger, map[string]string{"Directory": dir})
+		}
+		fmt.Println(dir)
+		return exitOK
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("could not read state directory", "err", err, "directory", dir)
+		return exitInternalError
+	}
+	removed := make([]string, 0)
+	code := exitOK
+	for _, e := range entries {
+		if e.IsDir() || !isDisposableState(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				logger.Error("could not remove state file", "err", err, "file", path)
+				code = exitInternalError
+				continue
+			}
+		}
+		removed = append(removed, path)
+	}
+	if jsonOutput {
+		if printJSON(logger, removed) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, path := range removed {
+		fmt.Printf("%s\t%s\n", verb, path)
+	}
+	return code
+}
+
+// isDisposableState reports whether a file of the state directory may be removed by ips state clean: disposable
+// state files and the temporary files writing any state file leaves behind when interrupted.
+func isDisposableState(name string) bool {
+	for _, f := range stateFiles {
+		if name == f.name && f.disposable || strings.HasPrefix(name, "."+f.name+".") || name == f.name+".tmp" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var stdio bool
+
+// stdioWatch is the watch loop running on behalf of a client of the stdio mode.
+type stdioWatch struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// runStdio speaks JSON-RPC 2.0 on stdin and stdout, one message per line, until stdin is closed. This allows
+// GUIs and editor plugins to keep a single process running instead of spawning one per query. Methods:
+//
+//   - collect returns the addresses ips would print using the same flags
+//   - classify returns the class of the address given as parameter
+//   - watch-subscribe starts the watch loop, changes are sent as watch-changed notifications
+//   - watch-unsubscribe stops the watch loop
+//
+// Public ip lookups are rate limited per provider like in watch mode.
+func runStdio(logger *slog.Logger) int {
+	guard = newProviderGuard(logger, providerMinInterval, breakerFailures, breakerCooldown)
+	conn := newRPCConn(os.Stdout)
+	w := &stdioWatch{}
+	defer w.stop()
+
+	err := conn.serve(logger, os.Stdin, func(method string, params json.RawMessage) (any, *rpcError) {
+		switch method {
+		case "collect":
+			return collectRPC(logger)
+		case "classify":
+			var p struct {
+				Address string `json:"address"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+			c, err := classifyAddress(p.Address)
+			if err != nil {
+				return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+			return c, nil
+		case "watch-subscribe":
+			return nil, w.start(logger, conn)
+		case "watch-unsubscribe":
+			w.stop()
+			return nil, nil
+		}
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + method}
+	})
+	if err != nil {
+		logger.Error("could not read request", "err", err)
+		return exitInternalError
+	}
+	return exitOK
+}
+
+// collectRPC returns the addresses selected by the flags. If the public lookup failed, the local addresses are
+// returned together with the error.
+func collectRPC(logger *slog.Logger) (any, *rpcError) {
+	list, err := getIpAddresses(logger)
+	result := map[string]any{}
+	if err != nil {
+		if len(list) == 0 {
+			return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		result["error"] = err.Error()
+	}
+	list, err = applyPlugins(logger, list)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: err.Error()}
+	}
+	result["addresses"] = list
+	return result, nil
+}
+
+// start runs the watch loop in the background, every change is sent as notification. The initial addresses are
+// sent as the first change. Fails if a subscription is active already.
+func (w *stdioWatch) start(logger *slog.Logger, conn *rpcConn) *rpcError {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel != nil {
+		return &rpcError{Code: rpcInvalidRequest, Message: "already subscribed"}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel, w.done = cancel, make(chan struct{})
+	go func() {
+		defer close(w.done)
+		watch(ctx, logger, func(logger *slog.Logger, c *change) int {
+			conn.notify(logger, "watch-changed", c)
+			return exitOK
+		})
+	}()
+	return nil
+}
+
+// stop ends the watch loop if one is running and waits for it to return.
+func (w *stdioWatch) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel, w.done = nil, nil
+}
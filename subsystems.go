@@ -0,0 +1,74 @@
+package main
+
+import "sort"
+
+// subsystem is a part of the toolbox left out of builds tagged ips_minimal. Its files carry the build constraint
+// `!ips_minimal || ips_full` and register the subsystem in an init function, so the core never references them.
+type subsystem struct {
+	name string
+
+	// commands are added to the verbs accepted as first argument
+	commands map[string]command
+
+	// flags registers the options of the subsystem, it is called before the flags are parsed
+	flags func()
+
+	// urls point to options holding urls given by the user, they are not considered third party services
+	urls []*string
+
+	// providers are added to the known public ip providers
+	providers []*provider
+
+	// formatters are added to the values of the output flag, failures render the reason no address list is
+	// available for formats that have to produce output in any case and return the exit code
+	formatters map[string]formatter
+	failures   map[string]func(reason string) int
+}
+
+// subsystems are the subsystems compiled into this build
+var subsystems []*subsystem
+
+// registerSubsystem adds the subsystem to the build, its commands and providers become available immediately.
+func registerSubsystem(s *subsystem) {
+	subsystems = append(subsystems, s)
+	for name, cmd := range s.commands {
+		commands[name] = cmd
+	}
+	knownProviders = append(knownProviders, s.providers...)
+	for name, f := range s.formatters {
+		formatters[name] = f
+	}
+	for name, f := range s.failures {
+		failures[name] = f
+	}
+}
+
+// registerSubsystemFlags registers the options of all subsystems.
+func registerSubsystemFlags() {
+	for _, s := range subsystems {
+		if s.flags != nil {
+			s.flags()
+		}
+	}
+}
+
+// subsystemURLs returns the urls configured for the subsystems.
+func subsystemURLs() []string {
+	urls := make([]string, 0)
+	for _, s := range subsystems {
+		for _, u := range s.urls {
+			urls = append(urls, *u)
+		}
+	}
+	return urls
+}
+
+// subsystemNames returns the names of the subsystems compiled into this build, sorted.
+func subsystemNames() []string {
+	names := make([]string, 0, len(subsystems))
+	for _, s := range subsystems {
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,151 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogTarget is the destination of change events, e.g. local, udp://host:514, tcp://host:514, tls://host:6514
+var syslogTarget string
+
+const (
+	// syslogPriority is facility daemon (3) with severity notice (5)
+	syslogPriority = 3*8 + 5
+
+	// syslogSDID is the structured data id, 32473 is the enterprise number reserved for documentation
+	syslogSDID = "ips@32473"
+)
+
+// syslogSink sends change events as RFC 5424 messages to a local or remote syslog daemon.
+type syslogSink struct {
+	mu      sync.Mutex
+	network string
+	address string
+	conn    net.Conn
+	logger  *slog.Logger
+}
+
+// newSyslogSink creates a sink sending to target: local, unix:///path, udp://host:514, tcp://host:514 or
+// tls://host:6514.
+func newSyslogSink(logger *slog.Logger, target string) (*syslogSink, error) {
+	if target == "local" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			if _, err := os.Stat(path); err == nil {
+				return &syslogSink{network: "unixgram", address: path, logger: logger}, nil
+			}
+		}
+		return nil, fmt.Errorf("no local syslog socket found")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+		return &syslogSink{network: u.Scheme, address: u.Host, logger: logger}, nil
+	case "unix", "unixgram":
+		return &syslogSink{network: "unixgram", address: u.Path, logger: logger}, nil
+	default:
+		return nil, fmt.Errorf("unsupported syslog scheme %q", u.Scheme)
+	}
+}
+
+// send writes the change as a single message, reconnecting once if the connection broke. A payload replaces the
+// summary of the change as message text. With dry-run set the message is printed instead.
+func (s *syslogSink) send(c *change, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.format(c, payload)
+	if dryRun {
+		fmt.Printf("would send to syslog over %s at %s\n%s\n", s.network, s.address, message)
+		return nil
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return fmt.Errorf("could not connect to syslog at %s: %w", s.address, err)
+			}
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(timeout))
+		_, err := s.conn.Write(message)
+		if err == nil {
+			return nil
+		}
+		s.logger.Debug("could not write to syslog", "err", err)
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return fmt.Errorf("could not write to syslog at %s", s.address)
+}
+
+// close closes the connection to the syslog daemon.
+func (s *syslogSink) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// connect dials the syslog daemon.
+func (s *syslogSink) connect() error {
+	var err error
+	start := time.Now()
+	dialer := &net.Dialer{Timeout: timeout}
+	if s.network == "tls" {
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.address, &tls.Config{})
+	} else {
+		s.conn, err = dialer.Dial(s.network, s.address)
+	}
+	audit(s.network, s.address, start, "", err)
+	return err
+}
+
+// format renders the change as RFC 5424 message with the addresses as structured data, the message text is the
+// payload or a summary of the change. Stream transports use octet counting framing as described in RFC 6587.
+func (s *syslogSink) format(c *change, payload []byte) []byte {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	params := make([]string, 0, len(c.Added)+len(c.Removed))
+	for _, i := range c.Added {
+		params = append(params, fmt.Sprintf(`added="%s"`, syslogEscape(i.key())))
+	}
+	for _, i := range c.Removed {
+		params = append(params, fmt.Sprintf(`removed="%s"`, syslogEscape(i.key())))
+	}
+	summary := fmt.Sprintf("%d added, %d removed", len(c.Added), len(c.Removed))
+	if c.Prefix != nil {
+		for _, p := range c.Prefix.Current {
+			params = append(params, fmt.Sprintf(`prefix="%s"`, syslogEscape(p)))
+		}
+		summary += ", delegated prefix changed"
+	}
+	if payload != nil {
+		summary = strings.TrimSpace(string(payload))
+	}
+	message := fmt.Sprintf("<%d>1 %s %s ips %d change [%s %s] %s",
+		syslogPriority, c.Time.Format(time.RFC3339Nano), hostname, os.Getpid(), syslogSDID, strings.Join(params, " "),
+		summary)
+	if s.network == "tcp" || s.network == "tls" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	return []byte(message)
+}
+
+// syslogEscape escapes a structured data parameter value as required by RFC 5424.
+func syslogEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "]", `\]`, "\t", " ").Replace(value)
+}
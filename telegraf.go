@@ -0,0 +1,49 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// influxTagEscaper escapes tag keys and values in the influx line protocol
+	influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+	// influxStringEscaper escapes string field values in the influx line protocol
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// telegrafMetrics renders the addresses as influx line protocol for telegraf's inputs.exec. Every address becomes
+// a point of the ips_address measurement, public addresses additionally carry the number of changes seen so far
+// in ips_public, and ips_lookup contains the duration of the public lookup.
+func telegrafMetrics(list ips, code int) (string, int, error) {
+	now := time.Now().UnixNano()
+	lines := make([]string, 0, len(list)+1)
+	for _, i := range list {
+		scope := "local"
+		if i.isPublic() {
+			scope = "public"
+		}
+		lines = append(lines, fmt.Sprintf(`ips_address,interface=%s,family=%s,scope=%s address="%s" %d`,
+			influxTagEscaper.Replace(i.Interface), i.family(), scope, influxStringEscaper.Replace(i.Address), now))
+		if !i.isPublic() {
+			continue
+		}
+		var changes uint64
+		if cached, ok := previousPublic[i.family()]; ok {
+			changes = cached.Changes
+			if cached.Address != i.Address {
+				changes++
+			}
+		}
+		lines = append(lines, fmt.Sprintf(`ips_public,family=%s address="%s",changes=%di %d`,
+			i.family(), influxStringEscaper.Replace(i.Address), changes, now))
+	}
+	public, local := splitPublic(list)
+	lines = append(lines, fmt.Sprintf("ips_lookup duration_ms=%.3f,addresses=%di,public=%di,exit_code=%di %d",
+		float64(publicLookupDuration.Microseconds())/1000, len(local), len(public), code, now))
+	return strings.Join(lines, "\n"), code, nil
+}
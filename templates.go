@@ -0,0 +1,268 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateCommandTimeout is the time a reload command may take before it is killed
+const templateCommandTimeout = 30 * time.Second
+
+type (
+
+	// fileTemplate is a template rendered into a file whenever the addresses change.
+	fileTemplate struct {
+		source, destination string
+
+		// command is run by the shell after the destination changed, e.g. to reload a service
+		command string
+
+		// flag is the flag value the template was parsed from
+		flag string
+	}
+
+	// templateValues is the flag.Value collecting the template flags.
+	templateValues struct{}
+
+	// templateData is passed to the templates.
+	templateData struct {
+
+		// Addresses are the addresses known to the watch loop
+		Addresses ips
+
+		Hostname string
+		Time     time.Time
+	}
+)
+
+// templates are rendered by watch on every change
+var templates []*fileTemplate
+
+// templateFuncs are available in templates in addition to the builtin functions
+var templateFuncs = template.FuncMap{
+	// family returns the addresses of a family, ipv4 or ipv6
+	"family": func(family string, list ips) ips {
+		return filterAddresses(list, func(i *ip) bool { return i.family() == family })
+	},
+	// public returns the addresses determined by the public ip providers
+	"public": func(list ips) ips {
+		return filterAddresses(list, func(i *ip) bool { return i.isPublic() })
+	},
+	// local returns the addresses of the interfaces
+	"local": func(list ips) ips {
+		return filterAddresses(list, func(i *ip) bool { return !i.isPublic() })
+	},
+	// iface returns the addresses of the named interface
+	"iface": func(name string, list ips) ips {
+		return filterAddresses(list, func(i *ip) bool { return i.Interface == name })
+	},
+	// global returns the addresses routable on the internet, public ones included
+	"global": func(list ips) ips {
+		return filterAddresses(list, func(i *ip) bool {
+			c, err := classifyAddress(i.Address)
+			return err == nil && c.Class == "global"
+		})
+	},
+	// addr strips the network length from an address
+	"addr": func(address string) string {
+		a, _, _ := strings.Cut(address, "/")
+		return a
+	},
+	// network returns the network an address belongs to in CIDR notation
+	"network": func(address string) (string, error) {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return "", err
+		}
+		return prefix.Masked().String(), nil
+	},
+	// addresses returns the addresses of a list without interfaces, e.g. for join
+	"addresses": func(list ips) []string {
+		result := make([]string, 0, len(list))
+		for _, i := range list {
+			result = append(result, i.Address)
+		}
+		return result
+	},
+
+	// the functions below follow the naming of sprig, arguments are ordered for use in pipelines
+
+	// default returns value unless it is empty, then fallback
+	"default": func(fallback, value any) any {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trim":      strings.TrimSpace,
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":      func(sep string, elements []string) string { return strings.Join(elements, sep) },
+	"quote":     strconv.Quote,
+	// indent prefixes every line of s with spaces
+	"indent": func(spaces int, s string) string {
+		padding := strings.Repeat(" ", spaces)
+		return padding + strings.ReplaceAll(s, "\n", "\n"+padding)
+	},
+	// toJson encodes value as JSON, e.g. to embed text in a JSON payload
+	"toJson": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"now": time.Now,
+	// date formats t using a Go layout, e.g. 2006-01-02 15:04
+	"date": func(layout string, t time.Time) string { return t.Format(layout) },
+}
+
+// Set parses and adds a template.
+func (templateValues) Set(value string) error {
+	return parseTemplate(value)
+}
+
+// String returns the templates as given, one per line.
+func (templateValues) String() string {
+	return strings.Join(templateValues{}.values(), "\n")
+}
+
+// reset removes all templates.
+func (templateValues) reset() {
+	templates = nil
+}
+
+// values returns the templates as given.
+func (templateValues) values() []string {
+	values := make([]string, 0, len(templates))
+	for _, t := range templates {
+		values = append(values, t.flag)
+	}
+	return values
+}
+
+// parseTemplate parses a template flag value of the form "source:destination[:command]" like consul-template does.
+// Windows drive letters are not taken for a separator.
+func parseTemplate(value string) error {
+	t := &fileTemplate{flag: value}
+	rest := value
+	for _, field := range []*string{&t.source, &t.destination} {
+		offset := 0
+		if len(rest) >= 3 && rest[1] == ':' && (rest[2] == '\\' || rest[2] == '/') {
+			offset = 2
+		}
+		idx := strings.Index(rest[offset:], ":")
+		if idx < 0 {
+			*field, rest = rest, ""
+			break
+		}
+		*field, rest = rest[:offset+idx], rest[offset+idx+1:]
+	}
+	t.command = strings.TrimSpace(rest)
+	if t.source == "" || t.destination == "" {
+		return fmt.Errorf("template %q is not of the form source:destination[:command]", value)
+	}
+	if _, err := os.Stat(t.source); err != nil {
+		return err
+	}
+	templates = append(templates, t)
+	return nil
+}
+
+// filterAddresses returns the addresses matching the predicate.
+func filterAddresses(list ips, keep func(*ip) bool) ips {
+	result := make(ips, 0, len(list))
+	for _, i := range list {
+		if keep(i) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// renderTemplates renders every template with the addresses. A destination is only replaced if its content
+// changed, atomically, and only then the command of the template is run. Failures are logged, the remaining
+// templates are rendered nevertheless.
+func renderTemplates(logger *slog.Logger, current ips) {
+	hostname, _ := os.Hostname()
+	data := &templateData{Addresses: current, Hostname: hostname, Time: time.Now()}
+	for _, t := range templates {
+		changed, err := t.render(data)
+		if err != nil {
+			logger.Error("could not render template", "err", err, "template", t.source)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would write %s\n", t.destination)
+			continue
+		}
+		logger.Info("rendered template", "template", t.source, "destination", t.destination)
+		if t.command == "" {
+			continue
+		}
+		if out, err := runTemplateCommand(t.command); err != nil {
+			logger.Error("template command failed", "err", err, "command", t.command, "output", strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+// render executes the template and writes the result to the destination unless it is unchanged. The template is
+// read on every call, so it can be edited while watch runs.
+func (t *fileTemplate) render(data *templateData) (bool, error) {
+	source, err := os.ReadFile(t.source)
+	if err != nil {
+		return false, err
+	}
+	tmpl, err := template.New(t.source).Funcs(templateFuncs).Parse(string(source))
+	if err != nil {
+		return false, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return false, err
+	}
+	if existing, err := os.ReadFile(t.destination); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	return true, writeAtomic(t.destination, buf.Bytes(), 0o644)
+}
+
+// runTemplateCommand runs the command by the shell of the platform and returns its combined output.
+func runTemplateCommand(command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), templateCommandTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	audit("exec", command, start, "", err)
+	return out, err
+}
+
+// shellCommand returns the command run by the shell of the platform.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
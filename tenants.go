@@ -0,0 +1,183 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultTenant is the tenant of the push-token option and of agents stored before tenants existed
+const defaultTenant = "default"
+
+// pushTokensFile names a file of tokens, each granting access to the agents of a tenant
+var pushTokensFile string
+
+type (
+
+	// apiToken grants access to the agents of a tenant.
+	apiToken struct {
+		tenant string
+
+		// hash is the SHA-256 of the token, tokens are not kept in plain text
+		hash [sha256.Size]byte
+
+		// push allows to push changes, read to query the agents
+		push, read bool
+	}
+
+	// tokenFile holds the tokens of the tokens file. The file is read again once it changed, so tokens are added,
+	// rotated and revoked without restarting the server.
+	tokenFile struct {
+		mu     sync.Mutex
+		file   *watchedFile
+		tokens []*apiToken
+	}
+)
+
+// tokens is set by the server mode if pushes are accepted
+var tokens *tokenFile
+
+// loadTokens reads the file of the push-tokens option, if given.
+func loadTokens(logger *slog.Logger) (*tokenFile, error) {
+	t := &tokenFile{}
+	if pushTokensFile == "" {
+		return t, nil
+	}
+	t.file = &watchedFile{path: pushTokensFile, logger: logger, parse: func(data []byte) error {
+		parsed, err := parseTokens(data)
+		if err == nil {
+			t.tokens = parsed
+		}
+		return err
+	}}
+	if err := t.file.read(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseTokens parses lines of the form "tenant token [push|read|all]", the scope defaults to all. Tokens given as
+// sha256:<hex> are compared by their hash. Empty lines and lines starting with # are ignored.
+func parseTokens(data []byte) ([]*apiToken, error) {
+	result := make([]*apiToken, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d is not of the form 'tenant token [push|read|all]'", line)
+		}
+		if !agentName.MatchString(fields[0]) {
+			return nil, fmt.Errorf("line %d: invalid tenant %q", line, fields[0])
+		}
+		token := &apiToken{tenant: fields[0], push: true, read: true}
+		if hash, ok := strings.CutPrefix(fields[1], "sha256:"); ok {
+			decoded, err := hex.DecodeString(hash)
+			if err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("line %d: invalid sha256 hash", line)
+			}
+			copy(token.hash[:], decoded)
+		} else {
+			token.hash = sha256.Sum256([]byte(fields[1]))
+		}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "push":
+				token.read = false
+			case "read":
+				token.push = false
+			case "all":
+			default:
+				return nil, fmt.Errorf("line %d: unknown scope %q", line, fields[2])
+			}
+		}
+		result = append(result, token)
+	}
+	return result, scanner.Err()
+}
+
+// lookup returns the token given by a client, nil if it is unknown.
+func (t *tokenFile) lookup(given string) *apiToken {
+	hash := sha256.Sum256([]byte(given))
+	if pushToken != "" && subtle.ConstantTimeCompare([]byte(given), []byte(pushToken)) == 1 {
+		return &apiToken{tenant: defaultTenant, hash: hash, push: true, read: true}
+	}
+	if t.file == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file.refresh()
+	var found *apiToken
+	for _, token := range t.tokens {
+		if subtle.ConstantTimeCompare(hash[:], token.hash[:]) == 1 {
+			found = token
+		}
+	}
+	return found
+}
+
+// authorize returns the tenant of the bearer token of the request if it allows to push or read, as asked by push.
+// Requests reading may give the token in the cookie of the web UI instead. It answers 401 for a missing or unknown
+// token and 403 for a token lacking the scope.
+func authorize(w http.ResponseWriter, r *http.Request, push bool) (string, bool) {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if cookie, err := r.Cookie(uiCookie); !ok && !push && err == nil {
+		// the cookie of the web UI is sent by browsers on their own, so it is not accepted for pushes
+		given, ok = cookie.Value, true
+	}
+	var token *apiToken
+	if ok && given != "" {
+		token = tokens.lookup(given)
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ips"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if push && !token.push || !push && !token.read {
+		http.Error(w, "token does not allow this request", http.StatusForbidden)
+		return "", false
+	}
+	return token.tenant, true
+}
+
+// runToken creates a random token for the tenant given as argument and prints it with the line granting it access
+// in the file of the push-tokens option. Only the hash is written to the file.
+func runToken(logger *slog.Logger, args []string) int {
+	if len(args) < 1 || len(args) > 2 || !agentName.MatchString(args[0]) {
+		logger.Error("usage: ips token <tenant> [push|read|all]")
+		return exitInternalError
+	}
+	scope := "all"
+	if len(args) == 2 {
+		scope = args[1]
+	}
+	if _, err := parseTokens([]byte(args[0] + " x " + scope)); err != nil {
+		logger.Error("invalid scope", "err", err)
+		return exitInternalError
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		logger.Error("could not create token", "err", err)
+		return exitInternalError
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	hash := sha256.Sum256([]byte(token))
+	fmt.Printf("token\t%s\n", token)
+	fmt.Printf("line\t%s sha256:%s %s\n", args[0], hex.EncodeToString(hash[:]), scope)
+	return exitOK
+}
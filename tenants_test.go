@@ -0,0 +1,142 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	hashed := sha256.Sum256([]byte("hashed-secret"))
+	tests := []struct {
+		name    string
+		data    string
+		want    []apiToken
+		wantErr bool
+	}{
+		{name: "empty", data: "", want: []apiToken{}},
+		{
+			name: "scopes",
+			data: "team-a secret-a\nteam-b secret-b push\nteam-c secret-c read\nteam-d secret-d all\n",
+			want: []apiToken{
+				{tenant: "team-a", hash: sha256.Sum256([]byte("secret-a")), push: true, read: true},
+				{tenant: "team-b", hash: sha256.Sum256([]byte("secret-b")), push: true},
+				{tenant: "team-c", hash: sha256.Sum256([]byte("secret-c")), read: true},
+				{tenant: "team-d", hash: sha256.Sum256([]byte("secret-d")), push: true, read: true},
+			},
+		},
+		{
+			name: "comments and blank lines",
+			data: "# tokens of team a\n\n   \n  team-a   secret-a  \n#team-b secret-b\n",
+			want: []apiToken{{tenant: "team-a", hash: sha256.Sum256([]byte("secret-a")), push: true, read: true}},
+		},
+		{
+			name: "hashed token",
+			data: "team-a sha256:" + hex.EncodeToString(hashed[:]) + " read\n",
+			want: []apiToken{{tenant: "team-a", hash: hashed, read: true}},
+		},
+		{name: "token missing", data: "team-a\n", wantErr: true},
+		{name: "too many fields", data: "team-a secret all extra\n", wantErr: true},
+		{name: "unknown scope", data: "team-a secret write\n", wantErr: true},
+		{name: "invalid tenant", data: "team/a secret\n", wantErr: true},
+		{name: "tenant starting with a dot", data: ".team secret\n", wantErr: true},
+		{name: "invalid hash", data: "team-a sha256:xyz\n", wantErr: true},
+		{name: "short hash", data: "team-a sha256:abcd\n", wantErr: true},
+		{name: "error after valid lines", data: "team-a secret\nteam-b secret nope\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTokens([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d tokens, want an error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d tokens, want %d", len(got), len(tt.want))
+			}
+			for i, token := range got {
+				if *token != tt.want[i] {
+					t.Errorf("token %d: got %+v, want %+v", i, *token, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("team-a pusher push\nteam-a reader read\nteam-b both\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previousFile, previousToken, previousTokens := pushTokensFile, pushToken, tokens
+	t.Cleanup(func() { pushTokensFile, pushToken, tokens = previousFile, previousToken, previousTokens })
+	pushTokensFile, pushToken = path, "default-secret"
+	var err error
+	if tokens, err = loadTokens(slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		push          bool
+		wantTenant    string
+		wantStatus    int
+	}{
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "unknown token", authorization: "Bearer guessed", wantStatus: http.StatusUnauthorized},
+		{name: "empty token", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "basic auth", authorization: "Basic cmVhZGVyOg==", wantStatus: http.StatusUnauthorized},
+		{name: "token without scheme", authorization: "reader", wantStatus: http.StatusUnauthorized},
+		{name: "push", authorization: "Bearer pusher", push: true, wantTenant: "team-a"},
+		{name: "push token reading", authorization: "Bearer pusher", wantStatus: http.StatusForbidden},
+		{name: "read", authorization: "Bearer reader", wantTenant: "team-a"},
+		{name: "read token pushing", authorization: "Bearer reader", push: true, wantStatus: http.StatusForbidden},
+		{name: "all scopes push", authorization: "Bearer both", push: true, wantTenant: "team-b"},
+		{name: "all scopes read", authorization: "Bearer both", wantTenant: "team-b"},
+		{name: "push-token option", authorization: "Bearer default-secret", push: true, wantTenant: defaultTenant},
+		{name: "cookie reading", cookie: "reader", wantTenant: "team-a"},
+		{name: "cookie pushing", cookie: "both", push: true, wantStatus: http.StatusUnauthorized},
+		{name: "unknown cookie", cookie: "guessed", wantStatus: http.StatusUnauthorized},
+		{name: "bearer token before cookie", authorization: "Bearer both", cookie: "reader", wantTenant: "team-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/agents", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: uiCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			tenant, ok := authorize(w, r, tt.push)
+			if tt.wantStatus != 0 {
+				if ok || w.Code != tt.wantStatus {
+					t.Fatalf("got tenant %q and status %d, want status %d", tenant, w.Code, tt.wantStatus)
+				}
+				if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("WWW-Authenticate header missing")
+				}
+				return
+			}
+			if !ok || tenant != tt.wantTenant {
+				t.Fatalf("got tenant %q (%t) and status %d, want tenant %q", tenant, ok, w.Code, tt.wantTenant)
+			}
+		})
+	}
+}
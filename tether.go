@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// tethering describes how an adapter reaches the internet through a phone.
+type tethering struct {
+
+	// link is the connection to the phone, usb or bluetooth
+	link string
+
+	// device names the phone or its vendor, empty if unknown
+	device string
+
+	// probable is set for adapters that are also found in docks and ethernet dongles, they only count as tethered
+	// if their address is out of tetherNetworks
+	probable bool
+}
+
+// tetherNames map prefixes of interface names to the tethering they provide, checked in order. Names are compared
+// in lower case, Windows names adapters by their description.
+var tetherNames = []struct {
+	prefix string
+	tethering
+}{
+	{"bnep", tethering{link: "bluetooth"}},
+	{"bluetooth device (personal area network", tethering{link: "bluetooth"}},
+	{"remote ndis", tethering{link: "usb", device: "Android"}},
+	{"apple mobile device ethernet", tethering{link: "usb", device: "iPhone"}},
+}
+
+// tetherNetworks are the networks phones assign to tethered clients unless configured otherwise
+var tetherNetworks = []struct {
+	network *net.IPNet
+	device  string
+}{
+	{mustParseCIDR("172.20.10.0/28"), "iPhone"},
+	{mustParseCIDR("192.168.42.0/24"), "Android"},
+	{mustParseCIDR("192.168.44.0/24"), "Android"},
+}
+
+// tetherKind returns how the adapter is tethered to a phone, judged by its name or on Linux by its driver, or nil
+// for adapters that are not.
+func tetherKind(i net.Interface) *tethering {
+	var t *tethering
+	name := strings.ToLower(i.Name)
+	for _, n := range tetherNames {
+		if strings.HasPrefix(name, n.prefix) {
+			found := n.tethering
+			t = &found
+			break
+		}
+	}
+	if t == nil {
+		t = tetherDriver(i.Name)
+	}
+	if t != nil && t.link == "bluetooth" && t.device == "" {
+		t.device = bluetoothPeer(i.Name)
+	}
+	return t
+}
+
+// labelTethered labels the addresses of adapters tethered to a phone using Bluetooth PAN or USB (RNDIS, NCM or
+// the iPhone driver) with tether and the link, and with upstream and the probable phone if it can be told. Roaming
+// users see where their addressing comes from. Interfaces that cannot be listed are logged only.
+func labelTethered(logger *slog.Logger, list ips) ips {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logger.Warn("could not get interfaces", "err", err)
+		return list
+	}
+	kinds := make(map[string]*tethering)
+	for _, i := range interfaces {
+		if t := tetherKind(i); t != nil {
+			kinds[i.Name] = t
+		}
+	}
+
+	// the network of any address of an adapter confirms probable tethering and tells the phone
+	for _, i := range list {
+		t, ok := kinds[i.Interface]
+		if !ok {
+			continue
+		}
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil {
+			continue
+		}
+		for _, n := range tetherNetworks {
+			if n.network.Contains(address) {
+				t.probable = false
+				if t.device == "" {
+					t.device = n.device
+				}
+			}
+		}
+	}
+	for _, i := range list {
+		t, ok := kinds[i.Interface]
+		if !ok || t.probable {
+			continue
+		}
+		if i.Labels == nil {
+			i.Labels = make(map[string]string)
+		}
+		i.Labels["tether"] = t.link
+		if t.device != "" {
+			i.Labels["upstream"] = t.device
+		}
+	}
+	return list
+}
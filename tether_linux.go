@@ -0,0 +1,87 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tetherDrivers map the kernel drivers of USB network adapters to the tethering they provide. NCM and ECM are also
+// used by docks and ethernet dongles.
+var tetherDrivers = map[string]tethering{
+	"rndis_host": {link: "usb"},
+	"ipheth":     {link: "usb", device: "iPhone"},
+	"cdc_ncm":    {link: "usb", probable: true},
+	"cdc_ether":  {link: "usb", probable: true},
+}
+
+// tetherDriver returns the tethering provided by the driver of the interface with the manufacturer and product
+// of the USB device as phone, or nil if the driver is not used for tethering.
+func tetherDriver(name string) *tethering {
+	device := filepath.Join("/sys/class/net", name, "device")
+	driver, err := os.Readlink(filepath.Join(device, "driver"))
+	if err != nil {
+		return nil
+	}
+	t, ok := tetherDrivers[filepath.Base(driver)]
+	if !ok {
+		return nil
+	}
+	// the device of the interface is the USB interface, its parent the USB device
+	usb, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return &t
+	}
+	usb = filepath.Dir(usb)
+	manufacturer := readSysfs(filepath.Join(usb, "manufacturer"))
+	product := readSysfs(filepath.Join(usb, "product"))
+	switch {
+	case product != "" && manufacturer != "" && !strings.HasPrefix(product, manufacturer):
+		t.device = manufacturer + " " + product
+	case product != "":
+		t.device = product
+	}
+	return &t
+}
+
+// readSysfs returns the trimmed content of a sysfs attribute, empty if it cannot be read.
+func readSysfs(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// bluetoothPeer returns the phone a Bluetooth PAN interface is connected to: its name as stored by BlueZ if
+// readable, its Bluetooth address otherwise. The phone sends from its Bluetooth address, so it is found in the
+// neighbor table of the interface.
+func bluetoothPeer(name string) string {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[5] != name || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		peer := strings.ToUpper(fields[3])
+		infos, _ := filepath.Glob(filepath.Join("/var/lib/bluetooth", "*", peer, "info"))
+		for _, info := range infos {
+			for _, line := range strings.Split(readSysfs(info), "\n") {
+				if value, ok := strings.CutPrefix(line, "Name="); ok && value != "" {
+					return value
+				}
+			}
+		}
+		return peer
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// tetherDriver returns nil, drivers are only inspected on Linux.
+func tetherDriver(_ string) *tethering {
+	return nil
+}
+
+// bluetoothPeer returns an empty string, the peer is only looked up on Linux.
+func bluetoothPeer(_ string) string {
+	return ""
+}
@@ -0,0 +1,258 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	// traceBasePort is the udp port probes are sent to, incremented by the hop limit like traceroute does
+	traceBasePort = 33434
+
+	// maxTraceHops bounds the number of hops probed
+	maxTraceHops = 30
+
+	// maxSilentHops stops a trace after that many hops in a row did not answer
+	maxSilentHops = 5
+
+	// hopTimeout is the time a single hop has to answer
+	hopTimeout = time.Second
+)
+
+type (
+
+	// traceHop is a router on the path to the provider.
+	traceHop struct {
+		TTL int
+
+		// Address is the router answering, empty if the hop did not answer
+		Address string `json:",omitempty"`
+
+		// Class is the class of Address as determined by classifyAddress, e.g. private, cgnat or global
+		Class string `json:",omitempty"`
+
+		// RTT is the time the answer took
+		RTT string `json:",omitempty"`
+
+		// Reached is set for the answer of the provider itself
+		Reached bool `json:",omitempty"`
+	}
+
+	// traceResult is the path to the provider as printed by ips trace-public.
+	traceResult struct {
+		Provider string
+		Target   string
+		Family   string
+
+		// Method tells how the answers of the hops were received: icmp using a raw socket or errqueue using the
+		// error queue of the probe socket (Linux, no privileges required)
+		Method string
+
+		Hops []*traceHop
+	}
+
+	// hopAnswer is the origin of an ICMP error caused by a probe.
+	hopAnswer struct {
+		from net.IP
+
+		// unreachable is set if the answer was destination unreachable instead of time exceeded, e.g. port
+		// unreachable sent by the destination itself
+		unreachable bool
+	}
+)
+
+// runTracePublic traces the path to the first selected http provider of the family given as argument, ipv4 by
+// default, and classifies every hop. Private and cgnat hops in front of the first global one reveal the layers of
+// NAT in front of the host. Probes are udp datagrams with increasing hop limits; the answers are read from a raw
+// ICMP socket, which requires privileges, or on Linux from the error queue of the probe socket.
+func runTracePublic(logger *slog.Logger, args []string) int {
+	family := "ipv4"
+	if len(args) > 1 || len(args) == 1 && args[0] != "ipv4" && args[0] != "ipv6" {
+		logger.Error("usage: ips trace-public [ipv4|ipv6]")
+		return exitInternalError
+	}
+	if len(args) == 1 {
+		family = args[0]
+	}
+	if offline {
+		logger.Error("trace-public needs the network")
+		return exitInternalError
+	}
+	result, err := tracePublic(family)
+	if err != nil {
+		logger.Error("could not trace path to provider", "err", err)
+		return exitInternalError
+	}
+
+	if jsonOutput {
+		return printJSON(logger, result)
+	}
+	fmt.Printf("trace\t%s\t%s\t%s\n", result.Provider, result.Target, result.Method)
+	for _, h := range result.Hops {
+		if h.Address == "" {
+			fmt.Printf("%d\t*\n", h.TTL)
+			continue
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", h.TTL, h.Address, h.Class, h.RTT)
+	}
+	return exitOK
+}
+
+// tracePublic traces the path to the first selected http provider of the family.
+func tracePublic(family string) (*traceResult, error) {
+	name, target, err := traceTarget(family)
+	if err != nil {
+		return nil, err
+	}
+	result := &traceResult{Provider: name, Target: target.String(), Family: family, Hops: make([]*traceHop, 0)}
+
+	probe := probeICMP
+	conn, err := net.ListenPacket(icmpNetwork(family), "")
+	result.Method = "icmp"
+	if err != nil {
+		probe, result.Method = probeErrQueue, "errqueue"
+	} else {
+		defer conn.Close()
+	}
+
+	begin := time.Now()
+	silent := 0
+	for ttl := 1; ttl <= maxTraceHops && silent < maxSilentHops; ttl++ {
+		hop := &traceHop{TTL: ttl}
+		result.Hops = append(result.Hops, hop)
+		start := time.Now()
+		answer, err := probe(conn, family, target, ttl)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil, fmt.Errorf("tracing requires root privileges on this platform: %w", err)
+		}
+		if err != nil && !isTimeout(err) {
+			return nil, err
+		}
+		if answer == nil {
+			silent++
+			continue
+		}
+		silent = 0
+		hop.Address, hop.RTT = answer.from.String(), time.Since(start).Round(time.Microsecond).String()
+		hop.Reached = answer.from.Equal(target)
+		if c, err := classifyAddress(hop.Address); err == nil {
+			hop.Class = c.Class
+		}
+		// no hop behind the destination or a router refusing to forward the probe will answer
+		if hop.Reached || answer.unreachable {
+			break
+		}
+	}
+	audit("trace", target.String(), begin, "", nil)
+	return result, nil
+}
+
+// traceTarget returns the name and an address of the family of the first selected provider queried using http.
+func traceTarget(family string) (string, net.IP, error) {
+	providers, err := selectedProviders()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range providers {
+		if p.Command != "" || p.Lookup != nil {
+			continue
+		}
+		raw, ok := p.URLs[family]
+		if !ok {
+			if raw, ok = p.URLs["any"]; !ok {
+				continue
+			}
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return "", nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		cancel()
+		if err != nil {
+			return "", nil, err
+		}
+		if target := firstOfFamily(addrs, family); target != nil {
+			return p.Name, target, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no selected http provider supports %s", family)
+}
+
+// icmpNetwork returns the network of a raw ICMP socket of the family.
+func icmpNetwork(family string) string {
+	if family == "ipv4" {
+		return "ip4:icmp"
+	}
+	return "ip6:ipv6-icmp"
+}
+
+// sendProbe sends a udp datagram to the trace port of the hop limit.
+func sendProbe(family string, target net.IP, ttl int) (*net.UDPConn, error) {
+	probe, err := net.DialUDP("udp"+family[3:], nil, &net.UDPAddr{IP: target, Port: traceBasePort + ttl})
+	if err != nil {
+		return nil, err
+	}
+	if err := setHopLimit(probe, family, ttl); err != nil {
+		_ = probe.Close()
+		return nil, err
+	}
+	if _, err := probe.Write([]byte("ips")); err != nil {
+		_ = probe.Close()
+		return nil, err
+	}
+	return probe, nil
+}
+
+// probeICMP sends a probe and reads the ICMP error it causes from the raw socket conn. The answer is matched by
+// the destination of the datagram quoted in the error. Returns nil if the hop did not answer in time.
+func probeICMP(conn net.PacketConn, family string, target net.IP, ttl int) (*hopAnswer, error) {
+	probe, err := sendProbe(family, target, ttl)
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+	port := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+
+	// ICMP and ICMPv6 types of time exceeded and destination unreachable, and the size of the quoted ip header
+	exceeded, unreachable, quoted := byte(11), byte(3), 20
+	if family == "ipv6" {
+		exceeded, unreachable, quoted = 3, 1, 40
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(hopTimeout))
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if isTimeout(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := buf[:n]
+		if len(msg) < 8 || msg[0] != exceeded && msg[0] != unreachable {
+			continue
+		}
+		inner := msg[8:]
+		if family == "ipv4" && len(inner) > 0 {
+			quoted = int(inner[0]&0x0f) * 4
+		}
+		if len(inner) < quoted+4 {
+			continue
+		}
+		udp := inner[quoted:]
+		if binary.BigEndian.Uint16(udp[0:2]) != port || binary.BigEndian.Uint16(udp[2:4]) != uint16(traceBasePort+ttl) {
+			continue
+		}
+		return &hopAnswer{from: from.(*net.IPAddr).IP, unreachable: msg[0] == unreachable}, nil
+	}
+}
@@ -0,0 +1,38 @@
+//go:build darwin && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// traceSupport names the socket answers of hops are read from, which requires root
+const traceSupport = "raw icmp socket"
+
+// setHopLimit sets the hop limit of the datagrams sent using conn.
+func setHopLimit(conn *net.UDPConn, family string, ttl int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, hops := syscall.IPPROTO_IP, syscall.IP_TTL
+	if family == "ipv6" {
+		level, hops = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, hops, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+// probeErrQueue is not implemented on this platform, the answers of the hops need a raw ICMP socket.
+func probeErrQueue(_ net.PacketConn, _ string, _ net.IP, _ int) (*hopAnswer, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,108 @@
+//go:build linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// traceSupport names how hops are traced without privileges
+const traceSupport = "error queue"
+
+// origins of extended socket errors (linux/errqueue.h)
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// setHopLimit sets the hop limit of the datagrams sent using conn and makes the kernel queue the ICMP errors they
+// cause, so probeErrQueue can read them without privileges.
+func setHopLimit(conn *net.UDPConn, family string, ttl int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, hops, recvErr := syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_RECVERR
+	if family == "ipv6" {
+		level, hops, recvErr = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, syscall.IPV6_RECVERR
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if sockErr = syscall.SetsockoptInt(int(fd), level, hops, ttl); sockErr == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), level, recvErr, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+// probeErrQueue sends a probe and reads the ICMP error it causes from the error queue of the probe socket. Returns
+// nil if the hop did not answer in time.
+func probeErrQueue(_ net.PacketConn, family string, target net.IP, ttl int) (*hopAnswer, error) {
+	probe, err := sendProbe(family, target, ttl)
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+	raw, err := probe.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	_ = probe.SetReadDeadline(time.Now().Add(hopTimeout))
+
+	var answer *hopAnswer
+	var readErr error
+	buf, oob := make([]byte, 512), make([]byte, 512)
+	err = raw.Read(func(fd uintptr) bool {
+		_, oobn, _, _, err := syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE)
+		if err == syscall.EAGAIN {
+			return false
+		}
+		if err != nil {
+			readErr = os.NewSyscallError("recvmsg", err)
+			return true
+		}
+		answer, readErr = parseErrQueue(oob[:oobn])
+		return true
+	})
+	if isTimeout(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return answer, readErr
+}
+
+// parseErrQueue returns the sender of the ICMP error found in the control messages of the error queue. The
+// struct sock_extended_err is followed by the address of the sender.
+func parseErrQueue(oob []byte) (*hopAnswer, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, os.NewSyscallError("parsesocketcontrolmessage", err)
+	}
+	for _, m := range messages {
+		data := m.Data
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR:
+			if len(data) < 24 || data[4] != soEEOriginICMP {
+				continue
+			}
+			from := net.IP(append([]byte(nil), data[20:24]...))
+			return &hopAnswer{from: from, unreachable: data[5] == 3}, nil
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR:
+			if len(data) < 40 || data[4] != soEEOriginICMP6 || binary.NativeEndian.Uint16(data[16:18]) != syscall.AF_INET6 {
+				continue
+			}
+			from := net.IP(append([]byte(nil), data[24:40]...))
+			return &hopAnswer{from: from, unreachable: data[5] == 1}, nil
+		}
+	}
+	return nil, nil
+}
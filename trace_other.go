@@ -0,0 +1,21 @@
+//go:build !darwin && !linux && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// traceSupport is empty, tracing is not available on this platform
+const traceSupport = ""
+
+// setHopLimit is not implemented on this platform.
+func setHopLimit(_ *net.UDPConn, _ string, _ int) error {
+	return errors.ErrUnsupported
+}
+
+// probeErrQueue is not implemented on this platform.
+func probeErrQueue(_ net.PacketConn, _ string, _ net.IP, _ int) (*hopAnswer, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// fallbackDelay is the head start of the preferred address family when racing connections
+const fallbackDelay = 300 * time.Millisecond
+
+var (
+	// preferV6 makes http connections try ipv6 first and race ipv4 only after fallbackDelay
+	preferV6 bool
+
+	// forceV4Transport makes http connections use ipv4 only
+	forceV4Transport bool
+)
+
+// dialed is the outcome of connecting to the addresses of one family.
+type dialed struct {
+	conn net.Conn
+	err  error
+}
+
+// setupTransport makes the default http transport, which the transports of all http clients are derived from,
+// connect using the family selected by preferV6 or forceV4Transport. Requests to dual-stack provider endpoints keep
+// using the family looked up. Without either flag the order of the resolver decides.
+func setupTransport() {
+	if !preferV6 && !forceV4Transport {
+		return
+	}
+	http.DefaultTransport.(*http.Transport).DialContext = dialPreferred
+}
+
+// dialPreferred connects to address using happy eyeballs (RFC 8305) with ipv6 preferred, or using ipv4 only if
+// forceV4Transport is set.
+func dialPreferred(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if forceV4Transport {
+		return dialer.DialContext(ctx, networkFor("ipv4"), address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primary, fallback := make([]string, 0), make([]string, 0)
+	for _, a := range addrs {
+		if a.IP.To4() == nil {
+			primary = append(primary, net.JoinHostPort(a.String(), port))
+			continue
+		}
+		fallback = append(fallback, net.JoinHostPort(a.String(), port))
+	}
+	if len(primary) == 0 {
+		primary, fallback = fallback, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialed, 2)
+	dialAll := func(targets []string) {
+		var errs []error
+		for _, target := range targets {
+			conn, err := dialer.DialContext(ctx, network, target)
+			if err == nil {
+				results <- dialed{conn: conn}
+				return
+			}
+			errs = append(errs, err)
+		}
+		results <- dialed{err: errors.Join(errs...)}
+	}
+	go dialAll(primary)
+	running := 1
+	startFallback := func() {
+		if fallback != nil {
+			go dialAll(fallback)
+			running++
+			fallback = nil
+		}
+	}
+
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+	errs := make([]error, 0, 2)
+	for {
+		select {
+		case <-timer.C:
+			startFallback()
+		case r := <-results:
+			running--
+			if r.err == nil {
+				// a connection established by the other family after all is not needed
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(running)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			startFallback()
+			if running == 0 {
+				return nil, errors.Join(errs...)
+			}
+		}
+	}
+}
+
+// networkFor returns the dial network restricting connections to family.
+func networkFor(family string) string {
+	if family == "ipv4" {
+		return "tcp4"
+	}
+	return "tcp6"
+}
@@ -0,0 +1,201 @@
+//go:build (linux || windows || (darwin && cgo)) && (!ips_minimal || ips_full)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
+	"fyne.io/systray"
+	"github.com/godbus/dbus/v5"
+)
+
+// traySupport names what the tray command offers, clicked addresses are copied to the clipboard
+const traySupport = "tray icon"
+
+func init() {
+	registerSubsystem(&subsystem{name: "tray", commands: map[string]command{"tray": runTray}})
+}
+
+// tray keeps the addresses shown in the menu of the tray icon.
+type tray struct {
+	mu      sync.Mutex
+	logger  *slog.Logger
+	current ips
+
+	// notifications announces all changes but the initial addresses
+	notifications *desktopSink
+
+	// done is closed when the menu is rebuilt, ending the click handlers of the previous items
+	done chan struct{}
+}
+
+// runTray shows an icon in the system tray titled with the public ip. Its menu lists the public and interface
+// addresses, clicking one copies it to the clipboard. Changes found by the watch loop update the menu and are
+// announced using desktop notifications.
+func runTray(logger *slog.Logger, _ []string) int {
+	if runtime.GOOS == "linux" {
+		// the icon is registered using the session bus, systray crashes without one
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			logger.Error("could not connect to session bus", "err", err)
+			return exitInternalError
+		}
+		_ = conn.Close()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	t := &tray{logger: logger, notifications: &desktopSink{logger: logger}}
+	systray.Run(func() {
+		systray.SetIcon(trayIcon())
+		systray.SetTitle("ips")
+		systray.SetTooltip("ips")
+		t.rebuild()
+		go func() {
+			watch(ctx, logger, t.update)
+			systray.Quit()
+		}()
+	}, stop)
+	return exitOK
+}
+
+// update applies a change to the menu and announces it.
+func (t *tray) update(_ *slog.Logger, c *change) int {
+	t.mu.Lock()
+	t.current = applyChange(t.current, c)
+	t.mu.Unlock()
+	t.rebuild()
+	_ = t.notifications.send(c, nil)
+	return exitOK
+}
+
+// rebuild replaces the menu by the current addresses and updates the title.
+func (t *tray) rebuild() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done != nil {
+		close(t.done)
+	}
+	t.done = make(chan struct{})
+	systray.ResetMenu()
+
+	title, tooltip := "ips", make([]string, 0)
+	for _, family := range []string{"ipv6", "ipv4"} {
+		for _, i := range t.current {
+			if i.isPublic() && i.family() == family {
+				title = i.Address
+				tooltip = append([]string{i.Address}, tooltip...)
+			}
+		}
+	}
+	systray.SetTitle(title)
+	if len(tooltip) > 0 {
+		systray.SetTooltip(strings.Join(tooltip, "\n"))
+	}
+
+	for _, public := range []bool{true, false} {
+		added := false
+		for _, i := range t.current {
+			if i.isPublic() != public {
+				continue
+			}
+			added = true
+			glyph := glyphLocal
+			if public {
+				glyph = glyphPublic
+			}
+			address, _, _ := strings.Cut(i.Address, "/")
+			item := systray.AddMenuItem(fmt.Sprintf("%s %s (%s)", glyph, i.Address, i.Interface), "copy "+address)
+			t.onClick(item, func() {
+				if err := copyToClipboard(address); err != nil {
+					t.logger.Warn("could not copy address", "err", err)
+				}
+			})
+		}
+		if added {
+			systray.AddSeparator()
+		}
+	}
+	t.onClick(systray.AddMenuItem("Quit", "quit ips"), systray.Quit)
+}
+
+// onClick calls f whenever the item is clicked until the menu is rebuilt.
+func (t *tray) onClick(item *systray.MenuItem, f func()) {
+	done := t.done
+	go func() {
+		for {
+			select {
+			case <-item.ClickedCh:
+				f()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// copyToClipboard puts text on the clipboard using the tools shipped with the platform, on linux the first of
+// wl-copy, xclip and xsel found is used.
+func copyToClipboard(text string) error {
+	candidates := [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard tool found")
+}
+
+// trayIcon draws the icon, a filled circle. Windows expects an ico file, which may embed the png.
+func trayIcon() []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	fill := color.NRGBA{R: 0x2b, G: 0x7b, B: 0xb9, A: 0xff}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := 2*x+1-size, 2*y+1-size
+			if dx*dx+dy*dy <= (size-2)*(size-2) {
+				img.Set(x, y, fill)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	if runtime.GOOS != "windows" {
+		return buf.Bytes()
+	}
+
+	// ICONDIR followed by a single ICONDIRENTRY pointing to the png
+	ico := make([]byte, 22, 22+buf.Len())
+	binary.LittleEndian.PutUint16(ico[2:4], 1)
+	binary.LittleEndian.PutUint16(ico[4:6], 1)
+	ico[6], ico[7] = size, size
+	binary.LittleEndian.PutUint16(ico[10:12], 1)
+	binary.LittleEndian.PutUint16(ico[12:14], 32)
+	binary.LittleEndian.PutUint32(ico[14:18], uint32(buf.Len()))
+	binary.LittleEndian.PutUint32(ico[18:22], 22)
+	return append(ico, buf.Bytes()...)
+}
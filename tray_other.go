@@ -0,0 +1,18 @@
+//go:build !linux && !windows && !(darwin && cgo) && (!ips_minimal || ips_full)
+
+package main
+
+import "log/slog"
+
+// traySupport is empty, the tray command is not available on this platform or build
+const traySupport = ""
+
+func init() {
+	registerSubsystem(&subsystem{name: "tray", commands: map[string]command{"tray": runTray}})
+}
+
+// runTray is not supported on this platform, on macOS it requires building with cgo.
+func runTray(logger *slog.Logger, _ []string) int {
+	logger.Error("the tray command is not supported on this platform")
+	return exitInternalError
+}
@@ -0,0 +1,153 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// uiCookie is the cookie the web UI keeps the token of the user in
+const uiCookie = "ips_token"
+
+// uiFuncs are the functions available to the pages of the web UI
+var uiFuncs = template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return time.Since(t).Truncate(time.Second).String() + " ago"
+	},
+	"join": strings.Join,
+	"newest": func(list []*change) []*change {
+		result := slices.Clone(list)
+		slices.Reverse(result)
+		return result
+	},
+}
+
+// uiLayout is shared by the pages of the web UI, they define the content
+const uiLayout = `{{ define "layout" }}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="60"><title>ips {{ .Title }}</title>
+<style>body{font-family:sans-serif}td,th{padding:2px 8px;text-align:left;vertical-align:top}.removed{color:#b00}
+.added{color:#070}.violation{color:#b00}form.logout{float:right}</style>
+</head><body>{{ if .Tenant }}<form class="logout" method="post" action="/ui/logout">{{ .Tenant }}
+<button>log out</button></form>{{ end }}<h1>{{ .Title }}</h1>{{ template "content" . }}</body></html>{{ end }}`
+
+var (
+	// loginPage asks for a token of the tenant
+	loginPage = template.Must(template.New("login").Funcs(uiFuncs).Parse(uiLayout + `{{ define "content" }}
+<form method="post" action="/ui/login"><label>Token <input type="password" name="token" autofocus></label>
+<button>log in</button></form>{{ if .Error }}<p class="violation">{{ .Error }}</p>{{ end }}{{ end }}`))
+
+	// fleetPage lists the agents of the tenant with their addresses
+	fleetPage = template.Must(template.New("fleet").Funcs(uiFuncs).Parse(uiLayout + `{{ define "content" }}
+<table><tr><th>host</th><th>addresses</th><th>last seen</th><th>source</th><th>violations</th></tr>
+{{- range .Agents }}
+<tr><td><a href="/ui/agents/{{ .Name }}">{{ .Name }}</a></td><td>{{ range .Addresses }}{{ .Address }} {{ .Interface }}<br>
+{{ end }}</td><td>{{ ago .Updated }}</td><td>{{ .Source }}</td><td class="violation">{{ len .Violations }}</td></tr>
+{{- else }}
+<tr><td colspan="5">no agent pushed yet</td></tr>
+{{- end }}
+</table>{{ end }}`))
+
+	// agentPage shows the addresses of an agent and the changes it pushed
+	agentPage = template.Must(template.New("agent").Funcs(uiFuncs).Parse(uiLayout + `{{ define "content" }}
+<p><a href="/ui">fleet</a> · last seen {{ ago .Agent.Updated }} from {{ .Agent.Source }}</p>
+<h2>Addresses</h2><table><tr><th>address</th><th>interface</th><th>first seen</th><th>last seen</th></tr>
+{{- range .Agent.Addresses }}
+<tr><td>{{ .Address }}</td><td>{{ .Interface }}</td><td>{{ ago .FirstSeen }}</td><td>{{ ago .LastSeen }}</td></tr>
+{{- end }}
+</table>{{ if .Agent.Violations }}<h2>Violations</h2><ul>{{ range .Agent.Violations }}
+<li class="violation">{{ .Address }}: {{ .Reason }}</li>{{ end }}</ul>{{ end }}
+<h2>History</h2><table><tr><th>time</th><th>change</th></tr>
+{{- range newest .Agent.History }}
+<tr><td>{{ .Time.Format "2006-01-02 15:04:05" }}</td><td>{{ if .Snapshot }}snapshot<br>{{ end }}
+{{- range .Added }}<span class="added">+ {{ .Address }} {{ .Interface }}</span><br>{{ end }}
+{{- range .Removed }}<span class="removed">- {{ .Address }} {{ .Interface }}</span><br>{{ end }}
+{{- if .Prefix }}prefix {{ join .Prefix.Previous ", " }} → {{ join .Prefix.Current ", " }}{{ end }}</td></tr>
+{{- end }}
+</table>{{ end }}`))
+)
+
+// uiData is passed to the pages of the web UI.
+type uiData struct {
+	Title  string
+	Tenant string
+	Error  string
+	Agents []*agentState
+	Agent  *agentState
+}
+
+// handleUI shows the agents of the tenant, or the login form if the browser has no valid token.
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := uiTenant(r)
+	if !ok {
+		renderUI(w, loginPage, &uiData{Title: "log in"})
+		return
+	}
+	renderUI(w, fleetPage, &uiData{Title: "fleet", Tenant: tenant, Agents: agents.list(tenant)})
+}
+
+// handleUIAgent shows an agent of the tenant with its change history.
+func handleUIAgent(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := uiTenant(r)
+	if !ok {
+		http.Redirect(w, r, "/ui", http.StatusSeeOther)
+		return
+	}
+	state := agents.get(tenant, r.PathValue("agent"))
+	if state == nil {
+		http.Error(w, "unknown agent", http.StatusNotFound)
+		return
+	}
+	renderUI(w, agentPage, &uiData{Title: state.Name, Tenant: tenant, Agent: state})
+}
+
+// handleUILogin keeps a token allowing to read in a cookie, the browser sends it with every request to the UI.
+func handleUILogin(w http.ResponseWriter, r *http.Request) {
+	token := tokens.lookup(r.PostFormValue("token"))
+	if token == nil || !token.read {
+		w.WriteHeader(http.StatusUnauthorized)
+		renderUI(w, loginPage, &uiData{Title: "log in", Error: "unknown token or token may not read"})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     uiCookie,
+		Value:    r.PostFormValue("token"),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/ui", http.StatusSeeOther)
+}
+
+// handleUILogout removes the cookie holding the token.
+func handleUILogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: uiCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/ui", http.StatusSeeOther)
+}
+
+// uiTenant returns the tenant of the token kept in the cookie if it allows to read.
+func uiTenant(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(uiCookie)
+	if err != nil {
+		return "", false
+	}
+	token := tokens.lookup(cookie.Value)
+	if token == nil || !token.read {
+		return "", false
+	}
+	return token.tenant, true
+}
+
+// renderUI renders a page of the web UI, pages are never cached as they show the state of the fleet.
+func renderUI(w http.ResponseWriter, page *template.Template, data *uiData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = page.ExecuteTemplate(w, "layout", data)
+}
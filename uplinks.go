@@ -0,0 +1,107 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+// uplink is the public address as seen through a single interface.
+type uplink struct {
+	Interface string
+
+	// Public maps the families to the public address seen through the interface
+	Public map[string]string `json:",omitempty"`
+
+	// Errors maps the families to the reason the lookup through the interface failed
+	Errors map[string]string `json:",omitempty"`
+}
+
+// runUplinks looks up the public address through every interface able to carry a default route, one lookup per
+// family the interface has a global address of, and prints the result as matrix. Uplinks sharing a public address
+// egress through the same WAN. Interfaces with a default route in any table are candidates, where the routing
+// tables can't be read every interface with a global address is. Returns exitPublicLookupFailed if a lookup
+// failed.
+func runUplinks(logger *slog.Logger, _ []string) int {
+	if offline {
+		logger.Error("uplinks needs the network")
+		return exitInternalError
+	}
+	local, err := getInterfaceAddresses(logger)
+	if err != nil {
+		logger.Error("could not get ip addresses", "err", err)
+		return exitInternalError
+	}
+	candidates := make(map[string]bool)
+	routes, err := defaultRoutes()
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		logger.Error("could not read routing table", "err", err)
+		return exitInternalError
+	}
+	for _, r := range routes {
+		candidates[r.Interface] = true
+	}
+
+	families := make(map[string][]string)
+	names := make([]string, 0)
+	for _, i := range local {
+		address, _, err := net.ParseCIDR(i.Address)
+		if err != nil || !address.IsGlobalUnicast() || routes != nil && !candidates[i.Interface] {
+			continue
+		}
+		if !slices.Contains(names, i.Interface) {
+			names = append(names, i.Interface)
+		}
+		if !slices.Contains(families[i.Interface], i.family()) {
+			families[i.Interface] = append(families[i.Interface], i.family())
+		}
+	}
+	slices.Sort(names)
+
+	code := exitOK
+	uplinks := make([]*uplink, 0, len(names))
+	defer func(previous string) { viaInterface = previous }(viaInterface)
+	for _, name := range names {
+		u := &uplink{Interface: name, Public: make(map[string]string), Errors: make(map[string]string)}
+		viaInterface = name
+		for _, family := range families[name] {
+			address, err := getPublicIp(family)
+			if err != nil {
+				logger.Warn("could not get public ip", "err", err, "interface", name, "family", family)
+				u.Errors[family] = err.Error()
+				code = exitPublicLookupFailed
+				continue
+			}
+			u.Public[family] = address.Address
+		}
+		uplinks = append(uplinks, u)
+	}
+
+	if jsonOutput {
+		if printJSON(logger, uplinks) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	fmt.Println("interface\tipv4\tipv6")
+	for _, u := range uplinks {
+		row := []string{u.Interface}
+		for _, family := range []string{"ipv4", "ipv6"} {
+			switch {
+			case u.Public[family] != "":
+				row = append(row, u.Public[family])
+			case u.Errors[family] != "":
+				row = append(row, "failed")
+			default:
+				row = append(row, "-")
+			}
+		}
+		fmt.Println(strings.Join(row, "\t"))
+	}
+	return code
+}
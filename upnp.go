@@ -0,0 +1,360 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upnpSupport names the UPnP devices nat and portmap talk to
+const upnpSupport = "IGD"
+
+// ssdpAddress is the multicast group UPnP devices answer discovery requests on
+const ssdpAddress = "239.255.255.250:1900"
+
+// igdServices are the UPnP services able to manage port mappings, preferred first
+var igdServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type (
+
+	// igd is the connection service of a UPnP internet gateway device.
+	igd struct {
+
+		// location is the url of the device description
+		location string
+
+		// controlURL receives the SOAP requests
+		controlURL string
+
+		// serviceType is the urn of the service, it is part of every request
+		serviceType string
+
+		// transport sends the requests, nil for the default one
+		transport http.RoundTripper
+	}
+
+	// upnpDevice is a device of a UPnP description, devices are nested.
+	upnpDevice struct {
+		Services []struct {
+			ServiceType string `xml:"serviceType"`
+			ControlURL  string `xml:"controlURL"`
+		} `xml:"serviceList>service"`
+		Devices []upnpDevice `xml:"deviceList>device"`
+	}
+
+	// upnpError is the fault returned by a SOAP action.
+	upnpError struct {
+		action, code, description string
+	}
+
+	// upnpDescription is the root of a UPnP device description.
+	upnpDescription struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+)
+
+// Error returns the action together with the UPnP error code and description.
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("%s failed: %s %s", e.action, e.code, e.description)
+}
+
+// discoverIGD searches the local network for an internet gateway device using SSDP and returns the first one
+// offering a connection service.
+func discoverIGD() (*igd, error) {
+	start := time.Now()
+	locations, err := ssdpSearch()
+	audit("ssdp", ssdpAddress, start, strconv.Itoa(len(locations)), err)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, 0)
+	for _, location := range locations {
+		g, err := describeIGD(location)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return g, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, errors.New("no UPnP internet gateway device found")
+}
+
+// ssdpSearch sends an M-SEARCH request and collects the locations of all answering gateways until the timeout
+// passed or the first answer arrived plus a short grace period for further ones.
+func ssdpSearch() ([]string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(req), group); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	seen := make(map[string]bool)
+	locations := make([]string, 0)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if isTimeout(err) {
+			return locations, nil
+		}
+		if err != nil {
+			return locations, err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" || seen[location] {
+			continue
+		}
+		if len(locations) == 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		}
+		seen[location] = true
+		locations = append(locations, location)
+	}
+}
+
+// trustLocalURL marks the host of rawURL as trusted if it is part of the local network: the default gateway or
+// a private or link-local address. It is used for URLs announced on the network, e.g. by SSDP, which anyone on
+// the link may send, so host names and other addresses stay behind the gate.
+func trustLocalURL(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	address := net.ParseIP(u.Hostname())
+	if address == nil {
+		return
+	}
+	if gw, err := defaultGateway(); err == nil && gw.Equal(address) {
+		trustURL(rawURL)
+		return
+	}
+	if c, err := classifyAddress(address.String()); err == nil && (c.Class == "private" || c.Class == "ula" || c.Class == "link-local") {
+		trustURL(rawURL)
+	}
+}
+
+// describeIGD fetches the device description and looks for a connection service. Devices of the local network
+// are trusted by the no-external gate, locations and control urls pointing elsewhere are not.
+func describeIGD(location string) (*igd, error) {
+	trustLocalURL(location)
+	resp, err := newHTTPClient(nil).Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not read description %s: %s", location, resp.Status)
+	}
+	var description upnpDescription
+	if err := xml.NewDecoder(resp.Body).Decode(&description); err != nil {
+		return nil, fmt.Errorf("could not parse description %s: %w", location, err)
+	}
+	base := location
+	if description.URLBase != "" {
+		base = description.URLBase
+	}
+	for _, serviceType := range igdServices {
+		if controlURL := findService(description.Device, serviceType); controlURL != "" {
+			resolved, err := resolveURL(base, controlURL)
+			if err != nil {
+				return nil, err
+			}
+			trustLocalURL(resolved)
+			return &igd{location: location, controlURL: resolved, serviceType: serviceType}, nil
+		}
+	}
+	return nil, fmt.Errorf("device %s offers no connection service", location)
+}
+
+// findService returns the control url of the service in the device tree or an empty string.
+func findService(d upnpDevice, serviceType string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s.ControlURL
+		}
+	}
+	for _, child := range d.Devices {
+		if controlURL := findService(child, serviceType); controlURL != "" {
+			return controlURL
+		}
+	}
+	return ""
+}
+
+// resolveURL resolves ref relative to base.
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// call invokes a SOAP action with the arguments given as name, value pairs in the order the specification lists
+// them. The text of all leaf elements of the response is returned by name.
+func (g *igd) call(action string, args ...string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, g.serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&body, "<%s>", args[i])
+		_ = xml.EscapeText(&body, []byte(args[i+1]))
+		fmt.Fprintf(&body, "</%s>", args[i])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest(http.MethodPost, g.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+g.serviceType+"#"+action+`"`)
+	resp, err := newHTTPClient(g.transport).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	values, err := leafValues(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s response: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if values["errorCode"] != "" {
+			return nil, &upnpError{action: action, code: values["errorCode"], description: values["errorDescription"]}
+		}
+		return nil, fmt.Errorf("%s failed: %s", action, resp.Status)
+	}
+	return values, nil
+}
+
+// leafValues collects the text of all elements without child elements by their local name.
+func leafValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+	var name string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if name == t.Name.Local {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}
+
+// externalAddress returns the public address of the gateway.
+func (g *igd) externalAddress() (string, error) {
+	values, err := g.call("GetExternalIPAddress")
+	if err != nil {
+		return "", err
+	}
+	return values["NewExternalIPAddress"], nil
+}
+
+// addPortMapping forwards the external port to the same port of client. A lease of 0 requests a permanent
+// mapping, which some routers refuse.
+func (g *igd) addPortMapping(protocol string, port uint16, client string, lease time.Duration) error {
+	_, err := g.call("AddPortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(int(port)),
+		"NewProtocol", strings.ToUpper(protocol),
+		"NewInternalPort", strconv.Itoa(int(port)),
+		"NewInternalClient", client,
+		"NewEnabled", "1",
+		"NewPortMappingDescription", "ips",
+		"NewLeaseDuration", strconv.Itoa(int(lease/time.Second)),
+	)
+	return err
+}
+
+// deletePortMapping removes the mapping of the external port.
+func (g *igd) deletePortMapping(protocol string, port uint16) error {
+	_, err := g.call("DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(int(port)),
+		"NewProtocol", strings.ToUpper(protocol),
+	)
+	return err
+}
+
+// portMappings lists all mappings of the gateway by index until the gateway reports an invalid index.
+func (g *igd) portMappings() ([]*portMapping, error) {
+	mappings := make([]*portMapping, 0)
+	for index := 0; ; index++ {
+		values, err := g.call("GetGenericPortMappingEntry", "NewPortMappingIndex", strconv.Itoa(index))
+		var ue *upnpError
+		if errors.As(err, &ue) && (ue.code == "713" || ue.code == "714") {
+			// SpecifiedArrayIndexInvalid or NoSuchEntryInArray mark the end of the list
+			return mappings, nil
+		}
+		if err != nil {
+			return mappings, err
+		}
+		externalPort, _ := strconv.ParseUint(values["NewExternalPort"], 10, 16)
+		internalPort, _ := strconv.ParseUint(values["NewInternalPort"], 10, 16)
+		lease, _ := strconv.Atoi(values["NewLeaseDuration"])
+		mappings = append(mappings, &portMapping{
+			Protocol:       strings.ToLower(values["NewProtocol"]),
+			ExternalPort:   uint16(externalPort),
+			InternalClient: values["NewInternalClient"],
+			InternalPort:   uint16(internalPort),
+			Lease:          (time.Duration(lease) * time.Second).String(),
+			Description:    values["NewPortMappingDescription"],
+			Method:         "UPnP",
+		})
+	}
+}
@@ -0,0 +1,180 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+const (
+	// ipv6OnlyHost is only reachable using ipv6
+	ipv6OnlyHost = "ipv6.google.com"
+
+	// dualStackHost is reachable using both families
+	dualStackHost = "www.google.com"
+)
+
+type (
+
+	// v6Report is the result of ips v6check.
+	v6Report struct {
+
+		// Score rates the ipv6 readiness from 0 to 10
+		Score int
+
+		// Checks contains the outcome of every check that was run
+		Checks []*check
+	}
+
+	// v6Check is a single step of the readiness test, weighted by its importance.
+	v6Check struct {
+		weight int
+
+		// external marks checks contacting the internet, they are skipped when offline or with no-external set
+		external bool
+
+		run func() *check
+	}
+)
+
+// runV6Check tests the ipv6 readiness of the host similar to test-ipv6.com: address, default route, AAAA
+// resolution, reachability of an ipv6-only host, the public address and whether dual-stack connections prefer
+// ipv6. The score is computed from the weights of the passed checks. Exits with exitCheckFailed if any check fails.
+func runV6Check(logger *slog.Logger, _ []string) int {
+	steps := []v6Check{
+		{weight: 2, run: checkGlobalIPv6},
+		{weight: 2, run: checkIPv6Route},
+		{weight: 1, external: true, run: checkAAAA},
+		{weight: 2, external: true, run: checkIPv6Only},
+		{weight: 2, external: true, run: checkPublicIPv6},
+		{weight: 1, external: true, run: checkPrefersIPv6},
+	}
+	report := &v6Report{Checks: make([]*check, 0, len(steps))}
+	total, passed := 0, 0
+	for _, step := range steps {
+		if step.external && (offline || noExternal) {
+			continue
+		}
+		c := step.run()
+		report.Checks = append(report.Checks, c)
+		total += step.weight
+		if c.OK {
+			passed += step.weight
+		}
+	}
+	if total > 0 {
+		report.Score = (10*passed + total/2) / total
+	}
+
+	if jsonOutput {
+		if printJSON(logger, report) != exitOK {
+			return exitInternalError
+		}
+		for _, c := range report.Checks {
+			if !c.OK {
+				return exitCheckFailed
+			}
+		}
+		return exitOK
+	}
+	code := printChecks(logger, report.Checks)
+	fmt.Printf("score\t%d/10\n", report.Score)
+	return code
+}
+
+// checkGlobalIPv6 verifies that an interface has a global ipv6 address, unique local addresses don't count.
+func checkGlobalIPv6() *check {
+	c := &check{Name: "global address"}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	for _, i := range interfaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.To4() != nil || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+				continue
+			}
+			c.OK, c.Detail = true, ipNet.IP.String()+" on "+i.Name
+			return c
+		}
+	}
+	c.Detail = "no global ipv6 address"
+	return c
+}
+
+// checkIPv6Route verifies that an ipv6 default route exists.
+func checkIPv6Route() *check {
+	c := &check{Name: "default route"}
+	r := routeTo(net.ParseIP("2001:db8::1"))
+	if r.Error != "" {
+		c.Detail = r.Error
+		return c
+	}
+	c.OK, c.Detail = true, "via "+r.Interface+" from "+r.Source
+	return c
+}
+
+// checkAAAA verifies that the resolver returns AAAA records.
+func checkAAAA() *check {
+	c := &check{Name: "dns aaaa"}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip6", dualStackHost)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.OK, c.Detail = true, fmt.Sprintf("%s resolves to %s", dualStackHost, addrs[0])
+	return c
+}
+
+// checkIPv6Only verifies that a host only reachable using ipv6 can be connected to.
+func checkIPv6Only() *check {
+	c := &check{Name: "ipv6-only host"}
+	attempt := &heAttempt{}
+	dialAttempt(attempt, "tcp6", net.JoinHostPort(ipv6OnlyHost, "443"))
+	if attempt.Error != "" {
+		c.Detail = attempt.Error
+		return c
+	}
+	c.OK, c.Detail = true, fmt.Sprintf("connected to %s in %s", ipv6OnlyHost, attempt.Duration)
+	return c
+}
+
+// checkPublicIPv6 verifies that the public ipv6 address can be determined using the providers.
+func checkPublicIPv6() *check {
+	c := &check{Name: "public address"}
+	address, err := getPublicIp("ipv6")
+	if err != nil {
+		// the errors of all providers are joined by newlines
+		c.Detail = strings.ReplaceAll(err.Error(), "\n", "; ")
+		return c
+	}
+	c.OK, c.Detail = true, address.Address
+	return c
+}
+
+// checkPrefersIPv6 verifies that a dual-stack connection uses ipv6, as operating systems fall back to ipv4 when
+// ipv6 is broken or slow.
+func checkPrefersIPv6() *check {
+	c := &check{Name: "prefers ipv6"}
+	attempt := &heAttempt{}
+	dialAttempt(attempt, "tcp", net.JoinHostPort(dualStackHost, "443"))
+	if attempt.Error != "" {
+		c.Detail = attempt.Error
+		return c
+	}
+	c.OK = familyOf(attempt.Remote) == "ipv6"
+	c.Detail = "connected to " + attempt.Remote
+	return c
+}
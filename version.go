@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// commit and date identify the source and the time of the build, set during the build using ldflags
+var commit, date string
+
+// buildInfo is the answer of ips version.
+type buildInfo struct {
+	Version string
+
+	// Commit is the revision of the source, Modified is set if the working tree had uncommitted changes
+	Commit   string `json:",omitempty"`
+	Modified bool   `json:",omitempty"`
+
+	// Date is the time the build, or without ldflags the commit, was made
+	Date string `json:",omitempty"`
+
+	GoVersion string
+
+	// Platform is the operating system and architecture, e.g. linux/amd64
+	Platform string
+
+	// Tags are the build tags, e.g. ips_minimal
+	Tags []string `json:",omitempty"`
+
+	// Subsystems names the subsystems compiled in, see ips capabilities
+	Subsystems []string
+}
+
+// init takes the version from the module information when not set using ldflags, e.g. for go install.
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if version == "dev" && ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+}
+
+// currentBuild describes the running binary. Values set using ldflags take precedence over the ones the Go
+// toolchain records, which lack the build date and are missing for builds outside a version control checkout.
+func currentBuild() *buildInfo {
+	b := &buildInfo{
+		Version:    version,
+		Commit:     commit,
+		Date:       date,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Subsystems: subsystemNames(),
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.Commit == "" {
+				b.Commit = s.Value
+			}
+		case "vcs.time":
+			if b.Date == "" {
+				b.Date = s.Value
+			}
+		case "vcs.modified":
+			b.Modified = commit == "" && s.Value == "true"
+		case "-tags":
+			b.Tags = strings.Split(s.Value, ",")
+		}
+	}
+	return b
+}
+
+// runVersion prints the version of ips and how it was built, one line per property, so bug reports and
+// aggregators can identify the binary exactly.
+func runVersion(logger *slog.Logger, _ []string) int {
+	b := currentBuild()
+	if jsonOutput {
+		return printJSON(logger, b)
+	}
+	revision := b.Commit
+	if b.Modified {
+		revision += " (modified)"
+	}
+	fmt.Printf("version\t%s\n", b.Version)
+	fmt.Printf("commit\t%s\n", revision)
+	fmt.Printf("date\t%s\n", b.Date)
+	fmt.Printf("go\t%s\n", b.GoVersion)
+	fmt.Printf("platform\t%s\n", b.Platform)
+	fmt.Printf("tags\t%s\n", strings.Join(b.Tags, ","))
+	fmt.Printf("subsystems\t%s\n", strings.Join(b.Subsystems, ","))
+	return exitOK
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// physicalOnly hides the addresses of loopback and virtual adapters
+var physicalOnly bool
+
+// virtualNames map prefixes of interface names to the software creating the adapter, checked in order. Names are
+// compared in lower case, Windows names adapters by their description.
+var virtualNames = []struct{ prefix, kind string }{
+	{"vethernet (wsl", "wsl"},
+	{"vethernet (", "hyper-v"},
+	{"virtualbox", "virtualbox"},
+	{"vboxnet", "virtualbox"},
+	{"vmware", "vmware"},
+	{"vmnet", "vmware"},
+	{"parallels", "parallels"},
+	{"vnic", "parallels"},
+	{"virbr", "libvirt"},
+	{"vnet", "libvirt"},
+	{"docker", "docker"},
+	{"br-", "docker"},
+	{"veth", "container"},
+	{"podman", "podman"},
+	{"cni", "kubernetes"},
+	{"flannel", "kubernetes"},
+	{"cali", "kubernetes"},
+}
+
+// virtualOUIs map the vendor part of hardware addresses to the hypervisor assigning them to its adapters
+var virtualOUIs = map[string]string{
+	"00:15:5d": "hyper-v",
+	"0a:00:27": "virtualbox",
+	"08:00:27": "virtualbox",
+	"00:50:56": "vmware",
+	"00:0c:29": "vmware",
+	"00:05:69": "vmware",
+	"00:1c:14": "vmware",
+	"00:1c:42": "parallels",
+	"52:54:00": "libvirt",
+	"fe:54:00": "libvirt",
+}
+
+// virtualKind returns the software creating the adapter, judged by its name or the vendor of its hardware
+// address, or an empty string for physical adapters.
+func virtualKind(i net.Interface) string {
+	name := strings.ToLower(i.Name)
+	for _, v := range virtualNames {
+		if strings.HasPrefix(name, v.prefix) {
+			return v.kind
+		}
+	}
+	if mac := i.HardwareAddr.String(); len(mac) >= 8 {
+		return virtualOUIs[mac[:8]]
+	}
+	return ""
+}
+
+// labelVirtual labels the addresses of virtual adapters, e.g. the Hyper-V default switch or VirtualBox host-only
+// networks, with virtual and the software creating them. Interfaces that cannot be listed are logged only.
+func labelVirtual(logger *slog.Logger, list ips) ips {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logger.Warn("could not get interfaces", "err", err)
+		return list
+	}
+	kinds := make(map[string]string)
+	for _, i := range interfaces {
+		if kind := virtualKind(i); kind != "" {
+			kinds[i.Name] = kind
+		}
+	}
+	for _, i := range list {
+		if kind, ok := kinds[i.Interface]; ok {
+			if i.Labels == nil {
+				i.Labels = make(map[string]string)
+			}
+			i.Labels["virtual"] = kind
+		}
+	}
+	return list
+}
+
+// isPhysical reports whether the address belongs to an adapter that is neither virtual nor a loopback.
+func (i ip) isPhysical() bool {
+	if i.Labels["virtual"] != "" {
+		return false
+	}
+	c, err := classifyAddress(i.Address)
+	return err != nil || c.Class != "loopback"
+}
@@ -0,0 +1,272 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+var (
+	// listVMs adds the addresses of the guests of a virtualization host to the output
+	listVMs bool
+
+	// proxmoxURL is the base url of the Proxmox VE API, e.g. https://pve.example.com:8006
+	proxmoxURL string
+
+	// proxmoxToken is an API token of Proxmox VE as user@realm!tokenid=secret
+	proxmoxToken string
+)
+
+// vmInventory is what the hypervisors know about their guests.
+type vmInventory struct {
+
+	// addresses are the addresses of the guests, the interface is vm followed by guest name and interface
+	addresses ips
+
+	// interfaces maps the interfaces of the host connecting guests, e.g. vnet0 or tap100i0, to the guest name
+	interfaces map[string]string
+}
+
+func init() {
+	registerSubsystem(&subsystem{
+		name: "vms",
+		flags: func() {
+			flag.BoolVar(&listVMs, "vms", false, "add the addresses of the guests of libvirt and Proxmox VE and label the interfaces connecting them")
+			flag.StringVar(&proxmoxURL, "proxmox-url", "", "base url of the Proxmox VE API guests are listed from, e.g. https://pve.example.com:8006")
+			flag.StringVar(&proxmoxToken, "proxmox-token", "", "API token of Proxmox VE as user@realm!tokenid=secret")
+		},
+		urls: []*string{&proxmoxURL},
+	})
+}
+
+// addGuests labels the interfaces of the host connecting guests with the name of the guest and appends the
+// addresses of the guests if -vms is set, as reported by libvirt, if virsh is installed, and by Proxmox VE, if
+// -proxmox-url is set and not offline. Hypervisors failing to answer are logged only.
+func addGuests(logger *slog.Logger, list ips) ips {
+	if !listVMs {
+		return list
+	}
+	inventory := &vmInventory{interfaces: make(map[string]string)}
+	if _, err := exec.LookPath("virsh"); err == nil {
+		if err := inventory.addLibvirt(logger); err != nil {
+			logger.Warn("could not list libvirt guests", "err", err)
+		}
+	}
+	if proxmoxURL != "" && !offline {
+		if err := inventory.addProxmox(logger); err != nil {
+			logger.Warn("could not list proxmox guests", "err", err)
+		}
+	}
+
+	for _, i := range list {
+		if name, ok := inventory.interfaces[i.Interface]; ok {
+			if i.Labels == nil {
+				i.Labels = make(map[string]string)
+			}
+			i.Labels["vm"] = name
+		}
+	}
+	return append(list, inventory.addresses...)
+}
+
+// add appends an address of a guest unless it is a loopback address.
+func (v *vmInventory) add(guest, iface, address string) {
+	if c, err := classifyAddress(address); err != nil || c.Class == "loopback" {
+		return
+	}
+	v.addresses = append(v.addresses, &ip{Address: address, Interface: "vm " + guest + "/" + iface})
+}
+
+// addLibvirt adds the running domains of libvirt. Addresses are asked from the QEMU guest agent, for guests without
+// agent the DHCP leases of libvirt are used, which name the interface of the host instead of the one of the guest.
+// virsh connects to the default uri, e.g. qemu:///session for other users than root, LIBVIRT_DEFAULT_URI overrides
+// it.
+func (v *vmInventory) addLibvirt(logger *slog.Logger) error {
+	out, err := exec.Command("virsh", "list", "--name").Output()
+	if err != nil {
+		return fmt.Errorf("virsh: %w", err)
+	}
+	for _, domain := range strings.Fields(string(out)) {
+		if out, err := exec.Command("virsh", "domiflist", domain).Output(); err == nil {
+			for _, fields := range virshTable(out) {
+				if fields[0] != "-" {
+					v.interfaces[fields[0]] = domain
+				}
+			}
+		}
+		var rows [][]string
+		for _, source := range []string{"agent", "lease"} {
+			out, err := exec.Command("virsh", "domifaddr", domain, "--source", source).Output()
+			if err != nil {
+				logger.Debug("could not get guest addresses", "err", err, "domain", domain, "source", source)
+				continue
+			}
+			if rows = virshTable(out); len(rows) > 0 {
+				break
+			}
+		}
+		// continuation lines of domifaddr name the interface -
+		iface := ""
+		for _, fields := range rows {
+			if fields[0] != "-" {
+				iface = fields[0]
+			}
+			if len(fields) >= 4 {
+				v.add(domain, iface, fields[3])
+			}
+		}
+	}
+	return nil
+}
+
+// virshTable returns the fields of the rows of a table printed by virsh, skipping the heading and the separator.
+func virshTable(data []byte) [][]string {
+	rows := make([][]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for body := false; scanner.Scan(); {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") {
+			body = true
+			continue
+		}
+		if fields := strings.Fields(line); body && len(fields) > 0 {
+			rows = append(rows, fields)
+		}
+	}
+	return rows
+}
+
+// addProxmox adds the running virtual machines and containers of every node of the Proxmox VE cluster. Virtual
+// machines are asked using the QEMU guest agent, containers report their interfaces themselves. The interfaces of
+// the host connecting a guest are named by its id, e.g. tap100i0 or veth101i0.
+func (v *vmInventory) addProxmox(logger *slog.Logger) error {
+	var nodes []struct {
+		Node   string `json:"node"`
+		Status string `json:"status"`
+	}
+	if err := proxmoxRequest("/nodes", &nodes); err != nil {
+		return err
+	}
+	type guest struct {
+		VMID   json.Number `json:"vmid"`
+		Name   string      `json:"name"`
+		Status string      `json:"status"`
+	}
+	for _, n := range nodes {
+		if n.Status != "online" {
+			continue
+		}
+		node := "/nodes/" + url.PathEscape(n.Node)
+
+		var vms []*guest
+		if err := proxmoxRequest(node+"/qemu", &vms); err != nil {
+			return err
+		}
+		for _, vm := range vms {
+			v.proxmoxInterfaces(vm.VMID.String(), vm.Name, "tap", "fwbr", "fwln", "fwpr")
+			if vm.Status != "running" {
+				continue
+			}
+			var agent struct {
+				Result []struct {
+					Name      string `json:"name"`
+					Addresses []struct {
+						Address string `json:"ip-address"`
+						Prefix  int    `json:"prefix"`
+					} `json:"ip-addresses"`
+				} `json:"result"`
+			}
+			// fails for virtual machines without a running agent
+			if err := proxmoxRequest(node+"/qemu/"+vm.VMID.String()+"/agent/network-get-interfaces", &agent); err != nil {
+				logger.Debug("could not get guest addresses", "err", err, "vmid", vm.VMID.String())
+				continue
+			}
+			for _, i := range agent.Result {
+				for _, a := range i.Addresses {
+					v.add(vm.Name, i.Name, fmt.Sprintf("%s/%d", a.Address, a.Prefix))
+				}
+			}
+		}
+
+		var containers []*guest
+		if err := proxmoxRequest(node+"/lxc", &containers); err != nil {
+			return err
+		}
+		for _, ct := range containers {
+			v.proxmoxInterfaces(ct.VMID.String(), ct.Name, "veth", "fwbr", "fwln", "fwpr")
+			if ct.Status != "running" {
+				continue
+			}
+			var interfaces []struct {
+				Name  string `json:"name"`
+				Inet  string `json:"inet"`
+				Inet6 string `json:"inet6"`
+			}
+			if err := proxmoxRequest(node+"/lxc/"+ct.VMID.String()+"/interfaces", &interfaces); err != nil {
+				logger.Debug("could not get container addresses", "err", err, "vmid", ct.VMID.String())
+				continue
+			}
+			for _, i := range interfaces {
+				for _, address := range []string{i.Inet, i.Inet6} {
+					if address != "" {
+						v.add(ct.Name, i.Name, address)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// proxmoxInterfaces maps the interfaces Proxmox VE creates for the network devices of a guest to its name. Guests
+// rarely have more than a few network devices, the first eight are mapped.
+func (v *vmInventory) proxmoxInterfaces(vmid, name string, prefixes ...string) {
+	for _, prefix := range prefixes {
+		for idx := 0; idx < 8; idx++ {
+			v.interfaces[fmt.Sprintf("%s%si%d", prefix, vmid, idx)] = name
+		}
+	}
+}
+
+// proxmoxRequest sends a GET request to the Proxmox VE API and decodes the data of the answer into out.
+func proxmoxRequest(path string, out any) error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(proxmoxURL, "/")+"/api2/json"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if proxmoxToken != "" {
+		req.Header.Set("Authorization", "PVEAPIToken="+proxmoxToken)
+	}
+	resp, err := newHTTPClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxmox answered with %s", resp.Status)
+	}
+	var answer struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return err
+	}
+	if len(answer.Data) == 0 || string(answer.Data) == "null" {
+		return errors.New("proxmox answered without data")
+	}
+	return json.Unmarshal(answer.Data, out)
+}
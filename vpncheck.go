@@ -0,0 +1,95 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+var expectASN, expectInterface string
+
+// runVPNCheck verifies that traffic egresses through the VPN: the expected interface is present, the default
+// route uses it and the public address belongs to the expected autonomous system. Exits with exitCheckFailed if
+// any of the checks fails.
+func runVPNCheck(logger *slog.Logger, _ []string) int {
+	if expectInterface == "" && expectASN == "" {
+		logger.Error("usage: ips vpn-check [-expect-interface wg0] [-expect-asn AS9009]")
+		return exitInternalError
+	}
+
+	checks := make([]*check, 0)
+	if expectInterface != "" {
+		checks = append(checks, checkInterfacePresent(expectInterface))
+		for _, family := range []string{"ipv4", "ipv6"} {
+			checks = append(checks, checkDefaultRoute(family, expectInterface))
+		}
+	}
+	if expectASN != "" {
+		checks = append(checks, checkPublicASN(splitList(strings.ToUpper(expectASN))))
+	}
+
+	return printChecks(logger, checks)
+}
+
+// checkInterfacePresent verifies the interface exists, is up and has addresses.
+func checkInterfacePresent(name string) *check {
+	c := &check{Name: "interface"}
+	i, err := net.InterfaceByName(name)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	if i.Flags&net.FlagUp == 0 {
+		c.Detail = name + " is down"
+		return c
+	}
+	addrs, err := i.Addrs()
+	if err != nil || len(addrs) == 0 {
+		c.Detail = name + " has no addresses"
+		return c
+	}
+	c.OK, c.Detail = true, name+" is up"
+	return c
+}
+
+// checkDefaultRoute verifies that the default route of the family uses the interface. A family without a
+// default route passes, as no traffic can leak through it.
+func checkDefaultRoute(family, name string) *check {
+	c := &check{Name: "default route " + family}
+	destination := net.ParseIP("192.0.2.1")
+	if family == "ipv6" {
+		destination = net.ParseIP("2001:db8::1")
+	}
+	r := routeTo(destination)
+	switch {
+	case r.Error != "":
+		c.OK, c.Detail = true, "no default route"
+	case r.Interface == name:
+		c.OK, c.Detail = true, "via "+name+" from "+r.Source
+	default:
+		c.Detail = "via " + r.Interface + " from " + r.Source
+	}
+	return c
+}
+
+// checkPublicASN verifies that the public ipv4 address belongs to one of the expected autonomous systems.
+func checkPublicASN(expected []string) *check {
+	c := &check{Name: "public asn"}
+	address, err := getPublicIp("ipv4")
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	info, err := lookupGeo(address.Address)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.Detail = fmt.Sprintf("%s belongs to %s %s", address.Address, info.ASN, info.Organization)
+	c.OK = slices.Contains(expected, info.ASN)
+	return c
+}
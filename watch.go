@@ -0,0 +1,296 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	flag "github.com/sascha-andres/reuse/flag"
+)
+
+// settleTime is the quiet period after a network change notification before the addresses are polled
+const settleTime = time.Second
+
+var (
+	interval, providerMinInterval, breakerCooldown time.Duration
+	jitter                                         float64
+	breakerFailures                                uint
+)
+
+func init() {
+	registerSubsystem(&subsystem{
+		name:     "watch",
+		commands: map[string]command{"watch": runWatch, "health": runHealth, "service": runService, "payload-template": runPayloadTemplate},
+		flags: func() {
+			flag.Var(templateValues{}, "template", "template rendered by watch on every change as 'source:destination[:command]', the command runs after the destination changed, may be repeated")
+			flag.StringVar(&healthListen, "health-listen", "", "address watch serves /healthz and /readyz on, e.g. :8081")
+			flag.DurationVar(&confirmWindow, "confirm-window", 0, "time a new public ip has to be stable before a change is reported in watch mode")
+			flag.DurationVar(&debounce, "debounce", 0, "quiet period sinks wait for in watch mode before a burst of changes is delivered as one, 0 delivers every change")
+			flag.StringVar(&allowedCountries, "allow-country", "", "comma separated countries the public ip may be located in, alerts otherwise in watch mode")
+			flag.StringVar(&allowedASNs, "allow-asn", "", "comma separated autonomous systems (e.g. AS9009) the public ip may belong to, alerts otherwise in watch mode")
+			flag.StringVar(&alertWebhook, "alert-webhook", "", "url geofence alerts are posted to as JSON in watch mode")
+			flag.StringVar(&syslogTarget, "syslog", "", "send changes in watch mode to syslog: local, udp://host:514, tcp://host:514 or tls://host:6514")
+			flag.StringVar(&notifyEvents, "notify", "", "comma separated changes announced using desktop notifications in watch mode: public, interface, prefix or all")
+			flag.Var(sinkValues{}, "sink", "sink receiving changes in watch mode as 'type target [option=value ...]', type is webhook, mqtt, exec, syslog, desktop or email, may be repeated")
+			flag.Var(routeValues{}, "route", "route the changes matching an expression to sinks in watch mode as 'expression -> sink[,sink...]', may be repeated")
+			flag.DurationVar(&interval, "interval", time.Minute, "polling interval in watch mode")
+			flag.Float64Var(&jitter, "jitter", 0.1, "fraction the polling interval is randomly spread by")
+			flag.DurationVar(&providerMinInterval, "provider-min-interval", 5*time.Minute, "minimum time between two queries to the same provider in watch mode")
+			flag.UintVar(&breakerFailures, "breaker-failures", 3, "consecutive failures disabling a provider in watch mode, 0 to never disable")
+			flag.UintVar(&delegatedPrefixLength, "delegated-prefix-length", 64, "length of the ipv6 prefix delegated by the ISP, rotations are reported in watch mode")
+			flag.BoolVar(&scheduledTask, "scheduled-task", false, "install a scheduled task instead of a windows service")
+			flag.DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Minute, "time a failing provider stays disabled in watch mode")
+		},
+		urls: []*string{&alertWebhook},
+	})
+}
+
+// runWatch polls the addresses selected by the p and a flags in a jittered interval and prints every change
+// until interrupted. Public ip lookups are rate limited per provider and failing providers are disabled for a while.
+func runWatch(logger *slog.Logger, _ []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := serveControl(ctx, logger); err != nil {
+		logger.Warn("could not serve control socket", "err", err)
+	}
+	return watch(ctx, logger, printChange)
+}
+
+// watch runs the polling loop until ctx is cancelled, every change is passed to emit. Where the platform notifies
+// about network changes, the addresses are polled immediately after a change instead of waiting for the interval
+// to pass.
+//
+// On SIGHUP the config file is read again and the sinks are set up anew, the addresses known, the history and the
+// state of the providers are kept. If the control socket is served, requests to refresh, pause or reload are
+// handled as well.
+func watch(ctx context.Context, logger *slog.Logger, emit func(*slog.Logger, *change) int) int {
+	if err := validateWatch(); err != nil {
+		logger.Error("invalid configuration", "err", err)
+		return exitInternalError
+	}
+	if guard == nil {
+		guard = newProviderGuard(logger, providerMinInterval, breakerFailures, breakerCooldown)
+	}
+
+	health.start()
+	if err := serveHealth(ctx, logger); err != nil {
+		logger.Error("could not serve health endpoints", "err", err)
+		return exitInternalError
+	}
+	if err := serveMDNS(ctx, logger); err != nil {
+		logger.Error("could not advertise using mDNS", "err", err)
+		return exitInternalError
+	}
+
+	changes, err := networkChanges(ctx, logger)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		logger.Warn("could not subscribe to network changes, polling only", "err", err)
+	}
+
+	if sightings == nil {
+		loaded, err := loadSightings()
+		if err != nil {
+			logger.Warn("could not load sightings, starting anew", "err", err)
+		}
+		sightings = loaded
+	}
+	defer func() {
+		if err := sightings.close(); err != nil {
+			logger.Warn("could not save sightings", "err", err)
+		}
+	}()
+
+	damper := &publicDamper{window: confirmWindow, logger: logger}
+	fence := newGeofence(logger)
+	bus, err := newEventBus(logger)
+	if err != nil {
+		logger.Error("could not set up sinks", "err", err)
+		return exitInternalError
+	}
+	defer func() { bus.close() }()
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+
+	reload := func() {
+		if err := reloadConfig(validateWatch); err != nil {
+			logger.Error("could not reload configuration, keeping the previous one", "err", err)
+			return
+		}
+		guard.configure(providerMinInterval, breakerFailures, breakerCooldown)
+		damper.window = confirmWindow
+		next := newGeofence(logger)
+		if next != nil && fence != nil {
+			next.last = fence.last
+		}
+		fence = next
+		if next, err := newEventBus(logger); err != nil {
+			logger.Error("could not set up sinks, keeping the previous ones", "err", err)
+		} else {
+			bus.close()
+			bus = next
+		}
+		logger.Info("configuration reloaded")
+	}
+
+	var previous ips
+	var pruned time.Time
+	for {
+		if !control.isPaused() {
+			if time.Since(pruned) >= pruneInterval {
+				if _, err := pruneHistory(historyKeep); err != nil {
+					logger.Warn("could not prune history", "err", err)
+				}
+				pruned = time.Now()
+			}
+			current, err := getIpAddresses(logger)
+			if err == nil || errors.Is(err, ErrNoPublicProvider) {
+				if err := sightings.observe(current, time.Now(), historyKeep); err != nil {
+					logger.Warn("could not save sightings", "err", err)
+				}
+			}
+			switch {
+			case errors.Is(err, ErrNoPublicProvider):
+				// keep the last known public addresses instead of reporting them as removed
+				current = append(missingPublic(previous, current), current...)
+				health.polled(nil)
+			case err != nil:
+				logger.Error("could not get ip addresses", "err", err)
+				current = previous
+				health.polled(err)
+			default:
+				health.polled(nil)
+			}
+			current = damper.apply(current, time.Now())
+
+			if c := diff(previous, current); c != nil {
+				// the initial addresses are reported as added, but the prefix did not change
+				if previous != nil {
+					c.Prefix = detectPrefixChange(previous, current)
+				}
+				fence.check(c.Added)
+				bus.publish(c)
+				if err := recordHistory(c, c.Snapshot); err != nil {
+					logger.Warn("could not record history", "err", err)
+				}
+				if err := writeOutput(current, c); err != nil {
+					logger.Warn("could not write output file", "err", err, "file", outputFile)
+				}
+				renderTemplates(logger, current)
+				if code := emit(logger, c); code != exitOK {
+					return code
+				}
+			}
+			previous = current
+			control.update(current)
+			sightings.update(current)
+			responder.update(logger, current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-time.After(jittered(interval)):
+		case <-changes:
+			settle(changes)
+		case <-reloads:
+			reload()
+		case request := <-control.pending():
+			if request == controlReload {
+				reload()
+			}
+		}
+	}
+}
+
+// validateWatch checks the options used by the watch loop.
+func validateWatch() error {
+	if delegatedPrefixLength > 128 {
+		return fmt.Errorf("invalid delegated prefix length %d", delegatedPrefixLength)
+	}
+	if !validDiffFormat() {
+		return fmt.Errorf("unknown diff format %q", diffFormat)
+	}
+	return nil
+}
+
+// settle waits until no further notification arrived for settleTime, as changes tend to come in bursts.
+func settle(changes <-chan struct{}) {
+	for {
+		select {
+		case <-changes:
+		case <-time.After(settleTime):
+			return
+		}
+	}
+}
+
+// jittered spreads d randomly by the configured jitter fraction in both directions.
+func jittered(d time.Duration) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// missingPublic returns the public addresses of previous whose family is missing in current.
+func missingPublic(previous, current ips) ips {
+	present := make(map[string]bool)
+	for _, i := range current {
+		present[i.Interface] = true
+	}
+	result := make(ips, 0)
+	for _, i := range previous {
+		if i.isPublic() && !present[i.Interface] {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// diff returns the change between two address sets or nil if they contain the same addresses.
+func diff(previous, current ips) *change {
+	c := &change{Time: time.Now(), Snapshot: previous == nil, previous: previous}
+	seen := make(map[string]bool, len(previous))
+	for _, i := range previous {
+		seen[i.key()] = true
+	}
+	for _, i := range current {
+		if !seen[i.key()] {
+			c.Added = append(c.Added, i)
+		}
+		delete(seen, i.key())
+	}
+	for _, i := range previous {
+		if seen[i.key()] {
+			c.Removed = append(c.Removed, i)
+		}
+	}
+	if len(c.Added) == 0 && len(c.Removed) == 0 {
+		return nil
+	}
+	return c
+}
+
+// applyChange returns the addresses of current updated by a change.
+func applyChange(current ips, c *change) ips {
+	removed := make(map[string]bool, len(c.Removed))
+	for _, i := range c.Removed {
+		removed[i.key()] = true
+	}
+	result := make(ips, 0, len(current)+len(c.Added))
+	for _, i := range current {
+		if !removed[i.key()] {
+			result = append(result, i)
+		}
+	}
+	return append(result, c.Added...)
+}
@@ -0,0 +1,61 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// watchedFile is a file the server mode reads again once it changed, so its content is updated without restarting
+// the server. It is checked at most once a second, the caller serializes access.
+type watchedFile struct {
+	path string
+
+	// parse takes the content of the file, it keeps the content read before if it returns an error
+	parse func(data []byte) error
+
+	// modified and size identify the content read, checked is the time the file was looked at last
+	modified time.Time
+	size     int64
+	checked  time.Time
+
+	logger *slog.Logger
+}
+
+// read reads and parses the file.
+func (f *watchedFile) read() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := f.parse(data); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.modified, f.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// refresh reads the file again if it changed since it was read, an invalid file is logged.
+func (f *watchedFile) refresh() {
+	now := time.Now()
+	if now.Sub(f.checked) <= time.Second {
+		return
+	}
+	f.checked = now
+	info, err := os.Stat(f.path)
+	if err == nil && info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return
+	}
+	if err := f.read(); err != nil {
+		f.logger.Error("could not read file", "err", err)
+		return
+	}
+	f.logger.Info("file read again", "file", f.path)
+}
@@ -0,0 +1,95 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// webhookSink posts changes to an url, as JSON or as the payload rendered by the template of the sink.
+type webhookSink struct {
+	url string
+
+	// display is the url as given, a secret:// uri is printed instead of the secret
+	display string
+
+	contentType string
+
+	// token is sent as bearer token if set
+	token string
+}
+
+// newWebhookSink creates a sink posting to the target of spec, which may be a secret:// uri like the token option.
+// The content type defaults to application/json. The url was given by the user, so it is not a third party service.
+func newWebhookSink(_ *slog.Logger, spec *sinkSpec) (sink, error) {
+	url, err := resolveSecret(spec.target)
+	if err != nil {
+		return nil, err
+	}
+	trustURL(url)
+	contentType := spec.options["content-type"]
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	token, err := resolveSecret(spec.options["token"])
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{url: url, display: spec.target, contentType: contentType, token: token}, nil
+}
+
+// send posts the payload or the change as JSON.
+func (w *webhookSink) send(c *change, payload []byte) error {
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(c); err != nil {
+			return err
+		}
+	}
+	return postBody(w.url, w.display, w.contentType, w.token, payload)
+}
+
+// close does nothing, every change is posted using a request of its own.
+func (w *webhookSink) close() {}
+
+// postWebhook sends payload as JSON to url, with dry-run set the request is printed instead.
+func postWebhook(url string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postBody(url, redactSecret(url), "application/json", "", data)
+}
+
+// postBody posts data to url authenticated by the bearer token if not empty, with dry-run set the request is printed
+// instead showing display as url.
+func postBody(url, display, contentType, token string, data []byte) error {
+	if dryRun {
+		fmt.Printf("would POST %s\n%s\n", display, data)
+		return nil
+	}
+	client := newHTTPClient(nil)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", userAgent)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with %s", resp.Status)
+	}
+	return nil
+}
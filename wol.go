@@ -0,0 +1,216 @@
+//go:build !ips_minimal || ips_full
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+// wolPort is the discard port magic packets are sent to
+const wolPort = 9
+
+// wakeOnLANSupport names what wol sends
+const wakeOnLANSupport = "magic packet"
+
+func init() {
+	registerSubsystem(&subsystem{name: "wol", commands: map[string]command{"wol": runWOL}})
+}
+
+// wakeup is a magic packet sent to a broadcast address.
+type wakeup struct {
+	MAC       string
+	Broadcast string
+	Interface string
+
+	// Error describes why the packet could not be sent
+	Error string `json:",omitempty"`
+}
+
+// runWOL wakes the machine given by its MAC address or host name up by sending magic packets to the broadcast
+// address of every ipv4 network of the local interfaces, or of the interface given using -via. Host names and
+// addresses are looked up in /etc/ethers and the neighbor table. Exits with exitInternalError if no packet could
+// be sent.
+func runWOL(logger *slog.Logger, args []string) int {
+	if len(args) != 1 {
+		logger.Error("usage: ips wol <mac|host> [-via interface]")
+		return exitInternalError
+	}
+	mac, err := wolTarget(args[0])
+	if err != nil {
+		logger.Error("could not determine mac address", "err", err, "target", args[0])
+		return exitInternalError
+	}
+	targets, err := broadcastAddresses(viaInterface)
+	if err != nil {
+		logger.Error("could not determine broadcast addresses", "err", err)
+		return exitInternalError
+	}
+	if len(targets) == 0 {
+		logger.Error("no interface with an ipv4 broadcast address found")
+		return exitInternalError
+	}
+
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+	sent := make([]*wakeup, 0, len(targets))
+	code := exitInternalError
+	for _, t := range targets {
+		w := &wakeup{MAC: mac.String(), Broadcast: t.broadcast.String(), Interface: t.name}
+		sent = append(sent, w)
+		if dryRun {
+			fmt.Printf("would send magic packet for %s to %s via %s\n", w.MAC, w.Broadcast, w.Interface)
+			code = exitOK
+			continue
+		}
+		if err := sendMagicPacket(packet, t.local, t.broadcast); err != nil {
+			logger.Warn("could not send magic packet", "err", err, "interface", t.name)
+			w.Error = err.Error()
+			continue
+		}
+		code = exitOK
+	}
+	if dryRun {
+		return code
+	}
+
+	if jsonOutput {
+		if printJSON(logger, sent) != exitOK {
+			return exitInternalError
+		}
+		return code
+	}
+	for _, w := range sent {
+		if w.Error != "" {
+			fmt.Printf("failed\t%s\t%s\t%s\t%s\n", w.MAC, w.Broadcast, w.Interface, w.Error)
+			continue
+		}
+		fmt.Printf("sent\t%s\t%s\t%s\n", w.MAC, w.Broadcast, w.Interface)
+	}
+	return code
+}
+
+// broadcastTarget is the broadcast address of a local network.
+type broadcastTarget struct {
+	name      string
+	local     net.IP
+	broadcast net.IP
+}
+
+// broadcastAddresses returns the broadcast address of every ipv4 network of the interfaces that are up and
+// support broadcasts, only of the named interface if given.
+func broadcastAddresses(only string) ([]*broadcastTarget, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInterfaceEnumeration, err)
+	}
+	targets := make([]*broadcastTarget, 0)
+	for _, i := range interfaces {
+		if only != "" && i.Name != only || i.Flags&net.FlagUp == 0 || i.Flags&net.FlagBroadcast == 0 || i.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInterfaceEnumeration, err)
+		}
+		for _, a := range addrs {
+			prefix, ok := a.(*net.IPNet)
+			if !ok || prefix.IP.To4() == nil || len(prefix.Mask) != net.IPv4len {
+				continue
+			}
+			broadcast := make(net.IP, net.IPv4len)
+			for idx, b := range prefix.IP.To4() {
+				broadcast[idx] = b | ^prefix.Mask[idx]
+			}
+			targets = append(targets, &broadcastTarget{name: i.Name, local: prefix.IP, broadcast: broadcast})
+		}
+	}
+	return targets, nil
+}
+
+// sendMagicPacket sends the packet from the local address to the broadcast address.
+func sendMagicPacket(packet []byte, local, broadcast net.IP) error {
+	conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: local}, &net.UDPAddr{IP: broadcast, Port: wolPort})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+// wolTarget returns the mac address given or the one of the host as found in /etc/ethers or, for hosts that were
+// contacted recently, in the neighbor table.
+func wolTarget(target string) (net.HardwareAddr, error) {
+	if mac, err := net.ParseMAC(target); err == nil {
+		if len(mac) != 6 {
+			return nil, fmt.Errorf("%s is not an ethernet address", target)
+		}
+		return mac, nil
+	}
+	if mac, err := lookupEthers(target); err == nil {
+		return mac, nil
+	}
+	addresses := []string{target}
+	if net.ParseIP(target) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		resolved, err := net.DefaultResolver.LookupHost(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		addresses = resolved
+	}
+	for _, address := range addresses {
+		if mac, err := lookupEthers(address); err == nil {
+			return mac, nil
+		}
+		if mac, err := lookupNeighbor(address); err == nil {
+			return mac, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found in /etc/ethers or the neighbor table", target)
+}
+
+// lookupEthers returns the mac address of a host name or address listed in /etc/ethers.
+func lookupEthers(host string) (net.HardwareAddr, error) {
+	f, err := os.Open("/etc/ethers")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == host {
+			return net.ParseMAC(fields[0])
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// lookupNeighbor returns the mac address of an ipv4 address from the neighbor table, only Linux exposes it as
+// /proc/net/arp.
+func lookupNeighbor(address string) (net.HardwareAddr, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == address && fields[3] != "00:00:00:00:00:00" {
+			return net.ParseMAC(fields[3])
+		}
+	}
+	return nil, errors.New("not found")
+}